@@ -8,13 +8,12 @@ package accounting
 
 import (
 	"context"
-	"fmt"
-	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 	"sync"
 	"time"
 
 	"github.com/bittorrent/go-btfs/transaction/storage"
+	"github.com/ethereum/go-ethereum/common"
 	logging "github.com/ipfs/go-log"
 )
 
@@ -70,9 +69,21 @@ func (a *Accounting) Close() error {
 	return nil
 }
 
-// Settle to a peer. The lock on the accountingPeer must be held when called.
+// Settle to a peer, the payment is recorded as owed for the contract until it
+// is sent.
 func (a *Accounting) Settle(toPeer string, paymentAmount *big.Int, contractId string, token common.Address) error {
 	if paymentAmount.Cmp(a.minimumPayment) >= 0 {
+		if contractId != "" {
+			accountingPeer := a.getAccountingPeer(toPeer)
+			accountingPeer.lock.Lock()
+			err := a.updateContract(payableKey(toPeer, contractId), toPeer, contractId, token, func(c *ContractLedger) {
+				c.Expected.Add(c.Expected, paymentAmount)
+			})
+			accountingPeer.lock.Unlock()
+			if err != nil {
+				return err
+			}
+		}
 		a.wg.Add(1)
 		go a.payFunction(context.Background(), toPeer, paymentAmount, contractId, token)
 	}
@@ -97,32 +108,74 @@ func (a *Accounting) getAccountingPeer(peer string) *accountingPeer {
 	return peerData
 }
 
-// NotifyPaymentSent is triggered by async monetary settlement to update our balance and remove it's price from the shadow reserve
-func (a *Accounting) NotifyPaymentSent(peer string, amount *big.Int, receivedError error, token common.Address) {
-	defer a.wg.Done()
+// NotifyPaymentSending records the cheque about to be sent for the contract,
+// before it goes out, so that an interrupted payment can be reconciled with
+// the cheques the vault issued.
+func (a *Accounting) NotifyPaymentSending(peer string, amount *big.Int, contractId string, cumulativePayout *big.Int, token common.Address) error {
+	if contractId == "" {
+		return nil
+	}
 	accountingPeer := a.getAccountingPeer(peer)
 
 	accountingPeer.lock.Lock()
 	defer accountingPeer.lock.Unlock()
 
-	fmt.Println("NotifyPaymentSent...")
+	return a.updateContract(payableKey(peer, contractId), peer, contractId, token, func(c *ContractLedger) {
+		c.Pending = &PendingCheque{
+			Amount:           new(big.Int).Set(amount),
+			CumulativePayout: new(big.Int).Set(cumulativePayout),
+		}
+	})
+}
+
+// NotifyPaymentSent is triggered by async monetary settlement to record the payment of the contract, or its failure
+func (a *Accounting) NotifyPaymentSent(peer string, amount *big.Int, contractId string, receivedError error, token common.Address) {
+	defer a.wg.Done()
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
 
 	if receivedError != nil {
 		accountingPeer.lastSettlementFailureTimestamp = a.timeNow().Unix()
 		log.Warnf("accounting: payment failure %v", receivedError)
+	}
+	if contractId == "" {
 		return
 	}
+	err := a.updateContract(payableKey(peer, contractId), peer, contractId, token, func(c *ContractLedger) {
+		c.Pending = nil
+		if receivedError != nil {
+			c.Error = receivedError.Error()
+			return
+		}
+		c.Paid.Add(c.Paid, amount)
+		c.Sent = a.timeNow().Unix()
+		c.Error = ""
+	})
+	if err != nil {
+		log.Errorf("accounting: record payment of contract %s to %s: %v", contractId, peer, err)
+	}
 }
 
-// NotifyPayment is called by Settlement when we receive a payment.
-func (a *Accounting) NotifyPaymentReceived(peer string, amount *big.Int, token common.Address) error {
+// NotifyPaymentReceived is called by Settlement when we receive a payment, for
+// the contract if contractId is set.
+func (a *Accounting) NotifyPaymentReceived(peer string, amount *big.Int, contractId string, token common.Address) error {
 	accountingPeer := a.getAccountingPeer(peer)
 
 	accountingPeer.lock.Lock()
 	defer accountingPeer.lock.Unlock()
 
 	log.Infof("accounting: crediting peer %v with amount %d due to payment.", peer, amount)
-	fmt.Println("NotifyPaymentReceived...")
 
-	return nil
+	if contractId == "" {
+		unallocated := big.NewInt(0)
+		if err := a.store.Get(unallocatedKey(peer, token), &unallocated); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		return a.store.Put(unallocatedKey(peer, token), unallocated.Add(unallocated, amount))
+	}
+	return a.updateContract(receivableKey(peer, contractId), peer, contractId, token, func(c *ContractLedger) {
+		c.Paid.Add(c.Paid, amount)
+	})
 }
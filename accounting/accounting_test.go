@@ -59,7 +59,7 @@ func TestAccountingCallSettlementTooSoon(t *testing.T) {
 		t.Fatal("payment not sent")
 	}
 
-	acc.NotifyPaymentSent(peer1Addr, big.NewInt(int64(requestPriceTmp)), errors.New("error"), addr)
+	acc.NotifyPaymentSent(peer1Addr, big.NewInt(int64(requestPriceTmp)), "", errors.New("error"), addr)
 }
 
 // NotifyPaymentReceived
@@ -78,8 +78,198 @@ func TestAccountingNotifyPaymentReceived(t *testing.T) {
 
 	var amoutTmp uint64 = 5000
 
-	err = acc.NotifyPaymentReceived(peer1Addr, new(big.Int).SetUint64(amoutTmp), addr)
+	err = acc.NotifyPaymentReceived(peer1Addr, new(big.Int).SetUint64(amoutTmp), "", addr)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestAccountingLedger(t *testing.T) {
+	store := mock.NewStateStore()
+	defer store.Close()
+
+	token := common.HexToAddress("aaaa")
+	now := time.Unix(1000000, 0)
+
+	acc, err := accounting.NewAccounting(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.SetTimeNow(func() time.Time { return now })
+
+	renter := peer.ID("00112233").String()
+	p := &accounting.CreditPolicy{
+		Limits: map[common.Address]*big.Int{token: big.NewInt(2500)},
+		Grace:  time.Hour,
+	}
+
+	// c1 is paid in full, c2 half paid, c3 is not paid and written off
+	for _, id := range []string{"c1", "c2", "c3"} {
+		if err := acc.ExpectPayment(renter, id, big.NewInt(1000), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := acc.NotifyPaymentReceived(renter, big.NewInt(1000), "c1", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.NotifyPaymentReceived(renter, big.NewInt(500), "c2", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.NotifyPaymentReceived(renter, big.NewInt(7), "", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.WriteOff(renter, "c3"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := acc.PeerLedger(renter, token, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Receivable.Expected.Int64() != 3000 || l.Receivable.Paid.Int64() != 1500 || l.Receivable.Outstanding.Int64() != 500 {
+		t.Fatalf("unexpected reconciliation %+v", l.Receivable)
+	}
+	if l.Unallocated.Int64() != 7 {
+		t.Fatalf("expect 7 unallocated, got %s", l.Unallocated)
+	}
+	if underPaid := l.Receivable.UnderPaid(); len(underPaid) != 2 || underPaid[0].ContractId != "c2" || !underPaid[1].WrittenOff {
+		t.Fatalf("unexpected under paid contracts %+v", underPaid)
+	}
+	if l.InArrears {
+		t.Fatal("in arrears before the grace is over")
+	}
+
+	// within the grace, the credit limit counts the outstanding payments
+	if err := acc.CheckCredit(renter, big.NewInt(2000), token, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.CheckCredit(renter, big.NewInt(2001), token, p); !errors.Is(err, accounting.ErrCreditLimit) {
+		t.Fatalf("expect credit limit error, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := acc.CheckCredit(renter, big.NewInt(1), token, p); !errors.Is(err, accounting.ErrInArrears) {
+		t.Fatalf("expect arrears error, got %v", err)
+	}
+	if err := acc.NotifyPaymentReceived(renter, big.NewInt(500), "c2", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.CheckCredit(renter, big.NewInt(1), token, p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccountingPayableContract(t *testing.T) {
+	store := mock.NewStateStore()
+	defer store.Close()
+
+	token := common.HexToAddress("aaaa")
+	now := time.Unix(1000000, 0)
+
+	acc, err := accounting.NewAccounting(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.SetTimeNow(func() time.Time { return now })
+	sendErr := errors.New("error")
+	acc.SetPayFunc(func(ctx context.Context, peer string, amount *big.Int, contractId string, token common.Address) {
+		acc.NotifyPaymentSent(peer, amount, contractId, sendErr, token)
+	})
+
+	host := peer.ID("00112233").String()
+	if _, err := acc.PayableContract(host, "c1"); err == nil {
+		t.Fatal("expect no ledger before the contract is settled")
+	}
+
+	// a failed payment is owed, but not sent
+	if err := acc.Settle(host, big.NewInt(1000), "c1", token); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the payment
+	acc.Close()
+	c, err := acc.PayableContract(host, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Expected.Int64() != 1000 || c.Paid.Int64() != 0 || c.Sent != 0 || c.Error == "" {
+		t.Fatalf("unexpected ledger %+v", c)
+	}
+
+	// the payment settled again is owed again, and recorded sent when it is
+	now = now.Add(time.Minute)
+	sendErr = nil
+	if err := acc.Settle(host, big.NewInt(1000), "c1", token); err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	c, err = acc.PayableContract(host, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Expected.Int64() != 2000 || c.Paid.Int64() != 1000 || c.Sent != now.Unix() || c.Error != "" {
+		t.Fatalf("unexpected ledger %+v", c)
+	}
+}
+
+func TestAccountingReconcilePayment(t *testing.T) {
+	store := mock.NewStateStore()
+	defer store.Close()
+
+	token := common.HexToAddress("aaaa")
+	now := time.Unix(1000000, 0)
+
+	acc, err := accounting.NewAccounting(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.SetTimeNow(func() time.Time { return now })
+	host := peer.ID("00112233").String()
+
+	// nothing is pending for a contract never paid
+	if sent, err := acc.ReconcilePayment(host, "c1", big.NewInt(1000)); err != nil || sent {
+		t.Fatalf("unexpected reconciliation %v, %v", sent, err)
+	}
+
+	// the cheque of the payment is recorded before it goes out
+	if err := acc.NotifyPaymentSending(host, big.NewInt(100), "c1", big.NewInt(300), token); err != nil {
+		t.Fatal(err)
+	}
+	// the vault did not issue it
+	if sent, err := acc.ReconcilePayment(host, "c1", big.NewInt(200)); err != nil || sent {
+		t.Fatalf("unexpected reconciliation %v, %v", sent, err)
+	}
+	// the vault issued it
+	if sent, err := acc.ReconcilePayment(host, "c1", big.NewInt(300)); err != nil || !sent {
+		t.Fatalf("unexpected reconciliation %v, %v", sent, err)
+	}
+	c, err := acc.PayableContract(host, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Paid.Int64() != 100 || c.Sent != now.Unix() || c.Pending != nil {
+		t.Fatalf("unexpected ledger %+v", c)
+	}
+	// and it is not recorded twice
+	if sent, err := acc.ReconcilePayment(host, "c1", big.NewInt(300)); err != nil || sent {
+		t.Fatalf("unexpected reconciliation %v, %v", sent, err)
+	}
+
+	// a payment that completes is no longer pending
+	acc.SetPayFunc(func(ctx context.Context, peer string, amount *big.Int, contractId string, token common.Address) {
+		if err := acc.NotifyPaymentSending(peer, amount, contractId, big.NewInt(400), token); err != nil {
+			t.Error(err)
+		}
+		acc.NotifyPaymentSent(peer, amount, contractId, nil, token)
+	})
+	if err := acc.Settle(host, big.NewInt(100), "c2", token); err != nil {
+		t.Fatal(err)
+	}
+	acc.Close()
+	c, err = acc.PayableContract(host, "c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Paid.Int64() != 100 || c.Pending != nil {
+		t.Fatalf("unexpected ledger %+v", c)
+	}
+}
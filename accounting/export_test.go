@@ -0,0 +1,11 @@
+// Copyright 2020 The Btfs Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package accounting
+
+import "time"
+
+func (a *Accounting) SetTimeNow(f func() time.Time) {
+	a.timeNow = f
+}
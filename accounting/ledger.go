@@ -0,0 +1,357 @@
+// Copyright 2020 The Btfs Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package accounting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bittorrent/go-btfs/transaction/storage"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// DefaultPaymentGrace is how long a peer has to pay for a contract before
+	// the payment is overdue, when the credit policy does not set it.
+	DefaultPaymentGrace = time.Hour
+
+	receivablePrefix  = "accounting_receivable_"
+	payablePrefix     = "accounting_payable_"
+	unallocatedPrefix = "accounting_unallocated_"
+)
+
+var (
+	// ErrInArrears is the error if a peer is overdue with the payment of contracts.
+	ErrInArrears = errors.New("peer is in arrears")
+	// ErrCreditLimit is the error if a peer would owe more than its credit limit.
+	ErrCreditLimit = errors.New("peer is over its credit limit")
+)
+
+// ContractLedger is what is owed for a contract and what was paid for it.
+type ContractLedger struct {
+	ContractId string
+	Peer       string
+	Token      common.Address
+	Expected   *big.Int
+	Paid       *big.Int
+	// Created is when the first payment for the contract was expected, Updated
+	// when the ledger last changed, in unix seconds.
+	Created int64
+	Updated int64
+	// Sent is when a payment for the contract was last sent, in unix seconds.
+	Sent int64 `json:",omitempty"`
+	// Pending is the cheque going out for the contract, until its payment is
+	// recorded as sent or failed.
+	Pending *PendingCheque `json:",omitempty"`
+	// WrittenOff is set when the payment is no longer expected, e.g. because
+	// the shard of the contract was removed.
+	WrittenOff bool `json:",omitempty"`
+	// Error is the last failure to pay for the contract.
+	Error string `json:",omitempty"`
+}
+
+// PendingCheque is a cheque about to be sent in payment of a contract.
+type PendingCheque struct {
+	Amount *big.Int
+	// CumulativePayout is the cumulative payout of the cheque to the peer.
+	CumulativePayout *big.Int
+}
+
+// Outstanding returns how much of the expected payment is not paid yet.
+func (c *ContractLedger) Outstanding() *big.Int {
+	outstanding := new(big.Int).Sub(c.Expected, c.Paid)
+	if outstanding.Sign() < 0 || c.WrittenOff {
+		return big.NewInt(0)
+	}
+	return outstanding
+}
+
+// Reconciliation sums up the contracts with a peer in a token.
+type Reconciliation struct {
+	Expected    *big.Int
+	Paid        *big.Int
+	Outstanding *big.Int
+	// Overdue is the outstanding amount of the contracts past the payment grace.
+	Overdue   *big.Int
+	Contracts []*ContractLedger
+}
+
+// UnderPaid returns the contracts with an outstanding payment, written off
+// ones included.
+func (r *Reconciliation) UnderPaid() []*ContractLedger {
+	var contracts []*ContractLedger
+	for _, c := range r.Contracts {
+		if c.Paid.Cmp(c.Expected) < 0 {
+			contracts = append(contracts, c)
+		}
+	}
+	return contracts
+}
+
+// PeerLedger is the balance with a peer in a token.
+type PeerLedger struct {
+	Peer  string
+	Token common.Address
+	// Receivable is what the peer owes us for its contracts, Payable what we
+	// owe the peer for ours.
+	Receivable *Reconciliation
+	Payable    *Reconciliation
+	// Unallocated is what the peer paid us outside of any contract.
+	Unallocated *big.Int
+	CreditLimit *big.Int `json:",omitempty"`
+	InArrears   bool
+}
+
+// CreditPolicy sets how much credit peers get before contracts are refused.
+type CreditPolicy struct {
+	// Limits is the most a peer may owe in each token. Tokens without a limit
+	// have no limit on the outstanding amount.
+	Limits map[common.Address]*big.Int
+	// Grace is how long a peer has to pay for a contract.
+	Grace time.Duration
+}
+
+func (p *CreditPolicy) grace() time.Duration {
+	if p == nil || p.Grace <= 0 {
+		return DefaultPaymentGrace
+	}
+	return p.Grace
+}
+
+func (p *CreditPolicy) limit(token common.Address) *big.Int {
+	if p == nil {
+		return nil
+	}
+	return p.Limits[token]
+}
+
+func receivableKey(peer, contractId string) string {
+	return fmt.Sprintf("%s%s_%s", receivablePrefix, peer, contractId)
+}
+
+func payableKey(peer, contractId string) string {
+	return fmt.Sprintf("%s%s_%s", payablePrefix, peer, contractId)
+}
+
+func unallocatedKey(peer string, token common.Address) string {
+	return fmt.Sprintf("%s%s_%s", unallocatedPrefix, peer, token)
+}
+
+// updateContract applies f to the ledger of a contract, creating it when it is
+// not found. The lock on the accountingPeer must be held when called.
+func (a *Accounting) updateContract(key, peer, contractId string, token common.Address, f func(c *ContractLedger)) error {
+	now := a.timeNow().Unix()
+	c := new(ContractLedger)
+	err := a.store.Get(key, c)
+	if err == storage.ErrNotFound {
+		c = &ContractLedger{
+			ContractId: contractId,
+			Peer:       peer,
+			Token:      token,
+			Expected:   big.NewInt(0),
+			Paid:       big.NewInt(0),
+			Created:    now,
+		}
+	} else if err != nil {
+		return err
+	}
+	f(c)
+	c.Updated = now
+	return a.store.Put(key, c)
+}
+
+// ExpectPayment records that the peer owes amount for a contract, e.g. when the
+// host signs it.
+func (a *Accounting) ExpectPayment(peer string, contractId string, amount *big.Int, token common.Address) error {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	return a.updateContract(receivableKey(peer, contractId), peer, contractId, token, func(c *ContractLedger) {
+		c.Expected.Add(c.Expected, amount)
+		c.WrittenOff = false
+	})
+}
+
+// WriteOff records that the outstanding payment of a contract is no longer
+// expected. It is still listed, but does not count against the peer.
+func (a *Accounting) WriteOff(peer string, contractId string) error {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	c := new(ContractLedger)
+	if err := a.store.Get(receivableKey(peer, contractId), c); err != nil {
+		return err
+	}
+	c.WrittenOff = true
+	c.Updated = a.timeNow().Unix()
+	return a.store.Put(receivableKey(peer, contractId), c)
+}
+
+// PayableContract returns the ledger of what we owe the peer for a contract, or
+// storage.ErrNotFound if nothing was settled for it.
+func (a *Accounting) PayableContract(peer string, contractId string) (*ContractLedger, error) {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	c := new(ContractLedger)
+	if err := a.store.Get(payableKey(peer, contractId), c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReconcilePayment records the pending cheque of a contract as sent if the
+// last cheque issued to the peer pays out at least as much, i.e. the payment
+// went out but was not recorded. It tells if the payment of the contract was
+// sent.
+func (a *Accounting) ReconcilePayment(peer string, contractId string, lastIssued *big.Int) (bool, error) {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	c := new(ContractLedger)
+	err := a.store.Get(payableKey(peer, contractId), c)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if c.Pending == nil || lastIssued.Cmp(c.Pending.CumulativePayout) < 0 {
+		return false, nil
+	}
+	now := a.timeNow().Unix()
+	c.Paid.Add(c.Paid, c.Pending.Amount)
+	c.Pending = nil
+	c.Sent = now
+	c.Updated = now
+	c.Error = ""
+	return true, a.store.Put(payableKey(peer, contractId), c)
+}
+
+// contracts returns the ledgers of the contracts with the peer in the token,
+// oldest first.
+func (a *Accounting) contracts(prefix, peer string, token common.Address) ([]*ContractLedger, error) {
+	var contracts []*ContractLedger
+	peerPrefix := prefix + peer + "_"
+	err := a.store.Iterate(peerPrefix, func(key, value []byte) (bool, error) {
+		if !strings.HasPrefix(string(key), peerPrefix) {
+			return true, nil
+		}
+		c := new(ContractLedger)
+		if err := json.Unmarshal(value, c); err != nil {
+			return true, err
+		}
+		if c.Token == token {
+			contracts = append(contracts, c)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		if contracts[i].Created != contracts[j].Created {
+			return contracts[i].Created < contracts[j].Created
+		}
+		return contracts[i].ContractId < contracts[j].ContractId
+	})
+	return contracts, nil
+}
+
+func (a *Accounting) reconcile(prefix, peer string, token common.Address, grace time.Duration) (*Reconciliation, error) {
+	contracts, err := a.contracts(prefix, peer, token)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reconciliation{
+		Expected:    big.NewInt(0),
+		Paid:        big.NewInt(0),
+		Outstanding: big.NewInt(0),
+		Overdue:     big.NewInt(0),
+		Contracts:   contracts,
+	}
+	due := a.timeNow().Add(-grace).Unix()
+	for _, c := range contracts {
+		r.Expected.Add(r.Expected, c.Expected)
+		r.Paid.Add(r.Paid, c.Paid)
+		outstanding := c.Outstanding()
+		r.Outstanding.Add(r.Outstanding, outstanding)
+		if c.Created <= due {
+			r.Overdue.Add(r.Overdue, outstanding)
+		}
+	}
+	return r, nil
+}
+
+// PeerLedger reconciles the payments expected from and to the peer in the
+// token against the payments made.
+func (a *Accounting) PeerLedger(peer string, token common.Address, p *CreditPolicy) (*PeerLedger, error) {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	return a.peerLedger(peer, token, p)
+}
+
+func (a *Accounting) peerLedger(peer string, token common.Address, p *CreditPolicy) (*PeerLedger, error) {
+	receivable, err := a.reconcile(receivablePrefix, peer, token, p.grace())
+	if err != nil {
+		return nil, err
+	}
+	payable, err := a.reconcile(payablePrefix, peer, token, p.grace())
+	if err != nil {
+		return nil, err
+	}
+	unallocated := big.NewInt(0)
+	if err := a.store.Get(unallocatedKey(peer, token), &unallocated); err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	return &PeerLedger{
+		Peer:        peer,
+		Token:       token,
+		Receivable:  receivable,
+		Payable:     payable,
+		Unallocated: unallocated,
+		CreditLimit: p.limit(token),
+		InArrears:   receivable.Overdue.Sign() > 0,
+	}, nil
+}
+
+// CheckCredit checks the peer may owe amount more in the token: it must not be
+// in arrears, and stay within its credit limit.
+func (a *Accounting) CheckCredit(peer string, amount *big.Int, token common.Address, p *CreditPolicy) error {
+	accountingPeer := a.getAccountingPeer(peer)
+
+	accountingPeer.lock.Lock()
+	defer accountingPeer.lock.Unlock()
+
+	l, err := a.peerLedger(peer, token, p)
+	if err != nil {
+		return err
+	}
+	if l.InArrears {
+		return fmt.Errorf("%w: %s overdue", ErrInArrears, l.Receivable.Overdue)
+	}
+	if l.CreditLimit != nil {
+		owed := new(big.Int).Add(l.Receivable.Outstanding, amount)
+		if owed.Cmp(l.CreditLimit) > 0 {
+			return fmt.Errorf("%w: would owe %s, the limit is %s", ErrCreditLimit, owed, l.CreditLimit)
+		}
+	}
+	return nil
+}
@@ -62,6 +62,8 @@ type SettleInfo struct {
 	SwapService    *swap.Service
 	OracleService  priceoracle.Service
 	BttcService    bttc.Service
+	AutoCashout    *vault.AutoCashoutService
+	Accounting     *accounting.Accounting
 }
 
 // InitChain will initialize the Ethereum backend at the given endpoint and
@@ -216,6 +218,9 @@ func InitSettlement(
 		SwapService:    swapService,
 		OracleService:  priceOracleService,
 		BttcService:    bttcService,
+		AutoCashout: vault.NewAutoCashoutService(stateStore, chaininfo.Backend, cashoutService, chequeStore,
+			priceOracleService, vaultService.Address()),
+		Accounting: accounting,
 	}
 
 	return &SettleObject, nil
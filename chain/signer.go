@@ -0,0 +1,130 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bittorrent/go-btfs/repo"
+	rcommon "github.com/bittorrent/go-btfs/repo/common"
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+	"github.com/bittorrent/go-btfs/transaction/crypto/clef"
+	"github.com/bittorrent/go-btfs/transaction/crypto/remote"
+
+	config "github.com/bittorrent/go-btfs-config"
+	serialize "github.com/bittorrent/go-btfs-config/serialize"
+	"github.com/ethereum/go-ethereum/accounts/external"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	signerConfigKey = "Signer"
+
+	// SignerLocal signs with the node key from the config.
+	SignerLocal = "local"
+	// SignerClef signs with an account of clef, over its ipc endpoint.
+	SignerClef = "clef"
+	// SignerRemote signs with a remote signer, over http.
+	SignerRemote = "remote"
+)
+
+// SignerConfig selects the signer of cheques and chain transactions. With an
+// external signer the bttc address of the node, and so its vault, is the one of
+// the external account, and the node key does not have to hold any funds.
+type SignerConfig struct {
+	// Type is one of local, clef or remote, local if it is not set.
+	Type string
+	// Endpoint is the ipc path of clef, its default path if it is not set, or
+	// the url of the remote signer.
+	Endpoint string
+	// Address is the account to sign with. Clef uses its first account if it is
+	// not set, a remote signer must sign with it if set.
+	Address string
+	// Token is sent to the remote signer as a bearer token.
+	Token string
+}
+
+// LoadSignerConfig reads the signer config of the repo. Unlike other optional
+// config a malformed one is an error, since falling back to the node key would
+// switch the node to another address.
+func LoadSignerConfig(r repo.Repo) (*SignerConfig, error) {
+	return decodeSignerConfig(r.GetConfigKey(signerConfigKey))
+}
+
+// LoadSignerConfigFile reads the signer config from the config file of the repo
+// at root, for commands that run without the repo.
+func LoadSignerConfigFile(root string) (*SignerConfig, error) {
+	filename, err := config.Filename(root)
+	if err != nil {
+		return nil, err
+	}
+	var cfg map[string]interface{}
+	if err := serialize.ReadConfigFile(filename, &cfg); err != nil {
+		return nil, err
+	}
+	return decodeSignerConfig(rcommon.MapGetKV(cfg, signerConfigKey))
+}
+
+func decodeSignerConfig(v interface{}, err error) (*SignerConfig, error) {
+	c := new(SignerConfig)
+	if err != nil {
+		// not set
+		return c, nil
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s config: %w", signerConfigKey, err)
+	}
+	return c, nil
+}
+
+// NewSigner returns the signer the config selects, local is the signer of the
+// node key.
+func NewSigner(c *SignerConfig, local crypto.Signer) (crypto.Signer, error) {
+	var address *common.Address
+	if c.Address != "" {
+		if !common.IsHexAddress(c.Address) {
+			return nil, fmt.Errorf("invalid signer address %q", c.Address)
+		}
+		a := common.HexToAddress(c.Address)
+		address = &a
+	}
+
+	switch strings.ToLower(c.Type) {
+	case "", SignerLocal:
+		return local, nil
+	case SignerClef:
+		endpoint := c.Endpoint
+		if endpoint == "" {
+			var err error
+			endpoint, err = clef.DefaultIpcPath()
+			if err != nil {
+				return nil, err
+			}
+		}
+		externalSigner, err := external.NewExternalSigner(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("connect to clef at %s: %w", endpoint, err)
+		}
+		rpcClient, err := rpc.Dial(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("connect to clef at %s: %w", endpoint, err)
+		}
+		signer, err := clef.NewSigner(externalSigner, rpcClient, crypto.Recover, address)
+		if err != nil {
+			return nil, fmt.Errorf("clef signer: %w", err)
+		}
+		return signer, nil
+	case SignerRemote:
+		if c.Endpoint == "" {
+			return nil, fmt.Errorf("no endpoint of the remote signer")
+		}
+		return remote.NewSigner(c.Endpoint, c.Token, address)
+	default:
+		return nil, fmt.Errorf("unknown signer type %q", c.Type)
+	}
+}
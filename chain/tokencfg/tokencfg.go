@@ -82,6 +82,12 @@ func IsWBTT(token common.Address) bool {
 	return token == MpTokenAddr["WBTT"]
 }
 
+// IsWBTTOfAnyChain reports whether the token is WBTT on the online or the test
+// chain, for when the chain is not known yet.
+func IsWBTTOfAnyChain(token common.Address) bool {
+	return token == common.HexToAddress(bttcWBTTHex) || token == common.HexToAddress(bttcTestWBTTHex)
+}
+
 func AddToken(s string, token common.Address) string {
 	if token == MpTokenAddr["WBTT"] {
 		return s
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
@@ -44,9 +45,21 @@ func GetBttcNonDaemon(env cmds.Environment) (defaultAddr string, _err error) {
 		return defaultAddr, err
 	}
 
+	// with an external signer, the bttc address is the one of its account
+	signerCfg, err := LoadSignerConfigFile(cctx.ConfigRoot)
+	if err != nil {
+		return defaultAddr, err
+	}
+	if t := strings.ToLower(signerCfg.Type); t != "" && t != SignerLocal && common.IsHexAddress(signerCfg.Address) {
+		return common.HexToAddress(signerCfg.Address).Hex(), nil
+	}
+
 	//new singer
 	pk := cpt.Secp256k1PrivateKeyFromBytes(pkbytesOri[4:])
-	singer := cpt.NewDefaultSigner(pk)
+	singer, err := NewSigner(signerCfg, cpt.NewDefaultSigner(pk))
+	if err != nil {
+		return defaultAddr, err
+	}
 
 	address0x, err := singer.EthereumAddress()
 	if err != nil {
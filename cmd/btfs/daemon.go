@@ -36,6 +36,7 @@ import (
 	utilmain "github.com/bittorrent/go-btfs/cmd/btfs/util"
 	oldcmds "github.com/bittorrent/go-btfs/commands"
 	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/bittorrent"
 	commands "github.com/bittorrent/go-btfs/core/commands"
 	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
 	"github.com/bittorrent/go-btfs/core/commands/storage/path"
@@ -388,7 +389,22 @@ If the user need to start multiple nodes on the same machine, the configuration
 	}
 	//new singer
 	pk := crypto.Secp256k1PrivateKeyFromBytes(pkbytesOri[4:])
-	singer := crypto.NewDefaultSigner(pk)
+	localSinger := crypto.NewDefaultSigner(pk)
+
+	// cheques and transactions may be signed off the node, e.g. by clef
+	signerCfg, err := chain.LoadSignerConfig(repo)
+	if err != nil {
+		return err
+	}
+	singer, err := chain.NewSigner(signerCfg, localSinger)
+	if err != nil {
+		return err
+	}
+	// the node key is not the key of the bttc address with an external signer
+	bttcPrivateKey := ""
+	if singer == localSinger {
+		bttcPrivateKey = hex.EncodeToString(pkbytesOri[4:])
+	}
 
 	address0x, _ := singer.EthereumAddress()
 
@@ -404,7 +420,7 @@ If the user need to start multiple nodes on the same machine, the configuration
 			BtfsVersion: version.CurrentVersionNumber,
 			HostID:      cfg.Identity.PeerID,
 			BttcAddress: address0x.String(),
-			PrivateKey:  hex.EncodeToString(pkbytesOri[4:]),
+			PrivateKey:  bttcPrivateKey,
 		})
 		guide.StartServer()
 		defer guide.TryShutdownServer()
@@ -671,6 +687,12 @@ If the user need to start multiple nodes on the same machine, the configuration
 		return err
 	}
 
+	// unpin the pins that were added with a duration once they expire
+	reapErrc := runPinReaper(req, node)
+
+	// seed the content shared to the bittorrent network
+	startSeeder(req, env, node)
+
 	// construct http gateway
 	gwErrc, err := serveHTTPGateway(req, cctx)
 	if err != nil {
@@ -706,6 +728,9 @@ If the user need to start multiple nodes on the same machine, the configuration
 	fmt.Printf("Daemon is ready\n")
 	notifyReady()
 
+	// resume moving the repo to a new path, if the daemon stopped meanwhile
+	path.ResumeMigration(cctx.ConfigRoot)
+
 	runStartupTest, _ := req.Options[enableStartupTest].(bool)
 
 	// BTFS functional test
@@ -720,6 +745,8 @@ If the user need to start multiple nodes on the same machine, the configuration
 		}
 		// Spin jobs in the background
 		spin.RenterSessions(req, env)
+		spin.Renewals(req, env)
+		spin.Audits(req, env)
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
@@ -728,6 +755,7 @@ If the user need to start multiple nodes on the same machine, the configuration
 		spin.Analytics(api, cctx.ConfigRoot, node, version.CurrentVersionNumber, hValue)
 		spin.Hosts(node, env)
 		spin.Contracts(node, req, env, nodepb.ContractStat_HOST.String())
+		spin.AutoCashout(node)
 	}
 
 	// Give the user some immediate feedback when they hit C-c
@@ -741,7 +769,7 @@ If the user need to start multiple nodes on the same machine, the configuration
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, rapiErrc, gcErrc) {
+	for err := range merge(apiErrc, gwErrc, rapiErrc, gcErrc, reapErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -1158,6 +1186,32 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	return errc, nil
 }
 
+func runPinReaper(req *cmds.Request, node *core.IpfsNode) <-chan error {
+	errc := make(chan error)
+	go func() {
+		errc <- corerepo.PeriodicPinReaper(req.Context, node)
+		close(errc)
+	}()
+	return errc
+}
+
+// startSeeder resumes seeding btfs content to the bittorrent network, the torrent
+// client is only started when there is content to seed. Seeding is not essential
+// to the node, so the daemon keeps running if it can not start.
+func startSeeder(req *cmds.Request, env cmds.Environment, node *core.IpfsNode) {
+	api, err := cmdenv.GetApi(env, req)
+	if err != nil {
+		log.Errorf("start bittorrent seeder: %v", err)
+		return
+	}
+	seeder, err := bittorrent.StartSeeder(req.Context, node, api)
+	if err != nil {
+		log.Errorf("start bittorrent seeder: %v", err)
+		return
+	}
+	node.Process.AddChild(goprocess.WithTeardown(seeder.Close))
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
@@ -0,0 +1,101 @@
+// package main provides a stand-in for a remote signer, e.g. a key management
+// service. It serves the protocol of the transaction/crypto/remote package with
+// a local key, so that a btfs node configured with
+//
+//	btfs config --json Signer '{"Type": "remote", "Endpoint": "http://<host>:<port>", "Token": "<token>"}'
+//
+// signs its cheques and chain transactions without the key on the node.
+// Usage:
+//
+//	remote-signer [-listen <address>] [-key-file <file>] [-token <token>]
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+	"github.com/bittorrent/go-btfs/transaction/crypto/remote"
+)
+
+const (
+	keyEnv   = "REMOTE_SIGNER_KEY"
+	tokenEnv = "REMOTE_SIGNER_TOKEN"
+)
+
+// Usage prints out the usage of this module.
+// Assumes flags use go stdlib flag package.
+var Usage = func() {
+	text := `remote-signer - stand-in remote signer for btfs
+
+Usage:
+
+  %s [-listen <address>] [-key-file <file>] [-token <token>]
+
+The secp256k1 key is read in hex from the key file, or from $%s. Without a key
+a new one is generated, which is lost on exit. The token can also be set with
+$%s.
+`
+
+	fmt.Fprintf(os.Stderr, text, os.Args[0], keyEnv, tokenEnv)
+	flag.PrintDefaults()
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:5050", "address to listen on")
+	keyFile := flag.String("key-file", "", "file with the hex private key")
+	token := flag.String("token", os.Getenv(tokenEnv), "bearer token required from clients")
+	flag.Usage = Usage
+	flag.Parse()
+
+	if err := run(*listen, *keyFile, *token); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(listen, keyFile, token string) error {
+	keyHex := os.Getenv(keyEnv)
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		keyHex = string(b)
+	}
+
+	var signer crypto.Signer
+	if keyHex = strings.TrimPrefix(strings.TrimSpace(keyHex), "0x"); keyHex != "" {
+		b, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return fmt.Errorf("decode key: %w", err)
+		}
+		key, err := crypto.DecodeSecp256k1PrivateKey(b)
+		if err != nil {
+			return err
+		}
+		signer = crypto.NewDefaultSigner(key)
+	} else {
+		key, err := crypto.GenerateSecp256k1Key()
+		if err != nil {
+			return err
+		}
+		signer = crypto.NewDefaultSigner(key)
+		fmt.Println("no key given, signing with a new key")
+	}
+
+	address, err := signer.EthereumAddress()
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		fmt.Println("no token set, any client may sign")
+	}
+	fmt.Printf("signing for %s on http://%s\n", address, listen)
+	return http.ListenAndServe(listen, remote.NewHandler(signer, token))
+}
@@ -0,0 +1,234 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+	ds "github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("bittorrent")
+
+var (
+	// GotInfoTimeout is how long to wait for the metainfo of a magnet link.
+	GotInfoTimeout = 5 * time.Minute
+	// ProgressInterval is how often the progress of an import is reported.
+	ProgressInterval = 2 * time.Second
+	// AddAttempts is how many times the downloaded torrent is added, when the
+	// garbage collector removes some of its pieces before they are pinned.
+	AddAttempts = 3
+)
+
+// Source is the torrent to import, either from a torrent file or a magnet link.
+type Source struct {
+	MetaInfo *metainfo.MetaInfo
+	Magnet   string
+}
+
+func (s *Source) infoHash() (metainfo.Hash, error) {
+	if s.MetaInfo != nil {
+		return s.MetaInfo.HashInfoBytes(), nil
+	}
+	if s.Magnet == "" {
+		return metainfo.Hash{}, errors.New("either a torrent file or a magnet uri must be provided")
+	}
+	m, err := metainfo.ParseMagnetUri(s.Magnet)
+	if err != nil {
+		return metainfo.Hash{}, err
+	}
+	return m.InfoHash, nil
+}
+
+// Progress reports the state of a torrent import.
+type Progress struct {
+	InfoHash        string
+	Name            string
+	BytesCompleted  int64
+	Length          int64
+	PiecesCompleted int
+	NumPieces       int
+}
+
+// Import downloads a torrent straight into the blockstore of the node, and adds it as
+// a pinned UnixFS node with the file layout of the torrent. Completed pieces are kept
+// across interruptions, so importing the same torrent again resumes the download.
+func Import(ctx context.Context, n *core.IpfsNode, api coreiface.CoreAPI, src *Source,
+	progress func(*Progress)) (*TorrentRecord, error) {
+	ih, err := src.infoHash()
+	if err != nil {
+		return nil, err
+	}
+	d := n.Repo.Datastore()
+	rec, err := GetTorrentRecord(ctx, d, ih)
+	if err != nil && err != ds.ErrNotFound {
+		return nil, err
+	}
+	if rec != nil && rec.Root != "" {
+		return rec, nil
+	}
+
+	st := &blockStorage{ctx: ctx, bs: n.Blockstore, d: d}
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.ListenPort = 0
+	cfg.DefaultStorage = st
+	client, err := torrent.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating client: %w", err)
+	}
+	defer client.Close()
+
+	var t *torrent.Torrent
+	if src.MetaInfo != nil {
+		t, err = client.AddTorrent(src.MetaInfo)
+	} else {
+		t, err = client.AddMagnet(src.Magnet)
+		if err == nil && rec != nil {
+			err = t.SetInfoBytes(rec.InfoBytes)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("adding torrent: %w", err)
+	}
+
+	select {
+	case <-t.GotInfo():
+	case <-time.After(GotInfoTimeout):
+		return nil, errors.New("get metainfo timeout, the torrent can not be found")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if rec == nil {
+		rec = &TorrentRecord{
+			InfoHash:  ih.HexString(),
+			Name:      t.Name(),
+			Length:    t.Length(),
+			InfoBytes: t.Metainfo().InfoBytes,
+			CreatedAt: time.Now(),
+		}
+		if err := SaveTorrentRecord(ctx, d, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	t.DownloadAll()
+	report := func() {
+		if progress == nil {
+			return
+		}
+		progress(&Progress{
+			InfoHash:        rec.InfoHash,
+			Name:            rec.Name,
+			BytesCompleted:  t.BytesCompleted(),
+			Length:          t.Length(),
+			PiecesCompleted: t.Stats().PiecesComplete,
+			NumPieces:       t.NumPieces(),
+		})
+	}
+
+	info := t.Info()
+	bt := &blockTorrent{blockStorage: st, info: info, ih: rec.InfoHash, blockSize: BlockSize(info)}
+	// The pieces are not locked against the garbage collector while they are
+	// downloaded, Add takes the pin lock itself. Pieces collected before they
+	// are pinned are verified again and downloaded again.
+	var p path.Resolved
+	for attempt := 1; ; attempt++ {
+		if err := waitComplete(ctx, t, report); err != nil {
+			return nil, err
+		}
+		// raw leaves of the same size as the blocks of the pieces, so that the blocks
+		// of pieces that line up with the files are shared.
+		p, err = api.Unixfs().Add(ctx, torrentNode(info, bt),
+			options.Unixfs.Pin(true),
+			options.Unixfs.RawLeaves(true),
+			options.Unixfs.CidVersion(1),
+			options.Unixfs.Chunker(fmt.Sprintf("size-%d", bt.blockSize)))
+		if err == nil {
+			break
+		}
+		dropped, derr := bt.dropMissingPieces()
+		if derr != nil || dropped == 0 || attempt >= AddAttempts {
+			return nil, fmt.Errorf("adding torrent %s: %w", rec.Name, err)
+		}
+		log.Infof("%d pieces of torrent %s were garbage collected before they were added, downloading them again",
+			dropped, rec.InfoHash)
+		t.VerifyData()
+	}
+	rec.Root = p.Cid().String()
+	rec.CompletedAt = time.Now()
+	if err := SaveTorrentRecord(ctx, d, rec); err != nil {
+		return nil, err
+	}
+	if err := bt.cleanup(); err != nil {
+		log.Warnf("clean up pieces of torrent %s: %v", rec.InfoHash, err)
+	}
+	return rec, nil
+}
+
+// waitComplete waits for the torrent to be downloaded, reporting the progress.
+func waitComplete(ctx context.Context, t *torrent.Torrent, report func()) error {
+	tick := time.NewTicker(ProgressInterval)
+	defer tick.Stop()
+	for {
+		report()
+		select {
+		case <-t.Complete.On():
+			report()
+			return nil
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// torrentNode lays out the data of a torrent as a UnixFS file, or a directory of
+// the files of the torrent.
+func torrentNode(info *metainfo.Info, r io.ReaderAt) files.Node {
+	if !info.IsDir() {
+		return files.NewReaderFile(io.NewSectionReader(r, 0, info.TotalLength()))
+	}
+	root := make(dirTree)
+	var offset int64
+	for _, fi := range info.UpvertedFiles() {
+		path := fi.BestPath()
+		dir := root
+		for _, name := range path[:len(path)-1] {
+			sub, ok := dir[name].(dirTree)
+			if !ok {
+				sub = make(dirTree)
+				dir[name] = sub
+			}
+			dir = sub
+		}
+		dir[path[len(path)-1]] = files.NewReaderFile(io.NewSectionReader(r, offset, fi.Length))
+		offset += fi.Length
+	}
+	return root.node()
+}
+
+type dirTree map[string]interface{}
+
+func (t dirTree) node() files.Node {
+	m := make(map[string]files.Node, len(t))
+	for name, v := range t {
+		switch v := v.(type) {
+		case dirTree:
+			m[name] = v.node()
+		case files.Node:
+			m[name] = v
+		}
+	}
+	return files.NewMapDirectory(m)
+}
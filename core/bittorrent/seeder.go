@@ -0,0 +1,514 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+	cid "github.com/ipfs/go-cid"
+)
+
+const (
+	minListenPort = 30000
+	maxListenPort = 31000
+)
+
+// DefaultTrackers are announced in the torrents seeded from BTFS.
+var DefaultTrackers = []string{
+	"wss://tracker.btorrent.xyz",
+	"wss://tracker.openwebtorrent.com",
+	"http://p4p.arenabg.com:1337/announce",
+	"udp://tracker.opentrackr.org:1337/announce",
+	"udp://tracker.openbittorrent.com:6969/announce",
+}
+
+// ErrSeederNotRunning is returned when seeding is requested from a node that is not a daemon.
+var ErrSeederNotRunning = errors.New("seeding requires a running daemon")
+
+var seeders = struct {
+	sync.Mutex
+	m map[*core.IpfsNode]*Seeder
+}{m: make(map[*core.IpfsNode]*Seeder)}
+
+// Seeder seeds BTFS content to the BitTorrent network. Its pieces are read from
+// the DAG of the content, so nothing is exported to disk.
+type Seeder struct {
+	ctx context.Context
+	n   *core.IpfsNode
+	api coreiface.CoreAPI
+
+	// the torrent client is started with the first seeded torrent
+	clientMu sync.Mutex
+	client   *torrent.Client
+	closed   bool
+
+	// seedMu serializes adding torrents, mu guards the maps
+	seedMu   sync.Mutex
+	mu       sync.Mutex
+	records  map[metainfo.Hash]*SeedRecord
+	torrents map[metainfo.Hash]*torrent.Torrent
+}
+
+// SeedStatus is a seeded torrent along with its swarm statistics.
+type SeedStatus struct {
+	*SeedRecord
+	Magnet        string
+	Peers         int
+	BytesUploaded int64
+}
+
+// StartSeeder registers the seeder of the daemon node. The torrent client, and
+// its listening port, is only started once there is content to seed: right away
+// for the torrents seeded before the daemon was restarted, else on the first Seed.
+func StartSeeder(ctx context.Context, n *core.IpfsNode, api coreiface.CoreAPI) (*Seeder, error) {
+	s := &Seeder{
+		ctx:      ctx,
+		n:        n,
+		api:      api,
+		records:  make(map[metainfo.Hash]*SeedRecord),
+		torrents: make(map[metainfo.Hash]*torrent.Torrent),
+	}
+	records, err := ListSeedRecords(ctx, n.Repo.Datastore())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if err := s.add(r); err != nil {
+			log.Errorf("resume seeding %s (%s): %v", r.Name, r.Root, err)
+		}
+	}
+
+	seeders.Lock()
+	seeders.m[n] = s
+	seeders.Unlock()
+	return s, nil
+}
+
+// torrentClient returns the torrent client, starting it on the first call.
+func (s *Seeder) torrentClient() (*torrent.Client, error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.closed {
+		return nil, ErrSeederNotRunning
+	}
+	if s.client != nil {
+		return s.client, nil
+	}
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.Seed = true
+	cfg.DefaultStorage = s
+	var (
+		client *torrent.Client
+		err    error
+	)
+	for cfg.ListenPort = minListenPort; cfg.ListenPort <= maxListenPort; cfg.ListenPort++ {
+		client, err = torrent.NewClient(cfg)
+		if err == nil || !strings.Contains(err.Error(), "address already in use") {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new torrent client: %w", err)
+	}
+	s.client = client
+	return client, nil
+}
+
+// GetSeeder returns the seeder of the daemon node.
+func GetSeeder(n *core.IpfsNode) (*Seeder, error) {
+	seeders.Lock()
+	defer seeders.Unlock()
+	s, ok := seeders.m[n]
+	if !ok {
+		return nil, ErrSeederNotRunning
+	}
+	return s, nil
+}
+
+// Close stops seeding all torrents, they are resumed when the seeder is started again.
+func (s *Seeder) Close() error {
+	seeders.Lock()
+	delete(seeders.m, s.n)
+	seeders.Unlock()
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	s.closed = true
+	if s.client != nil {
+		s.client.Close()
+	}
+	return nil
+}
+
+// Seed starts seeding the content at root, which was resolved from source.
+// The torrent is built from a snapshot of the content, seeding the same content
+// again returns the existing torrent. The content is pinned while it is seeded,
+// so that it is not garbage collected.
+func (s *Seeder) Seed(ctx context.Context, root cid.Cid, name, source string) (*SeedStatus, error) {
+	p := path.IpfsPath(root)
+	_, pinned, err := s.api.Pin().IsPinned(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !pinned {
+		if err := s.api.Pin().Add(ctx, p); err != nil {
+			return nil, fmt.Errorf("pinning %s: %w", source, err)
+		}
+	}
+	unpin := func() {
+		if pinned {
+			return
+		}
+		if err := s.api.Pin().Rm(ctx, p); err != nil {
+			log.Errorf("unpin %s: %v", root, err)
+		}
+	}
+	r, err := s.buildRecord(ctx, root, name, source)
+	if err != nil {
+		unpin()
+		return nil, err
+	}
+	r.Pinned = !pinned
+	ih := metainfo.NewHashFromHex(r.InfoHash)
+	s.seedMu.Lock()
+	defer s.seedMu.Unlock()
+	s.mu.Lock()
+	_, seeding := s.records[ih]
+	s.mu.Unlock()
+	if !seeding {
+		if err := SaveSeedRecord(ctx, s.n.Repo.Datastore(), r); err != nil {
+			unpin()
+			return nil, err
+		}
+		if err := s.add(r); err != nil {
+			DeleteSeedRecord(ctx, s.n.Repo.Datastore(), r.InfoHash)
+			unpin()
+			return nil, err
+		}
+	}
+	return s.status(ih), nil
+}
+
+// Stop stops seeding the torrent with the given info hash or root hash.
+func (s *Seeder) Stop(ctx context.Context, id string) (*SeedRecord, error) {
+	s.mu.Lock()
+	var (
+		ih metainfo.Hash
+		r  *SeedRecord
+	)
+	for h, rec := range s.records {
+		if rec.InfoHash == strings.ToLower(id) || rec.Root == id {
+			ih, r = h, rec
+			break
+		}
+	}
+	t := s.torrents[ih]
+	delete(s.records, ih)
+	delete(s.torrents, ih)
+	s.mu.Unlock()
+	if r == nil {
+		return nil, fmt.Errorf("%s is not seeded", id)
+	}
+	// a torrent still being added is dropped by add once it sees the record is gone
+	if t != nil {
+		t.Drop()
+	}
+	if err := DeleteSeedRecord(ctx, s.n.Repo.Datastore(), r.InfoHash); err != nil {
+		return nil, err
+	}
+	if r.Pinned {
+		c, err := cid.Decode(r.Root)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.api.Pin().Rm(ctx, path.IpfsPath(c)); err != nil {
+			return nil, fmt.Errorf("unpinning %s: %w", r.Root, err)
+		}
+	}
+	return r, nil
+}
+
+// List returns the torrents being seeded, oldest first.
+func (s *Seeder) List() []*SeedStatus {
+	s.mu.Lock()
+	hashes := make([]metainfo.Hash, 0, len(s.records))
+	for ih := range s.records {
+		hashes = append(hashes, ih)
+	}
+	s.mu.Unlock()
+	out := make([]*SeedStatus, 0, len(hashes))
+	for _, ih := range hashes {
+		if st := s.status(ih); st != nil {
+			out = append(out, st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+func (s *Seeder) status(ih metainfo.Hash) *SeedStatus {
+	s.mu.Lock()
+	r, t := s.records[ih], s.torrents[ih]
+	s.mu.Unlock()
+	if r == nil || t == nil {
+		return nil
+	}
+	st := &SeedStatus{SeedRecord: r, Magnet: Magnet(r)}
+	stats := t.Stats()
+	st.Peers = stats.ActivePeers
+	st.BytesUploaded = stats.BytesWrittenData.Int64()
+	return st
+}
+
+// Magnet returns the magnet link of a seeded torrent.
+func Magnet(r *SeedRecord) string {
+	ih := metainfo.NewHashFromHex(r.InfoHash)
+	mi := metainfo.MetaInfo{InfoBytes: r.InfoBytes, AnnounceList: [][]string{DefaultTrackers}}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		return ""
+	}
+	return mi.Magnet(&ih, &info).String()
+}
+
+func (s *Seeder) add(r *SeedRecord) error {
+	client, err := s.torrentClient()
+	if err != nil {
+		return err
+	}
+	ih := metainfo.NewHashFromHex(r.InfoHash)
+	s.mu.Lock()
+	s.records[ih] = r
+	s.mu.Unlock()
+	t, _ := client.AddTorrentOpt(torrent.AddTorrentOpts{InfoHash: ih})
+	err = t.MergeSpec(&torrent.TorrentSpec{
+		InfoBytes: r.InfoBytes,
+		Trackers:  [][]string{DefaultTrackers},
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		delete(s.records, ih)
+		t.Drop()
+		return fmt.Errorf("setting torrent info: %w", err)
+	}
+	if s.records[ih] != r {
+		// stopped while it was added
+		t.Drop()
+		return fmt.Errorf("torrent %s was stopped", r.InfoHash)
+	}
+	s.torrents[ih] = t
+	return nil
+}
+
+// buildRecord lays out the content at root as a torrent, hashing its pieces.
+func (s *Seeder) buildRecord(ctx context.Context, root cid.Cid, name, source string) (*SeedRecord, error) {
+	r := &SeedRecord{
+		Name:      name,
+		Root:      root.String(),
+		Source:    source,
+		CreatedAt: time.Now(),
+	}
+	nd, err := s.api.Unixfs().Get(ctx, path.IpfsPath(root))
+	if err != nil {
+		return nil, err
+	}
+	defer nd.Close()
+	switch nd := nd.(type) {
+	case files.File:
+		size, err := nd.Size()
+		if err != nil {
+			return nil, err
+		}
+		r.Files = []SeedFile{{Cid: root.String(), Length: size}}
+	case files.Directory:
+		if err := s.walk(ctx, root, nil, r); err != nil {
+			return nil, err
+		}
+		if len(r.Files) == 0 {
+			return nil, fmt.Errorf("%s has no files to seed", source)
+		}
+	default:
+		return nil, fmt.Errorf("%s is neither a file nor a directory", source)
+	}
+
+	info := metainfo.Info{Name: name}
+	for _, f := range r.Files {
+		r.Length += f.Length
+	}
+	info.PieceLength = metainfo.ChoosePieceLength(r.Length)
+	if len(r.Files) == 1 && r.Files[0].Path == nil {
+		info.Length = r.Length
+	} else {
+		for _, f := range r.Files {
+			info.Files = append(info.Files, metainfo.FileInfo{Path: f.Path, Length: f.Length})
+		}
+	}
+	next := 0
+	err = info.GeneratePieces(func(metainfo.FileInfo) (io.ReadCloser, error) {
+		f := r.Files[next]
+		next++
+		return s.openFile(ctx, f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hashing pieces: %w", err)
+	}
+	r.InfoBytes, err = bencode.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	r.InfoHash = metainfo.HashBytes(r.InfoBytes).HexString()
+	return r, nil
+}
+
+// walk adds the files under the directory to the record, ordered by path.
+func (s *Seeder) walk(ctx context.Context, dir cid.Cid, prefix []string, r *SeedRecord) error {
+	entries, err := s.api.Unixfs().Ls(ctx, path.IpfsPath(dir))
+	if err != nil {
+		return err
+	}
+	list := make([]coreiface.DirEntry, 0)
+	for e := range entries {
+		if e.Err != nil {
+			return e.Err
+		}
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	for _, e := range list {
+		p := append(append([]string{}, prefix...), e.Name)
+		switch e.Type {
+		case coreiface.TDirectory:
+			if err := s.walk(ctx, e.Cid, p, r); err != nil {
+				return err
+			}
+		case coreiface.TFile:
+			r.Files = append(r.Files, SeedFile{Path: p, Cid: e.Cid.String(), Length: int64(e.Size)})
+		}
+	}
+	return nil
+}
+
+func (s *Seeder) openFile(ctx context.Context, f SeedFile) (files.File, error) {
+	c, err := cid.Decode(f.Cid)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := s.api.Unixfs().Get(ctx, path.IpfsPath(c))
+	if err != nil {
+		return nil, err
+	}
+	file, ok := nd.(files.File)
+	if !ok {
+		nd.Close()
+		return nil, fmt.Errorf("%s is not a file", f.Cid)
+	}
+	return file, nil
+}
+
+// OpenTorrent implements storage.ClientImpl, serving the pieces of seeded torrents
+// from their DAG.
+func (s *Seeder) OpenTorrent(info *metainfo.Info, ih metainfo.Hash) (storage.TorrentImpl, error) {
+	s.mu.Lock()
+	r, ok := s.records[ih]
+	s.mu.Unlock()
+	if !ok {
+		return storage.TorrentImpl{}, fmt.Errorf("torrent %s is not seeded", ih.HexString())
+	}
+	t := &seedTorrent{s: s, r: r}
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return &seedPiece{t: t, p: p}
+		},
+		Close: func() error { return nil },
+	}, nil
+}
+
+type seedTorrent struct {
+	s *Seeder
+	r *SeedRecord
+}
+
+// ReadAt reads the torrent data at the given offset of the whole torrent.
+func (t *seedTorrent) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	var start int64
+	for _, f := range t.r.Files {
+		if n == len(b) {
+			break
+		}
+		end := start + f.Length
+		if off+int64(n) >= end {
+			start = end
+			continue
+		}
+		want := b[n:]
+		if rest := end - off - int64(n); rest < int64(len(want)) {
+			want = want[:rest]
+		}
+		m, err := t.readFile(f, want, off+int64(n)-start)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		start = end
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (t *seedTorrent) readFile(f SeedFile, b []byte, off int64) (int, error) {
+	file, err := t.s.openFile(t.s.ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(file, b)
+}
+
+type seedPiece struct {
+	t *seedTorrent
+	p metainfo.Piece
+}
+
+func (sp *seedPiece) ReadAt(b []byte, off int64) (int, error) {
+	return sp.t.ReadAt(b, sp.p.Offset()+off)
+}
+
+func (sp *seedPiece) WriteAt(b []byte, off int64) (int, error) {
+	return 0, errors.New("seeded content is read only")
+}
+
+func (sp *seedPiece) MarkComplete() error {
+	return nil
+}
+
+func (sp *seedPiece) MarkNotComplete() error {
+	return fmt.Errorf("piece %d of %s does not match its content", sp.p.Index(), sp.t.r.Root)
+}
+
+func (sp *seedPiece) Completion() storage.Completion {
+	return storage.Completion{Complete: true, Ok: true}
+}
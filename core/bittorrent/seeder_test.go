@@ -0,0 +1,137 @@
+package bittorrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	"github.com/anacrolix/torrent/metainfo"
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+)
+
+func TestSeedRecordFromDAG(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	content := map[string][]byte{"b": make([]byte, 300<<10), "a": make([]byte, 70<<10), "c": {}}
+	for _, b := range content {
+		rnd.Read(b)
+	}
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"b": files.NewBytesFile(content["b"]),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"a": files.NewBytesFile(content["a"]),
+			"c": files.NewBytesFile(content["c"]),
+		}),
+	})
+	root, err := api.Unixfs().Add(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Seeder{ctx: ctx, n: n, api: api}
+	r, err := s.buildRecord(ctx, root.Cid(), "test", root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files are laid out in path order
+	want := append(append(append([]byte{}, content["b"]...), content["a"]...), content["c"]...)
+	if len(r.Files) != 3 || r.Files[0].Path[0] != "b" || r.Files[1].Path[1] != "a" || r.Files[2].Path[1] != "c" {
+		t.Fatalf("unexpected files %+v", r.Files)
+	}
+	if r.Length != int64(len(want)) {
+		t.Fatalf("length %d, want %d", r.Length, len(want))
+	}
+
+	mi := metainfo.MetaInfo{InfoBytes: r.InfoBytes}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mi.HashInfoBytes().HexString() != r.InfoHash {
+		t.Fatal("info hash does not match the info bytes")
+	}
+
+	st := &seedTorrent{s: s, r: r}
+	got, err := io.ReadAll(io.NewSectionReader(st, 0, r.Length))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("torrent data does not match the content")
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		buf := make([]byte, p.Length())
+		if _, err := (&seedPiece{t: st, p: p}).ReadAt(buf, 0); err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha1.Sum(buf); !bytes.Equal(sum[:], p.Hash().Bytes()) {
+			t.Fatalf("piece %d does not match its hash", i)
+		}
+	}
+	if Magnet(r) == "" {
+		t.Fatal("no magnet link")
+	}
+}
+
+func TestSeedPinsContent(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	api, err := coreapi.NewCoreAPI(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 100<<10)
+	rand.New(rand.NewSource(2)).Read(data)
+	root, err := api.Unixfs().Add(ctx, files.NewBytesFile(data), options.Unixfs.Pin(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := StartSeeder(ctx, n, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.client != nil {
+		t.Fatal("torrent client started with nothing to seed")
+	}
+
+	st, err := s.Seed(ctx, root.Cid(), "test", root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, err := api.Pin().IsPinned(ctx, root); err != nil || !pinned {
+		t.Fatalf("seeded content is not pinned: %v", err)
+	}
+	if _, err := s.Stop(ctx, st.InfoHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, pinned, err := api.Pin().IsPinned(ctx, root); err != nil || pinned {
+		t.Fatalf("content still pinned after seeding stopped: %v", err)
+	}
+	if len(s.List()) != 0 {
+		t.Fatal("stopped torrent is still listed")
+	}
+}
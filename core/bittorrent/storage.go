@@ -0,0 +1,260 @@
+package bittorrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// maxBlockSize is the largest raw block a piece is split into. It matches the
+// default chunk size of `btfs add`.
+const maxBlockSize = 256 << 10
+
+var rawPrefix = cid.NewPrefixV1(cid.Raw, mh.SHA2_256)
+
+// BlockSize is the size of the raw blocks the pieces of a torrent are stored in.
+// Blocks of a piece never cross a piece boundary.
+func BlockSize(info *metainfo.Info) int64 {
+	if info.PieceLength < maxBlockSize {
+		return info.PieceLength
+	}
+	return maxBlockSize
+}
+
+// blockStorage is a torrent storage that keeps completed pieces as raw blocks
+// in the blockstore, and incomplete pieces as chunks in the datastore, so that
+// an interrupted download can be picked up where it stopped.
+type blockStorage struct {
+	ctx context.Context
+	bs  bstore.Blockstore
+	d   ds.Datastore
+}
+
+// NewBlockStorage returns a torrent storage on top of the node's blockstore and datastore.
+// Completed pieces are not pinned: pieces whose blocks were garbage collected are
+// found missing when the torrent is opened again, or verified again.
+func NewBlockStorage(ctx context.Context, bs bstore.Blockstore, d ds.Datastore) storage.ClientImpl {
+	return &blockStorage{ctx: ctx, bs: bs, d: d}
+}
+
+func (s *blockStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage.TorrentImpl, error) {
+	t := &blockTorrent{
+		blockStorage: s,
+		info:         info,
+		ih:           infoHash.HexString(),
+		blockSize:    BlockSize(info),
+	}
+	if _, err := t.dropMissingPieces(); err != nil {
+		return storage.TorrentImpl{}, err
+	}
+	return storage.TorrentImpl{
+		Piece: func(p metainfo.Piece) storage.PieceImpl {
+			return &blockPiece{t: t, p: p}
+		},
+		Close: func() error { return nil },
+	}, nil
+}
+
+type blockTorrent struct {
+	*blockStorage
+	info      *metainfo.Info
+	ih        string
+	blockSize int64
+}
+
+// dropMissingPieces marks pieces as not complete when their blocks were
+// garbage collected since they were downloaded, and returns how many were.
+func (t *blockTorrent) dropMissingPieces() (int, error) {
+	dropped := 0
+	for i := 0; i < t.info.NumPieces(); i++ {
+		r, err := t.pieceRecord(i)
+		if err == ds.ErrNotFound {
+			continue
+		} else if err != nil {
+			return dropped, err
+		}
+		for _, b := range r.Blocks {
+			c, err := cid.Decode(b)
+			if err != nil {
+				return dropped, err
+			}
+			has, err := t.bs.Has(t.ctx, c)
+			if err != nil {
+				return dropped, err
+			}
+			if !has {
+				if err := t.d.Delete(t.ctx, ds.NewKey(fmt.Sprintf(pieceKey, t.ih, i))); err != nil {
+					return dropped, err
+				}
+				dropped++
+				break
+			}
+		}
+	}
+	return dropped, nil
+}
+
+func (t *blockTorrent) pieceRecord(index int) (*pieceRecord, error) {
+	r := new(pieceRecord)
+	if err := getJSON(t.ctx, t.d, fmt.Sprintf(pieceKey, t.ih, index), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReadAt reads the torrent data at the given offset of the whole torrent.
+func (t *blockTorrent) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) {
+		if off >= t.info.TotalLength() {
+			return n, io.EOF
+		}
+		p := t.info.Piece(int(off / t.info.PieceLength))
+		pieceOff := off - p.Offset()
+		end := len(b)
+		if rest := int64(n) + p.Length() - pieceOff; rest < int64(end) {
+			end = int(rest)
+		}
+		m, err := (&blockPiece{t: t, p: p}).ReadAt(b[n:end], pieceOff)
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// cleanup removes the records of the pieces once the torrent was imported. The
+// blocks of the pieces are left to the garbage collector.
+func (t *blockTorrent) cleanup() error {
+	if err := deletePrefix(t.ctx, t.d, fmt.Sprintf(piecePrefix, t.ih)); err != nil {
+		return err
+	}
+	return deletePrefix(t.ctx, t.d, fmt.Sprintf(stagingTorrent, t.ih))
+}
+
+type blockPiece struct {
+	t *blockTorrent
+	p metainfo.Piece
+}
+
+func (bp *blockPiece) ReadAt(b []byte, off int64) (int, error) {
+	r, err := bp.t.pieceRecord(bp.p.Index())
+	if err == ds.ErrNotFound {
+		return bp.readStaged(b, off)
+	} else if err != nil {
+		return 0, err
+	}
+	n := 0
+	for n < len(b) && off < bp.p.Length() {
+		i := off / bp.t.blockSize
+		if int(i) >= len(r.Blocks) {
+			break
+		}
+		c, err := cid.Decode(r.Blocks[i])
+		if err != nil {
+			return n, err
+		}
+		blk, err := bp.t.bs.Get(bp.t.ctx, c)
+		if err != nil {
+			return n, err
+		}
+		m := copy(b[n:], blk.RawData()[off-i*bp.t.blockSize:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readStaged reads the contiguous staged data of an incomplete piece at off.
+func (bp *blockPiece) readStaged(b []byte, off int64) (int, error) {
+	chunks, err := stagedChunks(bp.t.ctx, bp.t.d, bp.t.ih, bp.p.Index())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range chunks {
+		pos := off + int64(n)
+		end := c.offset + int64(len(c.data))
+		if end <= pos {
+			continue
+		}
+		if c.offset > pos {
+			break
+		}
+		n += copy(b[n:], c.data[pos-c.offset:])
+		if n == len(b) {
+			return n, nil
+		}
+	}
+	return n, io.EOF
+}
+
+func (bp *blockPiece) WriteAt(b []byte, off int64) (int, error) {
+	data := make([]byte, len(b))
+	copy(data, b)
+	key := ds.NewKey(fmt.Sprintf(stagingKey, bp.t.ih, bp.p.Index(), off))
+	if err := bp.t.d.Put(bp.t.ctx, key, data); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// MarkComplete moves the staged data of the piece into raw blocks.
+func (bp *blockPiece) MarkComplete() error {
+	data := make([]byte, bp.p.Length())
+	if _, err := bp.readStaged(data, 0); err != nil {
+		return fmt.Errorf("piece %d is incomplete: %w", bp.p.Index(), err)
+	}
+	r := &pieceRecord{Blocks: make([]string, 0)}
+	blks := make([]blocks.Block, 0)
+	for off := int64(0); off < int64(len(data)); off += bp.t.blockSize {
+		end := off + bp.t.blockSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		c, err := rawPrefix.Sum(data[off:end])
+		if err != nil {
+			return err
+		}
+		blk, err := blocks.NewBlockWithCid(data[off:end], c)
+		if err != nil {
+			return err
+		}
+		blks = append(blks, blk)
+		r.Blocks = append(r.Blocks, c.String())
+	}
+	if err := bp.t.bs.PutMany(bp.t.ctx, blks); err != nil {
+		return err
+	}
+	if err := putJSON(bp.t.ctx, bp.t.d, fmt.Sprintf(pieceKey, bp.t.ih, bp.p.Index()), r); err != nil {
+		return err
+	}
+	return deletePrefix(bp.t.ctx, bp.t.d, fmt.Sprintf(stagingPrefix, bp.t.ih, bp.p.Index()))
+}
+
+func (bp *blockPiece) MarkNotComplete() error {
+	err := bp.t.d.Delete(bp.t.ctx, ds.NewKey(fmt.Sprintf(pieceKey, bp.t.ih, bp.p.Index())))
+	if err != nil && !errors.Is(err, ds.ErrNotFound) {
+		return err
+	}
+	return deletePrefix(bp.t.ctx, bp.t.d, fmt.Sprintf(stagingPrefix, bp.t.ih, bp.p.Index()))
+}
+
+func (bp *blockPiece) Completion() storage.Completion {
+	has, err := bp.t.d.Has(bp.t.ctx, ds.NewKey(fmt.Sprintf(pieceKey, bp.t.ih, bp.p.Index())))
+	return storage.Completion{Complete: has, Ok: err == nil}
+}
@@ -0,0 +1,98 @@
+package bittorrent
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestBlockStorage(t *testing.T) {
+	ctx := context.Background()
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(d)
+
+	data := make([]byte, 5<<10+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	info := &metainfo.Info{Name: "test", PieceLength: 2 << 10, Length: int64(len(data))}
+	info.Pieces = make([]byte, 20*((info.Length+info.PieceLength-1)/info.PieceLength))
+	ih := metainfo.HashBytes([]byte("test"))
+
+	st := NewBlockStorage(ctx, bs, d)
+	ti, err := st.OpenTorrent(info, ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		p := info.Piece(i)
+		pi := ti.Piece(p)
+		if pi.Completion().Complete {
+			t.Fatalf("piece %d complete before it is written", i)
+		}
+		buf := data[p.Offset() : p.Offset()+p.Length()]
+		// write the second half first, the piece must not read as complete data
+		half := len(buf) / 2
+		if _, err := pi.WriteAt(buf[half:], int64(half)); err != nil {
+			t.Fatal(err)
+		}
+		if err := pi.MarkComplete(); err == nil {
+			t.Fatalf("piece %d marked complete with missing data", i)
+		}
+		if _, err := pi.WriteAt(buf[:half], 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := pi.MarkComplete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// reopening picks up the completed pieces
+	ti, err = st.OpenTorrent(info, ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		if c := ti.Piece(info.Piece(i)).Completion(); !c.Ok || !c.Complete {
+			t.Fatalf("piece %d not complete after reopen: %+v", i, c)
+		}
+	}
+
+	bt := &blockTorrent{blockStorage: st.(*blockStorage), info: info, ih: ih.HexString(), blockSize: BlockSize(info)}
+	got, err := io.ReadAll(io.NewSectionReader(bt, 0, info.TotalLength()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("torrent data does not match what was written")
+	}
+
+	// a piece whose blocks were garbage collected is downloaded again
+	r, err := bt.pieceRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cid.Decode(r.Blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.DeleteBlock(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := bt.dropMissingPieces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 1 {
+		t.Fatalf("dropped %d pieces, want 1", dropped)
+	}
+	if c := ti.Piece(info.Piece(1)).Completion(); c.Complete {
+		t.Fatal("piece with collected blocks still complete")
+	}
+}
@@ -0,0 +1,208 @@
+package bittorrent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const (
+	torrentPrefix  = "/btfs/bittorrent/torrents/"
+	torrentKey     = torrentPrefix + "%s"
+	piecePrefix    = "/btfs/bittorrent/pieces/%s/"
+	pieceKey       = piecePrefix + "%d"
+	stagingPrefix  = "/btfs/bittorrent/staging/%s/%d/"
+	stagingKey     = stagingPrefix + "%d"
+	stagingTorrent = "/btfs/bittorrent/staging/%s/"
+	seedPrefix     = "/btfs/bittorrent/seeds/"
+	seedKey        = seedPrefix + "%s"
+)
+
+// TorrentRecord is the persisted state of a torrent imported into BTFS.
+type TorrentRecord struct {
+	InfoHash  string
+	Name      string
+	Length    int64
+	InfoBytes []byte
+	// Root is the hash of the imported UnixFS node, empty until the import completes.
+	Root        string `json:",omitempty"`
+	CreatedAt   time.Time
+	CompletedAt time.Time `json:",omitempty"`
+}
+
+// SeedRecord is the persisted state of BTFS content seeded to the BitTorrent network.
+type SeedRecord struct {
+	InfoHash string
+	Name     string
+	// Root is the hash of the seeded content, Source the path it was resolved from.
+	Root      string
+	Source    string
+	Length    int64
+	InfoBytes []byte
+	Files     []SeedFile
+	CreatedAt time.Time
+	// Pinned is set when the content was pinned for seeding, it is unpinned
+	// when the seeding stops.
+	Pinned bool `json:",omitempty"`
+}
+
+// SeedFile is a file of a seeded torrent, in the order of the torrent.
+type SeedFile struct {
+	Path   []string `json:",omitempty"`
+	Cid    string
+	Length int64
+}
+
+// pieceRecord lists the raw blocks a completed piece was stored in.
+type pieceRecord struct {
+	Blocks []string
+}
+
+func putJSON(ctx context.Context, d ds.Datastore, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, ds.NewKey(key), b)
+}
+
+func getJSON(ctx context.Context, d ds.Datastore, key string, v interface{}) error {
+	b, err := d.Get(ctx, ds.NewKey(key))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// deletePrefix removes every key under the prefix.
+func deletePrefix(ctx context.Context, d ds.Datastore, prefix string) error {
+	results, err := d.Query(ctx, query.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := d.Delete(ctx, ds.NewKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTorrentRecord persists the state of a torrent.
+func SaveTorrentRecord(ctx context.Context, d ds.Datastore, r *TorrentRecord) error {
+	return putJSON(ctx, d, fmt.Sprintf(torrentKey, r.InfoHash), r)
+}
+
+// GetTorrentRecord returns the state of a torrent, or ds.ErrNotFound if it was never imported.
+func GetTorrentRecord(ctx context.Context, d ds.Datastore, ih metainfo.Hash) (*TorrentRecord, error) {
+	r := new(TorrentRecord)
+	if err := getJSON(ctx, d, fmt.Sprintf(torrentKey, ih.HexString()), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListTorrentRecords returns the state of all torrents imported into BTFS.
+func ListTorrentRecords(ctx context.Context, d ds.Datastore) ([]*TorrentRecord, error) {
+	records := make([]*TorrentRecord, 0)
+	err := queryJSON(ctx, d, torrentPrefix, func(b []byte) error {
+		r := new(TorrentRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// SaveSeedRecord persists the state of a seeded torrent.
+func SaveSeedRecord(ctx context.Context, d ds.Datastore, r *SeedRecord) error {
+	return putJSON(ctx, d, fmt.Sprintf(seedKey, r.InfoHash), r)
+}
+
+// DeleteSeedRecord removes the state of a torrent that is no longer seeded.
+func DeleteSeedRecord(ctx context.Context, d ds.Datastore, ih string) error {
+	return d.Delete(ctx, ds.NewKey(fmt.Sprintf(seedKey, ih)))
+}
+
+// ListSeedRecords returns the state of all seeded torrents.
+func ListSeedRecords(ctx context.Context, d ds.Datastore) ([]*SeedRecord, error) {
+	records := make([]*SeedRecord, 0)
+	err := queryJSON(ctx, d, seedPrefix, func(b []byte) error {
+		r := new(SeedRecord)
+		if err := json.Unmarshal(b, r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// queryJSON calls fn with the value of every key under the prefix.
+func queryJSON(ctx context.Context, d ds.Datastore, prefix string, fn func([]byte) error) error {
+	results, err := d.Query(ctx, query.Query{Prefix: prefix})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for e := range results.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		if err := fn(e.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedChunk is a chunk of an incomplete piece.
+type stagedChunk struct {
+	offset int64
+	data   []byte
+}
+
+// stagedChunks returns the staged chunks of a piece, ordered by offset.
+func stagedChunks(ctx context.Context, d ds.Datastore, ih string, piece int) ([]stagedChunk, error) {
+	prefix := fmt.Sprintf(stagingPrefix, ih, piece)
+	results, err := d.Query(ctx, query.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]stagedChunk, 0, len(entries))
+	for _, e := range entries {
+		off, err := strconv.ParseInt(strings.TrimPrefix(e.Key, prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		chunks = append(chunks, stagedChunk{offset: off, data: e.Value})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].offset < chunks[j].offset
+	})
+	return chunks, nil
+}
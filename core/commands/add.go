@@ -151,7 +151,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
 		cmds.StringOption(tokenMetaOptionName, "m", "Token metadata in JSON string"),
-		cmds.BoolOption(encryptName, "Encrypt the file."),
+		cmds.BoolOption(encryptName, "Encrypt the file or directory."),
 		cmds.StringOption(pubkeyName, "The public key to encrypt the file."),
 		cmds.StringOption(peerIdName, "The peer id to encrypt the file."),
 		cmds.IntOption(pinDurationCountOptionName, "d", "Duration for which the object is pinned in days.").WithDefault(0),
@@ -324,31 +324,13 @@ only-hash, and progress/status related flags) will change the final hash.
 
 			// Could be slow.
 			go func() {
-				op := res.Request().Options[encryptName]
-				encrypt := op != nil && op.(bool)
-				if encrypt {
-					it := req.Files.Entries()
-					var size int64 = 0
-					for it.Next() {
-						s, err := it.Node().Size()
-						if err != nil {
-							log.Warnf("error getting files size: %s", err)
-							// see comment above
-							return
-						}
-						blockCount := s/16 + 1
-						size += blockCount * 32
-						sizeChan <- size
-					}
-				} else {
-					size, err := req.Files.Size()
-					if err != nil {
-						log.Warnf("error getting files size: %s", err)
-						// see comment above
-						return
-					}
-					sizeChan <- size
+				size, err := req.Files.Size()
+				if err != nil {
+					log.Warnf("error getting files size: %s", err)
+					// see comment above
+					return
 				}
+				sizeChan <- size
 			}()
 
 			progressBar := func(wait chan struct{}) {
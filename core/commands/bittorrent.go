@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
@@ -9,19 +8,25 @@ import (
 	"io"
 	"net/url"
 	"os"
-	"os/exec"
+	gopath "path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	core "github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/bittorrent"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/metainfo"
-	"github.com/anacrolix/torrent/storage"
 	"github.com/anacrolix/torrent/tracker/udp"
 	cmds "github.com/bittorrent/go-btfs-cmds"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/path"
 	"github.com/bradfitz/iter"
 	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
 )
 
 var bittorrentCmd = &cmds.Command{
@@ -215,9 +220,25 @@ var bencodeBTCmd = &cmds.Command{
 	},
 }
 
+type BTDownloadOutput struct {
+	InfoHash        string
+	Name            string
+	BytesCompleted  int64
+	Length          int64
+	PiecesCompleted int
+	NumPieces       int
+	// Hash is the hash of the imported torrent, set once the import completes.
+	Hash string `json:",omitempty"`
+}
+
 var downloadBTCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
-		Tagline: "Download a bittorrent file from the bittorrent seed or a magnet URL.",
+		Tagline: "Download a bittorrent file from the bittorrent seed or a magnet URL into btfs.",
+		ShortDescription: `
+Downloads the torrent straight into the local blockstore, and adds it as a pinned
+UnixFS directory (or file) with the same file layout as the torrent. Completed pieces
+are kept if the download is interrupted, running the same command again resumes it.
+`,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("magnet uri", false, false, "Magnet uri if your seed is coming from magnet."),
@@ -226,231 +247,247 @@ var downloadBTCmd = &cmds.Command{
 		cmds.StringOption("t", "Bittorrent seed file."),
 	},
 	Run: func(req *cmds.Request, resp cmds.ResponseEmitter, env cmds.Environment) error {
-		magnet := req.Arguments[0]
-		btFilePath, _ := req.Options["t"].(string)
-		clientConfig := torrent.NewDefaultClientConfig()
-		clientConfig.ListenPort = 0
-		client, err := torrent.NewClient(clientConfig)
-		if err != nil {
-			return fmt.Errorf("creating client: %w", err)
-		}
-		defer client.Close()
-		var t *torrent.Torrent
-		if btFilePath != "" {
-			metaInfo, err := metainfo.LoadFromFile(btFilePath)
+		src := new(bittorrent.Source)
+		if btFilePath, _ := req.Options["t"].(string); btFilePath != "" {
+			mi, err := metainfo.LoadFromFile(btFilePath)
 			if err != nil {
 				return fmt.Errorf("error loading torrent file %s: %w", btFilePath, err)
 			}
-			t, err = client.AddTorrent(metaInfo)
-			if err != nil {
-				return fmt.Errorf("adding torrent: %w", err)
-			}
-		} else if magnet != "" {
-			t, err = client.AddMagnet(magnet)
-			if err != nil {
-				return fmt.Errorf("error adding magnet: %w", err)
-			}
+			src.MetaInfo = mi
+		} else if len(req.Arguments) > 0 && req.Arguments[0] != "" {
+			src.Magnet = req.Arguments[0]
 		} else {
 			return fmt.Errorf("your must provide a magnet uri or a torrent file path")
 		}
-		select {
-		case <-t.GotInfo():
-			fmt.Println("Got metainfo done.Begin to download files...")
-		case <-time.After(5 * time.Minute):
-			log.Error("Get metainfo timeout,exceed two minutes, we can't find the metainfo for this torrent.")
-			return fmt.Errorf("get metainfo timeout")
-		}
-		t.DownloadAll()
-		// print the progress of the download.
-		fmt.Printf("This torrent needs storage space about: %s\n", humanize.Bytes(uint64(t.Length())))
-		torrentBar(t, false)
-		isCompleted := client.WaitAll()
-		if !isCompleted {
-			log.Error("download error because of the closed of the client")
-			return fmt.Errorf("download error because of the closed of the client")
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
 		}
-
-		btfsBinaryPath := "btfs"
-		cmd := exec.Command(btfsBinaryPath, "add", "-r", "--pin", t.Name())
-
-		go func() {
-			time.Sleep(10 * time.Minute)
-			_, err := os.FindProcess(int(cmd.Process.Pid))
-			if err != nil {
-				log.Info("process already finished\n")
-			} else {
-				err := cmd.Process.Kill()
-				if err != nil {
-					if !strings.Contains(err.Error(), "process already finished") {
-						log.Errorf("cannot kill process: [%v] \n", err)
-					}
-				}
-			}
-		}()
-		// No matter what, delete the files after the download completed.
-		defer func() {
-			err := os.RemoveAll(t.Name())
-			if err != nil {
-				log.Errorf("cannot remove the downloaded files: [%s], [%v] \n", t.Name(), err)
-			}
-		}()
-		var errbuf bytes.Buffer
-		out, err := cmd.Output()
+		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
-			return fmt.Errorf("btfs add [%s] failed: [%v], [%s]", t.Name(), err, errbuf.String())
+			return err
 		}
-		s := strings.Split(string(out), " ")
-		if len(s) < 2 {
-			return fmt.Errorf("btfs add test failed: invalid add result[%s]", string(out))
+		rec, err := bittorrent.Import(req.Context, n, api, src, func(p *bittorrent.Progress) {
+			_ = resp.Emit(&BTDownloadOutput{
+				InfoHash:        p.InfoHash,
+				Name:            p.Name,
+				BytesCompleted:  p.BytesCompleted,
+				Length:          p.Length,
+				PiecesCompleted: p.PiecesCompleted,
+				NumPieces:       p.NumPieces,
+			})
+		})
+		if err != nil {
+			return err
 		}
-		fmt.Println(string(out))
-		return nil
+		return resp.Emit(&BTDownloadOutput{
+			InfoHash:       rec.InfoHash,
+			Name:           rec.Name,
+			BytesCompleted: rec.Length,
+			Length:         rec.Length,
+			Hash:           rec.Root,
+		})
+	},
+	Type: BTDownloadOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *BTDownloadOutput) error {
+			if out.Hash != "" {
+				_, err := fmt.Fprintf(w, "added %s %s\n", out.Hash, out.Name)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "downloading %q: %s/%s, %d/%d pieces completed\n", out.Name,
+				humanize.Bytes(uint64(out.BytesCompleted)), humanize.Bytes(uint64(out.Length)),
+				out.PiecesCompleted, out.NumPieces)
+			return err
+		}),
 	},
 }
 
-var minBTListenPort = 30000
-var maxBTListenPort = 31000
+type BTSeedOutput struct {
+	InfoHash      string
+	Name          string
+	Root          string
+	Source        string
+	Length        int64
+	Magnet        string
+	Peers         int
+	BytesUploaded int64
+}
+
+func newBTSeedOutput(st *bittorrent.SeedStatus) *BTSeedOutput {
+	return &BTSeedOutput{
+		InfoHash:      st.InfoHash,
+		Name:          st.Name,
+		Root:          st.Root,
+		Source:        st.Source,
+		Length:        st.Length,
+		Magnet:        st.Magnet,
+		Peers:         st.Peers,
+		BytesUploaded: st.BytesUploaded,
+	}
+}
 
 var serveBTCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
-		Tagline:         "Serve as a bittorrent client with the specified files.",
-		LongDescription: "Serve as a bittorrent client with the specified files.",
+		Tagline: "Seed btfs content to the bittorrent network.",
+		ShortDescription: `
+Seeds the content at each path to the bittorrent network, and prints the magnet
+link of the torrent it is shared as. A path is a hash, a /btfs/ path, or a path
+in the local mutable namespace (see 'btfs files'), which is seeded as it is when
+the command runs.
+
+The pieces of the torrents are read from the blocks of the content, nothing is
+exported to disk. Seeding is done by the daemon, and resumed when it restarts
+until it is stopped with 'btfs bittorrent serve stop'. The content is pinned
+while it is seeded. The daemon only listens for bittorrent peers once some
+content is seeded.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"list": serveListBTCmd,
+		"stop": serveStopBTCmd,
 	},
 	Arguments: []cmds.Argument{
-		cmds.StringArg("path", true, true, "the paths of some files that you want to serve as seeds"),
+		cmds.StringArg("btfs-path", true, true, "The path of the content to seed."),
 	},
 	Run: func(req *cmds.Request, resp cmds.ResponseEmitter, env cmds.Environment) error {
-		filePaths := req.Arguments
-		if len(filePaths) == 0 {
-			return fmt.Errorf("you must provide the paths of some files that you want to serve as seeds")
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
 		}
-		cfg := torrent.NewDefaultClientConfig()
-		cfg.ListenPort = minBTListenPort
-		cfg.Seed = true
-	retry:
-		cl, err := torrent.NewClient(cfg)
+		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
-			if strings.Contains(err.Error(), "address already in use") {
-				fmt.Println(err)
-				cfg.ListenPort = cfg.ListenPort + 1
-				if cfg.ListenPort > maxBTListenPort {
-					return fmt.Errorf("we have try all the port between %d and %d ,but they are all in used", minBTListenPort, maxBTListenPort)
-				}
-				goto retry
-			} else {
-				return fmt.Errorf("new torrent client: %w", err)
-			}
+			return err
 		}
-		defer cl.Close()
-
-		for _, filePath := range filePaths {
-			totalLength, err := totalLength(filePath)
-			if err != nil {
-				return fmt.Errorf("calculating total length of %q: %v", filePath, err)
-			}
-			pieceLength := metainfo.ChoosePieceLength(totalLength)
-			info := metainfo.Info{
-				PieceLength: pieceLength,
-			}
-			err = info.BuildFromFilePath(filePath)
+		seeder, err := bittorrent.GetSeeder(n)
+		if err != nil {
+			return err
+		}
+		for _, p := range req.Arguments {
+			root, name, err := resolveSeedPath(req.Context, n, api, p)
 			if err != nil {
-				return fmt.Errorf("building info from path %q: %w", filePath, err)
+				return fmt.Errorf("resolving %s: %w", p, err)
 			}
-			for _, fi := range info.Files {
-				fmt.Printf("added %q\n", fi.Path)
-			}
-			mi := metainfo.MetaInfo{
-				InfoBytes: bencode.MustMarshal(info),
-			}
-			pc, err := storage.NewDefaultPieceCompletionForDir(".")
+			st, err := seeder.Seed(req.Context, root, name, p)
 			if err != nil {
-				return fmt.Errorf("new piece completion: %w", err)
+				return fmt.Errorf("seeding %s: %w", p, err)
 			}
-			defer pc.Close()
-			ih := mi.HashInfoBytes()
-			to, _ := cl.AddTorrentOpt(torrent.AddTorrentOpts{
-				InfoHash: ih,
-				Storage: storage.NewFileOpts(storage.NewFileClientOpts{
-					ClientBaseDir: filePath,
-					FilePathMaker: func(opts storage.FilePathMakerOpts) string {
-						return filepath.Join(opts.File.Path...)
-					},
-					TorrentDirMaker: nil,
-					PieceCompletion: pc,
-				}),
-			})
-			defer to.Drop()
-			err = to.MergeSpec(&torrent.TorrentSpec{
-				InfoBytes: mi.InfoBytes,
-				Trackers: [][]string{{
-					`wss://tracker.btorrent.xyz`,
-					`wss://tracker.openwebtorrent.com`,
-					"http://p4p.arenabg.com:1337/announce",
-					"udp://tracker.opentrackr.org:1337/announce",
-					"udp://tracker.openbittorrent.com:6969/announce",
-				}},
-			})
-			if err != nil {
-				return fmt.Errorf("setting trackers: %w", err)
+			if err := resp.Emit(newBTSeedOutput(st)); err != nil {
+				return err
 			}
-			fmt.Printf("%v: %v\n", to, to.Metainfo().Magnet(&ih, &info))
 		}
-		select {}
+		return nil
+	},
+	Type: BTSeedOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *BTSeedOutput) error {
+			_, err := fmt.Fprintf(w, "seeding %s %s\n%s\n", out.Root, out.Name, out.Magnet)
+			return err
+		}),
 	},
 }
 
-func torrentBar(t *torrent.Torrent, pieceStates bool) {
-	go func() {
-		start := time.Now()
-		if t.Info() == nil {
-			fmt.Printf("%v: getting torrent info for %q\n", time.Since(start), t.Name())
-			<-t.GotInfo()
+// resolveSeedPath returns the hash of the content at p, and the name it is seeded as.
+func resolveSeedPath(ctx context.Context, n *core.IpfsNode, api coreiface.CoreAPI, p string) (cid.Cid, string, error) {
+	if c, err := cid.Decode(p); err == nil {
+		return c, c.String(), nil
+	}
+	var root cid.Cid
+	if strings.HasPrefix(p, "/btfs/") {
+		rp, err := api.ResolvePath(ctx, path.New(p))
+		if err != nil {
+			return cid.Undef, "", err
 		}
-		lastStats := t.Stats()
-		var lastLine string
-		interval := 10 * time.Second
-		tick := time.NewTicker(interval)
-		for range tick.C {
-			var completedPieces, partialPieces int
-			psrs := t.PieceStateRuns()
-			for _, r := range psrs {
-				if r.Complete {
-					completedPieces += r.Length
-				}
-				if r.Partial {
-					partialPieces += r.Length
-				}
-			}
-			stats := t.Stats()
-			byteRate := int64(time.Second)
-			byteRate *= stats.BytesReadUsefulData.Int64() - lastStats.BytesReadUsefulData.Int64()
-			byteRate /= int64(interval)
-			line := fmt.Sprintf(
-				"%v: downloading %q: %s/%s, %d/%d pieces completed (%d partial): %v/s\n",
-				time.Since(start),
-				t.Name(),
-				humanize.Bytes(uint64(t.BytesCompleted())),
-				humanize.Bytes(uint64(t.Length())),
-				completedPieces,
-				t.NumPieces(),
-				partialPieces,
-				humanize.Bytes(uint64(byteRate)),
-			)
-			if line != lastLine {
-				lastLine = line
-				fmt.Println(line)
+		root = rp.Cid()
+	} else {
+		nd, err := getNodeFromPath(ctx, n, api, p)
+		if err != nil {
+			return cid.Undef, "", err
+		}
+		root = nd.Cid()
+	}
+	name := gopath.Base(p)
+	if name == "/" || name == "." {
+		name = root.String()
+	}
+	return root, name, nil
+}
+
+type BTSeedListOutput struct {
+	Seeds []*BTSeedOutput
+}
+
+var serveListBTCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the btfs content seeded to the bittorrent network.",
+	},
+	Run: func(req *cmds.Request, resp cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		seeder, err := bittorrent.GetSeeder(n)
+		if err != nil {
+			return err
+		}
+		out := &BTSeedListOutput{Seeds: make([]*BTSeedOutput, 0)}
+		for _, st := range seeder.List() {
+			out.Seeds = append(out.Seeds, newBTSeedOutput(st))
+		}
+		return cmds.EmitOnce(resp, out)
+	},
+	Type: BTSeedListOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *BTSeedListOutput) error {
+			for _, s := range out.Seeds {
+				fmt.Fprintf(w, "%s %s %s: %d peers, %s uploaded\n%s\n", s.InfoHash, s.Root, s.Name,
+					s.Peers, humanize.Bytes(uint64(s.BytesUploaded)), s.Magnet)
 			}
-			if pieceStates {
-				fmt.Println(psrs)
+			return nil
+		}),
+	},
+}
+
+var serveStopBTCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Stop seeding btfs content to the bittorrent network.",
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("id", true, true, "The info hash of the torrent, or the hash of the seeded content."),
+	},
+	Run: func(req *cmds.Request, resp cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		seeder, err := bittorrent.GetSeeder(n)
+		if err != nil {
+			return err
+		}
+		for _, id := range req.Arguments {
+			r, err := seeder.Stop(req.Context, id)
+			if err != nil {
+				return err
 			}
-			lastStats = stats
-			if t.Complete.Bool() {
-				fmt.Println("Download completed!!!")
-				tick.Stop()
-				return
+			if err := resp.Emit(&BTSeedOutput{
+				InfoHash: r.InfoHash,
+				Name:     r.Name,
+				Root:     r.Root,
+				Source:   r.Source,
+				Length:   r.Length,
+			}); err != nil {
+				return err
 			}
 		}
-	}()
+		return nil
+	},
+	Type: BTSeedOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *BTSeedOutput) error {
+			_, err := fmt.Fprintf(w, "stopped seeding %s %s\n", out.Root, out.Name)
+			return err
+		}),
+	},
 }
 
 func totalLength(path string) (totalLength int64, err error) {
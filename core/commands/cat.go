@@ -164,11 +164,7 @@ func cat(ctx context.Context, api iface.CoreAPI, paths []string, offset int64, m
 		}
 
 		var count int64
-		var seekNotAllowed bool
-		if opts[decryptName] != nil && opts[decryptName].(bool) {
-			seekNotAllowed = true
-		}
-		if !seekNotAllowed && !meta {
+		if !meta {
 			count, err = file.Seek(offset, io.SeekStart)
 			if err != nil {
 				return nil, 0, err
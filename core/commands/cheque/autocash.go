@@ -0,0 +1,235 @@
+package cheque
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/repo"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	"github.com/bittorrent/go-btfs/utils"
+	"github.com/ethereum/go-ethereum/common"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("cheque")
+
+const (
+	autoCashoutConfigKey = "AutoCashout"
+
+	// DefaultAutoCashoutInterval is how many minutes apart the cheques are
+	// checked when AutoCashout.Interval is not set.
+	DefaultAutoCashoutInterval = 60
+	// DefaultAutoCashoutGasRatio is how many times the gas cost the cheques of
+	// a vault must be worth when AutoCashout.MinGasRatio is not set.
+	DefaultAutoCashoutGasRatio = 10
+
+	autoCashSinceOptionName = "since"
+)
+
+// AutoCashoutConfig is read from the AutoCashout config key, e.g.
+//
+//	$ btfs config --json AutoCashout '{"Enabled": true, "Thresholds": {"WBTT": "1000000000000000000000"}, "MaxAge": 168}'
+//
+// Amounts are strings of integers in the smallest unit of their token, gas in
+// wei of BTT.
+type AutoCashoutConfig struct {
+	Enabled bool
+	// Interval is how many minutes apart the cheques are checked.
+	Interval int
+	// Thresholds is the uncashed amount of each token, by name, from which the
+	// cheques of a vault are cashed.
+	Thresholds map[string]string
+	// MinGasRatio is how many times the gas cost of the cashout the cheques of
+	// a vault must be worth.
+	MinGasRatio int64
+	// MaxAge is how many hours cheques stay uncashed before they are cashed
+	// below the threshold.
+	MaxAge int
+	// DailyGasBudget is the most gas automatic cashouts spend per day.
+	DailyGasBudget string
+	// GasLimit is the gas a cashout is assumed to use.
+	GasLimit uint64
+}
+
+// GetAutoCashoutConfig returns the AutoCashout config, with the defaults
+// filled in.
+func GetAutoCashoutConfig(r repo.Repo) *AutoCashoutConfig {
+	cfg := new(AutoCashoutConfig)
+	v, err := r.GetConfigKey(autoCashoutConfigKey)
+	if err == nil {
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, cfg)
+		}
+		if err != nil {
+			log.Warnf("ignore malformed %s config: %v", autoCashoutConfigKey, err)
+		}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultAutoCashoutInterval
+	}
+	if cfg.MinGasRatio <= 0 {
+		cfg.MinGasRatio = DefaultAutoCashoutGasRatio
+	}
+	return cfg
+}
+
+// Policy returns the cashout policy of the config.
+func (c *AutoCashoutConfig) Policy() (*vault.AutoCashoutPolicy, error) {
+	p := &vault.AutoCashoutPolicy{
+		Thresholds:  make(map[common.Address]*big.Int),
+		MinGasRatio: c.MinGasRatio,
+		MaxAge:      time.Duration(c.MaxAge) * time.Hour,
+		GasLimit:    c.GasLimit,
+	}
+	for name, amount := range c.Thresholds {
+		token, ok := tokencfg.MpTokenAddr[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown token %s", name)
+		}
+		threshold, ok := new(big.Int).SetString(amount, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid threshold %q of token %s", amount, name)
+		}
+		p.Thresholds[token] = threshold
+	}
+	if c.DailyGasBudget != "" {
+		budget, ok := new(big.Int).SetString(c.DailyGasBudget, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid daily gas budget %q", c.DailyGasBudget)
+		}
+		p.DailyGasBudget = budget
+	}
+	return p, nil
+}
+
+// AutoCashoutTokens returns the tokens whose cheques are cashed automatically.
+func AutoCashoutTokens() []common.Address {
+	names := make([]string, 0, len(tokencfg.MpTokenAddr))
+	for name := range tokencfg.MpTokenAddr {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tokens := make([]common.Address, len(names))
+	for i, name := range names {
+		tokens[i] = tokencfg.MpTokenAddr[name]
+	}
+	return tokens
+}
+
+var ChequeAutoCashCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Cash received cheques automatically.",
+		ShortDescription: `
+When AutoCashout is enabled, the daemon checks the uncashed cheques of every
+vault and token periodically, and cashes them when their amount is over the
+threshold of the token and worth enough times the gas of the cashout, at the
+current gas price and exchange rate of the token. Cheques older than MaxAge are
+cashed below the threshold, as long as they are worth more than the gas, and no
+more than DailyGasBudget is spent on gas per day. Every decision is logged.
+
+    $ btfs config --json AutoCashout '{"Enabled": true, "Interval": 60, "Thresholds": {"WBTT": "1000000000000000000000"}, "MinGasRatio": 10, "MaxAge": 168, "DailyGasBudget": "100000000000000000000"}'`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"run": chequeAutoCashRunCmd,
+		"log": chequeAutoCashLogCmd,
+	},
+}
+
+var chequeAutoCashRunCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Apply the auto cashout policy now.",
+		ShortDescription: `
+This command checks the uncashed cheques against the AutoCashout policy and
+cashes them when it says so, whether AutoCashout is enabled or not.`,
+	},
+	RunTimeout: 5 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if chain.SettleObject.AutoCashout == nil {
+			return errors.New("settlement is not initialized")
+		}
+		p, err := GetAutoCashoutConfig(n.Repo).Policy()
+		if err != nil {
+			return err
+		}
+		decisions, err := chain.SettleObject.AutoCashout.Run(req.Context, p, AutoCashoutTokens())
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &AutoCashRet{Decisions: decisions})
+	},
+	Type: AutoCashRet{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(autoCashTextEncoder),
+	},
+}
+
+var chequeAutoCashLogCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the decisions of the auto cashout policy.",
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(autoCashSinceOptionName, "List the decisions of this duration only, e.g. 24h.").WithDefault("168h"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		since, err := time.ParseDuration(req.Options[autoCashSinceOptionName].(string))
+		if err != nil {
+			return err
+		}
+		if chain.SettleObject.AutoCashout == nil {
+			return errors.New("settlement is not initialized")
+		}
+		decisions, err := chain.SettleObject.AutoCashout.Log(time.Now().Add(-since))
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &AutoCashRet{Decisions: decisions})
+	},
+	Type: AutoCashRet{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(autoCashTextEncoder),
+	},
+}
+
+type AutoCashRet struct {
+	Decisions []*vault.AutoCashoutDecision
+}
+
+func autoCashTextEncoder(req *cmds.Request, w io.Writer, out *AutoCashRet) error {
+	for _, d := range out.Decisions {
+		action := "skip"
+		if d.Cash {
+			action = "cash"
+		}
+		fmt.Fprintf(w, "%s\t%s\tvault %s\ttoken %s\tuncashed %s\t%s",
+			time.Unix(d.Time, 0).Format(time.RFC3339), action, d.Vault, tokencfg.MpTokenStr[d.Token], d.Uncashed, d.Reason)
+		if d.Error != "" {
+			fmt.Fprintf(w, "\terror: %s", d.Error)
+		} else if d.Cash {
+			fmt.Fprintf(w, "\ttx %s", d.TxHash)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
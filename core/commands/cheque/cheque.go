@@ -11,15 +11,19 @@ import (
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/settlement/swap"
 	"github.com/ethereum/go-ethereum/common"
 )
 
+const cashAllOptionName = "all"
+
 type StorePriceRet struct {
 	Price *big.Int `json:"price"`
 }
 
 type CashChequeRet struct {
-	TxHash string
+	TxHash   string
+	Cashouts []*swap.VaultCashout `json:",omitempty"`
 }
 
 type cheque struct {
@@ -68,6 +72,7 @@ Vault services include issue cheque to peer, receive cheque and store operations
 		"cash":       CashChequeCmd,
 		"cashstatus": ChequeCashStatusCmd,
 		"cashlist":   ChequeCashListCmd,
+		"autocash":   ChequeAutoCashCmd,
 		"price":      StorePriceCmd,
 		"price-all":  StorePriceAllCmd,
 
@@ -202,12 +207,19 @@ var StorePriceAllCmd = &cmds.Command{
 var CashChequeCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Cash a cheque by peerID.",
+		ShortDescription: `
+Cash the last cheque received from a peer, or with --all the last cheques of
+every peer with uncashed cheques in the token. Each vault is cashed in its own
+transaction, the result lists the transaction or the error of every vault:
+
+    $ btfs cheque cash --all --token WBTT`,
 	},
 	Arguments: []cmds.Argument{
-		cmds.StringArg("peer-id", true, false, "Peer id tobe cashed."),
+		cmds.StringArg("peer-id", false, false, "Peer id tobe cashed."),
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(tokencfg.TokenTypeName, "tk", "file storage with token type,default WBTT, other TRX/USDD/USDT.").WithDefault("WBTT"),
+		cmds.BoolOption(cashAllOptionName, "Cash the cheques of all peers.").WithDefault(false),
 	},
 	RunTimeout: 5 * time.Minute,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -216,8 +228,6 @@ var CashChequeCmd = &cmds.Command{
 			return err
 		}
 
-		// get the peer id
-		peerID := req.Arguments[0]
 		tokenStr := req.Options[tokencfg.TokenTypeName].(string)
 		//fmt.Printf("... token:%+v\n", tokenStr)
 		token, bl := tokencfg.MpTokenAddr[tokenStr]
@@ -225,6 +235,19 @@ var CashChequeCmd = &cmds.Command{
 			return errors.New("your input token is none. ")
 		}
 
+		if all, _ := req.Options[cashAllOptionName].(bool); all {
+			cashouts, err := chain.SettleObject.SwapService.CashAllCheques(req.Context, token)
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(res, &CashChequeRet{Cashouts: cashouts})
+		}
+
+		// get the peer id
+		if len(req.Arguments) == 0 {
+			return errors.New("peer id is required without --all")
+		}
+		peerID := req.Arguments[0]
 		tx_hash, err := chain.SettleObject.SwapService.CashCheque(req.Context, peerID, token)
 		if err != nil {
 			return err
@@ -237,6 +260,24 @@ var CashChequeCmd = &cmds.Command{
 	Type: CashChequeRet{},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *CashChequeRet) error {
+			if all, _ := req.Options[cashAllOptionName].(bool); all {
+				if len(out.Cashouts) == 0 {
+					_, err := fmt.Fprintln(w, "no cheques to cash")
+					return err
+				}
+				for _, c := range out.Cashouts {
+					var err error
+					if c.Error != "" {
+						_, err = fmt.Fprintf(w, "peer %s: failed: %s\n", c.Peer, c.Error)
+					} else {
+						_, err = fmt.Fprintf(w, "peer %s: the hash of transaction: %s\n", c.Peer, c.TxHash)
+					}
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}
 			_, err := fmt.Fprintf(w, "the hash of transaction: %s", out.TxHash)
 			return err
 		}),
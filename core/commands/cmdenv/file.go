@@ -219,6 +219,9 @@ func RmPin(req *cmds.Request, res cmds.ResponseEmitter, n *core.IpfsNode, api co
 			options.Pin.RmRecursive(recursive), options.Pin.RmForce(force)); err != nil {
 			return err
 		}
+		if err := corerepo.RemovePinExpiry(req.Context, n.Repo.Datastore(), rp.Cid()); err != nil {
+			return err
+		}
 	}
 
 	if err := cmds.EmitOnce(res, &PinOutput{pins}); err != nil {
@@ -238,11 +238,26 @@ func TestCommands(t *testing.T) {
 		"/storage/path/list",
 		"/storage/path/mkdir",
 		"/storage/path/volumes",
+		"/storage/path/pool",
+		"/storage/path/pool/add",
+		"/storage/path/pool/drain",
+		"/storage/path/pool/rebalance",
+		"/storage/path/confirm",
+		"/storage/path/rollback",
 		"/storage/upload",
 		"/storage/upload/init",
 		"/storage/upload/recvcontract",
 		"/storage/upload/status",
 		"/storage/upload/repair",
+		"/storage/upload/resume",
+		"/storage/upload/renew",
+		"/storage/upload/renewinit",
+		"/storage/upload/recvcancel",
+		"/storage/upload/manifest",
+		"/storage/upload/audit",
+		"/storage/upload/audit/run",
+		"/storage/upload/audit/hosts",
+		"/storage/upload/audit/alerts",
 		"/storage/upload/getcontractbatch",
 		"/storage/upload/signcontractbatch",
 		"/storage/upload/getunsigned",
@@ -255,7 +270,11 @@ func TestCommands(t *testing.T) {
 		"/storage/challenge",
 		"/storage/challenge/request",
 		"/storage/challenge/response",
+		"/storage/challenge/commit",
+		"/storage/challenge/segments",
+		"/storage/challenge/prove",
 		"/storage/dcrepair",
+		"/storage/download",
 		"/storage/dcrepair/request",
 		"/storage/dcrepair/response",
 		"/storage/stats",
@@ -263,6 +282,7 @@ func TestCommands(t *testing.T) {
 		"/storage/stats/sync",
 		"/storage/stats/list",
 		"/storage/contracts",
+		"/storage/contracts/cancel",
 		"/storage/contracts/list",
 		"/storage/contracts/stat",
 		"/storage/contracts/sync",
@@ -278,6 +298,9 @@ func TestCommands(t *testing.T) {
 		"/cheque/send-history-stats",
 		"/cheque/send-history-stats-all",
 		"/cheque/cashlist",
+		"/cheque/autocash",
+		"/cheque/autocash/run",
+		"/cheque/autocash/log",
 		"/cheque/receive-history-stats",
 		"/cheque/receive-history-stats-all",
 		"/cheque/bttbalance",
@@ -339,6 +362,8 @@ func TestCommands(t *testing.T) {
 		"/bittorrent",
 		"/bittorrent/download",
 		"/bittorrent/serve",
+		"/bittorrent/serve/list",
+		"/bittorrent/serve/stop",
 		"/bittorrent/scrape",
 		"/bittorrent/metainfo",
 		"/bittorrent/bencode",
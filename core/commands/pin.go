@@ -11,6 +11,7 @@ import (
 	core "github.com/bittorrent/go-btfs/core"
 	cmdenv "github.com/bittorrent/go-btfs/core/commands/cmdenv"
 	e "github.com/bittorrent/go-btfs/core/commands/e"
+	"github.com/bittorrent/go-btfs/core/corerepo"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	coreiface "github.com/bittorrent/interface-go-btfs-core"
@@ -21,6 +22,7 @@ import (
 	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
+	ds "github.com/ipfs/go-datastore"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	dag "github.com/ipfs/go-merkledag"
 	verifcid "github.com/ipfs/go-verifcid"
@@ -70,10 +72,15 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
-		cmds.IntOption(pinAddDurationCountOptionName, "d", "Duration for which the object is pinned in days. It is unpinned after the duration.").WithDefault(defaultDurationCount),
+		cmds.IntOption(pinAddDurationCountOptionName, "d", "Duration for which the object is pinned in days. It is unpinned after the duration. 0 pins it until it is removed.").WithDefault(defaultDurationCount),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
@@ -82,7 +89,11 @@ var addPinCmd = &cmds.Command{
 		// set options
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
-		// duration := req.Options[pinAddDurationCountOptionName].(int)
+		duration, _ := req.Options[pinAddDurationCountOptionName].(int)
+		if duration < 0 {
+			return fmt.Errorf("invalid duration count %d, must not be negative", duration)
+		}
+		expiry := newPinExpirer(n.Repo.Datastore(), recursive, int64(duration))
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
@@ -94,7 +105,7 @@ var addPinCmd = &cmds.Command{
 		}
 
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, expiry)
 			if err != nil {
 				return err
 			}
@@ -112,7 +123,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, expiry)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -188,7 +199,7 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, expiry *pinExpirer) ([]string, error) {
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -199,12 +210,35 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
 			return nil, err
 		}
+		if err := expiry.apply(ctx, rp.Cid()); err != nil {
+			return nil, err
+		}
 		added[i] = enc.Encode(rp.Cid())
 	}
 
 	return added, nil
 }
 
+// pinExpirer sets the expiry of pins to a duration count from now.
+// A zero duration count makes the pins permanent.
+type pinExpirer struct {
+	d         ds.Datastore
+	recursive bool
+	count     int64
+}
+
+func newPinExpirer(d ds.Datastore, recursive bool, count int64) *pinExpirer {
+	return &pinExpirer{d: d, recursive: recursive, count: count}
+}
+
+func (p *pinExpirer) apply(ctx context.Context, c cid.Cid) error {
+	if p.count == 0 {
+		return corerepo.RemovePinExpiry(ctx, p.d, c)
+	}
+	expiresAt := time.Now().Add(corerepo.PinDurationDays(p.count))
+	return corerepo.SetPinExpiry(ctx, p.d, c, p.recursive, expiresAt)
+}
+
 var rmPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Remove pinned objects from local storage.",
@@ -291,6 +325,8 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Pins that were added with a duration count show their remaining lifetime.
+
 Example:
 	$ echo "hello" | btfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -337,21 +373,29 @@ Example:
 			return err
 		}
 
+		expiries, err := corerepo.ListPinExpiries(req.Context, n.Repo.Datastore())
+		if err != nil {
+			return err
+		}
+
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		emit := res.Emit
 		lgcList := map[string]PinLsType{}
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:      obj.PinLsObject.Type,
+					ExpiresAt: obj.PinLsObject.ExpiresAt,
+				}
 				return nil
 			}
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, n, api, emit)
+			err = pinLsKeys(req, typeStr, n, api, expiries, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, api, expiries, emit)
 		}
 		if err != nil {
 			return err
@@ -386,7 +430,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, pinLifetime(out.PinLsObject.ExpiresAt))
 				}
 				return nil
 			}
@@ -394,7 +438,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, pinLifetime(v.ExpiresAt))
 				}
 			}
 
@@ -416,18 +460,42 @@ type PinLsList struct {
 	Keys map[string]PinLsType
 }
 
-// PinLsType contains the type of a pin
+// PinLsType contains the type of a pin, and its expiry time in unix seconds
+// if it was pinned with a duration
 type PinLsType struct {
-	Type string
+	Type      string
+	ExpiresAt int64 `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid       string `json:",omitempty"`
+	Type      string `json:",omitempty"`
+	ExpiresAt int64  `json:",omitempty"`
+}
+
+// pinExpiresAt returns the expiry of the pin on c in unix seconds, or 0 if it never expires.
+func pinExpiresAt(expiries map[string]*corerepo.PinExpiry, c cid.Cid) int64 {
+	if e, ok := expiries[c.String()]; ok {
+		return e.ExpiresAt.Unix()
+	}
+	return 0
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+// pinLifetime describes the remaining lifetime of a pin for the text output.
+func pinLifetime(expiresAt int64) string {
+	if expiresAt == 0 {
+		return ""
+	}
+	remaining := time.Until(time.Unix(expiresAt, 0)).Round(time.Second)
+	if remaining <= 0 {
+		return " (expired)"
+	}
+	return fmt.Sprintf(" (expires in %s)", remaining)
+}
+
+func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreiface.CoreAPI,
+	expiries map[string]*corerepo.PinExpiry, emit func(value interface{}) error) error {
 	mode, ok := pin.StringToMode(typeStr)
 	if !ok {
 		return fmt.Errorf("invalid pin mode '%s'", typeStr)
@@ -461,8 +529,9 @@ func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreifac
 
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(c.Cid()),
+				Type:      pinType,
+				Cid:       enc.Encode(c.Cid()),
+				ExpiresAt: pinExpiresAt(expiries, c.Cid()),
 			},
 		})
 		if err != nil {
@@ -473,7 +542,8 @@ func pinLsKeys(req *cmds.Request, typeStr string, n *core.IpfsNode, api coreifac
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI,
+	expiries map[string]*corerepo.PinExpiry, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -502,8 +572,9 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		}
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
+				Type:      p.Type(),
+				Cid:       enc.Encode(p.Path().Cid()),
+				ExpiresAt: pinExpiresAt(expiries, p.Path().Cid()),
 			},
 		})
 		if err != nil {
@@ -530,18 +601,32 @@ derivative of an existing one, particularly for large objects. This allows a mor
 efficient DAG-traversal which fully skips already-pinned branches from the old
 object. As a requirement, the old object needs to be an existing recursive
 pin.
+
+The new pin keeps the expiry of the old one, unless --duration-count is given,
+which sets its expiry to the given number of days from now. With only one path,
+the expiry of that pin is extended or shortened in place, and a duration count
+of 0 makes it permanent:
+
+	$ btfs pin update --duration-count=7 QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
+	updated expiry of QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
 `,
 	},
 
 	Arguments: []cmds.Argument{
 		cmds.StringArg("from-path", true, false, "Path to old object."),
-		cmds.StringArg("to-path", true, false, "Path to a new object to be pinned."),
+		cmds.StringArg("to-path", false, false, "Path to a new object to be pinned."),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinUnpinOptionName, "Remove the old pin.").WithDefault(true),
+		cmds.IntOption(pinAddDurationCountOptionName, "d", "Duration for which the new pin is kept in days, counted from now. 0 keeps it until it is removed."),
 	},
 	Type: PinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
 		api, err := cmdenv.GetApi(env, req)
 		if err != nil {
 			return err
@@ -553,26 +638,77 @@ pin.
 		}
 
 		unpin, _ := req.Options[pinUnpinOptionName].(bool)
+		duration, hasDuration := req.Options[pinAddDurationCountOptionName].(int)
+		if duration < 0 {
+			return fmt.Errorf("invalid duration count %d, must not be negative", duration)
+		}
+		d := n.Repo.Datastore()
 
 		// Resolve the paths ahead of time so we can return the actual CIDs
 		from, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
 		if err != nil {
 			return err
 		}
+
+		if len(req.Arguments) < 2 {
+			if !hasDuration {
+				return fmt.Errorf("either to-path or --%s must be given", pinAddDurationCountOptionName)
+			}
+			mode, pinned, err := n.Pinning.IsPinned(req.Context, from.Cid())
+			if err != nil {
+				return err
+			}
+			if !pinned {
+				return fmt.Errorf("path '%s' is not pinned", req.Arguments[0])
+			}
+			recursive := mode == "recursive"
+			if mode != "direct" && !recursive {
+				return fmt.Errorf("path '%s' is pinned %s, only direct and recursive pins can expire", req.Arguments[0], mode)
+			}
+			err = newPinExpirer(d, recursive, int64(duration)).apply(req.Context, from.Cid())
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(res, &PinOutput{Pins: []string{enc.Encode(from.Cid())}})
+		}
+
 		to, err := api.ResolvePath(req.Context, path.New(req.Arguments[1]))
 		if err != nil {
 			return err
 		}
 
+		oldExpiry, err := corerepo.GetPinExpiry(req.Context, d, from.Cid())
+		if err != nil && err != ds.ErrNotFound {
+			return err
+		}
+
 		err = api.Pin().Update(req.Context, from, to, options.Pin.Unpin(unpin))
 		if err != nil {
 			return err
 		}
 
+		if unpin && !from.Cid().Equals(to.Cid()) {
+			if err := corerepo.RemovePinExpiry(req.Context, d, from.Cid()); err != nil {
+				return err
+			}
+		}
+		if hasDuration {
+			err = newPinExpirer(d, true, int64(duration)).apply(req.Context, to.Cid())
+		} else if oldExpiry != nil {
+			err = corerepo.SetPinExpiry(req.Context, d, to.Cid(), true, oldExpiry.ExpiresAt)
+		}
+		if err != nil {
+			return err
+		}
+
 		return cmds.EmitOnce(res, &PinOutput{Pins: []string{enc.Encode(from.Cid()), enc.Encode(to.Cid())}})
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinOutput) error {
+			if len(out.Pins) == 1 {
+				fmt.Fprintf(w, "updated expiry of %s\n", out.Pins[0])
+				return nil
+			}
 			fmt.Fprintf(w, "updated %s to %s\n", out.Pins[0], out.Pins[1])
 			return nil
 		}),
@@ -69,11 +69,8 @@ And if specified a new btfs path, it will be applied.
 				err = daemonCmd.Start()
 				os.Exit(0)
 			}()
-			if req.Options[postPathModificationName].(bool) && path.StorePath != "" && path.OriginPath != "" {
-				if err = path.MoveFolder(); err != nil {
-					return
-				}
-				if err = path.WriteProperties(); err != nil {
+			if req.Options[postPathModificationName].(bool) {
+				if err = path.SwitchOver(); err != nil {
 					return
 				}
 			}
@@ -236,6 +236,7 @@ var rootRemoteSubcommands = map[string]*cmds.Command{
 			"challenge": &cmds.Command{
 				Subcommands: map[string]*cmds.Command{
 					"response": challenge.StorageChallengeResponseCmd,
+					"prove":    challenge.StorageChallengeProveCmd,
 				},
 			},
 			"upload": &cmds.Command{
@@ -244,6 +245,8 @@ var rootRemoteSubcommands = map[string]*cmds.Command{
 					"supporttokens": upload.StorageUploadSupportTokensCmd,
 					"recvcontract":  upload.StorageUploadRecvContractCmd,
 					"cheque":        upload.StorageUploadChequeCmd,
+					"renewinit":     upload.StorageUploadRenewInitCmd,
+					"recvcancel":    upload.StorageUploadRecvCancelCmd,
 				},
 			},
 			"dcrepair": &cmds.Command{
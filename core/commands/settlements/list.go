@@ -9,13 +9,15 @@ import (
 	"time"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs/accounting"
 	"github.com/bittorrent/go-btfs/chain"
 )
 
 type settlementResponse struct {
-	Peer               string   `json:"peer"`
-	SettlementReceived *big.Int `json:"received"`
-	SettlementSent     *big.Int `json:"sent"`
+	Peer               string                 `json:"peer"`
+	SettlementReceived *big.Int               `json:"received"`
+	SettlementSent     *big.Int               `json:"sent"`
+	Ledger             *accounting.PeerLedger `json:"ledger,omitempty"`
 }
 
 type settlementsResponse struct {
@@ -3,19 +3,28 @@ package settlement
 import (
 	"errors"
 	"fmt"
-	"github.com/bittorrent/go-btfs/chain/tokencfg"
-	"github.com/bittorrent/go-btfs/utils"
+	"io"
 	"math/big"
 	"time"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
 	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
 	"github.com/bittorrent/go-btfs/settlement"
+	"github.com/bittorrent/go-btfs/utils"
 )
 
 var PeerSettlementCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Get chequebook balance.",
+		ShortDescription: `
+Shows the settlements with a peer, and reconciles the payments expected for
+each contract with the peer against the cheques actually received or sent.
+Contracts that were under-paid are listed, and whether the peer is in arrears,
+i.e. has payments overdue by more than HostAdmission.PaymentGrace minutes, in
+which case its new contracts are refused.`,
 	},
 	RunTimeout: 5 * time.Minute,
 	Arguments: []cmds.Argument{
@@ -66,6 +75,22 @@ var PeerSettlementCmd = &cmds.Command{
 			peerexists = true
 		}
 
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		policy, err := uh.LoadAdmissionConfig(n.Repo).CreditPolicy()
+		if err != nil {
+			return err
+		}
+		ledger, err := chain.SettleObject.Accounting.PeerLedger(peerID, token, policy)
+		if err != nil {
+			return err
+		}
+		if len(ledger.Receivable.Contracts) > 0 || len(ledger.Payable.Contracts) > 0 {
+			peerexists = true
+		}
+
 		if !peerexists {
 			return fmt.Errorf("can not get settlements for peer:%s", peerID)
 		}
@@ -74,8 +99,47 @@ var PeerSettlementCmd = &cmds.Command{
 			Peer:               peerID,
 			SettlementReceived: received,
 			SettlementSent:     sent,
+			Ledger:             ledger,
 		}
 		return cmds.EmitOnce(res, &rsp)
 	},
 	Type: &settlementResponse{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *settlementResponse) error {
+			fmt.Fprintf(w, "peer %s: received %s, sent %s\n", out.Peer, out.SettlementReceived, out.SettlementSent)
+			l := out.Ledger
+			if l == nil {
+				return nil
+			}
+			fmt.Fprintf(w, "receivable: expected %s, paid %s, outstanding %s, overdue %s\n",
+				l.Receivable.Expected, l.Receivable.Paid, l.Receivable.Outstanding, l.Receivable.Overdue)
+			if l.Unallocated.Sign() > 0 {
+				fmt.Fprintf(w, "paid outside of contracts: %s\n", l.Unallocated)
+			}
+			if l.CreditLimit != nil {
+				fmt.Fprintf(w, "credit limit: %s\n", l.CreditLimit)
+			}
+			if l.InArrears {
+				fmt.Fprintln(w, "the peer is in arrears")
+			}
+			fmt.Fprintf(w, "payable: expected %s, paid %s, outstanding %s\n",
+				l.Payable.Expected, l.Payable.Paid, l.Payable.Outstanding)
+			for _, c := range l.Receivable.UnderPaid() {
+				fmt.Fprintf(w, "under-paid contract %s: expected %s, paid %s, since %s",
+					c.ContractId, c.Expected, c.Paid, time.Unix(c.Created, 0).Format(time.RFC3339))
+				if c.WrittenOff {
+					fmt.Fprint(w, ", written off")
+				}
+				fmt.Fprintln(w)
+			}
+			for _, c := range l.Payable.UnderPaid() {
+				fmt.Fprintf(w, "unpaid contract %s of ours: expected %s, paid %s", c.ContractId, c.Expected, c.Paid)
+				if c.Error != "" {
+					fmt.Fprintf(w, ", error: %s", c.Error)
+				}
+				fmt.Fprintln(w)
+			}
+			return nil
+		}),
+	},
 }
@@ -1,16 +1,24 @@
 package challenge
 
 import (
+	"context"
 	"fmt"
 	"github.com/bittorrent/go-btfs/utils"
 	"strconv"
+	"strings"
 	"time"
 
+	core "github.com/bittorrent/go-btfs/core"
 	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
 	"github.com/bittorrent/go-btfs/core/corehttp/remote"
 
 	cmds "github.com/bittorrent/go-btfs-cmds"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
 	"github.com/bittorrent/go-common/v2/json"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
 
 	cidlib "github.com/ipfs/go-cid"
 )
@@ -22,11 +30,21 @@ var StorageChallengeCmd = &cmds.Command{
 These commands contain both client-side and host-side challenge functions.
 
 btfs storage challenge request <peer-id> <contract-id> <file-hash> <shard-hash> <chunk-index> <nonce>
-btfs storage challenge response <contract-id> <file-hash> <shard-hash> <chunk-index> <nonce>`,
+btfs storage challenge response <contract-id> <file-hash> <shard-hash> <chunk-index> <nonce>
+
+Segment challenges check random segments of a shard against the merkle root
+of its commitment, without the verifier reading the shard:
+
+btfs storage challenge commit <shard-hash>
+btfs storage challenge segments <peer-id> <shard-hash>
+btfs storage challenge prove <shard-hash> <segment-indices>`,
 	},
 	Subcommands: map[string]*cmds.Command{
 		"request":  storageChallengeRequestCmd,
 		"response": StorageChallengeResponseCmd,
+		"commit":   storageChallengeCommitCmd,
+		"segments": storageChallengeSegmentsCmd,
+		"prove":    StorageChallengeProveCmd,
 	},
 }
 
@@ -175,3 +193,316 @@ the challenge request back to the caller.`,
 	},
 	Type: StorageChallengeRes{},
 }
+
+const (
+	segmentCountOptionName = "segment-count"
+	rootOptionName         = "root"
+	shardSizeOptionName    = "shard-size"
+
+	defaultSegmentCount = 8
+	// maxProofSegments is how many segments a host proves in one request
+	maxProofSegments = 256
+)
+
+var storageChallengeCommitCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Compute the segment commitment of a shard.",
+		ShortDescription: `
+This command reads a shard stored on this node once, and saves the merkle root
+over its segments to check the segment proofs of its hosts later. Shards are
+committed when they are uploaded. Segments are as large as the ones hosts
+precompute their trees with.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("shard-hash", true, false, "Shard multihash to commit."),
+	},
+	RunTimeout: 5 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		shardHash, err := cidlib.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		c, err := CommitShard(req.Context, n, api, shardHash)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, c)
+	},
+	Type: Commitment{},
+}
+
+var storageChallengeSegmentsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Challenge a storage host with segment proofs of a shard.",
+		ShortDescription: `
+This command picks random segments of a shard, asks the host for all their
+merkle proofs in one request, and checks them against the commitment of the
+shard. The commitment saved when the shard was uploaded is used, unless the
+root and the shard size are given.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer-id", true, false, "Host Peer ID to challenge."),
+		cmds.StringArg("shard-hash", true, false, "Shard multihash stored at the host."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(segmentCountOptionName, "Number of segments to challenge.").WithDefault(defaultSegmentCount),
+		cmds.StringOption(rootOptionName, "Hex merkle root of the shard commitment."),
+		cmds.Int64Option(shardSizeOptionName, "Size of the shard content in bytes, with --root."),
+	},
+	RunTimeout: 1 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := cmdenv.GetConfig(env)
+		if err != nil {
+			return err
+		}
+		if !cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		shardHash := req.Arguments[1]
+		var c *Commitment
+		if root, ok := req.Options[rootOptionName].(string); ok {
+			size, ok := req.Options[shardSizeOptionName].(int64)
+			if !ok {
+				return fmt.Errorf("--%s is required with --%s", shardSizeOptionName, rootOptionName)
+			}
+			c = &Commitment{ShardHash: shardHash, Root: root, Size: size, SegmentSize: DefaultSegmentSize}
+		} else {
+			c, err = GetCommitment(n.Repo.Datastore(), n.Identity.String(), shardHash)
+			if err != nil {
+				return fmt.Errorf("no commitment of shard %s: %v", shardHash, err)
+			}
+		}
+		// hosts only prove segments of the size they precompute
+		if c.SegmentSize != DefaultSegmentSize {
+			return fmt.Errorf("commitment of shard %s has segments of %d bytes instead of %d, commit it again",
+				shardHash, c.SegmentSize, DefaultSegmentSize)
+		}
+		count := req.Options[segmentCountOptionName].(int)
+		if count <= 0 || count > maxProofSegments {
+			return fmt.Errorf("--%s must be between 1 and %d", segmentCountOptionName, maxProofSegments)
+		}
+		r, err := ChallengeSegments(req.Context, n, api, req.Arguments[0], c, count)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, r)
+	},
+	Type: SegmentChallengeRes{},
+}
+
+// SegmentChallengeRes is the outcome of a segment challenge, Errors has one
+// entry per segment that failed.
+type SegmentChallengeRes struct {
+	ShardHash string
+	Root      string
+	Segments  []int
+	Passed    bool
+	Errors    []string `json:",omitempty"`
+}
+
+// ChallengeSegments asks a host for the proofs of count random segments of a
+// shard, and checks them against its commitment.
+func ChallengeSegments(ctx context.Context, n *core.IpfsNode, api coreiface.CoreAPI, peerId string,
+	c *Commitment, count int) (*SegmentChallengeRes, error) {
+	indices, err := RandomSegments(c.Segments(), count)
+	if err != nil {
+		return nil, err
+	}
+	pi, err := remote.FindPeer(ctx, n, peerId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := remote.P2PCallStrings(ctx, n, api, pi.ID, "/storage/challenge/prove",
+		c.ShardHash, joinIndices(indices))
+	if err != nil {
+		return nil, err
+	}
+	var spr SegmentProofsRes
+	if err := json.Unmarshal(resp, &spr); err != nil {
+		return nil, err
+	}
+	return verifySegmentProofs(c, indices, spr.Proofs), nil
+}
+
+// ChallengeChunk asks a host for the hash of a random chunk of a shard with a
+// nonce, and checks it against the hash of the local copy of the chunk.
+func ChallengeChunk(ctx context.Context, n *core.IpfsNode, api coreiface.CoreAPI, peerId string,
+	contractId string, fileHash, shardHash cidlib.Cid) error {
+	sc, err := NewStorageChallenge(ctx, n, api, fileHash, shardHash)
+	if err != nil {
+		return err
+	}
+	if err := sc.GenChallenge(); err != nil {
+		return err
+	}
+	pi, err := remote.FindPeer(ctx, n, peerId)
+	if err != nil {
+		return err
+	}
+	resp, err := remote.P2PCallStrings(ctx, n, api, pi.ID, "/storage/challenge/response",
+		contractId, fileHash.String(), shardHash.String(), strconv.Itoa(sc.CIndex), sc.Nonce)
+	if err != nil {
+		return err
+	}
+	var scr StorageChallengeRes
+	if err := json.Unmarshal(resp, &scr); err != nil {
+		return err
+	}
+	if scr.Answer != sc.Hash {
+		return fmt.Errorf("wrong answer to the challenge of chunk %d", sc.CIndex)
+	}
+	return nil
+}
+
+func verifySegmentProofs(c *Commitment, indices []int, proofs []*SegmentProof) *SegmentChallengeRes {
+	r := &SegmentChallengeRes{ShardHash: c.ShardHash, Root: c.Root, Segments: indices}
+	byIndex := make(map[int]*SegmentProof)
+	for _, p := range proofs {
+		byIndex[p.Index] = p
+	}
+	for _, i := range indices {
+		p, ok := byIndex[i]
+		if !ok {
+			r.Errors = append(r.Errors, fmt.Sprintf("segment %d: no proof", i))
+		} else if err := c.Verify(p); err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+	r.Passed = len(r.Errors) == 0
+	return r
+}
+
+var StorageChallengeProveCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Storage host proves it stores segments of a shard.",
+		ShortDescription: `
+This command (on host) returns the requested segments of a shard with their
+merkle proofs, from the tree precomputed when the shard was received. Only
+shards of running contracts of the host are proven, from the local repo.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("shard-hash", true, false, "Shard multihash stored at this host."),
+		cmds.StringArg("segment-indices", true, false, "Comma separated indices of the segments to prove."),
+	},
+	RunTimeout: 1 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		cfg, err := cmdenv.GetConfig(env)
+		if err != nil {
+			return err
+		}
+		if !cfg.Experimental.StorageHostEnabled {
+			return fmt.Errorf("storage host api not enabled")
+		}
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		// a shard the host does not hold fails the proof, rather than being fetched
+		api, err = api.WithOptions(options.Api.Offline(true))
+		if err != nil {
+			return err
+		}
+		shardHash, err := cidlib.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		if err := checkShardContract(n, shardHash.String()); err != nil {
+			return err
+		}
+		indices, err := parseIndices(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+		if len(indices) > maxProofSegments {
+			return fmt.Errorf("at most %d segments can be proven at once", maxProofSegments)
+		}
+		t, err := HostSegmentTree(n, shardHash)
+		if err != nil {
+			return err
+		}
+		proofs, err := t.Prove(req.Context, api, indices)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &SegmentProofsRes{Proofs: proofs})
+	},
+	Type: SegmentProofsRes{},
+}
+
+// checkShardContract checks the host has a running contract to store the shard.
+func checkShardContract(n *core.IpfsNode, shardHash string) error {
+	cs, err := sessions.ListShardsContracts(n.Repo.Datastore(), n.Identity.String(), nodepb.ContractStat_HOST.String())
+	if err != nil {
+		return err
+	}
+	ctxParams := &uh.ContextParams{N: n}
+	now := time.Now()
+	for _, sc := range cs {
+		c := sc.SignedGuardContract
+		if c == nil || c.ShardHash != shardHash || c.RentEnd.Before(now) {
+			continue
+		}
+		if canceled, err := sessions.IsHostShardCanceled(ctxParams, c.ContractId); err != nil {
+			return err
+		} else if !canceled {
+			return nil
+		}
+	}
+	return fmt.Errorf("no running contract of shard %s", shardHash)
+}
+
+type SegmentProofsRes struct {
+	Proofs []*SegmentProof
+}
+
+func joinIndices(indices []int) string {
+	ss := make([]string, len(indices))
+	for i, index := range indices {
+		ss[i] = strconv.Itoa(index)
+	}
+	return strings.Join(ss, ",")
+}
+
+func parseIndices(s string) ([]int, error) {
+	var indices []int
+	for _, is := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(is))
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment index %q", is)
+		}
+		indices = append(indices, i)
+	}
+	return indices, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	unixtest "github.com/bittorrent/go-btfs/core/coreunix/test"
 
+	files "github.com/bittorrent/go-btfs-files"
 	unixfs "github.com/bittorrent/go-unixfs"
 	path "github.com/bittorrent/interface-go-btfs-core/path"
 )
@@ -64,3 +65,68 @@ func TestGenAndSolveChallenge(t *testing.T) {
 		}
 	}
 }
+
+func TestProveSegments(t *testing.T) {
+	_, api, root, _ := unixtest.HelpTestAddWithReedSolomonMetadata(t)
+	ctx := context.Background()
+
+	rn, err := api.ResolveNode(ctx, path.IpfsPath(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := unixfs.GetChildrenForDagWithMeta(ctx, rn, api.Dag())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, link := range nodes.DataNode.Links() {
+		// the renter keeps the commitment, the host its tree
+		tree, err := BuildSegmentTree(ctx, api, link.Cid, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Commitment{ShardHash: tree.ShardHash, Root: tree.Root, Size: tree.Size, SegmentSize: tree.SegmentSize}
+
+		indices, err := RandomSegments(c.Segments(), 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs, err := tree.Prove(ctx, api, indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := verifySegmentProofs(c, indices, proofs); !r.Passed {
+			t.Fatalf("segment proofs of shard %s failed: %v", c.ShardHash, r.Errors)
+		}
+
+		proofs[0].Segment[0] ^= 0xff
+		if r := verifySegmentProofs(c, indices, proofs[1:]); r.Passed {
+			t.Fatal("missing segment proof passed")
+		}
+		if r := verifySegmentProofs(c, indices, proofs); r.Passed {
+			t.Fatal("tampered segment proof passed")
+		}
+	}
+	// a larger file spans several nodes of the tree kept by the host
+	data := make([]byte, 5*nodeSegments*64+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	p, err := api.Unixfs().Add(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := BuildSegmentTree(ctx, api, p.Cid(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Commitment{ShardHash: tree.ShardHash, Root: tree.Root, Size: tree.Size, SegmentSize: tree.SegmentSize}
+	indices := []int{0, nodeSegments - 1, nodeSegments, 3*nodeSegments + 7, c.Segments() - 1}
+	proofs, err := tree.Prove(ctx, api, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := verifySegmentProofs(c, indices, proofs); !r.Passed {
+		t.Fatalf("segment proofs of a larger file failed: %v", r.Errors)
+	}
+}
@@ -0,0 +1,101 @@
+package challenge
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, so that an inner
+// node cannot be passed off as a segment.
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+// merkleTree is a binary hash tree over the segments of a shard. An odd node
+// at the end of a level is promoted to the next level as is.
+type merkleTree struct {
+	// levels[0] are the leaf hashes, the last level is the root
+	levels [][][]byte
+}
+
+func hashLeaf(segment []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(segment)
+	return h.Sum(nil)
+}
+
+func hashInner(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{innerPrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// newMerkleTree builds the tree over leaf hashes, there must be at least one.
+func newMerkleTree(leaves [][]byte) *merkleTree {
+	t := &merkleTree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, hashInner(level[i], level[i+1]))
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+func (t *merkleTree) root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// path returns the sibling hashes from the leaf at index up to the root.
+func (t *merkleTree) path(index int) [][]byte {
+	var path [][]byte
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			path = append(path, level[sibling])
+		}
+		index /= 2
+	}
+	return path
+}
+
+// verifyPath checks that a segment is the leaf at index of the tree with the
+// given root and number of leaves.
+func verifyPath(root []byte, leaves int, index int, segment []byte, path [][]byte) error {
+	if index < 0 || index >= leaves {
+		return fmt.Errorf("segment index %d is out of range", index)
+	}
+	h := hashLeaf(segment)
+	for width := leaves; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			if len(path) == 0 {
+				return errors.New("merkle path is too short")
+			}
+			if index%2 == 0 {
+				h = hashInner(h, path[0])
+			} else {
+				h = hashInner(path[0], h)
+			}
+			path = path[1:]
+		}
+		index /= 2
+	}
+	if len(path) != 0 {
+		return errors.New("merkle path is too long")
+	}
+	if !bytes.Equal(h, root) {
+		return errors.New("merkle path does not lead to the root")
+	}
+	return nil
+}
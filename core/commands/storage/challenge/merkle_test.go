@@ -0,0 +1,130 @@
+package challenge
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// testLeaves hashes the segments of data.
+func testLeaves(data []byte, segmentSize int) [][]byte {
+	var leaves [][]byte
+	for i := 0; i < len(data); i += segmentSize {
+		end := i + segmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		leaves = append(leaves, hashLeaf(data[i:end]))
+	}
+	if len(leaves) == 0 {
+		leaves = append(leaves, hashLeaf(nil))
+	}
+	return leaves
+}
+
+func segmentOf(data []byte, index, segmentSize int) []byte {
+	end := (index + 1) * segmentSize
+	if end > len(data) {
+		end = len(data)
+	}
+	return data[index*segmentSize : end]
+}
+
+func TestSegmentProofs(t *testing.T) {
+	for size := 0; size <= 10*16; size += 7 {
+		data := bytes.Repeat([]byte("0123456789abcdef"), 10)[:size]
+		leaves := testLeaves(data, 16)
+		tree := newMerkleTree(leaves)
+		c := &Commitment{ShardHash: "shard", Root: hex.EncodeToString(tree.root()), Size: int64(size), SegmentSize: 16}
+		if c.Segments() != len(leaves) {
+			t.Fatalf("size %d: %d segments, %d leaves", size, c.Segments(), len(leaves))
+		}
+		for i := 0; i < c.Segments(); i++ {
+			seg := segmentOf(data, i, 16)
+			p := &SegmentProof{Index: i, Segment: seg, Path: tree.path(i)}
+			if err := c.Verify(p); err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if len(seg) == 0 {
+				continue
+			}
+			// a segment that was not stored fails
+			bad := append([]byte(nil), seg...)
+			bad[0] ^= 0xff
+			if err := c.Verify(&SegmentProof{Index: i, Segment: bad, Path: p.Path}); err == nil {
+				t.Fatalf("size %d: tampered segment %d verified", size, i)
+			}
+			// so does a segment proven for another index
+			if c.Segments() > 1 {
+				other := (i + 1) % c.Segments()
+				if err := c.Verify(&SegmentProof{Index: other, Segment: seg, Path: p.Path}); err == nil &&
+					!bytes.Equal(seg, segmentOf(data, other, 16)) {
+					t.Fatalf("size %d: segment %d verified as %d", size, i, other)
+				}
+			}
+		}
+	}
+}
+
+func TestSegmentTreeNodes(t *testing.T) {
+	// the level of nodes kept by the host leads to the root and paths of the whole tree
+	for _, n := range []int{1, nodeSegments - 1, nodeSegments, nodeSegments + 1, 3*nodeSegments + 5} {
+		leaves := make([][]byte, n)
+		for i := range leaves {
+			leaves[i] = hashLeaf([]byte{byte(i), byte(i >> 8)})
+		}
+		whole := newMerkleTree(leaves)
+		st := &SegmentTree{Commitment: Commitment{ShardHash: "shard", Size: int64(n), SegmentSize: 1}}
+		for i := 0; i < n; i += nodeSegments {
+			end := i + nodeSegments
+			if end > n {
+				end = n
+			}
+			st.Nodes = append(st.Nodes, newMerkleTree(leaves[i:end]).root()...)
+		}
+		if err := st.init(); err != nil {
+			t.Fatal(err)
+		}
+		if st.Root != hex.EncodeToString(whole.root()) {
+			t.Fatalf("%d leaves: root differs from the whole tree", n)
+		}
+		for i := 0; i < n; i++ {
+			node := i / nodeSegments
+			end := (node + 1) * nodeSegments
+			if end > n {
+				end = n
+			}
+			sub := newMerkleTree(leaves[node*nodeSegments : end])
+			path := append(sub.path(i-node*nodeSegments), st.top.path(node)...)
+			want := whole.path(i)
+			if len(path) != len(want) {
+				t.Fatalf("%d leaves: path of %d has %d hashes, expect %d", n, i, len(path), len(want))
+			}
+			for j := range want {
+				if !bytes.Equal(path[j], want[j]) {
+					t.Fatalf("%d leaves: path of %d differs from the whole tree", n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestRandomSegments(t *testing.T) {
+	indices, err := RandomSegments(100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, i := range indices {
+		if i < 0 || i >= 100 || seen[i] {
+			t.Fatalf("invalid segments %v", indices)
+		}
+		seen[i] = true
+	}
+	if len(indices) != 10 {
+		t.Fatalf("expect 10 segments, got %d", len(indices))
+	}
+	if indices, _ := RandomSegments(3, 10); len(indices) != 3 {
+		t.Fatalf("expect all 3 segments, got %v", indices)
+	}
+}
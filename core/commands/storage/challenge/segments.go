@@ -0,0 +1,355 @@
+package challenge
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+
+	core "github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	// DefaultSegmentSize is the size of the segments a shard is split into for
+	// segment proofs.
+	DefaultSegmentSize = 4096
+	// nodeSegments is how many segments a node of the level of the tree kept by
+	// the host covers, a power of two. The segments under a node are hashed
+	// again to prove one of them.
+	nodeSegments = 64
+
+	hostSegmentTreeKey  = "/btfs/%s/host/segment-trees/%s"
+	renterCommitmentKey = "/btfs/%s/renter/commitments/%s"
+)
+
+// Commitment is all a verifier needs to check segment proofs of a shard. The
+// content of a shard is the raw data of the blocks of its DAG, in depth first
+// order, split into segments of SegmentSize bytes.
+type Commitment struct {
+	ShardHash string
+	// Root is the hex merkle root over the segments.
+	Root        string
+	Size        int64
+	SegmentSize int
+}
+
+// SegmentProof proves the segment at Index is part of a shard, with the sibling
+// hashes from the segment up to the root.
+type SegmentProof struct {
+	Index   int
+	Segment []byte
+	Path    [][]byte
+}
+
+// SegmentTree is the commitment of a shard with the level of its merkle tree
+// whose nodes each cover nodeSegments segments, precomputed by the host when the
+// shard is received.
+type SegmentTree struct {
+	Commitment
+	// Nodes are the hashes of the nodes of the level, concatenated.
+	Nodes []byte
+	// Blocks are the blocks of the shard with their offset in its content, so
+	// that segments are read from the blocks they span only.
+	Blocks []*ShardBlock
+
+	top *merkleTree
+}
+
+type ShardBlock struct {
+	Cid    string
+	Offset int64
+}
+
+// Segments is the number of segments of the shard.
+func (c *Commitment) Segments() int {
+	n := int((c.Size + int64(c.SegmentSize) - 1) / int64(c.SegmentSize))
+	if n == 0 {
+		// an empty shard has one empty segment
+		n = 1
+	}
+	return n
+}
+
+// Verify checks a segment proof against the commitment.
+func (c *Commitment) Verify(p *SegmentProof) error {
+	root, err := hex.DecodeString(c.Root)
+	if err != nil {
+		return fmt.Errorf("invalid commitment root: %v", err)
+	}
+	n := c.Segments()
+	if p.Index < 0 || p.Index >= n {
+		return fmt.Errorf("segment index %d is out of range", p.Index)
+	}
+	size := int64(c.SegmentSize)
+	if p.Index == n-1 {
+		size = c.Size - int64(p.Index)*int64(c.SegmentSize)
+	}
+	if int64(len(p.Segment)) != size {
+		return fmt.Errorf("segment %d has %d bytes, expect %d", p.Index, len(p.Segment), size)
+	}
+	if err := verifyPath(root, n, p.Index, p.Segment, p.Path); err != nil {
+		return fmt.Errorf("segment %d: %v", p.Index, err)
+	}
+	return nil
+}
+
+// BuildSegmentTree reads the whole shard once to build its tree.
+func BuildSegmentTree(ctx context.Context, api coreiface.CoreAPI, shardHash cid.Cid,
+	segmentSize int) (*SegmentTree, error) {
+	if segmentSize <= 0 {
+		return nil, fmt.Errorf("invalid segment size %d", segmentSize)
+	}
+	t := &SegmentTree{Commitment: Commitment{ShardHash: shardHash.String(), SegmentSize: segmentSize}}
+	seg := make([]byte, 0, segmentSize)
+	// the leaves under the node being hashed
+	leaves := make([][]byte, 0, nodeSegments)
+	addLeaf := func(leaf []byte) {
+		if leaves = append(leaves, leaf); len(leaves) == nodeSegments {
+			t.Nodes = append(t.Nodes, newMerkleTree(leaves).root()...)
+			leaves = leaves[:0]
+		}
+	}
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		nd, err := api.Dag().Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		t.Blocks = append(t.Blocks, &ShardBlock{Cid: c.String(), Offset: t.Size})
+		data := nd.RawData()
+		t.Size += int64(len(data))
+		for len(data) > 0 {
+			n := segmentSize - len(seg)
+			if n > len(data) {
+				n = len(data)
+			}
+			seg, data = append(seg, data[:n]...), data[n:]
+			if len(seg) == segmentSize {
+				addLeaf(hashLeaf(seg))
+				seg = seg[:0]
+			}
+		}
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(shardHash); err != nil {
+		return nil, err
+	}
+	if len(seg) > 0 || t.Size == 0 {
+		addLeaf(hashLeaf(seg))
+	}
+	if len(leaves) > 0 {
+		t.Nodes = append(t.Nodes, newMerkleTree(leaves).root()...)
+	}
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *SegmentTree) init() error {
+	n := (t.Segments() + nodeSegments - 1) / nodeSegments
+	if len(t.Nodes) != n*sha256.Size {
+		return fmt.Errorf("segment tree of shard %s has %d bytes of nodes, expect %d", t.ShardHash,
+			len(t.Nodes), n*sha256.Size)
+	}
+	nodes := make([][]byte, n)
+	for i := range nodes {
+		nodes[i] = t.Nodes[i*sha256.Size : (i+1)*sha256.Size]
+	}
+	// the segments under a node hash to the same node alone as in the whole
+	// tree, so the tree above the level is the tree over the nodes
+	t.top = newMerkleTree(nodes)
+	t.Root = hex.EncodeToString(t.top.root())
+	return nil
+}
+
+// Prove reads the given segments of the shard and returns their proofs. The
+// segments under the node of a segment are read and hashed again for its path
+// up to the level of the nodes, which fails if they changed.
+func (t *SegmentTree) Prove(ctx context.Context, api coreiface.CoreAPI, indices []int) ([]*SegmentProof, error) {
+	blocks := make(map[int][]byte)
+	subtrees := make(map[int]*merkleTree)
+	segments := t.Segments()
+	proofs := make([]*SegmentProof, 0, len(indices))
+	for _, index := range indices {
+		if index < 0 || index >= segments {
+			return nil, fmt.Errorf("segment index %d is out of range", index)
+		}
+		node := index / nodeSegments
+		first := node * nodeSegments
+		sub, ok := subtrees[node]
+		if !ok {
+			last := first + nodeSegments
+			if last > segments {
+				last = segments
+			}
+			leaves := make([][]byte, 0, last-first)
+			for i := first; i < last; i++ {
+				seg, err := t.readSegment(ctx, api, blocks, i)
+				if err != nil {
+					return nil, err
+				}
+				leaves = append(leaves, hashLeaf(seg))
+			}
+			sub = newMerkleTree(leaves)
+			if !bytes.Equal(sub.root(), t.top.levels[0][node]) {
+				return nil, fmt.Errorf("segments %d to %d of shard %s do not match its tree", first, last-1, t.ShardHash)
+			}
+			subtrees[node] = sub
+		}
+		seg, err := t.readSegment(ctx, api, blocks, index)
+		if err != nil {
+			return nil, err
+		}
+		path := append(sub.path(index-first), t.top.path(node)...)
+		proofs = append(proofs, &SegmentProof{Index: index, Segment: seg, Path: path})
+	}
+	return proofs, nil
+}
+
+// readSegment reads the segment at index from the blocks it spans, keeping the
+// blocks read in blocks by their position.
+func (t *SegmentTree) readSegment(ctx context.Context, api coreiface.CoreAPI, blocks map[int][]byte,
+	index int) ([]byte, error) {
+	start := int64(index) * int64(t.SegmentSize)
+	end := start + int64(t.SegmentSize)
+	if end > t.Size {
+		end = t.Size
+	}
+	seg := make([]byte, 0, end-start)
+	// the last block starting at or before the segment
+	i := sort.Search(len(t.Blocks), func(i int) bool { return t.Blocks[i].Offset > start }) - 1
+	for ; i >= 0 && i < len(t.Blocks) && t.Blocks[i].Offset < end; i++ {
+		data, ok := blocks[i]
+		if !ok {
+			c, err := cid.Parse(t.Blocks[i].Cid)
+			if err != nil {
+				return nil, err
+			}
+			nd, err := api.Dag().Get(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			data = nd.RawData()
+			blocks[i] = data
+		}
+		from, to := start-t.Blocks[i].Offset, end-t.Blocks[i].Offset
+		if from < 0 {
+			from = 0
+		}
+		if to > int64(len(data)) {
+			to = int64(len(data))
+		}
+		seg = append(seg, data[from:to]...)
+	}
+	if int64(len(seg)) != end-start {
+		return nil, fmt.Errorf("segment %d of shard %s is incomplete", index, t.ShardHash)
+	}
+	return seg, nil
+}
+
+// RandomSegments picks count distinct segment indices out of n, all of them
+// when count is not less than n.
+func RandomSegments(n, count int) ([]int, error) {
+	indices := make([]int, n)
+	for i := range indices {
+		indices[i] = i
+	}
+	if count >= n {
+		return indices, nil
+	}
+	for i := 0; i < count; i++ {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(n-i)))
+		if err != nil {
+			return nil, err
+		}
+		k := i + int(j.Int64())
+		indices[i], indices[k] = indices[k], indices[i]
+	}
+	indices = indices[:count]
+	sort.Ints(indices)
+	return indices, nil
+}
+
+// PrecomputeSegmentTree builds and saves the tree of a shard received by the
+// host, to answer segment challenges without reading the whole shard.
+func PrecomputeSegmentTree(ctx context.Context, node *core.IpfsNode, api coreiface.CoreAPI,
+	shardHash cid.Cid) (*SegmentTree, error) {
+	t, err := BuildSegmentTree(ctx, api, shardHash, DefaultSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveSegmentTree(node.Repo.Datastore(), node.Identity.String(), t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// HostSegmentTree returns the precomputed tree of a shard the host holds.
+func HostSegmentTree(node *core.IpfsNode, shardHash cid.Cid) (*SegmentTree, error) {
+	t, err := GetSegmentTree(node.Repo.Datastore(), node.Identity.String(), shardHash.String())
+	if err == ds.ErrNotFound {
+		return nil, fmt.Errorf("no segment tree of shard %s", shardHash)
+	}
+	return t, err
+}
+
+// CommitShard computes the commitment of a shard held by the renter, and saves
+// it to verify segment proofs of the hosts later. Its segments are the ones of
+// the trees the hosts precompute.
+func CommitShard(ctx context.Context, node *core.IpfsNode, api coreiface.CoreAPI,
+	shardHash cid.Cid) (*Commitment, error) {
+	t, err := BuildSegmentTree(ctx, api, shardHash, DefaultSegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveCommitment(node.Repo.Datastore(), node.Identity.String(), &t.Commitment); err != nil {
+		return nil, err
+	}
+	return &t.Commitment, nil
+}
+
+func SaveSegmentTree(d ds.Datastore, peerId string, t *SegmentTree) error {
+	return sessions.SaveJSON(d, fmt.Sprintf(hostSegmentTreeKey, peerId, t.ShardHash), t)
+}
+
+func GetSegmentTree(d ds.Datastore, peerId string, shardHash string) (*SegmentTree, error) {
+	t := new(SegmentTree)
+	if err := sessions.GetJSON(d, fmt.Sprintf(hostSegmentTreeKey, peerId, shardHash), t); err != nil {
+		return nil, err
+	}
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func RemoveSegmentTree(d ds.Datastore, peerId string, shardHash string) error {
+	return sessions.Remove(d, fmt.Sprintf(hostSegmentTreeKey, peerId, shardHash))
+}
+
+func SaveCommitment(d ds.Datastore, peerId string, c *Commitment) error {
+	return sessions.SaveJSON(d, fmt.Sprintf(renterCommitmentKey, peerId, c.ShardHash), c)
+}
+
+func GetCommitment(d ds.Datastore, peerId string, shardHash string) (*Commitment, error) {
+	c := new(Commitment)
+	if err := sessions.GetJSON(d, fmt.Sprintf(renterCommitmentKey, peerId, shardHash), c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,214 @@
+package contracts
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
+	"github.com/bittorrent/go-btfs-common/utils/grpc"
+	"github.com/bittorrent/protobuf/proto"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const cancelHostTimeout = time.Minute
+
+// sub-commands: btfs storage contracts cancel
+var storageContractsCancelCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Cancel storage contracts as a renter.",
+		ShortDescription: `
+This command cancels a contract of a file this node uploaded, or all the
+contracts of the file when a file hash is given:
+    $ btfs storage contracts cancel <contract-id|file-hash>
+
+A signed cancellation is sent to each host, which stops storing the shard, and to
+the guard, which stops the payouts left on the contract, e.g. of a customized
+payout schedule. The contracts are then neither paid nor renewed by this node,
+and they are listed as canceled in the contracts stats of both sides.
+
+A host that cannot be reached is still released by the guard, and drops the
+shard on its next contracts sync.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("contract-id", true, false, "ID of the contract to cancel, or hash of the file whose contracts to cancel."),
+	},
+	RunTimeout: 10 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		targets, err := cancelTargets(ctxParams, req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		result := &CancelRes{}
+		for _, t := range targets {
+			result.Contracts = append(result.Contracts, cancelContract(req.Context, ctxParams, t))
+		}
+		return cmds.EmitOnce(res, result)
+	},
+	Type: CancelRes{},
+}
+
+type CancelRes struct {
+	Contracts []*CanceledContract
+}
+
+// CanceledContract is the outcome of the cancellation of a contract.
+type CanceledContract struct {
+	ContractId string
+	FileHash   string
+	ShardIndex int
+	Host       string
+	Canceled   bool
+	// HostError is why the host could not be told, it then drops the shard
+	// on its next contracts sync.
+	HostError string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+// cancelTarget is a contract of a shard of a renter session.
+type cancelTarget struct {
+	rss      *sessions.RenterSession
+	shard    *sessions.RenterShard
+	index    int
+	contract *guardpb.Contract
+}
+
+// cancelTargets returns the contract with the given ID, or the contracts of the
+// file with the given hash that are not canceled yet.
+func cancelTargets(ctxParams *uh.ContextParams, arg string) ([]*cancelTarget, error) {
+	cursor, err := sessions.GetRenterSessionsCursor(ctxParams)
+	if err != nil {
+		return nil, err
+	}
+	statuses := append(sessions.ResumableStatuses(), sessions.RssCompleteStatus)
+	targets := make([]*cancelTarget, 0)
+	for {
+		rss, err := cursor.NextSession(statuses...)
+		if err != nil {
+			break
+		}
+		for pos, h := range rss.ShardHashes {
+			i := rss.ShardIndex(pos)
+			shard, err := sessions.GetRenterShard(ctxParams, rss.SsId, h, i)
+			if err != nil {
+				return nil, err
+			}
+			contracts, err := shard.Contracts()
+			if err != nil {
+				return nil, err
+			}
+			c := contracts.SignedGuardContract
+			if c == nil || (rss.Hash != arg && c.ContractId != arg) {
+				continue
+			}
+			canceled, err := shard.IsCanceled()
+			if err != nil {
+				return nil, err
+			}
+			if c.ContractId == arg {
+				if canceled {
+					return nil, fmt.Errorf("contract %s is already canceled", arg)
+				}
+				return []*cancelTarget{{rss, shard, i, c}}, nil
+			}
+			if !canceled {
+				targets = append(targets, &cancelTarget{rss, shard, i, c})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no contracts of %s to cancel", arg)
+	}
+	return targets, nil
+}
+
+// cancelContract sends a signed cancellation of the contract to its host and to
+// the guard, and records it as canceled once the guard accepted it.
+func cancelContract(ctx context.Context, ctxParams *uh.ContextParams, t *cancelTarget) *CanceledContract {
+	c := t.contract
+	cc := &CanceledContract{
+		ContractId: c.ContractId,
+		FileHash:   c.FileHash,
+		ShardIndex: t.index,
+		Host:       c.HostPid,
+	}
+	err := func() error {
+		cancelReq := &guardpb.CancelContractRequest{
+			FileHash:   c.FileHash,
+			ShardHash:  c.ShardHash,
+			ContractId: c.ContractId,
+			RenterPid:  ctxParams.N.Identity.String(),
+			HostPid:    c.HostPid,
+			Reason:     guardpb.CancelContractRequest_RENTER_REQUEST,
+			SignTime:   time.Now().UTC(),
+		}
+		sig, err := crypto.Sign(ctxParams.N.PrivateKey, cancelReq)
+		if err != nil {
+			return err
+		}
+		cancelReq.Signature = sig
+
+		hostErr := cancelWithHost(ctx, ctxParams, cancelReq)
+		if hostErr != nil {
+			cc.HostError = hostErr.Error()
+		}
+		cb := grpc.GuardClient(ctxParams.Cfg.Services.GuardDomain)
+		cb.Timeout(guardTimeout)
+		err = cb.WithContext(ctx, func(ctx context.Context, client guardpb.GuardServiceClient) error {
+			_, err := client.ReportFailToDownload(ctx, cancelReq)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("guard: %v", err)
+		}
+		if err := t.shard.Cancel(); err != nil {
+			return err
+		}
+		event := sessions.ShardEvent(sessions.EventCancel, t.index, c.ShardHash, c.HostPid, hostErr)
+		event.Message = fmt.Sprintf("contract %s canceled", c.ContractId)
+		t.rss.AddEvent(event)
+		return SaveCanceledContract(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(),
+			nodepb.ContractStat_RENTER.String(), c)
+	}()
+	if err != nil {
+		cc.Error = err.Error()
+	} else {
+		cc.Canceled = true
+	}
+	return cc
+}
+
+func cancelWithHost(ctx context.Context, ctxParams *uh.ContextParams, cancelReq *guardpb.CancelContractRequest) error {
+	hostPid, err := peer.Decode(cancelReq.HostPid)
+	if err != nil {
+		return err
+	}
+	reqBytes, err := proto.Marshal(cancelReq)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cancelHostTimeout)
+	defer cancel()
+	_, err = remote.P2PCall(ctx, ctxParams.N, ctxParams.Api, hostPid, "/storage/upload/recvcancel", reqBytes)
+	return err
+}
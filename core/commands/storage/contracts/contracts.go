@@ -13,7 +13,9 @@ import (
 	"github.com/bittorrent/go-btfs/core"
 	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
 	"github.com/bittorrent/go-btfs/core/commands/rm"
+	"github.com/bittorrent/go-btfs/core/commands/storage/challenge"
 	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
 	"github.com/bittorrent/go-btfs/logger"
 	contractspb "github.com/bittorrent/go-btfs/protos/contracts"
@@ -56,7 +58,7 @@ const (
 
 // Storage Contracts
 //
-// Includes sub-commands: sync, stat, list
+// Includes sub-commands: sync, stat, list, cancel
 var StorageContractsCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Get node storage contracts info.",
@@ -64,9 +66,10 @@ var StorageContractsCmd = &cmds.Command{
 This command get node storage contracts info respect to different roles.`,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"sync": storageContractsSyncCmd,
-		"stat": storageContractsStatCmd,
-		"list": storageContractsListCmd,
+		"sync":   storageContractsSyncCmd,
+		"stat":   storageContractsStatCmd,
+		"list":   storageContractsListCmd,
+		"cancel": storageContractsCancelCmd,
 	},
 }
 
@@ -333,6 +336,40 @@ func ListContracts(d datastore.Datastore, peerId, role string) ([]*nodepb.Contra
 	return fcs, nil
 }
 
+// SaveCanceledContract records a contract as canceled in the contracts stats of
+// the role, adding it if it was not synced from the guard yet.
+func SaveCanceledContract(d datastore.Datastore, peerId, role string, c *guardpb.Contract) error {
+	cs, err := ListContracts(d, peerId, role)
+	if err != nil {
+		return err
+	}
+	var ct *nodepb.Contracts_Contract
+	for _, e := range cs {
+		if e.ContractId == c.ContractId {
+			ct = e
+			break
+		}
+	}
+	if ct == nil {
+		ct = &nodepb.Contracts_Contract{
+			ContractId: c.ContractId,
+			HostId:     c.HostPid,
+			RenterId:   c.RenterPid,
+			StartTime:  c.RentStart,
+			EndTime:    c.RentEnd,
+			UnitPrice:  c.Price,
+			ShardSize:  c.ShardFileSize,
+			ShardHash:  c.ShardHash,
+			FileHash:   c.FileHash,
+		}
+		cs = append(cs, ct)
+	}
+	ct.Status = guardpb.Contract_CANCELED
+	// nothing is paid out on a canceled contract anymore
+	ct.CompensationOutstanding = 0
+	return Save(d, cs, role)
+}
+
 // SyncContracts does the following:
 // 1) Obtain latest guard contract updates and saves into cache
 // 2) Obtain latest payout status updates and saves into cache
@@ -379,6 +416,22 @@ func SyncContracts(ctx context.Context, n *core.IpfsNode, req *cmds.Request, env
 					contractsLog.Error("stale contracts clean up error:", err)
 				}
 			}()
+			released, err := releaseCanceledShards(&uh.ContextParams{Ctx: ctx, N: n}, updated)
+			if err != nil {
+				return err
+			}
+			go func() {
+				for _, h := range released {
+					if _, err := rm.RmDag(context.Background(), []string{h}, n, req, env, true); err != nil {
+						contractsLog.Error("canceled shard clean up error:", err)
+						continue
+					}
+					err := challenge.RemoveSegmentTree(n.Repo.Datastore(), n.Identity.Pretty(), h)
+					if err != nil {
+						contractsLog.Error("canceled shard segment tree clean up error:", err)
+					}
+				}
+			}()
 		}
 	}
 	if len(cs) > 0 {
@@ -423,6 +476,35 @@ func SyncContracts(ctx context.Context, n *core.IpfsNode, req *cmds.Request, env
 	return nil
 }
 
+// releaseCanceledShards records the updated contracts that the guard reports as
+// canceled as canceled on this host, e.g. of a renter that could not reach the
+// host to cancel them, and returns the shards that no running contract stores.
+func releaseCanceledShards(ctxParams *uh.ContextParams, updated []*guardpb.Contract) ([]string, error) {
+	var released []string
+	for _, c := range updated {
+		if c.State != guardpb.Contract_CANCELED {
+			continue
+		}
+		// a contract the renter canceled with this host was released already
+		if canceled, err := sessions.IsHostShardCanceled(ctxParams, c.ContractId); err != nil {
+			return nil, err
+		} else if canceled {
+			continue
+		}
+		if err := sessions.CancelHostShard(ctxParams, c.ContractId); err != nil {
+			return nil, err
+		}
+		kept, err := sessions.HostShardKept(ctxParams, c)
+		if err != nil {
+			return nil, err
+		}
+		if !kept {
+			released = append(released, c.ShardHash)
+		}
+	}
+	return released, nil
+}
+
 // GetUpdatedGuardContractsForHost retrieves updated guard contracts from remote based on latest timestamp
 // and returns the list updated
 func GetUpdatedGuardContractsForHost(ctx context.Context, n *core.IpfsNode,
@@ -575,6 +657,11 @@ func syncContractPayoutStatus(ctx context.Context, n *core.IpfsNode,
 		// If already exists, update existing
 		// Otherwise append/add to list
 		if cti, ok := ctsIndexMap[resCt.ContractId]; ok {
+			// a contract this node canceled stays canceled, also while the guard
+			// still reports its former state
+			if cts[cti].Status == guardpb.Contract_CANCELED {
+				resCt.Status = guardpb.Contract_CANCELED
+			}
 			cts[cti] = resCt
 		} else {
 			cts = append(cts, resCt)
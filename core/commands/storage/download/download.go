@@ -0,0 +1,268 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	gopath "path"
+	"time"
+
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	logging "github.com/ipfs/go-log"
+	"github.com/whyrusleeping/tar-utils"
+)
+
+const (
+	outputOptionName       = "output"
+	shardTimeoutOptionName = "shard-timeout"
+
+	defaultShardTimeout = "5m"
+)
+
+var log = logging.Logger("core/commands/storage/download")
+
+var StorageDownloadCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Download an uploaded file from the hosts that store its shards.",
+		ShortDescription: `
+This command retrieves a reed-solomon encoded file that this node uploaded, from
+the hosts in its contracts. The hosts are looked up in the local upload sessions,
+and in the file meta on the guard. The data and parity shards are fetched in
+parallel, and the file is reconstructed from the first #data shards
+that arrive, so that it can be read while some hosts are down:
+    $ btfs storage download <file-hash> -o <path>
+
+With -o, the file or directory is streamed to the client and written to <path>, as
+with 'btfs get'. The fetched shards are not pinned, and are removed by the next
+garbage collection. Run without -o to see the shards that were not fetched.
+
+Without -o, the fetched shards and the nodes of the file are pinned, so that the
+file stays in the local repo and can be read with 'btfs get' or 'btfs cat'. They
+stay pinned until removed with 'btfs pin rm'.
+
+A file or directory that was encoded on the fly when it was uploaded can be
+downloaded by its original hash, its manifest tells the encoded files.
+
+The hosts are connected first, but the shards are fetched over bitswap, which asks
+every connected peer for them, so a shard may be served by a peer other than its
+host. Unresponsive therefore only reflects connectivity: it lists the hosts of the
+shards that no peer served in time, either because the host could not be connected
+or because it did not answer. It does not prove that a host lost its shard, nor
+does a fetched shard prove that its host still stores it. The shards that were not
+fetched are listed in RepairShards, and can be placed again with e.g.:
+    $ btfs storage upload repair <file-hash> <repair-shards> <renter-pid> <unresponsive-hosts>`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, false, "Hash of the uploaded file."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(outputOptionName, "o", "Path to write the file or directory to."),
+		cmds.StringOption(shardTimeoutOptionName, "t", "How long to wait for a shard to be fetched.").WithDefault(defaultShardTimeout),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		timeout, err := time.ParseDuration(req.Options[shardTimeoutOptionName].(string))
+		if err != nil {
+			return fmt.Errorf("invalid shard timeout: %v", err)
+		}
+		output, _ := req.Options[outputOptionName].(string)
+		fileHash := req.Arguments[0]
+		if _, err := cidlib.Parse(fileHash); err != nil {
+			return err
+		}
+
+		targets, err := downloadTargets(ctxParams, fileHash)
+		if err != nil {
+			return err
+		}
+		result := &DownloadRes{FileHash: fileHash}
+		unresponsive := make(map[string]bool)
+		for _, t := range targets {
+			if t.hash == "" {
+				// directories and empty files of a manifest have nothing to download
+				continue
+			}
+			fr := &FileRes{Path: t.path, FileHash: t.hash}
+			result.Files = append(result.Files, fr)
+			err := Retrieve(req.Context, ctxParams, fr, timeout)
+			for _, s := range fr.Shards {
+				if s.Status == ShardFailedStatus {
+					for _, h := range s.Hosts {
+						unresponsive[h] = true
+					}
+				}
+			}
+			if err != nil {
+				result.Unresponsive = sortedKeys(unresponsive)
+				if output != "" {
+					return fmt.Errorf("%v, unresponsive hosts: %v", err, result.Unresponsive)
+				}
+				result.Error = err.Error()
+				return res.Emit(result)
+			}
+			if output == "" {
+				if err := pinFile(req.Context, ctxParams, fr); err != nil {
+					return err
+				}
+			}
+		}
+		result.Unresponsive = sortedKeys(unresponsive)
+		if output == "" {
+			return res.Emit(result)
+		}
+		if len(result.Unresponsive) > 0 {
+			log.Infof("download %s: unresponsive hosts: %v", fileHash, result.Unresponsive)
+		}
+		node, err := outputNode(req.Context, ctxParams, targets)
+		if err != nil {
+			return err
+		}
+		reader, err := tarReader(node, fileHash)
+		if err != nil {
+			return err
+		}
+		return res.Emit(reader)
+	},
+	PostRun: cmds.PostRunMap{
+		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
+			v, err := res.Next()
+			if err != nil {
+				return err
+			}
+			r, ok := v.(io.Reader)
+			if !ok {
+				return re.Emit(v)
+			}
+			output, _ := res.Request().Options[outputOptionName].(string)
+			fmt.Fprintf(os.Stdout, "Saving file(s) to %s\n", output)
+			extractor := &tar.Extractor{Path: output}
+			return extractor.Extract(r)
+		},
+	},
+	Type: DownloadRes{},
+}
+
+type DownloadRes struct {
+	FileHash string
+	Files    []*FileRes
+	// Unresponsive are the hosts of the shards that no peer served in time.
+	Unresponsive []string `json:",omitempty"`
+	Error        string   `json:",omitempty"`
+}
+
+// downloadTarget is a file to download, at a path under the output.
+type downloadTarget struct {
+	path string
+	hash string
+	dir  bool
+}
+
+// downloadTargets returns the encoded files to download for the given hash,
+// from its manifest if it was encoded for upload.
+func downloadTargets(ctxParams *uh.ContextParams, fileHash string) ([]*downloadTarget, error) {
+	m, err := sessions.GetManifest(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(), fileHash)
+	if err == datastore.ErrNotFound {
+		return []*downloadTarget{{hash: fileHash}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if m.Mode != sessions.ManifestPerFileMode {
+		return []*downloadTarget{{hash: m.EncodedHash}}, nil
+	}
+	targets := make([]*downloadTarget, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		if e.Target != "" {
+			log.Infof("skip symlink %s of %s", e.Path, fileHash)
+			continue
+		}
+		targets = append(targets, &downloadTarget{path: e.Path, hash: e.EncodedHash, dir: e.Dir})
+	}
+	return targets, nil
+}
+
+// outputNode returns the file or directory of the targets, with the tree of the
+// manifest for a directory uploaded per file.
+func outputNode(ctx context.Context, ctxParams *uh.ContextParams, targets []*downloadTarget) (files.Node, error) {
+	if len(targets) == 1 && targets[0].path == "" {
+		return getNode(ctx, ctxParams, targets[0].hash)
+	}
+	children := make(map[string][]*downloadTarget)
+	for _, t := range targets {
+		dir := gopath.Dir(t.path)
+		children[dir] = append(children[dir], t)
+	}
+	var build func(dir string) (files.Directory, error)
+	build = func(dir string) (files.Directory, error) {
+		entries := make(map[string]files.Node)
+		for _, t := range children[dir] {
+			var (
+				n   files.Node
+				err error
+			)
+			switch {
+			case t.dir:
+				n, err = build(t.path)
+			case t.hash == "":
+				n = files.NewBytesFile(nil)
+			default:
+				n, err = getNode(ctx, ctxParams, t.hash)
+			}
+			if err != nil {
+				return nil, err
+			}
+			entries[gopath.Base(t.path)] = n
+		}
+		return files.NewMapDirectory(entries), nil
+	}
+	return build(".")
+}
+
+func getNode(ctx context.Context, ctxParams *uh.ContextParams, hash string) (files.Node, error) {
+	c, err := cidlib.Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+	return ctxParams.Api.Unixfs().Get(ctx, path.IpfsPath(c))
+}
+
+// tarReader streams the node as a tar archive, the format 'btfs get' sends files in.
+func tarReader(n files.Node, name string) (io.Reader, error) {
+	piper, pipew := io.Pipe()
+	w, err := files.NewTarWriter(pipew)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer n.Close()
+		if err := w.WriteFile(n, name); err != nil {
+			_ = pipew.CloseWithError(err)
+			return
+		}
+		if err := w.Close(); err != nil {
+			_ = pipew.CloseWithError(err)
+			return
+		}
+		pipew.Close()
+	}()
+	return piper, nil
+}
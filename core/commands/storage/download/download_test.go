@@ -0,0 +1,62 @@
+package download
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/stretchr/testify/assert"
+	"github.com/whyrusleeping/tar-utils"
+)
+
+func TestOutputNode(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	add := func(data string) string {
+		p, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Cid().String()
+	}
+	// the entries of a manifest of a directory uploaded per file
+	targets := []*downloadTarget{
+		{path: "a.txt", hash: add("a")},
+		{path: "sub", dir: true},
+		{path: "sub/b.txt", hash: add("b")},
+		{path: "sub/empty.txt"},
+	}
+	n, err := outputNode(ctx, &uh.ContextParams{Api: api}, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := tarReader(n, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	if err := (&tar.Extractor{Path: out}).Extract(r); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{"a.txt": "a", "sub/b.txt": "b", "sub/empty.txt": ""} {
+		got, err := os.ReadFile(filepath.Join(out, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, string(got), p)
+	}
+}
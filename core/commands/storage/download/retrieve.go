@@ -0,0 +1,346 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	chunker "github.com/bittorrent/go-btfs-chunker"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/bittorrent/go-btfs-common/utils/grpc"
+	"github.com/bittorrent/go-unixfs"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// ShardFetchedStatus is a shard that was fetched.
+	ShardFetchedStatus = "fetched"
+	// ShardFailedStatus is a shard that no peer served in time.
+	ShardFailedStatus = "failed"
+	// ShardSkippedStatus is a shard that was not needed, as enough shards were
+	// fetched before it.
+	ShardSkippedStatus = "skipped"
+
+	guardTimeout = 30 * time.Second
+)
+
+// FileRes is the retrieval of a reed-solomon encoded file.
+type FileRes struct {
+	Path      string `json:",omitempty"`
+	FileHash  string
+	FileSize  uint64
+	NumData   uint64
+	NumParity uint64
+	Shards    []*ShardRes
+	// RepairShards are the shards that could not be fetched.
+	RepairShards []string `json:",omitempty"`
+}
+
+// ShardRes is the retrieval of a shard from the hosts it is contracted with.
+type ShardRes struct {
+	Index  int
+	Hash   string
+	Hosts  []string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// Retrieve fetches the shards of the file of fr until #data shards are local,
+// from which the file is reconstructed when read. The hosts that store them are
+// connected first, but shards are fetched over bitswap from any peer that has
+// them, so the status of a shard only reflects whether it could be fetched.
+func Retrieve(ctx context.Context, ctxParams *uh.ContextParams, fr *FileRes, timeout time.Duration) error {
+	fileCid, err := cidlib.Parse(fr.FileHash)
+	if err != nil {
+		return err
+	}
+	hosts, err := shardHosts(ctx, ctxParams, fr.FileHash)
+	if err != nil {
+		return err
+	}
+	// hosts are connected first, so that the file root and metadata that they
+	// keep along with their shards can be fetched from them
+	unreachable := connectHosts(ctx, ctxParams, hosts, timeout)
+
+	metaCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	shardCids, _, err := helper.CheckAndGetReedSolomonShardHashes(metaCtx, ctxParams.N, ctxParams.Api, fileCid)
+	if err != nil {
+		if len(unreachable) > 0 {
+			return fmt.Errorf("%v, unreachable hosts: %d", err, len(unreachable))
+		}
+		return err
+	}
+	mbytes, err := ctxParams.Api.Unixfs().GetMetadata(metaCtx, path.IpfsPath(fileCid))
+	if err != nil {
+		return err
+	}
+	var rsMeta chunker.RsMetaMap
+	if err := json.Unmarshal(mbytes, &rsMeta); err != nil {
+		return err
+	}
+	fr.FileSize, fr.NumData, fr.NumParity = rsMeta.FileSize, rsMeta.NumData, rsMeta.NumParity
+
+	fr.Shards = make([]*ShardRes, len(shardCids))
+	for i, c := range shardCids {
+		fr.Shards[i] = &ShardRes{Index: i, Hash: c.String(), Hosts: hosts[i]}
+	}
+	fetched := fetchShards(ctx, fr.Shards, int(fr.NumData), func(ctx context.Context, s *ShardRes) error {
+		if len(s.Hosts) > 0 && allUnreachable(s.Hosts, unreachable) {
+			return fmt.Errorf("hosts unreachable: %s", unreachable[s.Hosts[0]])
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return merkledag.FetchGraph(ctx, shardCids[s.Index], ctxParams.Api.Dag())
+	})
+	for _, s := range fr.Shards {
+		if s.Status == ShardFailedStatus {
+			fr.RepairShards = append(fr.RepairShards, s.Hash)
+		}
+	}
+	if fetched < int(fr.NumData) {
+		return fmt.Errorf("only %d of the %d shards needed to reconstruct %s were fetched",
+			fetched, fr.NumData, fr.FileHash)
+	}
+	return nil
+}
+
+// pinFile pins the shards of fr that were fetched, and the nodes of the file
+// above them, so that the file stays readable from the local repo. The root and
+// data nodes are pinned directly, as the shards that were not fetched are missing.
+func pinFile(ctx context.Context, ctxParams *uh.ContextParams, fr *FileRes) error {
+	fileCid, err := cidlib.Parse(fr.FileHash)
+	if err != nil {
+		return err
+	}
+	rn, err := ctxParams.Api.ResolveNode(ctx, path.IpfsPath(fileCid))
+	if err != nil {
+		return err
+	}
+	nodes, err := unixfs.GetChildrenForDagWithMeta(ctx, rn, ctxParams.Api.Dag())
+	if err != nil {
+		return err
+	}
+	direct := []cidlib.Cid{rn.Cid(), nodes.DataNode.Cid()}
+	recursive := make([]cidlib.Cid, 0, len(fr.Shards)+1)
+	if nodes.MetaNode != nil {
+		recursive = append(recursive, nodes.MetaNode.Cid())
+	}
+	for _, s := range fr.Shards {
+		if s.Status != ShardFetchedStatus {
+			continue
+		}
+		c, err := cidlib.Parse(s.Hash)
+		if err != nil {
+			return err
+		}
+		recursive = append(recursive, c)
+	}
+	pin := func(cids []cidlib.Cid, r bool) error {
+		for _, c := range cids {
+			if err := ctxParams.Api.Pin().Add(ctx, path.IpfsPath(c), options.Pin.Recursive(r)); err != nil {
+				return fmt.Errorf("pin %s: %v", c, err)
+			}
+		}
+		return nil
+	}
+	if err := pin(direct, false); err != nil {
+		return err
+	}
+	return pin(recursive, true)
+}
+
+// fetchShards fetches the shards in parallel until numData of them are fetched,
+// and returns how many were. The shards left are skipped.
+func fetchShards(ctx context.Context, shards []*ShardRes, numData int,
+	fetch func(context.Context, *ShardRes) error) int {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		lock    sync.Mutex
+		fetched int
+		wg      sync.WaitGroup
+	)
+	for _, s := range shards {
+		wg.Add(1)
+		go func(s *ShardRes) {
+			defer wg.Done()
+			err := fetch(ctx, s)
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err == nil:
+				s.Status = ShardFetchedStatus
+				fetched++
+				if fetched == numData {
+					cancel()
+				}
+			case fetched >= numData:
+				s.Status = ShardSkippedStatus
+			default:
+				s.Status, s.Error = ShardFailedStatus, err.Error()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return fetched
+}
+
+// shardHosts returns the hosts of each shard of a file, from the contracts of the
+// local sessions that uploaded it and from the file meta on the guard.
+func shardHosts(ctx context.Context, ctxParams *uh.ContextParams, fileHash string) (map[int][]string, error) {
+	hosts := make(map[int][]string)
+	add := func(i int, host string) {
+		for _, h := range hosts[i] {
+			if h == host {
+				return
+			}
+		}
+		hosts[i] = append(hosts[i], host)
+	}
+	cursor, err := sessions.GetRenterSessionsCursor(ctxParams)
+	if err != nil {
+		return nil, err
+	}
+	statuses := append(sessions.ResumableStatuses(), sessions.RssCompleteStatus)
+	for {
+		rss, err := cursor.NextSession(statuses...)
+		if err != nil {
+			break
+		}
+		if rss.Hash != fileHash {
+			continue
+		}
+		for pos, h := range rss.ShardHashes {
+			i := rss.ShardIndex(pos)
+			shard, err := sessions.GetRenterShard(ctxParams, rss.SsId, h, i)
+			if err != nil {
+				return nil, err
+			}
+			contracts, err := shard.Contracts()
+			if err != nil {
+				return nil, err
+			}
+			if c := contracts.SignedGuardContract; c != nil && c.HostPid != "" {
+				add(i, c.HostPid)
+			}
+		}
+	}
+	// the guard also knows the hosts that shards were repaired to
+	contracts, err := guardContracts(ctx, ctxParams, fileHash)
+	if err != nil {
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("no local contracts of %s, and none from the guard: %v", fileHash, err)
+		}
+		log.Debugf("get contracts of %s from guard error: %v", fileHash, err)
+	}
+	for _, c := range contracts {
+		add(int(c.ShardIndex), c.HostPid)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no contracts of %s", fileHash)
+	}
+	return hosts, nil
+}
+
+// guardContracts returns the contracts of a file in its meta on the guard.
+func guardContracts(ctx context.Context, ctxParams *uh.ContextParams, fileHash string) ([]*guardpb.Contract, error) {
+	req := &guardpb.CheckFileStoreMetaRequest{
+		FileHash:     fileHash,
+		RenterPid:    ctxParams.N.Identity.String(),
+		RequesterPid: ctxParams.N.Identity.String(),
+		RequestTime:  time.Now().UTC(),
+	}
+	sig, err := crypto.Sign(ctxParams.N.PrivateKey, req)
+	if err != nil {
+		return nil, err
+	}
+	req.Signature = sig
+	var meta *guardpb.FileStoreStatus
+	cb := grpc.GuardClient(ctxParams.Cfg.Services.GuardDomain)
+	cb.Timeout(guardTimeout)
+	err = cb.WithContext(ctx, func(ctx context.Context, client guardpb.GuardServiceClient) error {
+		meta, err = client.CheckFileStoreMeta(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.Contracts) == 0 {
+		return nil, errors.New("file meta has no contracts")
+	}
+	return meta.Contracts, nil
+}
+
+// connectHosts connects to the hosts in parallel and returns why the hosts that
+// could not be connected were not.
+func connectHosts(ctx context.Context, ctxParams *uh.ContextParams, hosts map[int][]string,
+	timeout time.Duration) map[string]string {
+	var (
+		lock        sync.Mutex
+		wg          sync.WaitGroup
+		unreachable = make(map[string]string)
+		seen        = make(map[string]bool)
+	)
+	for _, hs := range hosts {
+		for _, h := range hs {
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			wg.Add(1)
+			go func(h string) {
+				defer wg.Done()
+				err := func() error {
+					id, err := peer.Decode(h)
+					if err != nil {
+						return err
+					}
+					ctx, cancel := context.WithTimeout(ctx, timeout)
+					defer cancel()
+					return ctxParams.Api.Swarm().Connect(ctx, peer.AddrInfo{ID: id})
+				}()
+				if err != nil {
+					log.Debugf("connect to host %s error: %v", h, err)
+					lock.Lock()
+					unreachable[h] = err.Error()
+					lock.Unlock()
+				}
+			}(h)
+		}
+	}
+	wg.Wait()
+	return unreachable
+}
+
+func allUnreachable(hosts []string, unreachable map[string]string) bool {
+	for _, h := range hosts {
+		if _, ok := unreachable[h]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchShards(t *testing.T) {
+	shards := make([]*ShardRes, 6)
+	for i := range shards {
+		shards[i] = &ShardRes{Index: i, Hosts: []string{"host"}}
+	}
+	// shards 0 and 1 fail, 2 to 4 are served, and 5 hangs until it is not needed
+	fetched := fetchShards(context.Background(), shards, 3, func(ctx context.Context, s *ShardRes) error {
+		switch s.Index {
+		case 0, 1:
+			return errors.New("host timeout")
+		case 5:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	assert.Equal(t, 3, fetched)
+	statuses := make([]string, 0)
+	for _, s := range shards {
+		statuses = append(statuses, s.Status)
+	}
+	assert.Equal(t, []string{ShardFailedStatus, ShardFailedStatus, ShardFetchedStatus, ShardFetchedStatus,
+		ShardFetchedStatus, ShardSkippedStatus}, statuses)
+	assert.Equal(t, "host timeout", shards[0].Error)
+
+	// too few shards are served to reconstruct
+	for _, s := range shards {
+		s.Status, s.Error = "", ""
+	}
+	fetched = fetchShards(context.Background(), shards, 3, func(ctx context.Context, s *ShardRes) error {
+		if s.Index < 2 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return errors.New("host timeout")
+		}
+	})
+	assert.Equal(t, 2, fetched)
+	assert.Equal(t, ShardFailedStatus, shards[5].Status)
+}
@@ -3,6 +3,7 @@ package helper
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/bittorrent/go-btfs/core"
@@ -14,6 +15,10 @@ import (
 	cid "github.com/ipfs/go-cid"
 )
 
+// ErrNotReedSolomon is returned for a root hash that was not added with the
+// reed-solomon chunker.
+var ErrNotReedSolomon = errors.New("file must be reed-solomon encoded")
+
 // CheckAndGetReedSolomonShardHashes checks to see if a root hash is a reed solomon file,
 // if ok, returns the list of shard hashes.
 func CheckAndGetReedSolomonShardHashes(ctx context.Context, node *core.IpfsNode,
@@ -22,15 +27,15 @@ func CheckAndGetReedSolomonShardHashes(ctx context.Context, node *core.IpfsNode,
 	// check to see if a replicated file using reed-solomon
 	mbytes, err := api.Unixfs().GetMetadata(ctx, rootPath)
 	if err != nil {
-		return nil, 0, fmt.Errorf("file must be reed-solomon encoded: %s", err.Error())
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotReedSolomon, err.Error())
 	}
 	var rsMeta chunker.RsMetaMap
 	err = json.Unmarshal(mbytes, &rsMeta)
 	if err != nil {
-		return nil, 0, fmt.Errorf("file must be reed-solomon encoded: %s", err.Error())
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotReedSolomon, err.Error())
 	}
 	if rsMeta.NumData == 0 || rsMeta.NumParity == 0 || rsMeta.FileSize == 0 {
-		return nil, 0, fmt.Errorf("file must be reed-solomon encoded: metadata not valid")
+		return nil, 0, fmt.Errorf("%w: metadata not valid", ErrNotReedSolomon)
 	}
 	// use unixfs layer helper to grab the raw leaves under the data root node
 	// higher level helpers resolve the enrtire DAG instead of resolving the root
@@ -45,7 +50,7 @@ func CheckAndGetReedSolomonShardHashes(ctx context.Context, node *core.IpfsNode,
 	}
 	links := nodes.DataNode.Links()
 	if len(links) != int(rsMeta.NumData+rsMeta.NumParity) {
-		return nil, 0, fmt.Errorf("file must be reed-solomon encoded: encoding scheme mismatch")
+		return nil, 0, fmt.Errorf("%w: encoding scheme mismatch", ErrNotReedSolomon)
 	}
 	var hashes []cid.Cid
 	for _, link := range links {
@@ -3,11 +3,13 @@ package helper
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"testing"
 
 	unixtest "github.com/bittorrent/go-btfs/core/coreunix/test"
 
+	files "github.com/bittorrent/go-btfs-files"
 	uio "github.com/bittorrent/go-unixfs/io"
 	"github.com/bittorrent/interface-go-btfs-core/path"
 	rs "github.com/klauspost/reedsolomon"
@@ -51,3 +53,15 @@ func TestReedSolomonCheckGet(t *testing.T) {
 		}
 	}
 }
+
+func TestReedSolomonCheckNotEncoded(t *testing.T) {
+	node, api, _, _ := unixtest.HelpTestAddWithReedSolomonMetadata(t)
+	p, err := api.Unixfs().Add(context.Background(), files.NewBytesFile([]byte("plain file")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = CheckAndGetReedSolomonShardHashes(context.Background(), node, api, p.Cid())
+	if !errors.Is(err, ErrNotReedSolomon) {
+		t.Fatalf("expected ErrNotReedSolomon, got %v", err)
+	}
+}
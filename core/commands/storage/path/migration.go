@@ -0,0 +1,472 @@
+package path
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	migration = ".btfs.migration"
+
+	// MigrationCopying is a migration copying the repo while the node keeps
+	// running from the old path.
+	MigrationCopying = "copying"
+	// MigrationCopied is a migration waiting for the restart that switches the
+	// node over to the new path.
+	MigrationCopied = "copied"
+	// MigrationSwitched is a migration whose node runs from the new path, the old
+	// path is kept until the migration is confirmed or rolled back.
+	MigrationSwitched = "switched"
+	// MigrationFailed is a migration that stopped on an error, the node keeps
+	// running from the old path. Setting the same path again resumes it.
+	MigrationFailed = "failed"
+
+	// repoLock is the lock file of a running repo, it is never copied
+	repoLock = "repo.lock"
+	// blockExt is the extension of the flatfs files that hold blocks
+	blockExt = ".data"
+
+	migrationSaveInterval = 5 * time.Second
+)
+
+// MigrationFileName is where the state of a migration is kept, next to the
+// properties file so that both repos find it.
+var MigrationFileName string
+
+// Migration is the state of moving the repo to a new path.
+type Migration struct {
+	Source      string
+	Dest        string
+	State       string
+	Started     time.Time
+	Updated     time.Time
+	TotalBytes  uint64
+	CopiedBytes uint64
+	Files       int64
+	// Blocks is how many blocks were verified against their CID at the new path.
+	Blocks int64
+	Error  string `json:",omitempty"`
+}
+
+var (
+	// migrationLock guards the migration being copied
+	migrationLock   sync.Mutex
+	running         *Migration
+	migrationCancel context.CancelFunc
+	migrationDone   chan struct{}
+
+	// migrationStateLock guards the fields of a migration being copied
+	migrationStateLock sync.Mutex
+
+	errBlockMismatch = errors.New("block does not match its CID")
+)
+
+// StartMigration starts copying the repo at src to dest in the background. The
+// node keeps running from src, and restarts to switch over once every file is
+// copied and verified. A failed migration to the same path is resumed.
+func StartMigration(src, dest string) error {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	if running != nil {
+		return fmt.Errorf("the repo is already being migrated to %s", running.Dest)
+	}
+	m, err := loadMigration()
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if m.Source != src || m.Dest != dest || m.State != MigrationFailed {
+			return fmt.Errorf("a migration of the repo to %s is %s, confirm or roll it back first", m.Dest, m.State)
+		}
+		m.State, m.Error = MigrationCopying, ""
+	} else {
+		if !CheckDirEmpty(dest) {
+			return fmt.Errorf("path %s is not empty", dest)
+		}
+		m = &Migration{Source: src, Dest: dest, State: MigrationCopying, Started: time.Now()}
+	}
+	if err := m.save(); err != nil {
+		return err
+	}
+	startMigration(m)
+	return nil
+}
+
+// ResumeMigration resumes a migration interrupted by a shutdown of the node
+// running from configRoot.
+func ResumeMigration(configRoot string) {
+	m, err := loadMigration()
+	if err != nil {
+		log.Errorf("load repo migration: %v", err)
+		return
+	}
+	configRoot = filepath.Clean(configRoot)
+	if m == nil || filepath.Clean(m.Source) != configRoot {
+		if m != nil && filepath.Clean(m.Dest) == configRoot && m.State != MigrationSwitched {
+			// the node was switched over before the state was saved
+			m.State = MigrationSwitched
+			if err := m.save(); err != nil {
+				log.Errorf("save repo migration: %v", err)
+			}
+		}
+		return
+	}
+	switch m.State {
+	case MigrationCopying:
+		migrationLock.Lock()
+		defer migrationLock.Unlock()
+		startMigration(m)
+	case MigrationCopied:
+		go restartToSwitch()
+	}
+}
+
+func startMigration(m *Migration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	running, migrationCancel, migrationDone = m, cancel, make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		err := m.sync(ctx, false)
+		migrationLock.Lock()
+		running, migrationCancel = nil, nil
+		migrationLock.Unlock()
+		if ctx.Err() != nil {
+			// rolled back
+			return
+		}
+		if err != nil {
+			log.Errorf("migrate repo to %s: %v", m.Dest, err)
+			m.fail(err)
+			return
+		}
+		m.setState(MigrationCopied)
+		if err := m.save(); err != nil {
+			log.Errorf("save repo migration: %v", err)
+			return
+		}
+		restartToSwitch()
+	}(migrationDone)
+}
+
+func restartToSwitch() {
+	if e := os.Unsetenv(BtfsPathFlag); e != nil {
+		log.Error(e)
+	}
+	DoRestart(true)
+}
+
+// stopMigration cancels the migration being copied, and waits for it to stop.
+func stopMigration() {
+	migrationLock.Lock()
+	cancel, done := migrationCancel, migrationDone
+	migrationLock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// SwitchOver is called by the restart of the node, once it is shut down. It
+// copies what changed since the migration was copied, and points the
+// properties to the new path. On an error the node restarts from the old path.
+func SwitchOver() error {
+	m, err := loadMigration()
+	if err != nil || m == nil || m.State != MigrationCopied {
+		return err
+	}
+	if err := m.sync(context.Background(), true); err != nil {
+		m.fail(err)
+		return err
+	}
+	StorePath = m.Dest
+	if err := WriteProperties(); err != nil {
+		m.fail(err)
+		return err
+	}
+	m.setState(MigrationSwitched)
+	return m.save()
+}
+
+// GetMigration returns the state of the current migration, nil if there is none.
+func GetMigration() (*Migration, error) {
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+	if running != nil {
+		return running.snapshot(), nil
+	}
+	return loadMigration()
+}
+
+func loadMigration() (*Migration, error) {
+	b, err := ioutil.ReadFile(MigrationFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m := new(Migration)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("read %s: %v", MigrationFileName, err)
+	}
+	return m, nil
+}
+
+func removeMigration() error {
+	if err := os.Remove(MigrationFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (m *Migration) save() error {
+	b, err := json.Marshal(m.snapshot())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(MigrationFileName, b)
+}
+
+func (m *Migration) snapshot() *Migration {
+	migrationStateLock.Lock()
+	defer migrationStateLock.Unlock()
+	c := *m
+	return &c
+}
+
+func (m *Migration) update(fn func()) {
+	migrationStateLock.Lock()
+	defer migrationStateLock.Unlock()
+	fn()
+	m.Updated = time.Now()
+}
+
+func (m *Migration) setState(state string) {
+	m.update(func() { m.State = state })
+}
+
+func (m *Migration) fail(err error) {
+	m.update(func() { m.State, m.Error = MigrationFailed, err.Error() })
+	if err := m.save(); err != nil {
+		log.Errorf("save repo migration: %v", err)
+	}
+}
+
+// sync copies the files of the source that are missing or changed at the
+// destination. Blocks are verified against their CID, other files against the
+// checksum of what was read. A verified file gets the modification time of its
+// source, so that it is skipped when the migration is resumed. With final, the
+// source must not change, and the files no longer at the source are removed
+// from the destination.
+func (m *Migration) sync(ctx context.Context, final bool) error {
+	var total uint64
+	err := filepath.WalkDir(m.Source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.update(func() { m.TotalBytes, m.CopiedBytes, m.Files, m.Blocks = total, 0, 0, 0 })
+
+	saved := time.Now()
+	err = filepath.WalkDir(m.Source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.Source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(m.Dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dest, info.Mode().Perm()|0700)
+		case !d.Type().IsRegular() || rel == repoLock:
+			return nil
+		}
+		block, err := copyVerified(path, dest, info, final)
+		if err != nil {
+			return fmt.Errorf("copy %s: %v", path, err)
+		}
+		m.update(func() {
+			m.CopiedBytes += uint64(info.Size())
+			m.Files++
+			if block {
+				m.Blocks++
+			}
+		})
+		if time.Since(saved) > migrationSaveInterval {
+			saved = time.Now()
+			if err := m.save(); err != nil {
+				log.Errorf("save repo migration: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil || !final {
+		return err
+	}
+	return removeStale(m.Source, m.Dest)
+}
+
+// copyVerified copies a file unless it was already copied, and reports whether
+// it is a block.
+func copyVerified(src, dest string, info fs.FileInfo, final bool) (bool, error) {
+	key, isBlock := blockKey(src)
+	if di, err := os.Stat(dest); err == nil && di.Size() == info.Size() && di.ModTime().Equal(info.ModTime()) {
+		return isBlock, nil
+	}
+	sum, err := copyTemp(src, dest)
+	if err != nil {
+		return isBlock, err
+	}
+	b, err := ioutil.ReadFile(dest)
+	if err != nil {
+		return isBlock, err
+	}
+	if isBlock {
+		if err := verifyBlock(key, b); err != nil {
+			return isBlock, err
+		}
+	} else if sha256.Sum256(b) != sum {
+		return isBlock, errors.New("copy does not match its checksum")
+	}
+	after, err := os.Stat(src)
+	if err != nil {
+		return isBlock, err
+	}
+	if after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+		if final {
+			return isBlock, errors.New("changed while the node was shut down")
+		}
+		// written to meanwhile, it is copied again by the switch over
+		return isBlock, nil
+	}
+	return isBlock, os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
+
+// copyTemp copies src next to dest then renames it, and returns the checksum
+// of what was copied.
+func copyTemp(src, dest string) (sum [sha256.Size]byte, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return sum, err
+	}
+	defer in.Close()
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return sum, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, h))
+	if e := out.Sync(); err == nil {
+		err = e
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return sum, err
+	}
+	if info, e := in.Stat(); e == nil {
+		err = os.Chmod(dest, info.Mode().Perm())
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, err
+}
+
+// blockKey returns the multihash of a flatfs block file, from its name.
+func blockKey(path string) (mh.Multihash, bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, blockExt) {
+		return nil, false
+	}
+	b, err := dshelp.BinaryFromDsKey(ds.NewKey(strings.TrimSuffix(name, blockExt)))
+	if err != nil {
+		return nil, false
+	}
+	if _, err := mh.Decode(b); err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func verifyBlock(key mh.Multihash, data []byte) error {
+	dec, err := mh.Decode(key)
+	if err != nil {
+		return err
+	}
+	sum, err := mh.Sum(data, dec.Code, dec.Length)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, key) {
+		return errBlockMismatch
+	}
+	return nil
+}
+
+// removeStale removes the files of dest that are no longer at src.
+func removeStale(src, dest string) error {
+	return filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dest, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); os.IsNotExist(err) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,97 @@
+package path
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func blockName(t *testing.T, data []byte) string {
+	sum, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dshelp.MultihashToDsKey(sum).String()[1:] + blockExt
+}
+
+func TestMigration(t *testing.T) {
+	dir := t.TempDir()
+	MigrationFileName = filepath.Join(dir, migration)
+	PropertiesFileName = filepath.Join(dir, properties)
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+
+	block := []byte("block data")
+	blockPath := filepath.Join("blocks", "AB", blockName(t, block))
+	writeTestFile(t, filepath.Join(src, blockPath), block)
+	writeTestFile(t, filepath.Join(src, "config"), []byte("{}"))
+	writeTestFile(t, filepath.Join(src, "datastore", "000001.log"), []byte("log"))
+	writeTestFile(t, filepath.Join(src, repoLock), nil)
+
+	m := &Migration{Source: src, Dest: dest, State: MigrationCopying}
+	if err := m.sync(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if m.Files != 3 || m.Blocks != 1 || m.CopiedBytes != m.TotalBytes {
+		t.Fatalf("unexpected progress %+v", m)
+	}
+	if CheckExist(filepath.Join(dest, repoLock)) {
+		t.Fatal("repo lock was copied")
+	}
+
+	// the node keeps writing until it is shut down
+	writeTestFile(t, filepath.Join(src, "datastore", "000002.log"), []byte("new log"))
+	if err := os.Remove(filepath.Join(src, "datastore", "000001.log")); err != nil {
+		t.Fatal(err)
+	}
+	m.State = MigrationCopied
+	if err := m.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SwitchOver(); err != nil {
+		t.Fatal(err)
+	}
+	if ReadProperties(PropertiesFileName) != dest {
+		t.Fatal("properties do not point to the new path")
+	}
+	m, err := GetMigration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.State != MigrationSwitched {
+		t.Fatalf("unexpected state %s", m.State)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "datastore", "000002.log")); err != nil || string(b) != "new log" {
+		t.Fatal("changed file was not copied", err)
+	}
+	if CheckExist(filepath.Join(dest, "datastore", "000001.log")) {
+		t.Fatal("removed file was kept")
+	}
+	if !CheckExist(filepath.Join(src, blockPath)) {
+		t.Fatal("old path was not kept")
+	}
+}
+
+func TestMigrationCorruptBlock(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	writeTestFile(t, filepath.Join(src, "blocks", "AB", blockName(t, []byte("block data"))), []byte("corrupt"))
+
+	m := &Migration{Source: src, Dest: dest, State: MigrationCopying}
+	if err := m.sync(context.Background(), false); err == nil {
+		t.Fatal("corrupt block was copied")
+	}
+}
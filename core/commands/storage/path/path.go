@@ -14,6 +14,9 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/repo/pool"
+
 	cmds "github.com/bittorrent/go-btfs-cmds"
 
 	"github.com/dustin/go-humanize"
@@ -53,6 +56,7 @@ func init() {
 	}
 	srcProperties = filepath.Join(home, properties)
 	PropertiesFileName = filepath.Join(exPath, properties)
+	MigrationFileName = filepath.Join(exPath, migration)
 	// .btfs.properties migration
 	if !CheckExist(PropertiesFileName) && CheckExist(srcProperties) {
 		if err := copyFile(srcProperties, PropertiesFileName); err != nil {
@@ -100,6 +104,13 @@ var PathCmd = &cmds.Command{
 The default local repository path is located at ~/.btfs folder, in order to
 improve the hard disk space usage, provide the function to change the original 
 storage location, a specified path as a parameter need to be passed.
+
+The repo is copied to the new path in the background while the node keeps
+running, and every block is verified against its CID once copied. The node
+then restarts to copy what changed meanwhile and switch over to the new path.
+The progress is reported by 'btfs storage path status', and an interrupted
+migration resumes when the daemon starts again. The old path is kept until
+'btfs storage path confirm', and 'btfs storage path rollback' goes back to it.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
@@ -109,6 +120,9 @@ storage location, a specified path as a parameter need to be passed.
 		"list":     PathListCmd,
 		"mkdir":    PathMkdirCmd,
 		"volumes":  PathVolumesCmd,
+		"pool":     PathPoolCmd,
+		"confirm":  PathConfirmCmd,
+		"rollback": PathRollbackCmd,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("path-name", true, false,
@@ -167,13 +181,7 @@ storage location, a specified path as a parameter need to be passed.
 			return fmt.Errorf("Not enough disk space, expect: ge %v bytes, actual: %v bytes",
 				promisedStorageSize, usage.Free)
 		}
-		go func() {
-			if e := os.Unsetenv(BtfsPathFlag); e != nil {
-				log.Error(e)
-			}
-			DoRestart(true)
-		}()
-		return nil
+		return StartMigration(OriginPath, StorePath)
 	},
 }
 
@@ -188,17 +196,26 @@ func DoRestart(p bool) {
 var PathStatusCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Get status of resetting path.",
-		ShortDescription: "Get status of resetting path.",
+		ShortDescription: "Get status of resetting path, and of the disks of the storage pool.",
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		tryLock := lock.TryLock()
 		if tryLock {
 			lock.Unlock()
 		}
-		return cmds.EmitOnce(res, PathStatus{
-			Resetting: !tryLock,
+		m, err := GetMigration()
+		if err != nil {
+			return err
+		}
+		status := PathStatus{
+			Resetting: !tryLock || (m != nil && (m.State == MigrationCopying || m.State == MigrationCopied)),
 			Path:      StorePath,
-		})
+			Migration: m,
+		}
+		for _, p := range pool.Pools() {
+			status.Pools = append(status.Pools, p.Status(req.Context))
+		}
+		return cmds.EmitOnce(res, status)
 	},
 	Type: PathStatus{},
 }
@@ -206,6 +223,80 @@ var PathStatusCmd = &cmds.Command{
 type PathStatus struct {
 	Resetting bool
 	Path      string
+	Migration *Migration     `json:",omitempty"`
+	Pools     []*pool.Status `json:",omitempty"`
+}
+
+var PathConfirmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Confirm the migration of the repo, and remove the old path.",
+		ShortDescription: `
+Once the node runs from the new path, the old path is kept until the migration
+is confirmed, in case it has to be rolled back.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		m, err := GetMigration()
+		if err != nil {
+			return err
+		}
+		if m == nil || m.State != MigrationSwitched {
+			return errors.New("no migration of the repo waits for confirmation")
+		}
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		if filepath.Clean(cfgRoot) == filepath.Clean(m.Source) {
+			return fmt.Errorf("the node still runs from %s, restart it first", m.Source)
+		}
+		if err := os.RemoveAll(m.Source); err != nil {
+			return err
+		}
+		return removeMigration()
+	},
+}
+
+var PathRollbackCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Roll back the migration of the repo to the old path.",
+		ShortDescription: `
+A migration being copied is stopped, and what was copied to the new path is
+removed. A node already switched over restarts from the old path, the new path
+is left as is, and what the node stored since the switch over is not in the
+old path.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		stopMigration()
+		m, err := GetMigration()
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			return errors.New("the repo is not being migrated")
+		}
+		if m.State != MigrationSwitched {
+			if err := os.RemoveAll(m.Dest); err != nil {
+				return err
+			}
+			return removeMigration()
+		}
+		StorePath = m.Source
+		if err := WriteProperties(); err != nil {
+			return err
+		}
+		if err := removeMigration(); err != nil {
+			return err
+		}
+		go func() {
+			if e := os.Unsetenv(BtfsPathFlag); e != nil {
+				log.Error(e)
+			}
+			DoRestart(false)
+		}()
+		return nil
+	},
 }
 
 var PathCapacityCmd = &cmds.Command{
@@ -366,15 +457,8 @@ type PathCapacity struct {
 }
 
 func WriteProperties() error {
-	if !CheckExist(PropertiesFileName) {
-		newFile, err := os.Create(PropertiesFileName)
-		defer newFile.Close()
-		if err != nil {
-			return err
-		}
-	}
 	data := []byte(StorePath)
-	err := ioutil.WriteFile(PropertiesFileName, data, 0666)
+	err := writeFileAtomic(PropertiesFileName, data)
 	if err == nil {
 		fmt.Printf("Storage location was reset in %v\n", StorePath)
 	}
@@ -395,61 +479,6 @@ type storageInfo struct {
 	FileSystem string
 }
 
-func MoveFolder() error {
-	err := os.Rename(OriginPath, StorePath)
-	// src and dest dir are not in the same partition
-	if err != nil {
-		err := move(OriginPath, StorePath)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func move(src string, dst string) error {
-	if err := copyDir(src, dst); err != nil {
-		return err
-	}
-	if err := os.RemoveAll(src); err != nil {
-		fmt.Println("remove old path failed: %w", err)
-	}
-	return nil
-}
-
-func copyDir(src string, dst string) error {
-	var err error
-	var fds []os.FileInfo
-	var srcinfo os.FileInfo
-
-	if srcinfo, err = os.Stat(src); err != nil {
-		return err
-	}
-
-	if err = os.MkdirAll(dst, srcinfo.Mode()); err != nil {
-		return err
-	}
-
-	if fds, err = ioutil.ReadDir(src); err != nil {
-		return err
-	}
-	for _, fd := range fds {
-		srcfp := filepath.Join(src, fd.Name())
-		dstfp := filepath.Join(dst, fd.Name())
-
-		if fd.IsDir() {
-			if err = copyDir(srcfp, dstfp); err != nil {
-				fmt.Println(err)
-			}
-		} else {
-			if err = copyFile(srcfp, dstfp); err != nil {
-				fmt.Println(err)
-			}
-		}
-	}
-	return nil
-}
-
 // File copies a single file from src to dst
 func copyFile(src, dst string) error {
 	var err error
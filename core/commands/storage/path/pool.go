@@ -0,0 +1,222 @@
+package path
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	"github.com/bittorrent/go-btfs/repo/pool"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mitchellh/go-homedir"
+)
+
+const poolCapacityOptionName = "capacity"
+
+var PathPoolCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the disks of the storage pool.",
+		ShortDescription: `
+A storage pool spreads the blocks of the host over directories on several
+disks. It is set up by giving the blocks datastore the "pool" type in the
+Datastore.Spec config, e.g.
+
+  {"type": "pool", "path": "blocks", "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+   "sync": true, "policy": "most-free", "roots": [{"path": "/mnt/disk2/blocks", "capacity": "2TB"}]}
+
+The policy places new blocks on the roots, it is one of fill-first, balanced
+or most-free. The changes made by these commands take effect right away and
+are saved to the config. The state of the pool is reported by
+'btfs storage path status'.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add":       pathPoolAddCmd,
+		"drain":     pathPoolDrainCmd,
+		"rebalance": pathPoolRebalanceCmd,
+	},
+}
+
+var pathPoolAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add a directory to the storage pool.",
+		ShortDescription: `
+New blocks can be placed on the directory right away. With the balanced policy,
+run 'btfs storage path pool rebalance' to even out the existing blocks.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path-name", true, false, "Directory to add. Should be absolute path."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(poolCapacityOptionName, "How much the directory may hold, e.g. 500GB. "+
+			"Default is the free space of its disk."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		rc := pool.RootConfig{}
+		var err error
+		if rc.Path, err = absPath(req.Arguments[0]); err != nil {
+			return err
+		}
+		capacity, _ := req.Options[poolCapacityOptionName].(string)
+		if capacity != "" {
+			if rc.Capacity, err = humanize.ParseBytes(capacity); err != nil {
+				return err
+			}
+		}
+		p, err := runningPool()
+		if err != nil {
+			return err
+		}
+		if err := p.AddRoot(rc); err != nil {
+			return err
+		}
+		return updatePoolSpec(env, func(spec map[string]interface{}) error {
+			root := map[string]interface{}{"path": rc.Path}
+			if capacity != "" {
+				root["capacity"] = capacity
+			}
+			roots, _ := spec["roots"].([]interface{})
+			spec["roots"] = append(roots, root)
+			return nil
+		})
+	},
+}
+
+var pathPoolDrainCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move the blocks of a directory to the other disks of the storage pool.",
+		ShortDescription: `
+The directory takes no new blocks, and its blocks are moved in the background.
+Once 'btfs storage path status' shows it empty, it can be removed from the
+roots of the pool in the config. The primary root of the pool cannot be
+drained.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path-name", true, false, "Directory to drain."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		path, err := absPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		p, err := runningPool()
+		if err != nil {
+			return err
+		}
+		if err := p.Drain(path); err != nil {
+			return err
+		}
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		return updatePoolSpec(env, func(spec map[string]interface{}) error {
+			roots, _ := spec["roots"].([]interface{})
+			for _, r := range roots {
+				root, ok := r.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if rp, _ := root["path"].(string); rp == path ||
+					(!filepath.IsAbs(rp) && filepath.Join(cfgRoot, rp) == path) {
+					root["drain"] = true
+					return nil
+				}
+			}
+			return fmt.Errorf("%s is not in the roots of the storage pool config", path)
+		})
+	},
+}
+
+var pathPoolRebalanceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move blocks between the disks of the storage pool.",
+		ShortDescription: `
+Draining directories are emptied, directories over their capacity are brought
+back within it, and with the balanced policy the directories are evened out.
+The blocks move in the background while the node keeps running.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		p, err := runningPool()
+		if err != nil {
+			return err
+		}
+		return p.Rebalance()
+	},
+}
+
+func absPath(path string) (string, error) {
+	path = strings.Trim(path, " ")
+	if path == "" {
+		return "", fmt.Errorf("path is not defined")
+	}
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+func runningPool() (*pool.Datastore, error) {
+	ps := pool.Pools()
+	switch len(ps) {
+	case 0:
+		return nil, errors.New("no storage pool is configured, set the pool type in the Datastore.Spec config")
+	case 1:
+		return ps[0], nil
+	}
+	return nil, errors.New("more than one storage pool is configured")
+}
+
+// updatePoolSpec calls fn on the spec of the pool in the datastore config, and
+// saves the config.
+func updatePoolSpec(env cmds.Environment, fn func(spec map[string]interface{}) error) error {
+	n, err := cmdenv.GetNode(env)
+	if err != nil {
+		return err
+	}
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg, err = cfg.Clone()
+	if err != nil {
+		return err
+	}
+	spec := findPoolSpec(cfg.Datastore.Spec)
+	if spec == nil {
+		return errors.New("no storage pool in the Datastore.Spec config")
+	}
+	if err := fn(spec); err != nil {
+		return err
+	}
+	return n.Repo.SetConfig(cfg)
+}
+
+func findPoolSpec(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v["type"] == "pool" {
+			return v
+		}
+		for _, c := range v {
+			if spec := findPoolSpec(c); spec != nil {
+				return spec
+			}
+		}
+	case []interface{}:
+		for _, c := range v {
+			if spec := findPoolSpec(c); spec != nil {
+				return spec
+			}
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/bittorrent/go-btfs/core/commands/storage/announce"
 	"github.com/bittorrent/go-btfs/core/commands/storage/challenge"
 	"github.com/bittorrent/go-btfs/core/commands/storage/contracts"
+	"github.com/bittorrent/go-btfs/core/commands/storage/download"
 	"github.com/bittorrent/go-btfs/core/commands/storage/hosts"
 	"github.com/bittorrent/go-btfs/core/commands/storage/info"
 	"github.com/bittorrent/go-btfs/core/commands/storage/path"
@@ -30,5 +31,6 @@ host information sync/display operations, and BTT payment-related routines.`,
 		"contracts": contracts.StorageContractsCmd,
 		"path":      path.PathCmd,
 		"dcrepair":  upload.StorageDcRepairRouterCmd,
+		"download":  download.StorageDownloadCmd,
 	},
 }
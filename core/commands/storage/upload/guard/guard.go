@@ -115,7 +115,7 @@ func PrepCustomFileChallengeQuestions(rss *sessions.RenterSession, fileHash cidl
 	}
 	signBytes := <-cb
 	uh.FileMetaChanMaps.Remove(rss.SsId)
-	if err := rss.To(sessions.RssToGuardQuestionsSignedEvent); err != nil {
+	if err := rss.Advance(sessions.RssToGuardQuestionsSignedEvent); err != nil {
 		return nil, err
 	}
 	f := new(guardpb.FileChallengeQuestions)
@@ -0,0 +1,90 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/bittorrent/go-btfs/accounting"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/repo"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const admissionConfigKey = "HostAdmission"
+
+// AdmissionConfig is read from the HostAdmission config key, and sets which
+// contracts a host accepts, e.g.
+//
+//	$ btfs config --json HostAdmission '{"RenterMaxShare": 50, "MinPrice": {"WBTT": 125000}}'
+//
+// Limits left at 0 are not checked.
+type AdmissionConfig struct {
+	// ReservedSpace is how many bytes of the disk of the repo are kept free.
+	ReservedSpace int64
+	// MaxIngressRate is the inbound bandwidth in bytes per second above which
+	// the host is too busy to take more shards.
+	MaxIngressRate int64
+	// RenterMaxBytes and RenterMaxContracts are how many bytes and running
+	// contracts a renter may hold on the host.
+	RenterMaxBytes     int64
+	RenterMaxContracts int
+	// RenterMaxShare is the percentage of the storage max of the host that a
+	// renter may hold.
+	RenterMaxShare int
+	// AllowRenters only accepts contracts from these renters, when not empty.
+	AllowRenters []string
+	// DenyRenters never accepts contracts from these renters.
+	DenyRenters []string
+	// MinPrice is the lowest price per GiB per day accepted, by token symbol.
+	MinPrice map[string]int64
+	// CreditLimits is the most a renter may owe for its contracts, by token
+	// symbol, in strings of integers in the smallest unit of the token.
+	CreditLimits map[string]string
+	// PaymentGrace is how many minutes a renter has to pay for a contract
+	// before it is in arrears, and its new contracts are refused.
+	PaymentGrace int
+}
+
+// GetAdmissionConfig returns the HostAdmission config.
+func GetAdmissionConfig(cp *ContextParams) *AdmissionConfig {
+	return LoadAdmissionConfig(cp.N.Repo)
+}
+
+// LoadAdmissionConfig returns the HostAdmission config of the repo.
+func LoadAdmissionConfig(r repo.Repo) *AdmissionConfig {
+	cfg := new(AdmissionConfig)
+	v, err := r.GetConfigKey(admissionConfigKey)
+	if err == nil {
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, cfg)
+		}
+		if err != nil {
+			log.Warnf("ignore malformed %s config: %v", admissionConfigKey, err)
+		}
+	}
+	return cfg
+}
+
+// CreditPolicy returns the credit policy of the config.
+func (c *AdmissionConfig) CreditPolicy() (*accounting.CreditPolicy, error) {
+	p := &accounting.CreditPolicy{
+		Limits: make(map[common.Address]*big.Int),
+		Grace:  time.Duration(c.PaymentGrace) * time.Minute,
+	}
+	for symbol, amount := range c.CreditLimits {
+		token, ok := tokencfg.MpTokenAddr[strings.ToUpper(symbol)]
+		if !ok {
+			return nil, fmt.Errorf("unknown token %s", symbol)
+		}
+		limit, ok := new(big.Int).SetString(amount, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid credit limit %q of token %s", amount, symbol)
+		}
+		p.Limits[token] = limit
+	}
+	return p, nil
+}
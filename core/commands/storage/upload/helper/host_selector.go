@@ -0,0 +1,292 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/hub"
+
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// HostSelectCheapest is named apart from the "price" mode of the hub, which
+	// orders the hosts by the hub instead.
+	HostSelectCheapest    = "cheapest"
+	HostSelectLatency     = "latency"
+	HostSelectIPSpread    = "ip-spread"
+	HostSelectReliability = "reliability"
+
+	WeightPrice       = "price"
+	WeightLatency     = "latency"
+	WeightReliability = "reliability"
+	WeightScore       = "score"
+
+	hostSelectorConfigKey = "HostSelector"
+)
+
+// defaultHostSelectorWeights are the weights of each strategy when they are not set in config.
+// The hub score is given a small weight to break ties.
+var defaultHostSelectorWeights = map[string]map[string]float64{
+	HostSelectCheapest:    {WeightPrice: 1, WeightScore: 0.1},
+	HostSelectLatency:     {WeightLatency: 1, WeightScore: 0.1},
+	HostSelectReliability: {WeightReliability: 1, WeightScore: 0.1},
+	HostSelectIPSpread:    {WeightScore: 1},
+}
+
+// HostCandidate is a host synced from the hub, along with what the renter
+// knows locally about it.
+type HostCandidate struct {
+	Host *hubpb.Host
+	// Latency is the measured latency to the host, 0 if unknown.
+	Latency time.Duration
+	// IPPrefix is the prefix of the public ip the host is reached at, empty if
+	// unknown. Hosts with the same prefix are likely in the same data center.
+	IPPrefix string
+	// Reliability is the success rate of past contracts with the host.
+	Reliability float64
+}
+
+// Region is the geographic area the hub reports for the host, empty if unknown.
+func (c *HostCandidate) Region() string {
+	if c.Host.CountryShort == "" && c.Host.Region == "" {
+		return ""
+	}
+	return c.Host.CountryShort + "/" + c.Host.Region
+}
+
+// HostSelector orders the candidate hosts of an upload, best first.
+type HostSelector interface {
+	Name() string
+	Select(candidates []*HostCandidate) []*HostCandidate
+}
+
+// HostSelectorConfig is read from the HostSelector config key, e.g.
+//
+//	$ btfs config HostSelector.Strategy ip-spread
+//	$ btfs config --json HostSelector.Weights.cheapest '{"price": 1, "reliability": 0.5}'
+type HostSelectorConfig struct {
+	// Strategy is used when no mode is given to the upload.
+	Strategy string
+	// Weights overrides the default weights, per strategy.
+	Weights map[string]map[string]float64
+}
+
+// NewHostSelector returns the named strategy, scoring hosts with the given weights.
+func NewHostSelector(name string, weights map[string]float64) (HostSelector, error) {
+	defaults, ok := defaultHostSelectorWeights[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown host selection strategy: %s", name)
+	}
+	w := make(map[string]float64, len(defaults))
+	for k, v := range defaults {
+		w[k] = v
+	}
+	for k, v := range weights {
+		switch k {
+		case WeightPrice, WeightLatency, WeightReliability, WeightScore:
+			w[k] = v
+		default:
+			return nil, fmt.Errorf("unknown weight %q for host selection strategy %s", k, name)
+		}
+	}
+	ws := &weightedSelector{name: name, weights: w}
+	if name == HostSelectIPSpread {
+		return &ipSpreadSelector{ws}, nil
+	}
+	return ws, nil
+}
+
+// GetHostSelector returns the strategy for the given upload mode, nil if the
+// hosts should be tried in the order they were synced from the hub.
+func GetHostSelector(cp *ContextParams, mode string) (HostSelector, error) {
+	cfg := getHostSelectorConfig(cp)
+	if mode == "" {
+		mode = cfg.Strategy
+	}
+	if mode == "" {
+		return nil, nil
+	}
+	if _, ok := defaultHostSelectorWeights[mode]; !ok {
+		if _, _, err := hub.CheckValidMode(mode, true); err == nil {
+			return nil, nil
+		}
+	}
+	return NewHostSelector(mode, cfg.Weights[mode])
+}
+
+func getHostSelectorConfig(cp *ContextParams) *HostSelectorConfig {
+	cfg := new(HostSelectorConfig)
+	v, err := cp.N.Repo.GetConfigKey(hostSelectorConfigKey)
+	if err != nil {
+		// not set
+		return cfg
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(b, cfg)
+	}
+	if err != nil {
+		log.Warnf("ignore malformed %s config: %v", hostSelectorConfigKey, err)
+	}
+	return cfg
+}
+
+// NewHostCandidates gathers what is known locally about the given hosts.
+func NewHostCandidates(cp *ContextParams, hosts []*hubpb.Host) []*HostCandidate {
+	stats, err := ListHostStats(cp)
+	if err != nil {
+		log.Debug(err)
+		stats = make(map[string]*HostStats)
+	}
+	candidates := make([]*HostCandidate, 0, len(hosts))
+	for _, h := range hosts {
+		c := &HostCandidate{Host: h, Reliability: (&HostStats{}).Reliability()}
+		if s, ok := stats[h.NodeId]; ok {
+			c.Reliability = s.Reliability()
+		}
+		if id, err := peer.Decode(h.NodeId); err == nil {
+			c.Latency = cp.N.Peerstore.LatencyEWMA(id)
+			for _, addr := range cp.N.Peerstore.Addrs(id) {
+				if !manet.IsPublicAddr(addr) {
+					continue
+				}
+				if ip, err := manet.ToIP(addr); err == nil {
+					c.IPPrefix = ipPrefixOf(ip)
+					break
+				}
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// ipPrefixOf returns the /16 prefix of an ipv4 address, or the /32 prefix of an
+// ipv6 one. It stands in for the network of the host, as no ASN data is at hand.
+func ipPrefixOf(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// weightedSelector ranks hosts by the weighted sum of their normalized price,
+// latency, reliability and hub score.
+type weightedSelector struct {
+	name    string
+	weights map[string]float64
+}
+
+func (s *weightedSelector) Name() string {
+	return s.name
+}
+
+func (s *weightedSelector) Select(candidates []*HostCandidate) []*HostCandidate {
+	n := len(candidates)
+	prices := make([]float64, n)
+	latencies := make([]float64, n)
+	scores := make([]float64, n)
+	knownLatency := make([]bool, n)
+	for i, c := range candidates {
+		prices[i] = float64(c.Host.StoragePriceAsk)
+		latencies[i] = float64(c.Latency)
+		knownLatency[i] = c.Latency > 0
+		scores[i] = float64(c.Host.Score)
+	}
+	priceScores := normalize(prices, nil, true)
+	latencyScores := normalize(latencies, knownLatency, true)
+	hubScores := normalize(scores, nil, false)
+
+	total := make(map[*HostCandidate]float64, n)
+	for i, c := range candidates {
+		total[c] = s.weights[WeightPrice]*priceScores[i] +
+			s.weights[WeightLatency]*latencyScores[i] +
+			s.weights[WeightReliability]*c.Reliability +
+			s.weights[WeightScore]*hubScores[i]
+	}
+	ranked := make([]*HostCandidate, n)
+	copy(ranked, candidates)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return total[ranked[i]] > total[ranked[j]]
+	})
+	return ranked
+}
+
+// normalize maps values into [0, 1], 1 being the best. Values that are not
+// known are given 0.
+func normalize(values []float64, known []bool, lowerIsBetter bool) []float64 {
+	isKnown := func(i int) bool {
+		return known == nil || known[i]
+	}
+	min, max := 0.0, 0.0
+	first := true
+	for i, v := range values {
+		if !isKnown(i) {
+			continue
+		}
+		if first || v < min {
+			min = v
+		}
+		if first || v > max {
+			max = v
+		}
+		first = false
+	}
+	out := make([]float64, len(values))
+	for i, v := range values {
+		switch {
+		case !isKnown(i):
+			out[i] = 0
+		case max == min:
+			out[i] = 1
+		case lowerIsBetter:
+			out[i] = (max - v) / (max - min)
+		default:
+			out[i] = (v - min) / (max - min)
+		}
+	}
+	return out
+}
+
+// ipSpreadSelector spreads the hosts over as many ip prefixes and regions as
+// possible, so that the shards of a file are less likely to end up in the same
+// data center. Within the same spread, hosts keep their weighted rank.
+type ipSpreadSelector struct {
+	*weightedSelector
+}
+
+func (s *ipSpreadSelector) Select(candidates []*HostCandidate) []*HostCandidate {
+	remaining := s.weightedSelector.Select(candidates)
+	prefixes := make(map[string]int)
+	regions := make(map[string]int)
+	count := func(m map[string]int, k string) int {
+		if k == "" {
+			return 0
+		}
+		return m[k]
+	}
+	spread := make([]*HostCandidate, 0, len(remaining))
+	for len(remaining) > 0 {
+		best := 0
+		for i, c := range remaining[1:] {
+			b := remaining[best]
+			cn, bn := count(prefixes, c.IPPrefix), count(prefixes, b.IPPrefix)
+			if cn < bn || (cn == bn && count(regions, c.Region()) < count(regions, b.Region())) {
+				best = i + 1
+			}
+		}
+		c := remaining[best]
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		prefixes[c.IPPrefix]++
+		regions[c.Region()]++
+		spread = append(spread, c)
+	}
+	return spread
+}
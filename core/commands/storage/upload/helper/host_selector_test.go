@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+)
+
+func candidate(id string, price uint64, latency time.Duration, ipPrefix, country string, reliability float64) *HostCandidate {
+	return &HostCandidate{
+		Host: &hubpb.Host{
+			NodeId:          id,
+			StoragePriceAsk: price,
+			CountryShort:    country,
+			Score:           1,
+		},
+		Latency:     latency,
+		IPPrefix:    ipPrefix,
+		Reliability: reliability,
+	}
+}
+
+func ids(candidates []*HostCandidate) []string {
+	out := make([]string, len(candidates))
+	for i, c := range candidates {
+		out[i] = c.Host.NodeId
+	}
+	return out
+}
+
+func assertOrder(t *testing.T, got []*HostCandidate, want ...string) {
+	t.Helper()
+	g := ids(got)
+	if len(g) != len(want) {
+		t.Fatalf("got %v, want %v", g, want)
+	}
+	for i := range want {
+		if g[i] != want[i] {
+			t.Fatalf("got %v, want %v", g, want)
+		}
+	}
+}
+
+func TestHostSelectorStrategies(t *testing.T) {
+	candidates := []*HostCandidate{
+		candidate("a", 300, 10*time.Millisecond, "1.1.0.0/16", "US", 0.5),
+		candidate("b", 100, 0, "1.1.0.0/16", "US", 0.9),
+		candidate("c", 200, 50*time.Millisecond, "2.2.0.0/16", "DE", 0.1),
+	}
+
+	s, err := NewHostSelector(HostSelectCheapest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, s.Select(candidates), "b", "c", "a")
+
+	s, err = NewHostSelector(HostSelectLatency, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unknown latency ranks with the slowest host
+	assertOrder(t, s.Select(candidates), "a", "b", "c")
+
+	s, err = NewHostSelector(HostSelectReliability, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, s.Select(candidates), "b", "a", "c")
+
+	// weights from config override the defaults
+	s, err = NewHostSelector(HostSelectCheapest, map[string]float64{WeightPrice: 0, WeightReliability: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertOrder(t, s.Select(candidates), "b", "a", "c")
+
+	// "price" is left to the hub mode of that name
+	if _, err := NewHostSelector("price", nil); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+	if _, err := NewHostSelector(HostSelectCheapest, map[string]float64{"speed": 1}); err == nil {
+		t.Fatal("expected unknown weight error")
+	}
+}
+
+func TestIPSpreadHostSelector(t *testing.T) {
+	candidates := []*HostCandidate{
+		candidate("a1", 100, 0, "1.1.0.0/16", "US", 0.9),
+		candidate("a2", 100, 0, "1.1.0.0/16", "US", 0.8),
+		candidate("a3", 100, 0, "1.1.0.0/16", "US", 0.7),
+		candidate("b1", 100, 0, "2.2.0.0/16", "US", 0.6),
+		candidate("c1", 100, 0, "3.3.0.0/16", "DE", 0.5),
+	}
+	s, err := NewHostSelector(HostSelectIPSpread, map[string]float64{WeightReliability: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each ip prefix is used once before any is used again, and a new region is
+	// preferred among unused prefixes
+	assertOrder(t, s.Select(candidates), "a1", "c1", "b1", "a2", "a3")
+}
+
+func TestIPPrefixOf(t *testing.T) {
+	if n := ipPrefixOf(net.ParseIP("203.0.113.7")); n != "203.0.0.0/16" {
+		t.Fatalf("unexpected ipv4 prefix %s", n)
+	}
+	if n := ipPrefixOf(net.ParseIP("2001:db8:1:2::1")); n != "2001:db8::/32" {
+		t.Fatalf("unexpected ipv6 prefix %s", n)
+	}
+}
@@ -0,0 +1,110 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const (
+	hostStatsPrefix = "/btfs/%s/renter/host-stats/"
+	hostStatsKey    = hostStatsPrefix + "%s"
+)
+
+var (
+	// hostStatsLocks serializes the updates of the stats of each host, as
+	// contracts with the same host are set up concurrently.
+	hostStatsLocks   = make(map[string]*sync.Mutex)
+	hostStatsLocksMu sync.Mutex
+)
+
+func hostStatsLock(hostId string) *sync.Mutex {
+	hostStatsLocksMu.Lock()
+	defer hostStatsLocksMu.Unlock()
+	l, ok := hostStatsLocks[hostId]
+	if !ok {
+		l = new(sync.Mutex)
+		hostStatsLocks[hostId] = l
+	}
+	return l
+}
+
+// HostStats counts the outcomes of the contracts this renter tried to set up with a host.
+type HostStats struct {
+	HostId      string
+	Successes   uint64
+	Failures    uint64
+	LastOutcome time.Time
+}
+
+// Reliability is the laplace-smoothed success rate of the host, so that
+// unknown hosts start at 0.5 instead of being ruled out.
+func (s *HostStats) Reliability() float64 {
+	return float64(s.Successes+1) / float64(s.Successes+s.Failures+2)
+}
+
+// RecordHostOutcome stores whether a contract with the given host was set up successfully.
+func RecordHostOutcome(cp *ContextParams, hostId string, success bool) error {
+	l := hostStatsLock(hostId)
+	l.Lock()
+	defer l.Unlock()
+
+	stats, err := GetHostStats(cp, hostId)
+	if err != nil {
+		return err
+	}
+	if success {
+		stats.Successes++
+	} else {
+		stats.Failures++
+	}
+	stats.LastOutcome = time.Now()
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return cp.N.Repo.Datastore().Put(cp.Ctx, ds.NewKey(fmt.Sprintf(hostStatsKey, cp.N.Identity.String(), hostId)), b)
+}
+
+// GetHostStats returns the recorded contract outcomes of a host, empty if there are none.
+func GetHostStats(cp *ContextParams, hostId string) (*HostStats, error) {
+	stats := &HostStats{HostId: hostId}
+	b, err := cp.N.Repo.Datastore().Get(cp.Ctx, ds.NewKey(fmt.Sprintf(hostStatsKey, cp.N.Identity.String(), hostId)))
+	if err == ds.ErrNotFound {
+		return stats, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ListHostStats returns the recorded contract outcomes of all hosts, keyed by host id.
+func ListHostStats(cp *ContextParams) (map[string]*HostStats, error) {
+	results, err := cp.N.Repo.Datastore().Query(cp.Ctx, query.Query{
+		Prefix: fmt.Sprintf(hostStatsPrefix, cp.N.Identity.String()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	all := make(map[string]*HostStats)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		stats := new(HostStats)
+		if err := json.Unmarshal(r.Value, stats); err != nil {
+			log.Debugf("skip malformed host stats %s: %v", r.Key, err)
+			continue
+		}
+		all[stats.HostId] = stats
+	}
+	return all, nil
+}
@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+)
+
+func TestRecordHostOutcomeConcurrent(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	cp := &ContextParams{Ctx: context.Background(), N: node}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := RecordHostOutcome(cp, "host", i%4 != 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	stats, err := GetHostStats(cp, "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no outcome is lost to a concurrent update
+	if stats.Successes != 15 || stats.Failures != 5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -26,12 +27,15 @@ const (
 
 type IHostsProvider interface {
 	NextValidHost() (string, error)
+	// Blacklist keeps the host from being returned again.
+	Blacklist(host string)
 }
 
 type CustomizedHostsProvider struct {
-	cp      *ContextParams
-	current int
-	hosts   []string
+	cp        *ContextParams
+	current   int
+	hosts     []string
+	blacklist map[string]bool
 	sync.Mutex
 }
 
@@ -43,6 +47,9 @@ func (p *CustomizedHostsProvider) NextValidHost() (string, error) {
 
 	for true {
 		if index, err := p.AddIndex(); err == nil {
+			if p.isBlacklisted(p.hosts[index]) {
+				continue
+			}
 			id, err := peer.Decode(p.hosts[index])
 			if err != nil {
 				continue
@@ -66,12 +73,25 @@ func (p *CustomizedHostsProvider) NextValidHost() (string, error) {
 
 func GetCustomizedHostsProvider(cp *ContextParams, hosts []string) IHostsProvider {
 	return &CustomizedHostsProvider{
-		cp:      cp,
-		current: -1,
-		hosts:   hosts,
+		cp:        cp,
+		current:   -1,
+		hosts:     hosts,
+		blacklist: make(map[string]bool),
 	}
 }
 
+func (p *CustomizedHostsProvider) Blacklist(host string) {
+	p.Lock()
+	defer p.Unlock()
+	p.blacklist[host] = true
+}
+
+func (p *CustomizedHostsProvider) isBlacklisted(host string) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.blacklist[host]
+}
+
 func (p *CustomizedHostsProvider) AddIndex() (int, error) {
 	p.Lock()
 	defer p.Unlock()
@@ -95,9 +115,28 @@ type HostsProvider struct {
 	cancel          context.CancelFunc
 	times           int
 	needHigherPrice bool
+	lowestAsk       int64
+	selector        HostSelector
+	ceiling         *PriceCeiling
+}
+
+// PriceCeiling makes a hosts provider skip the hosts that ask more than MaxPrice
+// per GiB per day.
+type PriceCeiling struct {
+	MaxPrice int64
+	// OnSkip is called for every host that is skipped for its price.
+	OnSkip func(hostId string, priceAsk int64)
 }
 
 func GetHostsProvider(cp *ContextParams, blacklist []string) IHostsProvider {
+	return GetHostsProviderWithSelector(cp, blacklist, nil, nil)
+}
+
+// GetHostsProviderWithSelector returns a hosts provider that tries the hub-synced
+// hosts in the order given by the selector, skipping the ones above the price ceiling.
+// A nil selector keeps the hub order, and a nil ceiling accepts any price.
+func GetHostsProviderWithSelector(cp *ContextParams, blacklist []string, selector HostSelector,
+	ceiling *PriceCeiling) IHostsProvider {
 	ctx, cancel := context.WithTimeout(cp.Ctx, 10*time.Minute)
 	p := &HostsProvider{
 		cp:              cp,
@@ -107,6 +146,8 @@ func GetHostsProvider(cp *ContextParams, blacklist []string) IHostsProvider {
 		ctx:             ctx,
 		cancel:          cancel,
 		needHigherPrice: false,
+		selector:        selector,
+		ceiling:         ceiling,
 	}
 	p.init()
 	return p
@@ -117,6 +158,13 @@ func (p *HostsProvider) init() (err error) {
 	if err != nil {
 		return err
 	}
+	if p.selector != nil {
+		ranked := p.selector.Select(NewHostCandidates(p.cp, p.hosts))
+		for i, c := range ranked {
+			p.hosts[i] = c.Host
+		}
+		log.Debugf("hosts ordered by %s strategy", p.selector.Name())
+	}
 	peers, err := p.cp.Api.Swarm().Peers(p.cp.Ctx)
 	if err != nil {
 		log.Debug(err)
@@ -160,12 +208,13 @@ func (p Peers) Swap(i int, j int) {
 
 func (p *HostsProvider) AddIndex() (int, error) {
 	p.Lock()
-	defer p.Unlock()
 	p.current++
-	if p.current >= len(p.hosts) {
+	current, end := p.current, len(p.hosts)
+	p.Unlock()
+	if current >= end {
 		return -1, errors.New(p.getMsg())
 	}
-	return p.current, nil
+	return current, nil
 }
 
 func (p *HostsProvider) PickFromBackupHosts() (string, error) {
@@ -189,6 +238,9 @@ func (p *HostsProvider) PickFromBackupHosts() (string, error) {
 		if err != nil {
 			return "", err
 		}
+		if p.isBlacklisted(host) {
+			continue
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
 		id, err := peer.Decode(host)
@@ -221,6 +273,9 @@ func (p *HostsProvider) PickFromBackupHosts() (string, error) {
 		if !b {
 			continue
 		}
+		if !p.acceptPrice(host, int64(ns.StoragePriceAsk)) {
+			continue
+		}
 		return host, nil
 	}
 	return "", errors.New("shouldn't reach here")
@@ -246,16 +301,13 @@ LOOP:
 		p.Unlock()
 		if index, err := p.AddIndex(); times < 2000 && err == nil {
 			host := p.hosts[index]
-			for _, h := range p.blacklist {
-				if h == host.NodeId {
-					continue LOOP
-				}
+			if p.isBlacklisted(host.NodeId) {
+				continue LOOP
+			}
+			if !p.acceptPrice(host.NodeId, int64(host.StoragePriceAsk)) {
+				continue
 			}
 			id, err := peer.Decode(host.NodeId)
-			//if err != nil || int64(host.StoragePriceAsk) > price {
-			//	p.needHigherPrice = true
-			//	continue
-			//}
 			if err != nil {
 				continue
 			}
@@ -288,10 +340,47 @@ LOOP:
 	return "", errors.New(p.getMsg())
 }
 
+func (p *HostsProvider) Blacklist(host string) {
+	p.Lock()
+	defer p.Unlock()
+	p.blacklist = append(p.blacklist, host)
+}
+
+func (p *HostsProvider) isBlacklisted(host string) bool {
+	p.Lock()
+	defer p.Unlock()
+	for _, h := range p.blacklist {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptPrice reports whether the host asks no more than the price ceiling, and
+// keeps track of the lowest ask of the hosts that were skipped.
+func (p *HostsProvider) acceptPrice(hostId string, priceAsk int64) bool {
+	if p.ceiling == nil || p.ceiling.MaxPrice <= 0 || priceAsk <= p.ceiling.MaxPrice {
+		return true
+	}
+	p.Lock()
+	p.needHigherPrice = true
+	if p.lowestAsk == 0 || priceAsk < p.lowestAsk {
+		p.lowestAsk = priceAsk
+	}
+	p.Unlock()
+	if p.ceiling.OnSkip != nil {
+		p.ceiling.OnSkip(hostId, priceAsk)
+	}
+	return false
+}
+
 func (p *HostsProvider) getMsg() string {
+	p.Lock()
+	defer p.Unlock()
 	msg := failMsg
 	if p.needHigherPrice {
-		msg += " or raise price"
+		msg += fmt.Sprintf(" or raise price to at least %d", p.lowestAsk)
 	}
 	return msg
 }
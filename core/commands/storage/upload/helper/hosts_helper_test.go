@@ -0,0 +1,56 @@
+package helper
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHostsProviderPriceCeiling(t *testing.T) {
+	skipped := make(map[string]int64)
+	p := &HostsProvider{
+		ceiling: &PriceCeiling{
+			MaxPrice: 100,
+			OnSkip: func(hostId string, priceAsk int64) {
+				skipped[hostId] = priceAsk
+			},
+		},
+	}
+	if !p.acceptPrice("a", 100) {
+		t.Fatal("host asking the max price should be accepted")
+	}
+	if p.acceptPrice("b", 250) || p.acceptPrice("c", 150) {
+		t.Fatal("hosts asking more than the max price should be skipped")
+	}
+	if len(skipped) != 2 || skipped["b"] != 250 || skipped["c"] != 150 {
+		t.Fatalf("unexpected skipped hosts %v", skipped)
+	}
+	if msg := p.getMsg(); !strings.HasSuffix(msg, "raise price to at least 150") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+
+	p = &HostsProvider{}
+	if !p.acceptPrice("b", 250) {
+		t.Fatal("any price should be accepted without a ceiling")
+	}
+	if msg := p.getMsg(); msg != failMsg {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestHostsProviderPriceCeilingConcurrent(t *testing.T) {
+	p := &HostsProvider{ceiling: &PriceCeiling{MaxPrice: 100}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p.acceptPrice("host", int64(200-i))
+			_ = p.getMsg()
+		}(i)
+	}
+	wg.Wait()
+	if msg := p.getMsg(); !strings.HasSuffix(msg, "raise price to at least 191") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
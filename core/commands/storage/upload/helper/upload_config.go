@@ -0,0 +1,155 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+
+	chunker "github.com/bittorrent/go-btfs-chunker"
+)
+
+const (
+	uploadConfigKey = "Upload"
+
+	// DefaultShardRetryBudget is how many times the failed shards of a session are
+	// placed again when Upload.ShardRetryBudget is not set.
+	DefaultShardRetryBudget = 30
+
+	// DefaultResumeMaxAge is how many hours after its last change an interrupted
+	// session is still resumed by the daemon at startup, when Upload.ResumeMaxAge
+	// is not set.
+	DefaultResumeMaxAge = 72
+
+	// DefaultAutoRenewBefore is how many days before its contracts end a file is
+	// renewed when Upload.AutoRenew.Before is not set.
+	DefaultAutoRenewBefore = 7
+
+	// DefaultAuditInterval is how many hours apart a file is audited when
+	// Upload.Audit.Interval is not set.
+	DefaultAuditInterval = 24
+	// DefaultAuditSegments is how many segments of a shard are challenged when
+	// Upload.Audit.Segments is not set.
+	DefaultAuditSegments = 8
+
+	// DirectoryModeArchive packs a directory into a single reed-solomon encoded
+	// archive, uploaded by one session.
+	DirectoryModeArchive = "archive"
+	// DirectoryModePerFile encodes and uploads each file of a directory by its
+	// own session.
+	DirectoryModePerFile = "per-file"
+)
+
+// UploadConfig is read from the Upload config key, e.g.
+//
+//	$ btfs config --json Upload.ShardRetryBudget 10
+type UploadConfig struct {
+	// ShardRetryBudget is how many times, over the whole session, a shard that
+	// failed with a host is placed again with another host before the session
+	// fails. 0 uses DefaultShardRetryBudget, and a negative budget fails the
+	// session on the first failed shard.
+	ShardRetryBudget int
+	// ResumeMaxAge is how many hours after its last change an interrupted session
+	// is resumed by the daemon at startup. Older sessions are left for
+	// "storage upload resume". 0 uses DefaultResumeMaxAge, and a negative age
+	// turns off resuming at startup.
+	ResumeMaxAge int
+	AutoRenew    AutoRenewConfig
+	// ErasureCoding is how files that were not added with the reed-solomon
+	// chunker are encoded when they are uploaded.
+	ErasureCoding ErasureCodingConfig
+	// DirectoryMode is how directories are uploaded, DirectoryModeArchive or
+	// DirectoryModePerFile. Empty uses DirectoryModeArchive.
+	DirectoryMode string
+	Audit         AuditConfig
+}
+
+// ErasureCodingConfig sets the reed-solomon encoding of uploaded files, e.g.
+//
+//	$ btfs config --json Upload.ErasureCoding '{"DataShards": 10, "ParityShards": 20}'
+//
+// Fields left at 0 use the defaults of the reed-solomon chunker.
+type ErasureCodingConfig struct {
+	DataShards   int
+	ParityShards int
+	ShardSize    int64
+}
+
+// Chunker returns the reed-solomon chunker for the encoding.
+func (c ErasureCodingConfig) Chunker() string {
+	return fmt.Sprintf("%s-%d-%d-%d", chunker.PrefixForReedSolomon, c.DataShards, c.ParityShards, c.ShardSize)
+}
+
+// AutoRenewConfig turns on the renewal of uploaded files before they expire, e.g.
+//
+//	$ btfs config --json Upload.AutoRenew '{"Enabled": true, "Days": 30, "Budget": 100000000}'
+type AutoRenewConfig struct {
+	Enabled bool
+	// Days is how many days a file is extended by. 0 uses the storage length of
+	// the upload, or 30 days when it is unknown.
+	Days int
+	// Before is how many days before its contracts end a file is renewed.
+	Before int
+	// Budget is the total amount the automatic renewals may spend. Files are not
+	// renewed automatically without a budget.
+	Budget int64
+}
+
+// AuditConfig turns on the periodic challenge of the hosts of uploaded files, e.g.
+//
+//	$ btfs config --json Upload.Audit '{"Enabled": true, "Interval": 12, "Repair": true}'
+type AuditConfig struct {
+	Enabled bool
+	// Interval is how many hours apart each file is audited.
+	Interval int
+	// Segments is how many segments of a shard are challenged, for shards with
+	// a segment commitment.
+	Segments int
+	// Repair starts the repair of a file when less shards than needed to
+	// reconstruct it, plus RepairMargin, pass their audit.
+	Repair       bool
+	RepairMargin int
+}
+
+// GetUploadConfig returns the Upload config, with the defaults filled in.
+func GetUploadConfig(cp *ContextParams) *UploadConfig {
+	cfg := new(UploadConfig)
+	v, err := cp.N.Repo.GetConfigKey(uploadConfigKey)
+	if err == nil {
+		b, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(b, cfg)
+		}
+		if err != nil {
+			log.Warnf("ignore malformed %s config: %v", uploadConfigKey, err)
+		}
+	}
+	if cfg.ShardRetryBudget == 0 {
+		cfg.ShardRetryBudget = DefaultShardRetryBudget
+	} else if cfg.ShardRetryBudget < 0 {
+		cfg.ShardRetryBudget = 0
+	}
+	if cfg.ResumeMaxAge == 0 {
+		cfg.ResumeMaxAge = DefaultResumeMaxAge
+	}
+	if cfg.AutoRenew.Before <= 0 {
+		cfg.AutoRenew.Before = DefaultAutoRenewBefore
+	}
+	if cfg.ErasureCoding.DataShards <= 0 {
+		cfg.ErasureCoding.DataShards = chunker.DefaultReedSolomonDataShards
+	}
+	if cfg.ErasureCoding.ParityShards <= 0 {
+		cfg.ErasureCoding.ParityShards = chunker.DefaultReedSolomonParityShards
+	}
+	if cfg.ErasureCoding.ShardSize <= 0 {
+		cfg.ErasureCoding.ShardSize = chunker.DefaultReedSolomonShardSize
+	}
+	if cfg.Audit.Interval <= 0 {
+		cfg.Audit.Interval = DefaultAuditInterval
+	}
+	if cfg.Audit.Segments <= 0 {
+		cfg.Audit.Segments = DefaultAuditSegments
+	}
+	if cfg.DirectoryMode == "" {
+		cfg.DirectoryMode = DirectoryModeArchive
+	}
+	return cfg
+}
@@ -41,6 +41,14 @@ var (
 		bo.MaxInterval = 10 * time.Minute
 		return bo
 	}
+	SubmitFileMetaBo = func(maxTime time.Duration) *backoff.ExponentialBackOff {
+		bo := backoff.NewExponentialBackOff()
+		bo.InitialInterval = 5 * time.Second
+		bo.MaxElapsedTime = maxTime
+		bo.Multiplier = 1.5
+		bo.MaxInterval = time.Minute
+		return bo
+	}
 	HandleShardBo = func() *backoff.ExponentialBackOff {
 		bo := backoff.NewExponentialBackOff()
 		bo.InitialInterval = 1 * time.Second
@@ -118,8 +126,11 @@ func GetShardHashes(params *ContextParams, fileHash string) (shardHashes []strin
 		return nil, -1, -1, err
 	}
 	cids, fileSize, err := helper.CheckAndGetReedSolomonShardHashes(params.Ctx, params.N, params.Api, fileCid)
-	if err != nil || len(cids) == 0 {
-		return nil, -1, -1, fmt.Errorf("invalid hash: %s", err)
+	if err != nil {
+		return nil, -1, -1, fmt.Errorf("invalid hash: %w", err)
+	}
+	if len(cids) == 0 {
+		return nil, -1, -1, errors.New("invalid hash: no shards")
 	}
 
 	shardHashes = make([]string, 0)
@@ -189,8 +200,7 @@ func TotalPayReal(shardSize int64, price int64, storageLength int, rate *big.Int
 	if totalPay <= 0 {
 		totalPay = 1
 	}
-	//fmt.Printf("size:%v GB, price:%v000000000000 , storageLength:%v,  TotalPay:%v000000000000 \n", float64(shardSize)/float64(units.GiB), price, storageLength, totalPay)
-	fmt.Printf("size:%v GB, price:%v*%v , storageLength:%v,  TotalPay:%v*%v \n", float64(shardSize)/float64(units.GiB), price, rate.String(), storageLength, totalPay, rate.String())
+	log.Debugf("size:%v GB, price:%v*%v, storageLength:%v, TotalPay:%v*%v", float64(shardSize)/float64(units.GiB), price, rate.String(), storageLength, totalPay, rate.String())
 
 	return totalPay, nil
 }
@@ -199,8 +209,7 @@ func TotalPayRound(shardSize int64, price int64, storageLength int, rate *big.In
 	totalPayFloat := float64(shardSize) / float64(units.GiB) * float64(price) * float64(storageLength)
 
 	totalPay := int64(math.Floor(totalPayFloat + 0.5))
-	//fmt.Printf("size:%v GB, price:%v*%v , storageLength:%v,  TotalPay:%v*%v \n", float64(shardSize)/float64(units.GiB), price, rate.String(), storageLength, totalPay, rate.String())
-	fmt.Printf("size:%v GB, price:%v*%v , storageLength:%v,  TotalPay:%v*%v, TotalPayFloat:%v*%v \n", float64(shardSize)/float64(units.GiB), price, rate.String(), storageLength, totalPay, rate.String(), totalPayFloat, rate.String())
+	log.Debugf("size:%v GB, price:%v*%v, storageLength:%v, TotalPay:%v*%v, TotalPayFloat:%v*%v", float64(shardSize)/float64(units.GiB), price, rate.String(), storageLength, totalPay, rate.String(), totalPayFloat, rate.String())
 	if totalPay < 1 {
 		return 0, errors.New("Your file upload fee is less than 1 precision minimum unit, please use WBTT token to upload. ")
 	}
@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/bittorrent/protobuf/proto"
@@ -45,6 +46,24 @@ func Get(d ds.Datastore, key string, m proto.Message) error {
 	return proto.Unmarshal(bytes, m)
 }
 
+func SaveJSON(d ds.Datastore, key string, val interface{}) error {
+	ctx := context.TODO()
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, ds.NewKey(key), bytes)
+}
+
+func GetJSON(d ds.Datastore, key string, val interface{}) error {
+	ctx := context.TODO()
+	bytes, err := d.Get(ctx, ds.NewKey(key))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, val)
+}
+
 func Remove(d ds.Datastore, key string) error {
 	ctx := context.TODO()
 	return d.Delete(ctx, ds.NewKey(key))
@@ -46,3 +46,28 @@ func TestSaveGetRemove(t *testing.T) {
 		t.Fatal("ds.ds.test should have been removed")
 	}
 }
+
+func TestSaveGetJSON(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &UploadParams{
+		Price:          1000,
+		ShardSize:      1024,
+		StorageLength:  30,
+		HostSelectMode: "custom",
+		HostIDs:        []string{"h1", "h2"},
+	}
+	err = SaveJSON(node.Repo.Datastore(), "ds.ds.json", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newParams := &UploadParams{}
+	err = GetJSON(node.Repo.Datastore(), "ds.ds.json", newParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, params, newParams)
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
 	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
 	shardpb "github.com/bittorrent/go-btfs/protos/shard"
 
 	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
 	"github.com/bittorrent/protobuf/proto"
 
 	"github.com/ipfs/go-datastore"
@@ -29,6 +31,7 @@ const (
 	hshPayStatus      = "paid"
 	hshCompleteStatus = "complete"
 	hshErrorStatus    = "error"
+	hshCanceledStatus = "canceled"
 
 	hshToContractEvent = "to-contract"
 	hshToPayEvent      = "to-pay"
@@ -167,3 +170,64 @@ func (hs *HostShard) contracts() (*shardpb.SignedContracts, error) {
 	}
 	return contracts, err
 }
+
+// GetHostShardContract returns the guard contract the host signed for a shard,
+// datastore.ErrNotFound if it has none.
+func GetHostShardContract(ctxParams *uh.ContextParams, contractId string) (*guardpb.Contract, error) {
+	contracts := &shardpb.SignedContracts{}
+	err := Get(ctxParams.N.Repo.Datastore(), fmt.Sprintf(hostShardContractsKey, ctxParams.N.Identity.Pretty(), contractId),
+		contracts)
+	if err != nil {
+		return nil, err
+	}
+	if contracts.SignedGuardContract == nil {
+		return nil, datastore.ErrNotFound
+	}
+	return contracts.SignedGuardContract, nil
+}
+
+// CancelHostShard records that the renter canceled the contract of a shard.
+func CancelHostShard(ctxParams *uh.ContextParams, contractId string) error {
+	peerId := ctxParams.N.Identity.Pretty()
+	hostShardsInMem.Remove(fmt.Sprintf(hostShardsInMemKey, peerId, contractId))
+	return Save(ctxParams.N.Repo.Datastore(), fmt.Sprintf(hostShardStatusKey, peerId, contractId), &shardpb.Status{
+		Status: hshCanceledStatus,
+	})
+}
+
+// IsHostShardCanceled reports whether the renter canceled the contract of a shard.
+func IsHostShardCanceled(ctxParams *uh.ContextParams, contractId string) (bool, error) {
+	status := new(shardpb.Status)
+	err := Get(ctxParams.N.Repo.Datastore(), fmt.Sprintf(hostShardStatusKey, ctxParams.N.Identity.Pretty(), contractId),
+		status)
+	if err == datastore.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return status.Status == hshCanceledStatus, nil
+}
+
+// HostShardKept reports whether another running contract of the host stores the
+// shard of a canceled contract, e.g. for another upload of the same file.
+func HostShardKept(ctxParams *uh.ContextParams, canceled *guardpb.Contract) (bool, error) {
+	cs, err := ListShardsContracts(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(),
+		nodepb.ContractStat_HOST.String())
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, sc := range cs {
+		c := sc.SignedGuardContract
+		if c == nil || c.ContractId == canceled.ContractId || c.ShardHash != canceled.ShardHash ||
+			c.RentEnd.Before(now) {
+			continue
+		}
+		if isCanceled, err := IsHostShardCanceled(ctxParams, c.ContractId); err != nil {
+			return false, err
+		} else if !isCanceled {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,184 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	renterAuditHostsKey   = "/btfs/%s/renter/audits/hosts/"
+	renterAuditHostKey    = renterAuditHostsKey + "%s"
+	renterAuditFileKey    = "/btfs/%s/renter/audits/files/%s"
+	renterAuditAlertsKey  = "/btfs/%s/renter/audits/alerts/"
+	renterAuditAlertKey   = renterAuditAlertsKey + "%020d"
+	auditHostHistoryLimit = 50
+
+	// AuditChunkMethod is a challenge of a chunk of the shard, answered with the
+	// hash of the chunk and a nonce.
+	AuditChunkMethod = "chunk"
+	// AuditSegmentsMethod is a challenge of random segments of the shard,
+	// answered with their merkle proofs.
+	AuditSegmentsMethod = "segments"
+
+	// AlertHostFailing is a host failing audits in a row.
+	AlertHostFailing = "host-failing"
+	// AlertFileAtRisk is a file with less shards passing audits than it needs to
+	// be reconstructed.
+	AlertFileAtRisk = "file-at-risk"
+	// AlertRepair is the repair of a file started by an audit, or its failure.
+	AlertRepair = "repair"
+)
+
+var auditHostsLock sync.Mutex
+
+// AuditResult is the outcome of challenging the host of a shard.
+type AuditResult struct {
+	Time       time.Time
+	SessionId  string
+	FileHash   string
+	ShardIndex int
+	ShardHash  string
+	Host       string
+	ContractId string
+	Method     string
+	Passed     bool
+	Error      string `json:",omitempty"`
+}
+
+// HostAudit is the audit history of a host, over all the files it stores.
+type HostAudit struct {
+	Host   string
+	Passed int64
+	Failed int64
+	// ConsecutiveFailures is how many audits the host failed since it last passed.
+	ConsecutiveFailures int
+	LastAudit           time.Time
+	// History holds the latest results, newest last.
+	History []*AuditResult
+}
+
+// FileAudit is the last audit of an uploaded file.
+type FileAudit struct {
+	SessionId string
+	FileHash  string
+	Time      time.Time
+	Passed    int
+	Failed    int
+	// NumData is how many shards are needed to reconstruct the file.
+	NumData int
+	AtRisk  bool
+	// Repaired are the shards a repair was started for.
+	Repaired []string `json:",omitempty"`
+	// RepairSession is the session that places the repaired shards.
+	RepairSession string `json:",omitempty"`
+	Shards        []*AuditResult
+	Error         string `json:",omitempty"`
+}
+
+// AuditAlert is raised when audits find hosts or files in trouble.
+type AuditAlert struct {
+	Time      time.Time
+	Type      string
+	SessionId string `json:",omitempty"`
+	FileHash  string `json:",omitempty"`
+	Host      string `json:",omitempty"`
+	Message   string
+}
+
+// AddAuditResult adds a result to the history of its host, and returns the
+// updated history.
+func AddAuditResult(d datastore.Datastore, peerId string, r *AuditResult) (*HostAudit, error) {
+	auditHostsLock.Lock()
+	defer auditHostsLock.Unlock()
+	h, err := GetHostAudit(d, peerId, r.Host)
+	if err == datastore.ErrNotFound {
+		h = &HostAudit{Host: r.Host}
+	} else if err != nil {
+		return nil, err
+	}
+	if r.Passed {
+		h.Passed++
+		h.ConsecutiveFailures = 0
+	} else {
+		h.Failed++
+		h.ConsecutiveFailures++
+	}
+	h.LastAudit = r.Time
+	h.History = append(h.History, r)
+	if len(h.History) > auditHostHistoryLimit {
+		h.History = h.History[len(h.History)-auditHostHistoryLimit:]
+	}
+	if err := SaveJSON(d, fmt.Sprintf(renterAuditHostKey, peerId, r.Host), h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func GetHostAudit(d datastore.Datastore, peerId string, host string) (*HostAudit, error) {
+	h := new(HostAudit)
+	if err := GetJSON(d, fmt.Sprintf(renterAuditHostKey, peerId, host), h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ListHostAudits returns the audit histories of all the audited hosts.
+func ListHostAudits(d datastore.Datastore, peerId string) ([]*HostAudit, error) {
+	vs, err := List(d, fmt.Sprintf(renterAuditHostsKey, peerId))
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]*HostAudit, 0, len(vs))
+	for _, v := range vs {
+		h := new(HostAudit)
+		if err := json.Unmarshal(v, h); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+func SaveFileAudit(d datastore.Datastore, peerId string, a *FileAudit) error {
+	return SaveJSON(d, fmt.Sprintf(renterAuditFileKey, peerId, a.SessionId), a)
+}
+
+// GetFileAudit returns the last audit of the file of a session.
+func GetFileAudit(d datastore.Datastore, peerId string, ssId string) (*FileAudit, error) {
+	a := new(FileAudit)
+	if err := GetJSON(d, fmt.Sprintf(renterAuditFileKey, peerId, ssId), a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// AddAuditAlert records an alert, alerts are also logged.
+func AddAuditAlert(d datastore.Datastore, peerId string, a *AuditAlert) error {
+	log.Warnf("audit alert %s: %s", a.Type, a.Message)
+	return SaveJSON(d, fmt.Sprintf(renterAuditAlertKey, peerId, a.Time.UnixNano()), a)
+}
+
+// ListAuditAlerts returns the alerts raised since the given time, oldest first.
+func ListAuditAlerts(d datastore.Datastore, peerId string, since time.Time) ([]*AuditAlert, error) {
+	vs, err := List(d, fmt.Sprintf(renterAuditAlertsKey, peerId))
+	if err != nil {
+		return nil, err
+	}
+	alerts := make([]*AuditAlert, 0, len(vs))
+	for _, v := range vs {
+		a := new(AuditAlert)
+		if err := json.Unmarshal(v, a); err != nil {
+			return nil, err
+		}
+		if !a.Time.Before(since) {
+			alerts = append(alerts, a)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Time.Before(alerts[j].Time) })
+	return alerts, nil
+}
@@ -0,0 +1,167 @@
+package sessions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	RenterSessionEventsKey = RenterSessionKey + "events/"
+	renterSessionEventKey  = RenterSessionEventsKey + "%020d"
+
+	// EventStatus is a transition of the session to another status.
+	EventStatus = "status"
+	// EventEncode is the encoding of a file that was not reed-solomon encoded.
+	EventEncode = "encode"
+	// EventShardAttempt is a host being asked to take a shard.
+	EventShardAttempt = "shard-attempt"
+	// EventShardFailed is a host that did not take a shard, with the reason.
+	EventShardFailed = "shard-failed"
+	// EventShardContracted is a host that took a shard.
+	EventShardContracted = "shard-contracted"
+	// EventShardRequeued is a failed shard being placed again with another host.
+	EventShardRequeued = "shard-requeued"
+	// EventCheque is a cheque sent to a host, or the failure to send it.
+	EventCheque = "cheque"
+	// EventGuard is a response of the guard about the file.
+	EventGuard = "guard"
+	// EventRenew is a renewal of the contracts of the session.
+	EventRenew = "renew"
+	// EventCancel is the cancellation of a contract of the session.
+	EventCancel = "cancel"
+	// EventAudit is the challenge of the host of a shard by an audit.
+	EventAudit = "audit"
+)
+
+// Event is an entry of the history of a renter session.
+type Event struct {
+	Seq        uint64
+	Time       time.Time
+	Type       string
+	Status     string `json:",omitempty"`
+	ShardIndex *int   `json:",omitempty"`
+	ShardHash  string `json:",omitempty"`
+	Host       string `json:",omitempty"`
+	Message    string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+// ShardEvent returns an event about the shard at index i.
+func ShardEvent(typ string, i int, hash string, host string, err error) *Event {
+	e := &Event{
+		Type:       typ,
+		ShardIndex: &i,
+		ShardHash:  hash,
+		Host:       host,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// AddEvent appends an event to the history of the session and wakes up the
+// callers waiting on EventsSince. Events are only logged when they can not be
+// saved, so that recording the history never fails an upload.
+func (rs *RenterSession) AddEvent(e *Event) {
+	rs.eventsLock.Lock()
+	defer rs.eventsLock.Unlock()
+	if err := rs.loadEventSeq(); err != nil {
+		log.Errorf("session %s: load events error: %v", rs.SsId, err)
+		return
+	}
+	rs.eventSeq++
+	e.Seq = rs.eventSeq
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	err := SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionEventKey, rs.PeerId, rs.SsId, e.Seq), e)
+	if err != nil {
+		log.Errorf("session %s: save event error: %v", rs.SsId, err)
+		return
+	}
+	if rs.eventsChanged != nil {
+		close(rs.eventsChanged)
+		rs.eventsChanged = nil
+	}
+}
+
+// Events returns the history of the session, oldest first.
+func (rs *RenterSession) Events() ([]*Event, error) {
+	events, _, err := rs.EventsSince(0)
+	return events, err
+}
+
+// EventsSince returns the events after the given sequence number, and a channel
+// closed once a newer event is added.
+func (rs *RenterSession) EventsSince(seq uint64) ([]*Event, <-chan struct{}, error) {
+	rs.eventsLock.Lock()
+	defer rs.eventsLock.Unlock()
+	if err := rs.loadEventSeq(); err != nil {
+		return nil, nil, err
+	}
+	events := make([]*Event, 0)
+	for ; seq < rs.eventSeq; seq++ {
+		e := new(Event)
+		err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionEventKey, rs.PeerId, rs.SsId, seq+1), e)
+		if err == datastore.ErrNotFound {
+			// the event could not be saved when it was added
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, e)
+	}
+	if rs.eventsChanged == nil {
+		rs.eventsChanged = make(chan struct{})
+	}
+	return events, rs.eventsChanged, nil
+}
+
+// loadEventSeq reads the sequence number of the last saved event, the first
+// time the events of the session are used. Must be called with the events lock
+// held.
+func (rs *RenterSession) loadEventSeq() error {
+	if rs.eventsLoaded {
+		return nil
+	}
+	keys, err := ListKeys(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionEventsKey, rs.PeerId, rs.SsId))
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+	for _, k := range keys {
+		seq, err := strconv.ParseUint(k[strings.LastIndex(k, "/")+1:], 10, 64)
+		if err != nil {
+			return err
+		}
+		if seq > rs.eventSeq {
+			rs.eventSeq = seq
+		}
+	}
+	rs.eventsLoaded = true
+	return nil
+}
+
+// FailureReasons sums up why the shards of the session failed, from the events.
+func FailureReasons(events []*Event) string {
+	counts := make(map[string]int)
+	reasons := make([]string, 0)
+	for _, e := range events {
+		if e.Type != EventShardFailed || e.Error == "" {
+			continue
+		}
+		if counts[e.Error] == 0 {
+			reasons = append(reasons, e.Error)
+		}
+		counts[e.Error]++
+	}
+	for i, r := range reasons {
+		reasons[i] = r + " (x" + strconv.Itoa(counts[r]) + ")"
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,63 @@
+package sessions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	renterManifestKey = "/btfs/%s/renter/manifests/%s"
+
+	// ManifestFileMode is a plain file encoded as a whole.
+	ManifestFileMode = "file"
+	// ManifestArchiveMode is a directory packed into a single encoded archive.
+	ManifestArchiveMode = "archive"
+	// ManifestPerFileMode is a directory whose files are encoded one by one.
+	ManifestPerFileMode = "per-file"
+)
+
+// Manifest records how a file or directory that was not reed-solomon encoded
+// was encoded for upload, so that the tree can be restored from the uploads.
+type Manifest struct {
+	// Hash is the hash of the file or directory as it was given to upload.
+	Hash    string
+	Mode    string
+	Chunker string
+	// EncodedHash and SessionId are the encoded file or archive and its upload
+	// session, they are empty in ManifestPerFileMode.
+	EncodedHash string `json:",omitempty"`
+	SessionId   string `json:",omitempty"`
+	// Entries is the tree of a directory, in depth first order.
+	Entries []*ManifestEntry `json:",omitempty"`
+	Created time.Time
+}
+
+// ManifestEntry is a file or directory of the tree of a manifest.
+type ManifestEntry struct {
+	Path string
+	Hash string
+	Size uint64
+	Dir  bool `json:",omitempty"`
+	// Target is the target of a symlink.
+	Target string `json:",omitempty"`
+	// EncodedHash and SessionId are only set on the files of a directory
+	// uploaded in ManifestPerFileMode.
+	EncodedHash string `json:",omitempty"`
+	SessionId   string `json:",omitempty"`
+}
+
+// SaveManifest persists a manifest under the hash it was uploaded with.
+func SaveManifest(d datastore.Datastore, peerId string, m *Manifest) error {
+	return SaveJSON(d, fmt.Sprintf(renterManifestKey, peerId, m.Hash), m)
+}
+
+// GetManifest returns the manifest of the given file or directory hash.
+func GetManifest(d datastore.Datastore, peerId string, hash string) (*Manifest, error) {
+	m := new(Manifest)
+	if err := GetJSON(d, fmt.Sprintf(renterManifestKey, peerId, hash), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
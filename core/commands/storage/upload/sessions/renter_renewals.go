@@ -0,0 +1,120 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	shardpb "github.com/bittorrent/go-btfs/protos/shard"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	RenterSessionRenewalsKey = RenterSessionKey + "renewals/"
+	renterSessionRenewalKey  = RenterSessionRenewalsKey + "%s"
+	renterAutoRenewSpentKey  = "/btfs/%s/renter/auto-renew/spent"
+
+	RenewPendingStatus  = "pending"
+	RenewContractStatus = "contract"
+	RenewPaidStatus     = "paid"
+	RenewCompleteStatus = "complete"
+	RenewErrorStatus    = "error"
+)
+
+var autoRenewSpentLock sync.Mutex
+
+// Renewal is the extension of the contracts of a completed session with the
+// hosts that store its shards.
+type Renewal struct {
+	Id          string
+	SessionId   string
+	FileHash    string
+	Days        int
+	Auto        bool
+	Status      string
+	Message     string `json:",omitempty"`
+	Amount      int64
+	Shards      []*RenewedShard
+	Created     time.Time
+	LastUpdated time.Time
+}
+
+// RenewedShard is the extended contract of a shard of a renewal.
+type RenewedShard struct {
+	ShardIndex     int
+	ShardHash      string
+	Host           string
+	PrevContractId string
+	ContractId     string
+	RentEnd        time.Time
+	Price          int64
+	Amount         int64
+	Status         string
+	Error          string `json:",omitempty"`
+	// Contract is the renewal contract signed by the host, kept so that an
+	// interrupted renewal can be resumed.
+	Contract []byte `json:",omitempty"`
+}
+
+// SaveRenewal persists a renewal of the session.
+func (rs *RenterSession) SaveRenewal(r *Renewal) error {
+	r.LastUpdated = time.Now().UTC()
+	return SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionRenewalKey, rs.PeerId, rs.SsId, r.Id), r)
+}
+
+// Renewals returns the renewals of the session, oldest first.
+func (rs *RenterSession) Renewals() ([]*Renewal, error) {
+	vs, err := List(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionRenewalsKey, rs.PeerId, rs.SsId))
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	renewals := make([]*Renewal, 0, len(vs))
+	for _, v := range vs {
+		r := new(Renewal)
+		if err := json.Unmarshal(v, r); err != nil {
+			return nil, err
+		}
+		renewals = append(renewals, r)
+	}
+	sort.Slice(renewals, func(i, j int) bool { return renewals[i].Created.Before(renewals[j].Created) })
+	return renewals, nil
+}
+
+// Renewed replaces the contract of a paid shard with the contract that extends it.
+func (rs *RenterShard) Renewed(signedGuardContract *guardpb.Contract) error {
+	contracts, err := rs.Contracts()
+	if err != nil {
+		return err
+	}
+	return Save(rs.ds, fmt.Sprintf(renterShardContractsKey, rs.peerId, GetShardId(rs.ssId, rs.hash, rs.index)),
+		&shardpb.SignedContracts{
+			SignedEscrowContract: contracts.SignedEscrowContract,
+			SignedGuardContract:  signedGuardContract,
+		})
+}
+
+// AutoRenewSpent returns the amount spent by the automatic renewals so far.
+func AutoRenewSpent(d datastore.Datastore, peerId string) (int64, error) {
+	var spent int64
+	err := GetJSON(d, fmt.Sprintf(renterAutoRenewSpentKey, peerId), &spent)
+	if err != nil && err != datastore.ErrNotFound {
+		return 0, err
+	}
+	return spent, nil
+}
+
+// AddAutoRenewSpent adds the amount of an automatic renewal to the amount spent.
+func AddAutoRenewSpent(d datastore.Datastore, peerId string, amount int64) error {
+	autoRenewSpentLock.Lock()
+	defer autoRenewSpentLock.Unlock()
+	spent, err := AutoRenewSpent(d, peerId)
+	if err != nil {
+		return err
+	}
+	return SaveJSON(d, fmt.Sprintf(renterAutoRenewSpentKey, peerId), spent+amount)
+}
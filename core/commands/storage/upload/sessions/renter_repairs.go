@@ -0,0 +1,81 @@
+package sessions
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+)
+
+const (
+	renterSessionRepairKey  = RenterSessionKey + "repair"
+	renterSessionRepairsKey = RenterSessionKey + "repairs"
+)
+
+// Repair is what a repair session places again: some shards of a file, at
+// their index in the file rather than at their position in the session.
+type Repair struct {
+	// SessionId is the session whose shards are repaired, if known.
+	SessionId    string `json:",omitempty"`
+	ShardIndexes []int
+}
+
+// ShardIndex returns the index in the file of the shard at position i of the
+// session. The shards of a session are at their position, unless it is a
+// repair session.
+func (rs *RenterSession) ShardIndex(i int) int {
+	if i < len(rs.ShardIndexes) {
+		return rs.ShardIndexes[i]
+	}
+	return i
+}
+
+// Shard returns the shard at position i of the session.
+func (rs *RenterSession) Shard(i int) (*RenterShard, error) {
+	return GetRenterShard(rs.CtxParams, rs.SsId, rs.ShardHashes[i], rs.ShardIndex(i))
+}
+
+// SaveRepair makes the session a repair session, before its shards are placed.
+func (rs *RenterSession) SaveRepair(r *Repair) error {
+	if len(r.ShardIndexes) != len(rs.ShardHashes) {
+		return fmt.Errorf("%d shard indexes for %d shards", len(r.ShardIndexes), len(rs.ShardHashes))
+	}
+	if err := SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionRepairKey, rs.PeerId, rs.SsId),
+		r); err != nil {
+		return err
+	}
+	rs.ShardIndexes = r.ShardIndexes
+	return nil
+}
+
+// Repair returns what the session repairs, or nil if it is not a repair session.
+func (rs *RenterSession) Repair() (*Repair, error) {
+	r := new(Repair)
+	err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionRepairKey, rs.PeerId, rs.SsId), r)
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AddRepairSession records a session that repairs shards of the session.
+func (rs *RenterSession) AddRepairSession(ssId string) error {
+	ids, err := rs.RepairSessions()
+	if err != nil {
+		return err
+	}
+	return SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionRepairsKey, rs.PeerId, rs.SsId),
+		append(ids, ssId))
+}
+
+// RepairSessions returns the sessions that repair shards of the session, oldest
+// first.
+func (rs *RenterSession) RepairSessions() ([]string, error) {
+	ids := make([]string, 0)
+	err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(renterSessionRepairsKey, rs.PeerId, rs.SsId), &ids)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
@@ -50,6 +51,9 @@ const (
 	RenterSessionAdditionalInfoKey = RenterSessionKey + "additional-info"
 	RenterSessionOfflineMetaKey    = RenterSessionKey + "offline-meta"
 	RenterSessionOfflineSigningKey = RenterSessionKey + "offline-signing"
+	RenterSessionUploadParamsKey   = RenterSessionKey + "upload-params"
+	RenterSessionSkippedHostsKey   = RenterSessionKey + "skipped-hosts"
+	RenterSessionFailedHostsKey    = RenterSessionKey + "failed-hosts"
 )
 
 var (
@@ -64,6 +68,19 @@ var (
 		{Name: RssToPayEvent, Src: []string{RssWaitUploadReqSignedStatus}, Dst: RssPayStatus},
 		{Name: RssToCompleteEvent, Src: []string{RssPayStatus}, Dst: RssCompleteStatus},
 	}
+	// rssStatusOrder is the order in which a session walks through its statuses,
+	// used to tell whether a resumed session already went past a step.
+	rssStatusOrder = map[string]int{
+		RssInitStatus:                 0,
+		RssSubmitStatus:               1,
+		RssGuardStatus:                2,
+		RssGuardFileMetaSignedStatus:  3,
+		RssGuardQuestionsSignedStatus: 4,
+		RssWaitUploadStatus:           5,
+		RssWaitUploadReqSignedStatus:  6,
+		RssPayStatus:                  7,
+		RssCompleteStatus:             8,
+	}
 )
 
 func init() {
@@ -86,6 +103,32 @@ type RenterSession struct {
 	Ctx         context.Context
 	Cancel      context.CancelFunc
 	Token       common.Address
+	skippedLock sync.Mutex
+	failedLock  sync.Mutex
+
+	eventsLock    sync.Mutex
+	eventsLoaded  bool
+	eventSeq      uint64
+	eventsChanged chan struct{}
+
+	// ShardIndexes are the indexes in the file of the shards of a repair
+	// session, see ShardIndex.
+	ShardIndexes []int
+}
+
+// UploadParams are the parameters an upload session was started with. They are
+// persisted along with the session so that a restarted daemon can resume it.
+type UploadParams struct {
+	Price          int64
+	MaxPrice       int64
+	Token          common.Address
+	ShardSize      int64
+	FileSize       int64
+	StorageLength  int
+	OfflineSigning bool
+	RenterId       string
+	HostSelectMode string
+	HostIDs        []string
 }
 
 func GetRenterSession(ctxParams *uh.ContextParams, ssId string, hash string, shardHashes []string) (*RenterSession,
@@ -117,6 +160,11 @@ func GetRenterSession(ctxParams *uh.ContextParams, ssId string, hash string, sha
 		if rs.ShardHashes = shardHashes; shardHashes == nil || len(shardHashes) == 0 {
 			rs.ShardHashes = status.ShardHashes
 		}
+		if r, err := rs.Repair(); err != nil {
+			return nil, err
+		} else if r != nil {
+			rs.ShardIndexes = r.ShardIndexes
+		}
 		if status.Status != RssCompleteStatus {
 			rs.fsm = fsm.NewFSM(status.Status, rssFsmEvents, fsm.Callbacks{
 				"enter_state": rs.enterState,
@@ -157,6 +205,11 @@ func GetRenterSessionWithToken(ctxParams *uh.ContextParams, ssId string, hash st
 		if rs.ShardHashes = shardHashes; shardHashes == nil || len(shardHashes) == 0 {
 			rs.ShardHashes = status.ShardHashes
 		}
+		if r, err := rs.Repair(); err != nil {
+			return nil, err
+		} else if r != nil {
+			rs.ShardIndexes = r.ShardIndexes
+		}
 		if status.Status != RssCompleteStatus {
 			rs.fsm = fsm.NewFSM(status.Status, rssFsmEvents, fsm.Callbacks{
 				"enter_state": rs.enterState,
@@ -187,10 +240,12 @@ func (rs *RenterSession) enterState(e *fsm.Event) {
 	case RssErrorStatus:
 		msg = e.Args[0].(error).Error()
 		rs.Cancel()
+		shardUpdates.Remove(fmt.Sprintf(RenterSessionKey, rs.PeerId, rs.SsId))
 	case RssCompleteStatus:
 		rs.Cancel()
+		shardUpdates.Remove(fmt.Sprintf(RenterSessionKey, rs.PeerId, rs.SsId))
 	}
-	fmt.Printf("[%s] session: %s entered state: %s, msg: %s\n", time.Now().Format(time.RFC3339), rs.SsId, e.Dst, msg)
+	log.Debugf("session: %s entered state: %s, msg: %s", rs.SsId, e.Dst, msg)
 	err := Batch(rs.CtxParams.N.Repo.Datastore(),
 		[]string{fmt.Sprintf(RenterSessionStatusKey, rs.PeerId, rs.SsId),
 			fmt.Sprintf(RenterSessionAdditionalInfoKey, rs.PeerId, rs.SsId)},
@@ -205,6 +260,11 @@ func (rs *RenterSession) enterState(e *fsm.Event) {
 				Info:        "",
 				LastUpdated: time.Now(),
 			}})
+	event := &Event{Type: EventStatus, Status: e.Dst, Message: msg}
+	if e.Dst == RssErrorStatus {
+		event.Message, event.Error = "", msg
+	}
+	rs.AddEvent(event)
 	go func() {
 		_ = rs.To(RssErrorStatus, err)
 	}()
@@ -244,7 +304,7 @@ func (rs *RenterSession) GetCompleteShardsNum() (int, int, error) {
 		return 0, 0, err
 	}
 	for i, h := range status.ShardHashes {
-		shard, err := GetRenterShard(rs.CtxParams, rs.SsId, h, i)
+		shard, err := GetRenterShard(rs.CtxParams, rs.SsId, h, rs.ShardIndex(i))
 		if err != nil {
 			log.Errorf("get renter shard error:", err.Error())
 			continue
@@ -253,20 +313,190 @@ func (rs *RenterSession) GetCompleteShardsNum() (int, int, error) {
 		if err != nil {
 			return 0, 0, err
 		}
-		if s.Status == rshContractStatus {
+		if isContractedStatus(s.Status) {
 			completeNum++
-		} else if status.Status == rshErrorStatus {
+		} else if s.Status == rshErrorStatus {
 			errorNum++
-			return completeNum, errorNum, nil
 		}
 	}
 	return completeNum, errorNum, nil
 }
 
+// ShardUpdates returns a channel signalled when shards of the session get contracts.
+// Several updates may be signalled at once.
+func (rs *RenterSession) ShardUpdates() <-chan struct{} {
+	return shardUpdatesChan(rs.PeerId, rs.SsId)
+}
+
 func (rs *RenterSession) To(event string, args ...interface{}) error {
 	return rs.fsm.Event(event, args...)
 }
 
+// Advance fires the event unless the session has already reached the
+// destination of the event, which happens when a resumed session replays a step.
+func (rs *RenterSession) Advance(event string) error {
+	for _, e := range rssFsmEvents {
+		if e.Name == event && rs.Reached(e.Dst) {
+			return nil
+		}
+	}
+	return rs.To(event)
+}
+
+// Reached reports whether the session is at or past the given status.
+func (rs *RenterSession) Reached(status string) bool {
+	if rs.fsm == nil {
+		return true
+	}
+	current, ok := rssStatusOrder[rs.fsm.Current()]
+	if !ok {
+		return false
+	}
+	dst, ok := rssStatusOrder[status]
+	return ok && current >= dst
+}
+
+// SaveUploadParams persists the parameters of the session, and its init status
+// when the session has not moved on yet, so that it can be resumed from the start.
+func (rs *RenterSession) SaveUploadParams(params *UploadParams) error {
+	ds := rs.CtxParams.N.Repo.Datastore()
+	k := fmt.Sprintf(RenterSessionStatusKey, rs.PeerId, rs.SsId)
+	if has, err := ds.Has(context.TODO(), datastore.NewKey(k)); err != nil {
+		return err
+	} else if !has {
+		err := Save(ds, k, &renterpb.RenterSessionStatus{
+			Status:      RssInitStatus,
+			Message:     helperText[RssInitStatus],
+			Hash:        rs.Hash,
+			ShardHashes: rs.ShardHashes,
+			LastUpdated: time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return SaveJSON(ds, fmt.Sprintf(RenterSessionUploadParamsKey, rs.PeerId, rs.SsId), params)
+}
+
+func (rs *RenterSession) UploadParams() (*UploadParams, error) {
+	params := new(UploadParams)
+	err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionUploadParamsKey, rs.PeerId, rs.SsId), params)
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// SkippedHost is a host that was passed over during host selection.
+type SkippedHost struct {
+	HostId   string
+	PriceAsk int64
+	Reason   string
+}
+
+// AddSkippedHost records a host that was passed over, once per host.
+func (rs *RenterSession) AddSkippedHost(host *SkippedHost) error {
+	rs.skippedLock.Lock()
+	defer rs.skippedLock.Unlock()
+	hosts, err := rs.SkippedHosts()
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if h.HostId == host.HostId {
+			return nil
+		}
+	}
+	hosts = append(hosts, host)
+	return SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionSkippedHostsKey, rs.PeerId, rs.SsId), hosts)
+}
+
+// SkippedHosts returns the hosts that were passed over during host selection.
+func (rs *RenterSession) SkippedHosts() ([]*SkippedHost, error) {
+	hosts := make([]*SkippedHost, 0)
+	err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionSkippedHostsKey, rs.PeerId, rs.SsId), &hosts)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// FailedHost is a host that was asked to take a shard and did not.
+type FailedHost struct {
+	HostId string
+	Reason string
+}
+
+// AddFailedHost records a host that failed to take a shard, once per host.
+func (rs *RenterSession) AddFailedHost(host *FailedHost) error {
+	rs.failedLock.Lock()
+	defer rs.failedLock.Unlock()
+	hosts, err := rs.FailedHosts()
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if h.HostId == host.HostId {
+			return nil
+		}
+	}
+	hosts = append(hosts, host)
+	return SaveJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionFailedHostsKey, rs.PeerId, rs.SsId), hosts)
+}
+
+// FailedHosts returns the hosts that failed to take a shard of the session.
+func (rs *RenterSession) FailedHosts() ([]*FailedHost, error) {
+	hosts := make([]*FailedHost, 0)
+	err := GetJSON(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionFailedHostsKey, rs.PeerId, rs.SsId), &hosts)
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// IsResumable reports whether a session in the given status stopped before
+// reaching a final status and can be picked up again.
+func IsResumable(status string) bool {
+	_, ok := rssStatusOrder[status]
+	return ok && status != RssCompleteStatus
+}
+
+// ResumableStatuses lists every status a session can be resumed from.
+func ResumableStatuses() []string {
+	statuses := make([]string, 0, len(rssStatusOrder))
+	for s := range rssStatusOrder {
+		if IsResumable(s) {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses
+}
+
+// GetResumableRenterSession loads a persisted session for resuming. A session that
+// is still running in this daemon can not be resumed, while a cached session whose
+// context is already done is dropped and loaded again from the datastore.
+func GetResumableRenterSession(ctxParams *uh.ContextParams, ssId string) (*RenterSession, error) {
+	k := fmt.Sprintf(RenterSessionInMemKey, ctxParams.N.Identity.Pretty(), ssId)
+	if tmp, ok := renterSessionsInMem.Get(k); ok {
+		if tmp.(*RenterSession).Ctx.Err() == nil {
+			return nil, fmt.Errorf("session %s is still running", ssId)
+		}
+		renterSessionsInMem.Remove(k)
+	}
+	rs, err := GetRenterSession(ctxParams, ssId, "", make([]string, 0))
+	if err != nil {
+		return nil, err
+	}
+	status, err := rs.Status()
+	if err != nil {
+		return nil, err
+	}
+	if !IsResumable(status.Status) || len(rs.ShardHashes) == 0 {
+		return nil, fmt.Errorf("session %s in status %q can not be resumed", ssId, status.Status)
+	}
+	return rs, nil
+}
+
 func (rs *RenterSession) SaveOfflineMeta(meta *renterpb.OfflineMeta) error {
 	return Save(rs.CtxParams.N.Repo.Datastore(), fmt.Sprintf(RenterSessionOfflineMetaKey, rs.PeerId, rs.SsId), meta)
 }
@@ -320,13 +550,15 @@ func (r *RenterSessionsCursor) nextKey() string {
 	return result
 }
 
-func (r *RenterSessionsCursor) NextSession(status string) (*RenterSession, error) {
+func (r *RenterSessionsCursor) NextSession(statuses ...string) (*RenterSession, error) {
 	key := r.nextKey()
 	for ; key != ""; key = r.nextKey() {
 		s := &sessionpb.Status{}
 		if err := Get(r.ctxParam.N.Repo.Datastore(), key, s); err == nil {
-			if s.Status == status {
-				return GetRenterSession(r.ctxParam, getSessionId(key), "", make([]string, 0))
+			for _, status := range statuses {
+				if s.Status == status {
+					return GetRenterSession(r.ctxParam, getSessionId(key), "", make([]string, 0))
+				}
 			}
 		}
 	}
@@ -1,8 +1,17 @@
 package sessions
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +20,379 @@ func TestGetractSessionId(t *testing.T) {
 	id := getSessionId(key)
 	assert.Equal(t, "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a", id)
 }
+
+func TestIsResumable(t *testing.T) {
+	assert.True(t, IsResumable(RssInitStatus))
+	assert.True(t, IsResumable(RssGuardFileMetaSignedStatus))
+	assert.True(t, IsResumable(RssPayStatus))
+	assert.False(t, IsResumable(RssCompleteStatus))
+	assert.False(t, IsResumable(RssErrorStatus))
+	assert.Equal(t, 8, len(ResumableStatuses()))
+}
+
+func TestShardUpdates(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "0fb2f98b-3ff2-42ca-b297-7e5e13d0fe5a", "Qm123", []string{"Qm1", "Qm2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := rss.ShardUpdates()
+	select {
+	case <-updates:
+		t.Fatal("update before any shard got a contract")
+	default:
+	}
+
+	for i, h := range rss.ShardHashes {
+		shard, err := GetRenterShard(ctxParams, rss.SsId, h, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := shard.Contract(nil, &guardpb.Contract{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// both updates are merged into one
+	<-updates
+	select {
+	case <-updates:
+		t.Fatal("updates not merged")
+	default:
+	}
+	complete, _, err := rss.GetCompleteShardsNum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, complete)
+}
+
+func TestEvents(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	ssId := "4f1c2b7e-9a3d-4e21-8b6f-2d5e7a9c0b13"
+	rss, err := GetRenterSession(ctxParams, ssId, "Qm123", []string{"Qm1", "Qm2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, changed, err := rss.EventsSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, events)
+
+	if err := rss.To(RssToSubmitEvent); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("waiters not woken up by a new event")
+	}
+	rss.AddEvent(ShardEvent(EventShardAttempt, 1, "Qm2", "host1", nil))
+	rss.AddEvent(ShardEvent(EventShardFailed, 1, "Qm2", "host1", errors.New("host timeout")))
+	rss.AddEvent(ShardEvent(EventShardFailed, 0, "Qm1", "host2", errors.New("host timeout")))
+	rss.AddEvent(ShardEvent(EventShardFailed, 0, "Qm1", "host3", errors.New("token not supported by host")))
+
+	events, err = rss.Events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 5, len(events))
+	assert.Equal(t, EventStatus, events[0].Type)
+	assert.Equal(t, RssSubmitStatus, events[0].Status)
+	assert.Equal(t, 1, *events[1].ShardIndex)
+	assert.Equal(t, "host1", events[1].Host)
+	assert.Equal(t, "host timeout (x2); token not supported by host (x1)", FailureReasons(events))
+
+	// the history survives the session being dropped from memory
+	renterSessionsInMem.Remove(fmt.Sprintf(RenterSessionInMemKey, rss.PeerId, ssId))
+	rss, err = GetRenterSession(ctxParams, ssId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rss.AddEvent(&Event{Type: EventGuard, Message: "file meta submitted"})
+	events, _, err = rss.EventsSince(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint64(6), events[0].Seq)
+}
+
+func TestShardFailAndReset(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "9d0e6c1a-27b4-4f3e-a5c8-61f0b2d4e7a9", "Qm123", []string{"Qm1", "Qm2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := rss.ShardUpdates()
+	shards := make([]*RenterShard, len(rss.ShardHashes))
+	for i, h := range rss.ShardHashes {
+		if shards[i], err = GetRenterShard(ctxParams, rss.SsId, h, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := shards[0].Contract(nil, &guardpb.Contract{}); err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+	// a contracted shard is kept
+	assert.NoError(t, shards[0].Fail(errors.New("host timeout")))
+	failed, err := shards[0].IsFailed()
+	assert.NoError(t, err)
+	assert.False(t, failed)
+
+	assert.NoError(t, shards[1].Fail(errors.New("host timeout")))
+	<-updates
+	complete, errorNum, err := rss.GetCompleteShardsNum()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, complete)
+	assert.Equal(t, 1, errorNum)
+	st, err := shards[1].Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "host timeout", st.Message)
+
+	// the reset shard can get a contract with another host
+	assert.NoError(t, shards[1].Reset())
+	if err := shards[1].Contract(nil, &guardpb.Contract{}); err != nil {
+		t.Fatal(err)
+	}
+	complete, errorNum, err = rss.GetCompleteShardsNum()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, complete)
+	assert.Equal(t, 0, errorNum)
+}
+
+func TestFailedHosts(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "c27a9e40-5b1d-4f86-93e2-7d0a4b6f18c5", "Qm123", []string{"Qm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, rss.AddFailedHost(&FailedHost{HostId: "host1", Reason: "host timeout"}))
+	// a host is recorded once
+	assert.NoError(t, rss.AddFailedHost(&FailedHost{HostId: "host1", Reason: "token not supported by host"}))
+	hosts, err := rss.FailedHosts()
+	assert.NoError(t, err)
+	assert.Equal(t, []*FailedHost{{HostId: "host1", Reason: "host timeout"}}, hosts)
+	// failed hosts are not mixed with the skipped ones
+	skipped, err := rss.SkippedHosts()
+	assert.NoError(t, err)
+	assert.Empty(t, skipped)
+}
+
+func TestRenewals(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "8d2e4f6a-1b3c-4d5e-9f70-a1b2c3d4e5f6", "Qm123", []string{"Qm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewals, err := rss.Renewals()
+	assert.NoError(t, err)
+	assert.Empty(t, renewals)
+
+	first := &Renewal{Id: "r1", SessionId: rss.SsId, Days: 30, Status: RenewCompleteStatus, Created: time.Now().Add(-time.Hour)}
+	second := &Renewal{Id: "r0", SessionId: rss.SsId, Days: 10, Status: RenewPendingStatus, Created: time.Now()}
+	assert.NoError(t, rss.SaveRenewal(second))
+	assert.NoError(t, rss.SaveRenewal(first))
+	renewals, err = rss.Renewals()
+	assert.NoError(t, err)
+	if assert.Len(t, renewals, 2) {
+		assert.Equal(t, "r1", renewals[0].Id)
+		assert.Equal(t, "r0", renewals[1].Id)
+	}
+
+	// the renewed contract replaces the previous one of the shard
+	shard, err := GetRenterShard(ctxParams, rss.SsId, "Qm1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Contract(nil, &guardpb.Contract{ContractMeta: guardpb.ContractMeta{ContractId: "c1"}}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, shard.Renewed(&guardpb.Contract{ContractMeta: guardpb.ContractMeta{ContractId: "c2"}}))
+	contracts, err := shard.Contracts()
+	assert.NoError(t, err)
+	assert.Equal(t, "c2", contracts.SignedGuardContract.ContractId)
+
+	ds, peerId := node.Repo.Datastore(), node.Identity.String()
+	spent, err := AutoRenewSpent(ds, peerId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), spent)
+	assert.NoError(t, AddAutoRenewSpent(ds, peerId, 100))
+	assert.NoError(t, AddAutoRenewSpent(ds, peerId, 50))
+	spent, err = AutoRenewSpent(ds, peerId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), spent)
+}
+
+func TestShardCancel(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "4b7f2c9e-8d13-4a60-b5e2-0c9a1f6d3e58", "Qm123", []string{"Qm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard, err := GetRenterShard(ctxParams, rss.SsId, rss.ShardHashes[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Contract(nil, &guardpb.Contract{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, shard.Paid())
+	assert.NoError(t, shard.Cancel())
+	canceled, err := shard.IsCanceled()
+	assert.NoError(t, err)
+	assert.True(t, canceled)
+	paid, err := shard.IsPaid()
+	assert.NoError(t, err)
+	assert.False(t, paid)
+	// a canceled shard is not placed again
+	assert.NoError(t, shard.Fail(errors.New("host timeout")))
+	contracted, err := shard.IsContracted()
+	assert.NoError(t, err)
+	assert.True(t, contracted)
+
+	hostCanceled, err := IsHostShardCanceled(ctxParams, "contract-1")
+	assert.NoError(t, err)
+	assert.False(t, hostCanceled)
+	assert.NoError(t, CancelHostShard(ctxParams, "contract-1"))
+	hostCanceled, err = IsHostShardCanceled(ctxParams, "contract-1")
+	assert.NoError(t, err)
+	assert.True(t, hostCanceled)
+
+	// the shard of a canceled contract is kept while another running contract stores it
+	end := time.Now().Add(time.Hour)
+	gcs := []*guardpb.Contract{
+		{ContractMeta: guardpb.ContractMeta{ContractId: "contract-1", ShardHash: "Qm1", RentEnd: end}},
+		{ContractMeta: guardpb.ContractMeta{ContractId: "contract-2", ShardHash: "Qm1", RentEnd: end}},
+	}
+	_, _, err = SaveShardsContracts(node.Repo.Datastore(), nil, gcs, node.Identity.String(), nodepb.ContractStat_HOST.String())
+	assert.NoError(t, err)
+	kept, err := HostShardKept(ctxParams, gcs[0])
+	assert.NoError(t, err)
+	assert.True(t, kept)
+	assert.NoError(t, CancelHostShard(ctxParams, "contract-2"))
+	kept, err = HostShardKept(ctxParams, gcs[0])
+	assert.NoError(t, err)
+	assert.False(t, kept)
+}
+
+func TestShardPaying(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node}
+	rss, err := GetRenterSession(ctxParams, "9c0e3b51-27d4-4f8a-a6b9-5e1d7c2f4a03", "Qm123", []string{"Qm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard, err := GetRenterShard(ctxParams, rss.SsId, rss.ShardHashes[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Contract(nil, &guardpb.Contract{}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, shard.Paying())
+	since, err := shard.PayingSince()
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), since, time.Minute)
+	// a shard being paid keeps its contract
+	assert.NoError(t, shard.Fail(errors.New("host timeout")))
+	contracted, err := shard.IsContracted()
+	assert.NoError(t, err)
+	assert.True(t, contracted)
+	complete, failed, err := rss.GetCompleteShardsNum()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, complete)
+	assert.Equal(t, 0, failed)
+
+	assert.NoError(t, shard.Paid())
+	since, err = shard.PayingSince()
+	assert.NoError(t, err)
+	assert.True(t, since.IsZero())
+}
+
+func TestAudits(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	ds, peerId := node.Repo.Datastore(), node.Identity.String()
+
+	now := time.Now()
+	for i := 0; i < auditHostHistoryLimit+2; i++ {
+		h, err := AddAuditResult(ds, peerId, &AuditResult{Time: now, Host: "h1", ShardIndex: i, Passed: i%2 == 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, i%2, h.ConsecutiveFailures)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := AddAuditResult(ds, peerId, &AuditResult{Time: now, Host: "h2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hosts, err := ListHostAudits(ds, peerId)
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 2)
+	h, err := GetHostAudit(ds, peerId, "h1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(26), h.Passed)
+	assert.Equal(t, int64(26), h.Failed)
+	// the oldest results are dropped
+	if assert.Len(t, h.History, auditHostHistoryLimit) {
+		assert.Equal(t, 2, h.History[0].ShardIndex)
+	}
+	h, err = GetHostAudit(ds, peerId, "h2")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, h.ConsecutiveFailures)
+
+	assert.NoError(t, AddAuditAlert(ds, peerId, &AuditAlert{Time: now.Add(-48 * time.Hour), Type: AlertHostFailing}))
+	assert.NoError(t, AddAuditAlert(ds, peerId, &AuditAlert{Time: now, Type: AlertFileAtRisk}))
+	alerts, err := ListAuditAlerts(ds, peerId, now.Add(-24*time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, alerts, 1) {
+		assert.Equal(t, AlertFileAtRisk, alerts[0].Type)
+	}
+
+	a := &FileAudit{SessionId: "s1", FileHash: "Qm123", Time: now, Passed: 1, Failed: 2, NumData: 2, AtRisk: true}
+	assert.NoError(t, SaveFileAudit(ds, peerId, a))
+	got, err := GetFileAudit(ds, peerId, "s1")
+	assert.NoError(t, err)
+	assert.True(t, got.AtRisk)
+	assert.Equal(t, 2, got.Failed)
+}
@@ -3,6 +3,7 @@ package sessions
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,6 +33,9 @@ const (
 
 	rshInitStatus      = "init"
 	rshContractStatus  = "contract"
+	rshPayingStatus    = "paying"
+	rshPaidStatus      = "paid"
+	rshCanceledStatus  = "canceled"
 	rshErrorStatus     = "error"
 	rshToContractEvent = "to-contract"
 )
@@ -43,6 +47,9 @@ var (
 		{Name: rshToContractEvent, Src: []string{rshInitStatus}, Dst: rshContractStatus},
 	}
 	renterShardsInMem = cmap.New()
+	// shardUpdates holds per session a channel signalled when one of its shards
+	// gets a contract.
+	shardUpdates = cmap.New()
 )
 
 type RenterShard struct {
@@ -137,18 +144,155 @@ func (rs *RenterShard) doContract(signedEscrowContract []byte, signedGuardContra
 		SignedGuardContract:  signedGuardContract,
 	}
 	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
-	return Batch(rs.ds, []string{
+	err := Batch(rs.ds, []string{
 		fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId),
 		fmt.Sprintf(renterShardContractsKey, rs.peerId, shardId),
 	}, []proto.Message{
 		status, signedContracts,
 	})
+	if err == nil {
+		notifyShardUpdate(rs.peerId, rs.ssId)
+	}
+	return err
+}
+
+func shardUpdatesChan(peerId, ssId string) chan struct{} {
+	k := fmt.Sprintf(RenterSessionKey, peerId, ssId)
+	shardUpdates.SetIfAbsent(k, make(chan struct{}, 1))
+	ch, _ := shardUpdates.Get(k)
+	return ch.(chan struct{})
+}
+
+// notifyShardUpdate signals the shard updates of a session without blocking, updates
+// not yet received are merged into one.
+func notifyShardUpdate(peerId, ssId string) {
+	select {
+	case shardUpdatesChan(peerId, ssId) <- struct{}{}:
+	default:
+	}
 }
 
 func (rs *RenterShard) Contract(signedEscrowContract []byte, signedGuardContract *guardpb.Contract) error {
 	return rs.fsm.Event(rshToContractEvent, signedEscrowContract, signedGuardContract)
 }
 
+// IsContracted reports whether the shard already has a signed contract, paid,
+// not paid or canceled.
+func (rs *RenterShard) IsContracted() (bool, error) {
+	status, err := rs.Status()
+	if err != nil {
+		return false, err
+	}
+	return isContractedStatus(status.Status), nil
+}
+
+func isContractedStatus(status string) bool {
+	return status == rshContractStatus || status == rshPayingStatus || status == rshPaidStatus ||
+		status == rshCanceledStatus
+}
+
+// Fail records that the shard could not be placed with a host, unless it got a
+// contract meanwhile. The session watches failed shards to place them again.
+func (rs *RenterShard) Fail(reason error) error {
+	if contracted, err := rs.IsContracted(); err != nil || contracted {
+		return err
+	}
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	err := Save(rs.ds, fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId), &shardpb.Status{
+		Status:  rshErrorStatus,
+		Message: reason.Error(),
+	})
+	if err == nil {
+		notifyShardUpdate(rs.peerId, rs.ssId)
+	}
+	return err
+}
+
+func (rs *RenterShard) IsFailed() (bool, error) {
+	status, err := rs.Status()
+	if err != nil {
+		return false, err
+	}
+	return status.Status == rshErrorStatus, nil
+}
+
+// Reset puts a failed shard back in init, so that it can get a contract with
+// another host.
+func (rs *RenterShard) Reset() error {
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	err := Save(rs.ds, fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId), &shardpb.Status{
+		Status: rshInitStatus,
+	})
+	if err != nil {
+		return err
+	}
+	if rs.fsm == nil {
+		rs.fsm = fsm.NewFSM(rshInitStatus, renterShardFsmEvents, fsm.Callbacks{
+			"enter_state": rs.enterState,
+		})
+	}
+	return nil
+}
+
+// Paying records that the payment of the contract of the shard is about to be
+// sent, and when. It is saved before the payment, so that a session resumed
+// after a crash knows the payment may have been sent already.
+func (rs *RenterShard) Paying() error {
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	return Save(rs.ds, fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId), &shardpb.Status{
+		Status:  rshPayingStatus,
+		Message: strconv.FormatInt(time.Now().Unix(), 10),
+	})
+}
+
+// PayingSince returns when the payment of the shard was started, or the zero
+// time if the shard is not being paid.
+func (rs *RenterShard) PayingSince() (time.Time, error) {
+	status, err := rs.Status()
+	if err != nil || status.Status != rshPayingStatus {
+		return time.Time{}, err
+	}
+	since, err := strconv.ParseInt(status.Message, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed paying status %q: %v", status.Message, err)
+	}
+	return time.Unix(since, 0), nil
+}
+
+// Paid records that the contract of the shard has been paid for, so that a
+// resumed session does not pay it twice.
+func (rs *RenterShard) Paid() error {
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	return Save(rs.ds, fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId), &shardpb.Status{
+		Status: rshPaidStatus,
+	})
+}
+
+func (rs *RenterShard) IsPaid() (bool, error) {
+	status, err := rs.Status()
+	if err != nil {
+		return false, err
+	}
+	return status.Status == rshPaidStatus, nil
+}
+
+// Cancel records that the renter canceled the contract of the shard, so that it
+// is neither paid nor renewed anymore.
+func (rs *RenterShard) Cancel() error {
+	shardId := GetShardId(rs.ssId, rs.hash, rs.index)
+	return Save(rs.ds, fmt.Sprintf(renterShardStatusKey, rs.peerId, shardId), &shardpb.Status{
+		Status: rshCanceledStatus,
+	})
+}
+
+func (rs *RenterShard) IsCanceled() (bool, error) {
+	status, err := rs.Status()
+	if err != nil {
+		return false, err
+	}
+	return status.Status == rshCanceledStatus, nil
+}
+
 func (rs *RenterShard) Contracts() (*shardpb.SignedContracts, error) {
 	contracts := &shardpb.SignedContracts{}
 	err := Get(rs.ds, fmt.Sprintf(renterShardContractsKey, rs.peerId, GetShardId(rs.ssId, rs.hash, rs.index)), contracts)
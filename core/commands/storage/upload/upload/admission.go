@@ -0,0 +1,307 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/accounting"
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/core/commands/cmdenv"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/repo/pool"
+
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
+
+	"github.com/dustin/go-humanize"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// IHostStats is what the admission policy checks offered contracts against.
+type IHostStats interface {
+	// StorageUsed is how many bytes the repo stores.
+	StorageUsed() (uint64, error)
+	// DiskFree is how many more bytes the disks of the repo can take.
+	DiskFree(ctx context.Context) (uint64, error)
+	// IngressRate is the current inbound bandwidth in bytes per second.
+	IngressRate() float64
+	// RenterContracts returns the shard size of the running contracts of a renter,
+	// by contract ID.
+	RenterContracts(renter string) (map[string]int64, error)
+	// CheckCredit checks a peer may owe amount more in the token under the
+	// credit policy.
+	CheckCredit(peer string, amount *big.Int, token common.Address, p *accounting.CreditPolicy) error
+}
+
+type HostStats struct {
+	ctxParams *uh.ContextParams
+	cfgRoot   string
+}
+
+// AdmissionPolicy decides whether the host accepts an offered contract, from the
+// HostAdmission config and the state of the host.
+type AdmissionPolicy struct {
+	cfg        *uh.AdmissionConfig
+	storageMax uint64
+	stats      IHostStats
+}
+
+// contractOffer is a contract a renter offers to the host.
+type contractOffer struct {
+	contractId string
+	renter     string
+	shardSize  int64
+	price      int64
+	token      common.Address
+	// payer is the peer paying for the contract, and amount what it pays.
+	payer  string
+	amount *big.Int
+}
+
+// reservation is the space held for the shard of an admitted contract until it
+// is downloaded.
+type reservation struct {
+	contractId string
+	renter     string
+	size       int64
+}
+
+var (
+	// reservations are the shards being downloaded, by contract ID
+	reservations     = make(map[string]*reservation)
+	reservationsLock sync.Mutex
+)
+
+func NewAdmissionPolicy(ctxParams *uh.ContextParams) (*AdmissionPolicy, error) {
+	cfgRoot, err := cmdenv.GetConfigRoot(ctxParams.Env)
+	if err != nil {
+		return nil, err
+	}
+	var storageMax uint64
+	if ctxParams.Cfg.Datastore.StorageMax != "" {
+		storageMax, err = humanize.ParseBytes(ctxParams.Cfg.Datastore.StorageMax)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &AdmissionPolicy{
+		cfg:        uh.GetAdmissionConfig(ctxParams),
+		storageMax: storageMax,
+		stats:      &HostStats{ctxParams: ctxParams, cfgRoot: cfgRoot},
+	}, nil
+}
+
+// Admit checks an offered contract against the policy. The size of an admitted
+// shard is reserved until the returned reservation is released, so that shards
+// being downloaded are not promised the same space.
+func (p *AdmissionPolicy) Admit(ctx context.Context, o *contractOffer) (*reservation, error) {
+	if err := p.checkRenter(o); err != nil {
+		return nil, err
+	}
+	if err := p.checkCredit(o); err != nil {
+		return nil, err
+	}
+	if rate, max := p.stats.IngressRate(), p.cfg.MaxIngressRate; max > 0 && rate > float64(max) {
+		return nil, rejectf("host is busy receiving %s/s, above its limit of %s/s, try again later",
+			humanize.Bytes(uint64(rate)), humanize.Bytes(uint64(max)))
+	}
+
+	reservationsLock.Lock()
+	defer reservationsLock.Unlock()
+	var reserved int64
+	renterContracts, err := p.stats.RenterContracts(o.renter)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reservations {
+		reserved += r.size
+		// a shard being downloaded may already have its contract saved
+		if _, ok := renterContracts[r.contractId]; r.renter == o.renter && !ok {
+			renterContracts[r.contractId] = r.size
+		}
+	}
+	if err := p.checkSpace(ctx, o.shardSize, reserved); err != nil {
+		return nil, err
+	}
+	if err := p.checkQuotas(o.shardSize, renterContracts); err != nil {
+		return nil, err
+	}
+	r := &reservation{contractId: o.contractId, renter: o.renter, size: o.shardSize}
+	reservations[o.contractId] = r
+	return r, nil
+}
+
+// Release gives back the space reserved for the shard, it can be called more
+// than once.
+func (r *reservation) Release() {
+	reservationsLock.Lock()
+	defer reservationsLock.Unlock()
+	if reservations[r.contractId] == r {
+		delete(reservations, r.contractId)
+	}
+}
+
+// checkRenter checks the allow and deny lists, and the minimum price of the token.
+func (p *AdmissionPolicy) checkRenter(o *contractOffer) error {
+	for _, r := range p.cfg.DenyRenters {
+		if r == o.renter {
+			return rejectf("renter %s is denied by the host", o.renter)
+		}
+	}
+	if len(p.cfg.AllowRenters) > 0 {
+		allowed := false
+		for _, r := range p.cfg.AllowRenters {
+			if r == o.renter {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return rejectf("renter %s is not in the allow list of the host", o.renter)
+		}
+	}
+	symbol := tokencfg.MpTokenStr[o.token]
+	for t, min := range p.cfg.MinPrice {
+		if strings.EqualFold(t, symbol) && o.price < min {
+			return rejectf("price %d is below the minimum price %d of the host for %s", o.price, min, symbol)
+		}
+	}
+	return nil
+}
+
+// checkCredit checks the payer of the contract is not in arrears, and stays
+// within its credit limit with the contract.
+func (p *AdmissionPolicy) checkCredit(o *contractOffer) error {
+	if o.amount == nil {
+		return nil
+	}
+	policy, err := p.cfg.CreditPolicy()
+	if err != nil {
+		return err
+	}
+	err = p.stats.CheckCredit(o.payer, o.amount, o.token, policy)
+	if errors.Is(err, accounting.ErrInArrears) || errors.Is(err, accounting.ErrCreditLimit) {
+		return rejectf("renter %s: %v", o.payer, err)
+	}
+	return err
+}
+
+// checkSpace checks the shard fits in the storage max of the host and on its
+// disk, besides the shards being downloaded.
+func (p *AdmissionPolicy) checkSpace(ctx context.Context, size int64, reserved int64) error {
+	used, err := p.stats.StorageUsed()
+	if err != nil {
+		return err
+	}
+	if p.storageMax > 0 {
+		var left uint64
+		if taken := used + uint64(reserved); taken < p.storageMax {
+			left = p.storageMax - taken
+		}
+		if uint64(size) > left {
+			return rejectf("host has %s left of its %s storage max, with %s reserved for shards being downloaded, "+
+				"the shard needs %s", humanize.Bytes(left), humanize.Bytes(p.storageMax),
+				humanize.Bytes(uint64(reserved)), humanize.Bytes(uint64(size)))
+		}
+	}
+	free, err := p.stats.DiskFree(ctx)
+	if err != nil {
+		return err
+	}
+	var left uint64
+	if kept := uint64(p.cfg.ReservedSpace + reserved); kept < free {
+		left = free - kept
+	}
+	if uint64(size) > left {
+		return rejectf("host has %s of disk space left, the shard needs %s",
+			humanize.Bytes(left), humanize.Bytes(uint64(size)))
+	}
+	return nil
+}
+
+// checkQuotas checks the renter stays within its quotas with the shard.
+func (p *AdmissionPolicy) checkQuotas(size int64, renterContracts map[string]int64) error {
+	if max := p.cfg.RenterMaxContracts; max > 0 && len(renterContracts) >= max {
+		return rejectf("renter already holds %d contracts on the host, the limit is %d",
+			len(renterContracts), max)
+	}
+	held := size
+	for _, s := range renterContracts {
+		held += s
+	}
+	if max := p.cfg.RenterMaxBytes; max > 0 && held > max {
+		return rejectf("renter would hold %s on the host, the limit is %s",
+			humanize.Bytes(uint64(held)), humanize.Bytes(uint64(max)))
+	}
+	if share := p.cfg.RenterMaxShare; share > 0 && p.storageMax > 0 &&
+		uint64(held)*100 > p.storageMax*uint64(share) {
+		return rejectf("renter would hold %d%% of the storage max of the host, the limit is %d%%",
+			uint64(held)*100/p.storageMax, share)
+	}
+	return nil
+}
+
+func rejectf(format string, a ...interface{}) error {
+	return fmt.Errorf("contract rejected: "+format, a...)
+}
+
+func (h *HostStats) StorageUsed() (uint64, error) {
+	return h.ctxParams.N.Repo.GetStorageUsage()
+}
+
+// DiskFree is the room left in the storage pool of the repo, if it has one, or
+// else the free space of the disk of the repo.
+func (h *HostStats) DiskFree(ctx context.Context) (uint64, error) {
+	if ps := pool.Pools(); len(ps) == 1 {
+		return ps[0].Free(ctx)
+	}
+	du, err := disk.UsageWithContext(ctx, h.cfgRoot)
+	if err != nil {
+		return 0, err
+	}
+	return du.Free, nil
+}
+
+func (h *HostStats) IngressRate() float64 {
+	if h.ctxParams.N.Reporter == nil {
+		return 0
+	}
+	return h.ctxParams.N.Reporter.GetBandwidthTotals().RateIn
+}
+
+func (h *HostStats) RenterContracts(renter string) (map[string]int64, error) {
+	cs, err := sessions.ListShardsContracts(h.ctxParams.N.Repo.Datastore(), h.ctxParams.N.Identity.String(),
+		nodepb.ContractStat_HOST.String())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	contracts := make(map[string]int64)
+	for _, sc := range cs {
+		c := sc.SignedGuardContract
+		if c == nil || c.RenterPid != renter || c.RentEnd.Before(now) {
+			continue
+		}
+		if canceled, err := sessions.IsHostShardCanceled(h.ctxParams, c.ContractId); err != nil {
+			return nil, err
+		} else if canceled {
+			continue
+		}
+		contracts[c.ContractId] = c.ShardFileSize
+	}
+	return contracts, nil
+}
+
+func (h *HostStats) CheckCredit(peer string, amount *big.Int, token common.Address, p *accounting.CreditPolicy) error {
+	if chain.SettleObject.Accounting == nil {
+		return nil
+	}
+	return chain.SettleObject.Accounting.CheckCredit(peer, amount, token, p)
+}
@@ -0,0 +1,129 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/accounting"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockHostStats struct {
+	used      uint64
+	free      uint64
+	rate      float64
+	contracts map[string]map[string]int64
+	credit    map[string]error
+}
+
+func (m *MockHostStats) StorageUsed() (uint64, error) {
+	return m.used, nil
+}
+
+func (m *MockHostStats) DiskFree(ctx context.Context) (uint64, error) {
+	return m.free, nil
+}
+
+func (m *MockHostStats) IngressRate() float64 {
+	return m.rate
+}
+
+func (m *MockHostStats) RenterContracts(renter string) (map[string]int64, error) {
+	contracts := make(map[string]int64)
+	for id, size := range m.contracts[renter] {
+		contracts[id] = size
+	}
+	return contracts, nil
+}
+
+func (m *MockHostStats) CheckCredit(peer string, amount *big.Int, token common.Address, p *accounting.CreditPolicy) error {
+	return m.credit[peer]
+}
+
+func TestAdmit(t *testing.T) {
+	stats := &MockHostStats{
+		used: 6000,
+		free: 100000,
+		contracts: map[string]map[string]int64{
+			"r1": {"c1": 1000, "c2": 1000},
+		},
+	}
+	p := &AdmissionPolicy{
+		cfg: &uh.AdmissionConfig{
+			ReservedSpace:      1000,
+			MaxIngressRate:     5000,
+			RenterMaxContracts: 3,
+			RenterMaxBytes:     3500,
+			RenterMaxShare:     30,
+			DenyRenters:        []string{"r3"},
+		},
+		storageMax: 10000,
+		stats:      stats,
+	}
+	offer := func(id, renter string, size int64) *contractOffer {
+		return &contractOffer{contractId: id, renter: renter, shardSize: size}
+	}
+	tests := []struct {
+		offer  *contractOffer
+		reject string
+	}{
+		{offer: offer("c3", "r3", 100), reject: "denied"},
+		{offer: offer("c3", "r1", 2000), reject: "would hold 4.0 kB"},
+		{offer: offer("c3", "r2", 4500), reject: "storage max"},
+		{offer: offer("c3", "r2", 3200), reject: "32% of the storage max"},
+	}
+	for _, tc := range tests {
+		_, err := p.Admit(context.Background(), tc.offer)
+		if assert.Error(t, err) {
+			assert.True(t, strings.Contains(err.Error(), tc.reject), err.Error())
+		}
+	}
+
+	// the shard being downloaded is held until it is released
+	r, err := p.Admit(context.Background(), offer("c3", "r1", 1000))
+	assert.NoError(t, err)
+	_, err = p.Admit(context.Background(), offer("c4", "r1", 100))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "3 contracts")
+	}
+	_, err = p.Admit(context.Background(), offer("c4", "r2", 3500))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "reserved for shards being downloaded")
+	}
+	r.Release()
+	r.Release()
+	r, err = p.Admit(context.Background(), offer("c4", "r2", 2900))
+	assert.NoError(t, err)
+	r.Release()
+
+	stats.free = 2000
+	_, err = p.Admit(context.Background(), offer("c4", "r2", 1500))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "disk space")
+	}
+	stats.rate = 6000
+	_, err = p.Admit(context.Background(), offer("c4", "r2", 100))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "busy")
+	}
+
+	stats.rate = 0
+	stats.free = 100000
+	stats.credit = map[string]error{"p1": fmt.Errorf("%w: 100 overdue", accounting.ErrInArrears)}
+	o := offer("c4", "r2", 100)
+	o.payer, o.amount = "p1", big.NewInt(10)
+	_, err = p.Admit(context.Background(), o)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "renter p1: peer is in arrears")
+	}
+
+	p.cfg.AllowRenters = []string{"r1"}
+	assert.Error(t, p.checkRenter(offer("c4", "r2", 100)))
+	assert.NoError(t, p.checkRenter(offer("c4", "r1", 100)))
+}
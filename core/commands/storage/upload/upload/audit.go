@@ -0,0 +1,460 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/challenge"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	chunker "github.com/bittorrent/go-btfs-chunker"
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	auditTimeout = time.Minute
+	// auditFailingHost is how many audits in a row a host fails before it is
+	// alerted on.
+	auditFailingHost = 3
+
+	auditSinceOptionName = "since"
+)
+
+var StorageUploadAuditCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Audit the hosts of uploaded files.",
+		ShortDescription: `
+The renter challenges the hosts of its uploaded files periodically, and keeps
+the pass/fail history of each host. Shards with a segment commitment are
+challenged with segment proofs, the others with a chunk of the shard. When less
+shards pass than are needed to reconstruct a file, an alert is raised and the
+failed shards are repaired with other hosts.
+
+Audits are turned on with:
+    $ btfs config --json Upload.Audit '{"Enabled": true, "Interval": 24, "Repair": true}'`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"run":    storageUploadAuditRunCmd,
+		"hosts":  storageUploadAuditHostsCmd,
+		"alerts": storageUploadAuditAlertsCmd,
+	},
+}
+
+var storageUploadAuditRunCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Audit uploaded files now.",
+		ShortDescription: `
+This command audits the file of the given session, or all the files that are
+stored when no session is given, whether they are due or not.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", false, false, "ID of the completed upload session to audit."),
+	},
+	RunTimeout: 30 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		if len(req.Arguments) == 0 {
+			audits, err := AuditFiles(req.Context, ctxParams, true)
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(res, &AuditsRes{Files: audits})
+		}
+		rss, err := sessions.GetRenterSession(ctxParams, req.Arguments[0], "", nil)
+		if err != nil {
+			return err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return err
+		}
+		if status.Status != sessions.RssCompleteStatus {
+			return fmt.Errorf("session %s is %s, only completed sessions can be audited", rss.SsId, status.Status)
+		}
+		if r, err := rss.Repair(); err != nil {
+			return err
+		} else if r != nil {
+			return fmt.Errorf("session %s repairs shards of file %s, audit the session of the file instead",
+				rss.SsId, rss.Hash)
+		}
+		a, err := AuditSession(req.Context, rss)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &AuditsRes{Files: []*sessions.FileAudit{a}})
+	},
+	Type: AuditsRes{},
+}
+
+type AuditsRes struct {
+	Files []*sessions.FileAudit
+}
+
+var storageUploadAuditHostsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the audit history of the hosts.",
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		hosts, err := sessions.ListHostAudits(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String())
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &HostAuditsRes{Hosts: hosts})
+	},
+	Type: HostAuditsRes{},
+}
+
+type HostAuditsRes struct {
+	Hosts []*sessions.HostAudit
+}
+
+var storageUploadAuditAlertsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the alerts raised by audits.",
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(auditSinceOptionName, "List the alerts of this duration only, e.g. 24h.").WithDefault("168h"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		since, err := time.ParseDuration(req.Options[auditSinceOptionName].(string))
+		if err != nil {
+			return err
+		}
+		alerts, err := sessions.ListAuditAlerts(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(),
+			time.Now().Add(-since))
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &AuditAlertsRes{Alerts: alerts})
+	},
+	Type: AuditAlertsRes{},
+}
+
+type AuditAlertsRes struct {
+	Alerts []*sessions.AuditAlert
+}
+
+// AuditFiles audits the files whose contracts have not ended. Files audited
+// less than Upload.Audit.Interval ago are skipped unless force is set.
+func AuditFiles(ctx context.Context, ctxParams *helper.ContextParams, force bool) ([]*sessions.FileAudit, error) {
+	cfg := helper.GetUploadConfig(ctxParams).Audit
+	d := ctxParams.N.Repo.Datastore()
+	peerId := ctxParams.N.Identity.String()
+	cursor, err := sessions.GetRenterSessionsCursor(ctxParams)
+	if err != nil {
+		return nil, err
+	}
+	var audits []*sessions.FileAudit
+	now := time.Now()
+	for {
+		rss, err := cursor.NextSession(sessions.RssCompleteStatus)
+		if err != nil {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return audits, err
+		}
+		// repair sessions are audited with the session they repair, once done
+		if r, err := rss.Repair(); err != nil || r != nil {
+			continue
+		}
+		if repairing, err := repairInProgress(rss); err != nil || repairing {
+			continue
+		}
+		end, err := contractsEnd(rss)
+		if err != nil {
+			log.Debugf("audit: session %s: %v", rss.SsId, err)
+			continue
+		}
+		// the hosts do not have to store the file anymore
+		if end.Before(now) {
+			continue
+		}
+		if !force {
+			last, err := sessions.GetFileAudit(d, peerId, rss.SsId)
+			if err == nil && now.Sub(last.Time) < time.Duration(cfg.Interval)*time.Hour {
+				continue
+			}
+		}
+		a, err := AuditSession(ctx, rss)
+		if err != nil {
+			log.Errorf("audit: session %s: %v", rss.SsId, err)
+			continue
+		}
+		audits = append(audits, a)
+	}
+	return audits, nil
+}
+
+// AuditSession challenges the host of each shard of a completed session, and
+// records the results. A shard that a completed repair placed again is audited
+// with its new host. When less shards pass than needed to reconstruct the
+// file plus Upload.Audit.RepairMargin, the file is alerted on and, with
+// Upload.Audit.Repair, the failed shards are repaired.
+func AuditSession(ctx context.Context, rss *sessions.RenterSession) (*sessions.FileAudit, error) {
+	ctxParams := rss.CtxParams
+	cfg := helper.GetUploadConfig(ctxParams).Audit
+	d := ctxParams.N.Repo.Datastore()
+	peerId := ctxParams.N.Identity.String()
+	fileCid, err := cidlib.Parse(rss.Hash)
+	if err != nil {
+		return nil, err
+	}
+	a := &sessions.FileAudit{SessionId: rss.SsId, FileHash: rss.Hash, Time: time.Now()}
+	if numData, err := fileNumData(ctx, ctxParams, fileCid); err != nil {
+		// without the metadata, every shard is needed
+		log.Debugf("audit: session %s: %v", rss.SsId, err)
+		a.NumData = len(rss.ShardHashes)
+	} else {
+		a.NumData = numData
+	}
+
+	holders, err := shardSessions(rss)
+	if err != nil {
+		return nil, err
+	}
+	var failed, failedHosts []string
+	for i, h := range rss.ShardHashes {
+		r := auditShard(ctx, holders[i], fileCid, i, h, cfg.Segments)
+		a.Shards = append(a.Shards, r)
+		if r.Passed {
+			a.Passed++
+		} else {
+			a.Failed++
+			failed = append(failed, h)
+			if r.Host != "" {
+				failedHosts = append(failedHosts, r.Host)
+			}
+		}
+		e := sessions.ShardEvent(sessions.EventAudit, i, h, r.Host, nil)
+		e.Message, e.Error = r.Method, r.Error
+		rss.AddEvent(e)
+		if r.Host == "" {
+			continue
+		}
+		host, err := sessions.AddAuditResult(d, peerId, r)
+		if err != nil {
+			return nil, err
+		}
+		if host.ConsecutiveFailures == auditFailingHost {
+			addAuditAlert(d, peerId, &sessions.AuditAlert{
+				Type:      sessions.AlertHostFailing,
+				SessionId: rss.SsId,
+				FileHash:  rss.Hash,
+				Host:      r.Host,
+				Message:   fmt.Sprintf("host %s failed %d audits in a row: %s", r.Host, host.ConsecutiveFailures, r.Error),
+			})
+		}
+	}
+
+	if a.Passed < a.NumData+cfg.RepairMargin && len(failed) > 0 {
+		a.AtRisk = true
+		addAuditAlert(d, peerId, &sessions.AuditAlert{
+			Type:      sessions.AlertFileAtRisk,
+			SessionId: rss.SsId,
+			FileHash:  rss.Hash,
+			Message: fmt.Sprintf("file %s: %d of %d shards passed the audit, %d are needed",
+				rss.Hash, a.Passed, len(rss.ShardHashes), a.NumData),
+		})
+		if cfg.Repair {
+			ssId, err := RepairShards(ctx, ctxParams, rss.Hash, failed, ctxParams.N.Identity, failedHosts, rss)
+			if err != nil {
+				a.Error = err.Error()
+				addAuditAlert(d, peerId, &sessions.AuditAlert{
+					Type:      sessions.AlertRepair,
+					SessionId: rss.SsId,
+					FileHash:  rss.Hash,
+					Message:   fmt.Sprintf("repair of file %s failed: %v", rss.Hash, err),
+				})
+			} else {
+				a.Repaired, a.RepairSession = failed, ssId
+				addAuditAlert(d, peerId, &sessions.AuditAlert{
+					Type:      sessions.AlertRepair,
+					SessionId: rss.SsId,
+					FileHash:  rss.Hash,
+					Message: fmt.Sprintf("repair of %d shards of file %s started in session %s",
+						len(failed), rss.Hash, ssId),
+				})
+			}
+		}
+	}
+	if err := sessions.SaveFileAudit(d, peerId, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// shardSessions returns the session that holds each shard of a session: the
+// session itself, or the last completed repair that placed the shard again.
+func shardSessions(rss *sessions.RenterSession) ([]*sessions.RenterSession, error) {
+	holders := make([]*sessions.RenterSession, len(rss.ShardHashes))
+	for i := range holders {
+		holders[i] = rss
+	}
+	ids, err := rss.RepairSessions()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		repair, err := sessions.GetRenterSession(rss.CtxParams, id, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		status, err := repair.Status()
+		if err != nil {
+			return nil, err
+		}
+		if status.Status != sessions.RssCompleteStatus {
+			continue
+		}
+		for j := range repair.ShardHashes {
+			if i := repair.ShardIndex(j); i < len(holders) {
+				holders[i] = repair
+			}
+		}
+	}
+	return holders, nil
+}
+
+// repairInProgress tells if a repair of the session has not completed or failed
+// yet, in which case the session is not audited again. A repair too old to be
+// resumed does not count.
+func repairInProgress(rss *sessions.RenterSession) (bool, error) {
+	ids, err := rss.RepairSessions()
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		repair, err := sessions.GetRenterSession(rss.CtxParams, id, "", nil)
+		if err != nil {
+			return false, err
+		}
+		status, err := repair.Status()
+		if err != nil {
+			return false, err
+		}
+		if status.Status == sessions.RssCompleteStatus || status.Status == sessions.RssErrorStatus {
+			continue
+		}
+		if resumable, err := AutoResumable(repair); err != nil || resumable {
+			return resumable, err
+		}
+	}
+	return false, nil
+}
+
+// auditShard challenges the host of the shard at index i. A shard without a
+// contract, or whose contract was canceled, fails.
+func auditShard(ctx context.Context, rss *sessions.RenterSession, fileCid cidlib.Cid, i int,
+	shardHash string, segments int) *sessions.AuditResult {
+	r := &sessions.AuditResult{
+		Time:       time.Now(),
+		SessionId:  rss.SsId,
+		FileHash:   rss.Hash,
+		ShardIndex: i,
+		ShardHash:  shardHash,
+	}
+	err := func() error {
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, shardHash, i)
+		if err != nil {
+			return err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return err
+		}
+		c := contracts.SignedGuardContract
+		if c == nil {
+			return errors.New("no contract")
+		}
+		r.Host, r.ContractId = c.HostPid, c.ContractId
+		if canceled, err := shard.IsCanceled(); err != nil {
+			return err
+		} else if canceled {
+			return errors.New("contract is canceled")
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, auditTimeout)
+		defer cancel()
+		n, api := rss.CtxParams.N, rss.CtxParams.Api
+		commitment, err := challenge.GetCommitment(n.Repo.Datastore(), n.Identity.String(), shardHash)
+		if err == nil {
+			r.Method = sessions.AuditSegmentsMethod
+			res, err := challenge.ChallengeSegments(ctx, n, api, r.Host, commitment, segments)
+			if err != nil {
+				return err
+			}
+			if !res.Passed {
+				return errors.New(strings.Join(res.Errors, "; "))
+			}
+			return nil
+		} else if err != ds.ErrNotFound {
+			return err
+		}
+		r.Method = sessions.AuditChunkMethod
+		shardCid, err := cidlib.Parse(shardHash)
+		if err != nil {
+			return err
+		}
+		return challenge.ChallengeChunk(ctx, n, api, r.Host, r.ContractId, fileCid, shardCid)
+	}()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.Passed = err == nil
+	return r
+}
+
+// fileNumData returns how many shards are needed to reconstruct a file, from
+// its reed-solomon metadata.
+func fileNumData(ctx context.Context, ctxParams *helper.ContextParams, fileCid cidlib.Cid) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, auditTimeout)
+	defer cancel()
+	b, err := ctxParams.Api.Unixfs().GetMetadata(ctx, path.IpfsPath(fileCid))
+	if err != nil {
+		return 0, err
+	}
+	var rsMeta chunker.RsMetaMap
+	if err := json.Unmarshal(b, &rsMeta); err != nil {
+		return 0, err
+	}
+	if rsMeta.NumData == 0 {
+		return 0, errors.New("file is not reed-solomon encoded")
+	}
+	return int(rsMeta.NumData), nil
+}
+
+func addAuditAlert(d ds.Datastore, peerId string, a *sessions.AuditAlert) {
+	a.Time = time.Now()
+	if err := sessions.AddAuditAlert(d, peerId, a); err != nil {
+		log.Errorf("save audit alert: %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/bittorrent/go-btfs/core/commands/storage/challenge"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/guard"
 	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
@@ -21,13 +22,13 @@ import (
 )
 
 func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult, fileSize int64, offlineSigning bool) error {
-	if err := rss.To(sessions.RssToGuardEvent); err != nil {
+	if err := rss.Advance(sessions.RssToGuardEvent); err != nil {
 		return err
 	}
 	cts := make([]*guardpb.Contract, 0)
 	selectedHosts := make([]string, 0)
-	for i, h := range rss.ShardHashes {
-		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, i)
+	for i := range rss.ShardHashes {
+		shard, err := rss.Shard(i)
 		if err != nil {
 			return err
 		}
@@ -82,10 +83,11 @@ func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult,
 	}
 	signBytes := <-cb
 	uh.FileMetaChanMaps.Remove(rss.SsId)
-	if err := rss.To(sessions.RssToGuardFileMetaSignedEvent); err != nil {
+	if err := rss.Advance(sessions.RssToGuardFileMetaSignedEvent); err != nil {
 		return err
 	}
 	fsStatus, err = submitFileMetaHelper(rss.Ctx, rss.CtxParams.Cfg, fsStatus, signBytes)
+	guardEvent(rss, "file meta submitted", err)
 	if err != nil {
 		return err
 	}
@@ -99,10 +101,35 @@ func doGuardAndPay(rss *sessions.RenterSession, res *escrowpb.SignedPayinResult,
 		return err
 	}
 	err = guard.SendChallengeQuestions(rss.Ctx, rss.CtxParams.Cfg, fcid, qs)
+	guardEvent(rss, "challenge questions sent", err)
 	if err != nil {
 		return fmt.Errorf("failed to send challenge questions to guard: [%v]", err)
 	}
-	return waitUpload(rss, offlineSigning, fsStatus, false)
+	commitShards(rss)
+	return waitUpload(rss, offlineSigning, fsStatus)
+}
+
+// commitShards saves the segment commitments of the shards, to challenge their
+// hosts with segment proofs later.
+func commitShards(rss *sessions.RenterSession) {
+	for _, h := range rss.ShardHashes {
+		shardCid, err := cidlib.Parse(h)
+		if err == nil {
+			_, err = challenge.CommitShard(rss.Ctx, rss.CtxParams.N, rss.CtxParams.Api, shardCid)
+		}
+		if err != nil {
+			log.Errorf("commit segments of shard %s: %v", h, err)
+		}
+	}
+}
+
+// guardEvent records a response of the guard in the session history.
+func guardEvent(rss *sessions.RenterSession, msg string, err error) {
+	event := &sessions.Event{Type: sessions.EventGuard, Message: msg}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	rss.AddEvent(event)
 }
 
 func NewFileStatus(contracts []*guardpb.Contract, configuration *config.Config,
@@ -8,14 +8,29 @@ import (
 
 	"github.com/bittorrent/go-btfs/chain"
 	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	"github.com/bittorrent/go-btfs/transaction/storage"
 )
 
 func payInCheque(rss *sessions.RenterSession) error {
-	for i, hash := range rss.ShardHashes {
+	for pos, hash := range rss.ShardHashes {
+		i := rss.ShardIndex(pos)
 		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, hash, i)
 		if err != nil {
 			return err
 		}
+		// a resumed session must not pay a shard twice
+		if paid, err := shard.IsPaid(); err != nil {
+			return err
+		} else if paid {
+			continue
+		}
+		// nor a shard whose contract the renter canceled meanwhile
+		if canceled, err := shard.IsCanceled(); err != nil {
+			return err
+		} else if canceled {
+			continue
+		}
 		c, err := shard.Contracts()
 		if err != nil {
 			return err
@@ -30,18 +45,76 @@ func payInCheque(rss *sessions.RenterSession) error {
 
 		host := c.SignedGuardContract.HostPid
 		contractId := c.SignedGuardContract.ContractId
-		fmt.Printf("send cheque: paying...  host:%v, amount:%v, contractId:%v, token:%v. \n", host, realAmount.String(), contractId, rss.Token.String())
+
+		// the session stopped while paying the shard: the payment went out if
+		// the ledger recorded it sent since, or if the vault issued its pending
+		// cheque, otherwise it is sent again
+		if since, err := shard.PayingSince(); err != nil {
+			return err
+		} else if !since.IsZero() {
+			if sent, err := paymentSent(host, contractId, since, rss.Token); err != nil {
+				return err
+			} else if sent {
+				event := sessions.ShardEvent(sessions.EventCheque, i, hash, host, nil)
+				event.Message = fmt.Sprintf("contract %s was paid before the session stopped", contractId)
+				rss.AddEvent(event)
+				if err := shard.Paid(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := shard.Paying(); err != nil {
+			return err
+		}
+
+		log.Infof("send cheque: paying...  host:%v, amount:%v, contractId:%v, token:%v.", host, realAmount.String(), contractId, rss.Token.String())
 
 		err = chain.SettleObject.SwapService.Settle(host, realAmount, contractId, rss.Token)
+		event := sessions.ShardEvent(sessions.EventCheque, i, hash, host, err)
+		event.Message = fmt.Sprintf("amount %s of token %s for contract %s", realAmount, rss.Token, contractId)
+		rss.AddEvent(event)
 		if err != nil {
 			return err
 		}
+		if err := shard.Paid(); err != nil {
+			return err
+		}
 		time.Sleep(500 * time.Millisecond)
 	}
 
 	return nil
 }
 
+// paymentSent tells if the ledger recorded a payment of the contract to the host
+// as sent since the given time. A cheque still pending in the ledger was sent if
+// the vault issued it, the ledger is reconciled with it then.
+func paymentSent(host string, contractId string, since time.Time, token common.Address) (bool, error) {
+	c, err := chain.SettleObject.Accounting.PayableContract(host, contractId)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if c.Sent >= since.Unix() {
+		return true, nil
+	}
+	if c.Pending == nil {
+		return false, nil
+	}
+	beneficiary, known, err := chain.SettleObject.SwapService.Beneficiary(host)
+	if err != nil || !known {
+		return false, err
+	}
+	last, err := chain.SettleObject.VaultService.LastCheque(beneficiary, token)
+	if err == vault.ErrNoCheque {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return chain.SettleObject.Accounting.ReconcilePayment(host, contractId, last.CumulativePayout)
+}
+
 func getRealAmount(amount int64, token common.Address) (*big.Int, error) {
 	//this is price's rate [Compatible with older versions]
 	rateObj, err := chain.SettleObject.OracleService.CurrentRate(token)
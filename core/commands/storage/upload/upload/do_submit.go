@@ -2,7 +2,6 @@ package upload
 
 import (
 	"context"
-	"fmt"
 	"github.com/bittorrent/go-btfs/settlement/swap/vault"
 	"github.com/ethereum/go-ethereum/common"
 
@@ -11,7 +10,7 @@ import (
 )
 
 func Submit(rss *sessions.RenterSession, fileSize int64, offlineSigning bool) error {
-	if err := rss.To(sessions.RssToSubmitEvent); err != nil {
+	if err := rss.Advance(sessions.RssToSubmitEvent); err != nil {
 		return err
 	}
 
@@ -25,7 +24,7 @@ func Submit(rss *sessions.RenterSession, fileSize int64, offlineSigning bool) er
 func prepareAmount(rss *sessions.RenterSession, shardHashes []string) (int64, error) {
 	var totalPrice int64
 	for i, hash := range shardHashes {
-		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, hash, i)
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, hash, rss.ShardIndex(i))
 		if err != nil {
 			return 0, err
 		}
@@ -65,9 +64,8 @@ func checkAvailableBalance(ctx context.Context, amount int64, token common.Addre
 		return err
 	}
 
-	fmt.Printf("check,  balance=%v, realAmount=%v \n", AvailableBalance, realAmount)
+	log.Debugf("check available balance: balance=%v, realAmount=%v", AvailableBalance, realAmount)
 	if AvailableBalance.Cmp(realAmount) < 0 {
-		fmt.Println("check, err: ", vault.ErrInsufficientFunds)
 		return vault.ErrInsufficientFunds
 	}
 	return nil
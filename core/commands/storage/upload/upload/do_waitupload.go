@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"math"
 	"time"
 
@@ -30,20 +29,22 @@ func getSuccessThreshold(totalShards int) int {
 }
 
 func ResumeWaitUploadOnSigning(rss *sessions.RenterSession) error {
-	return waitUpload(rss, false, &guardpb.FileStoreStatus{
+	return waitUpload(rss, false, newResumeFileStatus(rss, math.MaxInt64))
+}
+
+func newResumeFileStatus(rss *sessions.RenterSession, fileSize int64) *guardpb.FileStoreStatus {
+	return &guardpb.FileStoreStatus{
 		FileStoreMeta: guardpb.FileStoreMeta{
 			RenterPid: rss.CtxParams.N.Identity.String(),
-			FileSize:  math.MaxInt64,
+			FileSize:  fileSize,
 		},
-	}, true)
+	}
 }
 
-func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guardpb.FileStoreStatus, resume bool) error {
+func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guardpb.FileStoreStatus) error {
 	threshold := getSuccessThreshold(len(rss.ShardHashes))
-	if !resume {
-		if err := rss.To(sessions.RssToWaitUploadEvent); err != nil {
-			return err
-		}
+	if err := rss.Advance(sessions.RssToWaitUploadEvent); err != nil {
+		return err
 	}
 	req := &guardpb.CheckFileStoreMetaRequest{
 		FileHash:     rss.Hash,
@@ -80,10 +81,8 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 	}
 	sign := <-cb
 	helper.WaitUploadChanMap.Remove(rss.SsId)
-	if !resume {
-		if err := rss.To(sessions.RssToWaitUploadReqSignedEvent); err != nil {
-			return err
-		}
+	if err := rss.Advance(sessions.RssToWaitUploadReqSignedEvent); err != nil {
+		return err
 	}
 	req.Signature = sign
 	lowRetry := 30 * time.Minute
@@ -94,11 +93,17 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 	} else if scaledRetry > highRetry {
 		scaledRetry = highRetry
 	}
+	// the guard is polled until the hosts stored their shards, only changes are recorded
+	var lastEvent string
 	err = backoff.Retry(func() error {
 		err = grpc.GuardClient(rss.CtxParams.Cfg.Services.GuardDomain).WithContext(rss.Ctx,
 			func(ctx context.Context, client guardpb.GuardServiceClient) error {
 				meta, err := client.CheckFileStoreMeta(ctx, req)
 				if err != nil {
+					if err.Error() != lastEvent {
+						lastEvent = err.Error()
+						guardEvent(rss, "", err)
+					}
 					return err
 				}
 				num := 0
@@ -121,6 +126,10 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 				bytes, err := json.Marshal(m)
 				if err == nil {
 					rss.UpdateAdditionalInfo(string(bytes))
+					if msg := "contracts " + string(bytes); msg != lastEvent {
+						lastEvent = msg
+						guardEvent(rss, msg, nil)
+					}
 				}
 				log.Infof("%d shards uploaded.", num)
 				if num >= threshold {
@@ -134,24 +143,28 @@ func waitUpload(rss *sessions.RenterSession, offlineSigning bool, fsStatus *guar
 		return err
 	}
 
+	return pay(rss)
+}
+
+func pay(rss *sessions.RenterSession) error {
 	// pay in cheque
-	if err := rss.To(sessions.RssToPayEvent); err != nil {
+	if err := rss.Advance(sessions.RssToPayEvent); err != nil {
 		return err
 	}
+	var err error
 	var errC = make(chan error)
 	go func() {
 		err = func() error {
 			return payInCheque(rss)
 		}()
 		if err != nil {
-			fmt.Println("payInCheque error:", err)
+			log.Debugf("session %s: pay in cheque error: %v", rss.SsId, err)
 		}
-		fmt.Println("payInCheque done")
 		errC <- err
 	}()
 	err = <-errC
 	if err != nil {
-		if fsmErr := rss.To(sessions.RssToErrorEvent); fsmErr != nil {
+		if fsmErr := rss.To(sessions.RssToErrorEvent, err); fsmErr != nil {
 			log.Errorf("fsm transfer error:%v", fsmErr)
 		}
 		log.Errorf("payInCheque error:%v", err)
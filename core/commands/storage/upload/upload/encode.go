@@ -0,0 +1,295 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gopath "path"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+
+	files "github.com/bittorrent/go-btfs-files"
+	iface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+
+	cidlib "github.com/ipfs/go-cid"
+)
+
+// uploadTarget is a reed-solomon encoded file that is uploaded by a session.
+type uploadTarget struct {
+	hash        string
+	shardHashes []string
+	fileSize    int64
+	shardSize   int64
+	// entry is the file of a manifest uploaded per file, if any
+	entry *sessions.ManifestEntry
+}
+
+// resolveUploadHash returns the hash of the file or directory to upload, given
+// either as a hash or as a path, e.g. /btfs/<hash>/docs.
+func resolveUploadHash(ctxParams *uh.ContextParams, arg string) (string, error) {
+	if _, err := cidlib.Parse(arg); err == nil {
+		return arg, nil
+	}
+	rp, err := ctxParams.Api.ResolvePath(ctxParams.Ctx, path.New(arg))
+	if err != nil {
+		return "", err
+	}
+	return rp.Cid().String(), nil
+}
+
+// uploadTargets returns the file to upload for the given hash. A file that was
+// added with the reed-solomon chunker is uploaded as is, and copies are uploaded
+// with the copy option. Anything else has to be encoded first, for which no
+// targets are returned.
+func uploadTargets(ctxParams *uh.ContextParams, fileHash string, copyNum int, copyOk bool) ([]*uploadTarget, error) {
+	shardHashes, fileSize, shardSize, err := uh.GetShardHashes(ctxParams, fileHash)
+	if err == nil {
+		return []*uploadTarget{{fileHash, shardHashes, fileSize, shardSize, nil}}, nil
+	}
+	if !errors.Is(err, helper.ErrNotReedSolomon) {
+		return nil, err
+	}
+	if !copyOk {
+		return nil, nil
+	}
+	shardHashes, fileSize, shardSize, err = uh.GetShardHashesCopy(ctxParams, fileHash, copyNum)
+	log.Debugf("copy get, shardHashes:%v fileSize:%v, shardSize:%v, copy:%v err:%v",
+		shardHashes, fileSize, shardSize, copyNum, err)
+	if err != nil {
+		return nil, err
+	}
+	return []*uploadTarget{{fileHash, shardHashes, fileSize, shardSize, nil}}, nil
+}
+
+// encodeTargets encodes the file or directory of the given hash and returns the
+// files to upload, with the manifest that records how they were encoded.
+func encodeTargets(ctxParams *uh.ContextParams, fileHash string, dirMode string) ([]*uploadTarget,
+	*sessions.Manifest, error) {
+	m, err := encodeForUpload(ctxParams.Ctx, ctxParams.Api, fileHash, uh.GetUploadConfig(ctxParams), dirMode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode %s: %v", fileHash, err)
+	}
+	targets := make([]*uploadTarget, 0)
+	add := func(hash string, entry *sessions.ManifestEntry) error {
+		shardHashes, fileSize, shardSize, err := uh.GetShardHashes(ctxParams, hash)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, &uploadTarget{hash, shardHashes, fileSize, shardSize, entry})
+		return nil
+	}
+	if m.Mode == sessions.ManifestPerFileMode {
+		for _, e := range m.Entries {
+			if e.EncodedHash == "" {
+				continue
+			}
+			if err := add(e.EncodedHash, e); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(targets) == 0 {
+			return nil, nil, fmt.Errorf("directory %s has no files to upload", fileHash)
+		}
+	} else if err := add(m.EncodedHash, nil); err != nil {
+		return nil, nil, err
+	}
+	return targets, m, nil
+}
+
+// encodeSession creates the session of a file that is encoded before it is
+// uploaded. The command returns before the file is encoded, so the session does
+// not take the deadline of the request but allows for encodeTimeout on top of
+// uploadTimeout. The sessions of the upload share its context from then on.
+func (j *uploadJob) encodeSession(ssId string, fileHash string) (*sessions.RenterSession, error) {
+	ctxParams := *j.ctxParams
+	ctx, cancel := context.WithTimeout(context.Background(), encodeTimeout+uploadTimeout)
+	defer cancel()
+	ctxParams.Ctx = ctx
+	rss, err := sessions.GetRenterSessionWithToken(&ctxParams, ssId, fileHash, nil, j.token)
+	if err != nil {
+		return nil, err
+	}
+	// the context of the session has the same deadline, and is not canceled on return
+	ctxParams.Ctx = rss.Ctx
+	j.ctxParams = &ctxParams
+	return rss, nil
+}
+
+// encodeAndStart encodes the file of an encode session, then starts the session
+// with the first encoded file, and a session for each other file of a directory
+// uploaded per file. The session fails if it does not get to start.
+func (j *uploadJob) encodeAndStart(rss *sessions.RenterSession, fileHash string, dirMode string) {
+	seRes, err := func() (*Res, error) {
+		rss.AddEvent(&sessions.Event{Type: sessions.EventEncode, Message: "encoding " + fileHash})
+		targets, manifest, err := encodeTargets(j.ctxParams, fileHash, dirMode)
+		if err != nil {
+			return nil, err
+		}
+		msg := fmt.Sprintf("encoded to %s", manifest.EncodedHash)
+		if manifest.Mode == sessions.ManifestPerFileMode {
+			msg = fmt.Sprintf("encoded %d files", len(targets))
+		}
+		rss.AddEvent(&sessions.Event{Type: sessions.EventEncode, Message: msg})
+		if err := j.check(targets); err != nil {
+			return nil, err
+		}
+		rss.Hash, rss.ShardHashes = targets[0].hash, targets[0].shardHashes
+		return j.start(rss.SsId, targets, manifest)
+	}()
+	if err == nil {
+		return
+	}
+	if seRes != nil && seRes.ID != "" {
+		// the session is under way, only a later file of the directory did not start
+		log.Errorf("upload %s: session %s started, but not the sessions after %v: %v",
+			fileHash, rss.SsId, seRes.Sessions, err)
+		return
+	}
+	log.Errorf("upload %s: session %s: %v", fileHash, rss.SsId, err)
+	_ = rss.To(sessions.RssToErrorEvent, err)
+}
+
+// encodeForUpload adds the file or directory of the given hash again with the
+// reed-solomon chunker of the Upload.ErasureCoding config. A directory is packed
+// into one encoded archive, or its files are encoded one by one with
+// uh.DirectoryModePerFile.
+func encodeForUpload(ctx context.Context, api iface.CoreAPI, hash string, cfg *uh.UploadConfig,
+	dirMode string) (*sessions.Manifest, error) {
+	c, err := cidlib.Parse(hash)
+	if err != nil {
+		return nil, err
+	}
+	p := path.IpfsPath(c)
+	node, err := api.Unixfs().Get(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+
+	m := &sessions.Manifest{
+		Hash:    hash,
+		Chunker: cfg.ErasureCoding.Chunker(),
+		Created: time.Now().UTC(),
+	}
+	switch n := node.(type) {
+	case files.File:
+		m.Mode = sessions.ManifestFileMode
+		m.EncodedHash, err = encodeNode(ctx, api, n, m.Chunker)
+		if err != nil {
+			return nil, err
+		}
+	case files.Directory:
+		m.Entries, err = listTree(ctx, api, p, "")
+		if err != nil {
+			return nil, err
+		}
+		if dirMode == "" {
+			dirMode = cfg.DirectoryMode
+		}
+		switch dirMode {
+		case uh.DirectoryModeArchive:
+			m.Mode = sessions.ManifestArchiveMode
+			// the reed-solomon adder only packs directories built in memory
+			archive, err := mapDirectory(n)
+			if err != nil {
+				return nil, err
+			}
+			m.EncodedHash, err = encodeNode(ctx, api, archive, m.Chunker)
+			if err != nil {
+				return nil, err
+			}
+		case uh.DirectoryModePerFile:
+			m.Mode = sessions.ManifestPerFileMode
+			for _, e := range m.Entries {
+				// empty files have nothing to encode and are restored from the manifest
+				if e.Dir || e.Target != "" || e.Size == 0 {
+					continue
+				}
+				if e.EncodedHash, err = encodeHash(ctx, api, e.Hash, m.Chunker); err != nil {
+					return nil, fmt.Errorf("%s: %v", e.Path, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown directory mode %q, expect %q or %q", dirMode,
+				uh.DirectoryModeArchive, uh.DirectoryModePerFile)
+		}
+	default:
+		return nil, errors.New("only files and directories can be uploaded")
+	}
+	return m, nil
+}
+
+// listTree lists the files and directories under p, depth first.
+func listTree(ctx context.Context, api iface.CoreAPI, p path.Path, prefix string) ([]*sessions.ManifestEntry, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries, err := api.Unixfs().Ls(ctx, p, options.Unixfs.ResolveChildren(true))
+	if err != nil {
+		return nil, err
+	}
+	tree := make([]*sessions.ManifestEntry, 0)
+	for e := range entries {
+		if e.Err != nil {
+			return nil, e.Err
+		}
+		entry := &sessions.ManifestEntry{
+			Path: gopath.Join(prefix, e.Name),
+			Hash: e.Cid.String(),
+			Size: e.Size,
+		}
+		tree = append(tree, entry)
+		switch e.Type {
+		case iface.TDirectory:
+			entry.Dir = true
+			children, err := listTree(ctx, api, path.IpfsPath(e.Cid), entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			tree = append(tree, children...)
+		case iface.TSymlink:
+			entry.Target = e.Target
+		}
+	}
+	return tree, nil
+}
+
+// mapDirectory copies the top level entries of a directory into memory.
+func mapDirectory(dir files.Directory) (files.Directory, error) {
+	entries := make(map[string]files.Node)
+	it := dir.Entries()
+	for it.Next() {
+		entries[it.Name()] = it.Node()
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return files.NewMapDirectory(entries), nil
+}
+
+func encodeHash(ctx context.Context, api iface.CoreAPI, hash string, chunker string) (string, error) {
+	c, err := cidlib.Parse(hash)
+	if err != nil {
+		return "", err
+	}
+	n, err := api.Unixfs().Get(ctx, path.IpfsPath(c))
+	if err != nil {
+		return "", err
+	}
+	defer n.Close()
+	return encodeNode(ctx, api, n, chunker)
+}
+
+// encodeNode adds and pins a node with the reed-solomon chunker, so that its
+// shards stay around until the hosts have stored them.
+func encodeNode(ctx context.Context, api iface.CoreAPI, n files.Node, chunker string) (string, error) {
+	rp, err := api.Unixfs().Add(ctx, n, options.Unixfs.Chunker(chunker), options.Unixfs.Pin(true))
+	if err != nil {
+		return "", err
+	}
+	return rp.Cid().String(), nil
+}
@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	cidlib "github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeForUpload(t *testing.T) {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	cfg := &uh.UploadConfig{ErasureCoding: uh.ErasureCodingConfig{DataShards: 2, ParityShards: 1, ShardSize: 1024}}
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile(bytes.Repeat([]byte("a"), 3000)),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile(bytes.Repeat([]byte("b"), 100)),
+		}),
+		"empty.txt": files.NewBytesFile(nil),
+	})
+	root, err := api.Unixfs().Add(ctx, dir, options.Unixfs.Pin(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := func(hash string) int {
+		c, err := cidlib.Parse(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards, _, err := helper.CheckAndGetReedSolomonShardHashes(ctx, node, api, c)
+		if err != nil {
+			t.Fatalf("%s is not reed-solomon encoded: %v", hash, err)
+		}
+		return len(shards)
+	}
+
+	m, err := encodeForUpload(ctx, api, root.Cid().String(), cfg, uh.DirectoryModeArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sessions.ManifestArchiveMode, m.Mode)
+	assert.Equal(t, "reed-solomon-2-1-1024", m.Chunker)
+	assert.Equal(t, 3, encoded(m.EncodedHash))
+	paths := make([]string, 0)
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path)
+	}
+	assert.Equal(t, []string{"a.txt", "empty.txt", "sub", "sub/b.txt"}, paths)
+	assert.True(t, m.Entries[2].Dir)
+
+	m, err = encodeForUpload(ctx, api, root.Cid().String(), cfg, uh.DirectoryModePerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sessions.ManifestPerFileMode, m.Mode)
+	assert.Empty(t, m.EncodedHash)
+	assert.Equal(t, 3, encoded(m.Entries[0].EncodedHash))
+	assert.Empty(t, m.Entries[1].EncodedHash)
+	assert.Equal(t, 3, encoded(m.Entries[3].EncodedHash))
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("plain")), options.Unixfs.Pin(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = encodeForUpload(ctx, api, file.Cid().String(), cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, sessions.ManifestFileMode, m.Mode)
+	assert.Equal(t, 3, encoded(m.EncodedHash))
+
+	_, err = encodeForUpload(ctx, api, root.Cid().String(), cfg, "zip")
+	assert.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package upload
+
+import (
+	"fmt"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+
+	"github.com/ipfs/go-datastore"
+)
+
+var StorageUploadManifestCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show how a file or directory was encoded for upload.",
+		ShortDescription: `
+This command prints the manifest of a file or directory that was encoded on the fly
+when it was uploaded: the reed-solomon chunker, the hash it was encoded to and its
+session, and for a directory the whole tree. A directory uploaded per file lists
+the encoded hash and session of each file, so that the tree can be restored.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("file-hash", true, false, "Hash of the uploaded file or directory.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		m, err := sessions.GetManifest(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(), req.Arguments[0])
+		if err == datastore.ErrNotFound {
+			return fmt.Errorf("no manifest of %s, it was not encoded for upload", req.Arguments[0])
+		} else if err != nil {
+			return err
+		}
+		return res.Emit(m)
+	},
+	Type: sessions.Manifest{},
+}
@@ -0,0 +1,109 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/contracts"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
+	"github.com/bittorrent/protobuf/proto"
+)
+
+var StorageUploadRecvCancelCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Release a stored shard whose contract the renter canceled.",
+		ShortDescription: `
+Storage host opens this endpoint to accept the cancellation of a contract by its
+renter. The host removes the shard, unless another contract still needs it, and
+records the contract as canceled.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cancel-request", true, false, "Renter signed cancellation of the contract."),
+	},
+	RunTimeout: time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageHostEnabled {
+			return fmt.Errorf("storage host api not enabled")
+		}
+		requestPid, ok := remote.GetStreamRequestRemotePeerID(req, ctxParams.N)
+		if !ok {
+			return fmt.Errorf("fail to get peer ID from request")
+		}
+
+		cancelReq := &guardpb.CancelContractRequest{}
+		if err := proto.Unmarshal([]byte(req.Arguments[0]), cancelReq); err != nil {
+			return err
+		}
+		if err := verifyCancelRequest(cancelReq, requestPid.String()); err != nil {
+			return err
+		}
+		c, err := sessions.GetHostShardContract(ctxParams, cancelReq.ContractId)
+		if err != nil {
+			return fmt.Errorf("contract %s not found: %v", cancelReq.ContractId, err)
+		}
+		if c.RenterPid != cancelReq.RenterPid || c.HostPid != ctxParams.N.Identity.String() ||
+			c.ShardHash != cancelReq.ShardHash || c.FileHash != cancelReq.FileHash {
+			return errors.New("cancellation does not match the contract")
+		}
+		if canceled, err := sessions.IsHostShardCanceled(ctxParams, c.ContractId); err != nil {
+			return err
+		} else if canceled {
+			return nil
+		}
+
+		if err := sessions.CancelHostShard(ctxParams, c.ContractId); err != nil {
+			return err
+		}
+		err = contracts.SaveCanceledContract(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(),
+			nodepb.ContractStat_HOST.String(), c)
+		if err != nil {
+			return err
+		}
+		kept, err := sessions.HostShardKept(ctxParams, c)
+		if err != nil {
+			return err
+		}
+		if kept {
+			log.Debugf("cancel: shard %s of contract %s is kept for another contract", c.ShardHash, c.ContractId)
+			return nil
+		}
+		return rmShard(ctxParams, req, env, c.ShardHash)
+	},
+}
+
+// verifyCancelRequest checks a cancellation was signed by the renter that sent it.
+func verifyCancelRequest(cancelReq *guardpb.CancelContractRequest, requestPid string) error {
+	if cancelReq.RenterPid != requestPid {
+		return errors.New("cancellation is not from the renter of the contract")
+	}
+	if cancelReq.Reason != guardpb.CancelContractRequest_RENTER_REQUEST {
+		return fmt.Errorf("unexpected cancel reason %s", cancelReq.Reason)
+	}
+	renterPubKey, err := crypto.GetPubKeyFromPeerId(cancelReq.RenterPid)
+	if err != nil {
+		return err
+	}
+	unsigned := *cancelReq
+	unsigned.Signature = nil
+	if ok, err := crypto.Verify(renterPubKey, &unsigned, cancelReq.Signature); !ok || err != nil {
+		return fmt.Errorf("can't verify cancellation: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package upload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/stretchr/testify/assert"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestVerifyCancelRequest(t *testing.T) {
+	priv, _, err := ic.GenerateSecp256k1Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renter, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelReq := &guardpb.CancelContractRequest{
+		FileHash:   "QmFile",
+		ShardHash:  "QmShard",
+		ContractId: "contract-1",
+		RenterPid:  renter.String(),
+		HostPid:    "host",
+		Reason:     guardpb.CancelContractRequest_RENTER_REQUEST,
+		SignTime:   time.Now().UTC(),
+	}
+	cancelReq.Signature, err = crypto.Sign(priv, cancelReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, verifyCancelRequest(cancelReq, renter.String()))
+	assert.Error(t, verifyCancelRequest(cancelReq, "other"))
+
+	tampered := *cancelReq
+	tampered.ContractId = "contract-2"
+	assert.Error(t, verifyCancelRequest(&tampered, renter.String()))
+}
@@ -34,8 +34,6 @@ var StorageUploadChequeCmd = &cmds.Command{
 			return err
 		}
 
-		fmt.Printf("receive cheque ...\n")
-
 		ctxParams, err := uh.ExtractContextParams(req, env)
 		if err != nil {
 			return err
@@ -57,7 +55,7 @@ var StorageUploadChequeCmd = &cmds.Command{
 		encodedCheque := req.Arguments[0]
 		contractId := req.Arguments[2]
 		tokenHex := req.Arguments[3]
-		fmt.Printf("receive cheque, requestPid:%s contractId:%+v,encodedCheque:%+v price:%v token:%+v\n",
+		log.Debugf("receive cheque, requestPid:%s contractId:%+v, encodedCheque:%+v price:%v token:%+v",
 			requestPid.String(), contractId, encodedCheque, price, tokenHex)
 
 		token := common.HexToAddress(tokenHex)
@@ -78,13 +76,13 @@ var StorageUploadChequeCmd = &cmds.Command{
 			)
 		}
 		realAmount := new(big.Int).Mul(big.NewInt(amountStore), rateStore)
-		fmt.Printf("receive cheque, price:%v amountStore:%v rateStore:%+v,realAmount:%+v \n",
+		log.Debugf("receive cheque, price:%v amountStore:%v rateStore:%+v, realAmount:%+v",
 			priceStore, amountStore, rateStore.String(), realAmount.String())
 
 		// decode and deal the cheque
-		err = swapprotocol.SwapProtocol.Handler(context.Background(), requestPid.String(), encodedCheque, realAmount, token)
+		err = swapprotocol.SwapProtocol.Handler(context.Background(), requestPid.String(), encodedCheque, realAmount, contractId, token)
 		if err != nil {
-			fmt.Println("receive cheque, swapprotocol.SwapProtocol.Handler, error:", err)
+			log.Debugf("receive cheque, swapprotocol.SwapProtocol.Handler error: %v", err)
 			return err
 		}
 
@@ -92,7 +90,7 @@ var StorageUploadChequeCmd = &cmds.Command{
 		if len(contractId) > 0 {
 			err := setPaidStatus(ctxParams, contractId)
 			if err != nil {
-				fmt.Println("receive cheque, setPaidStatus: contractId error:", contractId, err)
+				log.Debugf("receive cheque, setPaidStatus of contract %s error: %v", contractId, err)
 				return err
 			}
 		}
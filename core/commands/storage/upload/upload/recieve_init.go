@@ -7,7 +7,6 @@ import (
 	"github.com/bittorrent/go-btfs/utils"
 	"math/big"
 	"strconv"
-	"sync"
 	"time"
 
 	"github.com/bittorrent/go-btfs/chain/tokencfg"
@@ -97,7 +96,7 @@ the shard and replies back to client for the next challenge step.`,
 			return err
 		}
 		if !accept {
-			return errors.New("too many initialized contracts")
+			return rejectf("host holds too many initialized contracts, try another host")
 		}
 		_, err = strconv.ParseInt(req.Arguments[3], 10, 64)
 		if err != nil {
@@ -125,7 +124,7 @@ the shard and replies back to client for the next challenge step.`,
 			return err
 		}
 
-		fmt.Printf("--- upload init: start, shardSize:%v, requestPid:%v, shardIndex:%v . \n",
+		log.Debugf("upload init: start, shardSize:%v, requestPid:%v, shardIndex:%v",
 			shardSize, requestPid, shardIndex)
 
 		//halfSignedEscrowContString := req.Arguments[4]
@@ -188,7 +187,7 @@ the shard and replies back to client for the next challenge step.`,
 			if err != nil {
 				return err
 			}
-			fmt.Printf("receive init, token[%s] renter-price[%v], online-price[%v],  \n", token.String(), price, priceOnline)
+			log.Debugf("receive init, token[%s] renter-price[%v], online-price[%v]", token.String(), price, priceOnline)
 
 			if price < priceOnline.Int64() {
 				return errors.New(
@@ -216,7 +215,26 @@ the shard and replies back to client for the next challenge step.`,
 			}
 		}
 
+		// check the admission policy of the host, which reserves space for the shard
+		policy, err := NewAdmissionPolicy(ctxParams)
+		if err != nil {
+			return err
+		}
+		reserved, err := policy.Admit(req.Context, &contractOffer{
+			contractId: guardContractMeta.ContractId,
+			renter:     peerId,
+			shardSize:  shardSize,
+			price:      price,
+			token:      common.HexToAddress(halfSignedGuardContract.Token),
+			payer:      requestPid.String(),
+			amount:     new(big.Int).Mul(big.NewInt(amount), rate),
+		})
+		if err != nil {
+			return err
+		}
+
 		go func() {
+			defer reserved.Release()
 			tmp := func() error {
 				shard, err := sessions.GetHostShard(ctxParams, signedGuardContract.ContractId, price, amount, rate)
 				if err != nil {
@@ -237,9 +255,13 @@ the shard and replies back to client for the next challenge step.`,
 				if err := shard.Contract(nil, signedGuardContract); err != nil {
 					return err
 				}
+				expectPayment(requestPid.String(), signedGuardContract.ContractId, amount, rate,
+					common.HexToAddress(halfSignedGuardContract.Token))
 
 				fileHash := req.Arguments[1]
 				err = downloadShardFromClient(ctxParams, halfSignedGuardContract, fileHash, shardHash, false)
+				// the downloaded shard now counts in the storage used
+				reserved.Release()
 				if err != nil {
 					return err
 				}
@@ -249,50 +271,28 @@ the shard and replies back to client for the next challenge step.`,
 					return err
 				}
 
-				fmt.Printf("upload init: send /storage/upload/recvcontract ok, wait for pay status, requestPid:%v, shardIndex:%v. \n",
+				log.Debugf("upload init: send /storage/upload/recvcontract ok, wait for pay status, requestPid:%v, shardIndex:%v",
 					requestPid, shardIndex)
 
-				blPay := false
-				var wg sync.WaitGroup
-				wg.Add(1)
-				go func() {
-					// every 30s check pay status
-					tick := time.Tick(30 * time.Second)
-
-					// total timeout for checking pay status
-					timeoutPay := time.NewTimer(10 * time.Minute)
-					for true {
-						select {
-						case <-tick:
-							if bl := shard.IsPayStatus(); bl {
-								blPay = true
-								wg.Done()
-								return
-							}
-						case <-timeoutPay.C:
-							return
-						}
-					}
-				}()
-				wg.Wait()
-
-				if blPay == true {
+				if blPay := waitPaid(shard); blPay == true {
 					// pin shardHash
 					err = pinShard(ctxParams, halfSignedGuardContract, fileHash, shardHash)
 					if err != nil {
 						return err
 					}
-					fmt.Printf("upload init: pin shard ok, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+					log.Debugf("upload init: pin shard ok, requestPid:%v, shardIndex:%v", requestPid, shardIndex)
 				} else {
+					// the host keeps nothing of the contract, so it is owed nothing
+					writeOffPayment(requestPid.String(), signedGuardContract.ContractId)
 					// rm shardHash
 					err = rmShard(ctxParams, req, env, shardHash)
 					if err != nil {
 						return err
 					}
-					fmt.Printf("upload init: timeout, remove Shard, requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+					log.Debugf("upload init: timeout, remove shard, requestPid:%v, shardIndex:%v", requestPid, shardIndex)
 				}
 
-				fmt.Printf("upload init: Complete! requestPid:%v, shardIndex:%v. \n", requestPid, shardIndex)
+				log.Debugf("upload init: complete, requestPid:%v, shardIndex:%v", requestPid, shardIndex)
 				if err := shard.Complete(); err != nil {
 					return err
 				}
@@ -307,6 +307,50 @@ the shard and replies back to client for the next challenge step.`,
 	},
 }
 
+// expectPayment records in the ledger that the peer owes amount times rate of
+// the token for the contract.
+func expectPayment(peer string, contractId string, amount int64, rate *big.Int, token common.Address) {
+	if chain.SettleObject.Accounting == nil {
+		return
+	}
+	err := chain.SettleObject.Accounting.ExpectPayment(peer, contractId, new(big.Int).Mul(big.NewInt(amount), rate), token)
+	if err != nil {
+		log.Errorf("record expected payment of contract %s: %v", contractId, err)
+	}
+}
+
+// writeOffPayment records in the ledger that the payment of the contract is no
+// longer expected.
+func writeOffPayment(peer string, contractId string) {
+	if chain.SettleObject.Accounting == nil {
+		return
+	}
+	if err := chain.SettleObject.Accounting.WriteOff(peer, contractId); err != nil {
+		log.Errorf("write off payment of contract %s: %v", contractId, err)
+	}
+}
+
+// waitPaid waits for the cheque paying the contract of a shard, and reports
+// whether it came in time.
+func waitPaid(shard *sessions.HostShard) bool {
+	// every 30s check pay status
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	// total timeout for checking pay status
+	timeoutPay := time.NewTimer(10 * time.Minute)
+	defer timeoutPay.Stop()
+	for {
+		select {
+		case <-tick.C:
+			if shard.IsPayStatus() {
+				return true
+			}
+		case <-timeoutPay.C:
+			return false
+		}
+	}
+}
+
 func challengeShard(ctxParams *uh.ContextParams, fileHash string, isRepair bool, guardContractMeta *guardpb.ContractMeta) error {
 	in := &guardpb.ReadyForChallengeRequest{
 		RenterPid:   guardContractMeta.RenterPid,
@@ -493,6 +537,10 @@ func rmShard(ctxParams *uh.ContextParams, req *cmds.Request, env cmds.Environmen
 		// may have been cleaned up already, ignore
 		return errors.New("rmShard, stale contracts clean up error:" + err.Error())
 	}
+	if err := challenge.RemoveSegmentTree(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(),
+		shardHash); err != nil {
+		log.Errorf("remove segment tree of shard %s: %v", shardHash, err)
+	}
 
 	return nil
 }
@@ -542,6 +590,11 @@ func downloadShardFromClient(ctxParams *uh.ContextParams, guardContract *guardpb
 		return fmt.Errorf("failed to download shard %s from file %s with contract id %s: [%v]",
 			guardContract.ShardHash, guardContract.FileHash, guardContract.ContractId, err)
 	}
+	// segment challenges of the shard are only answered from its tree
+	if _, err := challenge.PrecomputeSegmentTree(context.Background(), ctxParams.N, ctxParams.Api,
+		shardCid); err != nil {
+		return fmt.Errorf("precompute segment tree of shard %s: %v", shardHash, err)
+	}
 	return nil
 }
 
@@ -0,0 +1,175 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/bittorrent/protobuf/proto"
+
+	"github.com/ethereum/go-ethereum/common"
+	cidlib "github.com/ipfs/go-cid"
+)
+
+var StorageUploadRenewInitCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Extend the contract of a stored shard with the renter.",
+		ShortDescription: `
+Storage host opens this endpoint to accept the renewal of a contract it holds.
+If the renewal starts where the contract ends and pays for the added days, the
+host signs it, and keeps the shard pinned until the new end once the renter paid.`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("contract-id", true, false, "ID of the contract to extend."),
+		cmds.StringArg("guard-contract", true, false, "Renter signed guard contract of the renewal."),
+	},
+	RunTimeout: time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		ctxParams, err := uh.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageHostEnabled {
+			return fmt.Errorf("storage host api not enabled")
+		}
+		requestPid, ok := remote.GetStreamRequestRemotePeerID(req, ctxParams.N)
+		if !ok {
+			return fmt.Errorf("fail to get peer ID from request")
+		}
+
+		renewal := &guardpb.Contract{}
+		if err := proto.Unmarshal([]byte(req.Arguments[1]), renewal); err != nil {
+			return err
+		}
+		meta := renewal.ContractMeta
+		if meta.RenterPid != requestPid.String() || meta.HostPid != ctxParams.N.Identity.String() {
+			return errors.New("renewal is not between the requester and this host")
+		}
+		renterPubKey, err := crypto.GetPubKeyFromPeerId(meta.RenterPid)
+		if err != nil {
+			return err
+		}
+		if ok, err := crypto.Verify(renterPubKey, &meta, renewal.RenterSignature); !ok || err != nil {
+			return fmt.Errorf("can't verify guard contract: %v", err)
+		}
+
+		prev, err := sessions.GetHostShardContract(ctxParams, req.Arguments[0])
+		if err != nil {
+			return fmt.Errorf("contract %s not found: %v", req.Arguments[0], err)
+		}
+		if canceled, err := sessions.IsHostShardCanceled(ctxParams, prev.ContractId); err != nil {
+			return err
+		} else if canceled {
+			return fmt.Errorf("contract %s was canceled", prev.ContractId)
+		}
+		if err := checkRenewal(&prev.ContractMeta, &meta); err != nil {
+			return err
+		}
+		shardCid, err := cidlib.Parse(meta.ShardHash)
+		if err != nil {
+			return err
+		}
+		if has, err := ctxParams.N.Blockstore.Has(req.Context, shardCid); err != nil || !has {
+			return fmt.Errorf("shard %s is not stored anymore", meta.ShardHash)
+		}
+
+		// check renter-token, price and amount as on upload init
+		token := common.HexToAddress(renewal.Token)
+		if _, ok := tokencfg.MpTokenStr[token]; !ok {
+			return errors.New("receive renew, your input token is not supported. " + token.String())
+		}
+		priceOnline, err := chain.SettleObject.OracleService.CurrentPrice(token)
+		if err != nil {
+			return err
+		}
+		if meta.Price < priceOnline.Int64() {
+			return fmt.Errorf("receive renew, your renter-price[%v] is less than online-price[%v]. ",
+				meta.Price, priceOnline)
+		}
+		rate, err := chain.SettleObject.OracleService.CurrentRate(token)
+		if err != nil {
+			return err
+		}
+		amountCal, err := uh.TotalPay(meta.ShardFileSize, meta.Price, renewalDays(&meta), rate)
+		if err != nil {
+			return err
+		}
+		if meta.Amount < amountCal {
+			return fmt.Errorf("receive renew, your renter-amount[%v] is less than cal-amount[%v]. ",
+				meta.Amount, amountCal)
+		}
+
+		signed, err := signGuardContract(&meta, renewal, ctxParams.N.PrivateKey)
+		if err != nil {
+			return err
+		}
+		signedBytes, err := proto.Marshal(signed)
+		if err != nil {
+			return err
+		}
+		shard, err := sessions.GetHostShard(ctxParams, meta.ContractId, meta.Price, meta.Amount, rate)
+		if err != nil {
+			return err
+		}
+		if err := shard.Contract(nil, signed); err != nil {
+			return err
+		}
+		expectPayment(requestPid.String(), meta.ContractId, meta.Amount, rate, token)
+
+		go func() {
+			if !waitPaid(shard) {
+				log.Debugf("renew: contract %s was not paid", meta.ContractId)
+				return
+			}
+			// pins the shard again until the new end of the contract
+			if err := pinShard(ctxParams, signed, meta.FileHash, meta.ShardHash); err != nil {
+				log.Debug(err)
+				return
+			}
+			if err := shard.Complete(); err != nil {
+				log.Debug(err)
+			}
+		}()
+		return res.Emit(&RenewContractRes{Contract: signedBytes})
+	},
+	Type: RenewContractRes{},
+}
+
+type RenewContractRes struct {
+	Contract []byte
+}
+
+// checkRenewal checks a renewal extends the previous contract of the same shard.
+func checkRenewal(prev, renewal *guardpb.ContractMeta) error {
+	if prev.RenterPid != renewal.RenterPid || prev.HostPid != renewal.HostPid ||
+		prev.FileHash != renewal.FileHash || prev.ShardHash != renewal.ShardHash ||
+		prev.ShardIndex != renewal.ShardIndex || prev.ShardFileSize != renewal.ShardFileSize {
+		return errors.New("renewal does not extend the contract of the same shard")
+	}
+	if renewal.RentStart.Before(prev.RentEnd) {
+		return fmt.Errorf("renewal starts at %s, before the contract ends at %s", renewal.RentStart, prev.RentEnd)
+	}
+	if renewalDays(renewal) < 1 {
+		return errors.New("renewal must extend the contract by at least a day")
+	}
+	return nil
+}
+
+// renewalDays is the number of days a contract is extended by.
+func renewalDays(meta *guardpb.ContractMeta) int {
+	return int(meta.RentEnd.Sub(meta.RentStart) / (24 * time.Hour))
+}
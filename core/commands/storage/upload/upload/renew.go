@@ -0,0 +1,645 @@
+package upload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	"github.com/bittorrent/go-btfs/settlement/swap/swapprotocol"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	"github.com/bittorrent/go-btfs-common/crypto"
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/bittorrent/protobuf/proto"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var StorageUploadRenewCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Extend the storage of an uploaded file with the same hosts.",
+		ShortDescription: `
+This command extends the contracts of a completed upload session by the number
+of days given with --storage-length. The hosts that store the shards sign new
+contracts that start where the current ones end, only the added days are paid,
+and the file meta is updated on the guard. The file is not renewed unless every host agrees.
+A renewal that is interrupted after the hosts signed it, by a restart or an
+error, is resumed by the next renewal of the session without paying twice, and
+only one renewal of a file runs at a time.
+
+The file can be given by session ID or by file hash, in which case the latest
+completed session of the file is renewed:
+    $ btfs storage upload renew <session-id|file-hash> --len 30
+
+Files can also be renewed automatically before they expire, within a budget:
+    $ btfs config --json Upload.AutoRenew '{"Enabled": true, "Days": 30, "Before": 7, "Budget": 100000000}'`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID of the completed upload session, or hash of the uploaded file."),
+	},
+	RunTimeout: 15 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+		swapprotocol.Req = req
+		swapprotocol.Env = env
+
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		if !ctxParams.Cfg.Experimental.StorageClientEnabled {
+			return fmt.Errorf("storage client api not enabled")
+		}
+		rss, err := renewableSession(ctxParams, req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		days := req.Options[storageLengthOptionName].(int)
+		if days < 1 {
+			return errors.New("storage length must be at least a day")
+		}
+		r, err := RenewSession(req.Context, rss, days, false, 0)
+		if err != nil {
+			return err
+		}
+		return res.Emit(r)
+	},
+	Type: sessions.Renewal{},
+}
+
+// renewableSession returns the completed session with the given ID, or the
+// latest completed session of the file with the given hash.
+func renewableSession(ctxParams *helper.ContextParams, arg string) (*sessions.RenterSession, error) {
+	if _, err := uuid.Parse(arg); err == nil {
+		rss, err := sessions.GetRenterSession(ctxParams, arg, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return nil, err
+		}
+		if status.Status != sessions.RssCompleteStatus {
+			return nil, fmt.Errorf("session %s is %s, only completed sessions can be renewed", arg, status.Status)
+		}
+		return rss, nil
+	}
+	cursor, err := sessions.GetRenterSessionsCursor(ctxParams)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		latest *sessions.RenterSession
+		last   time.Time
+	)
+	for {
+		rss, err := cursor.NextSession(sessions.RssCompleteStatus)
+		if err != nil {
+			break
+		}
+		if rss.Hash != arg {
+			continue
+		}
+		status, err := rss.Status()
+		if err != nil {
+			return nil, err
+		}
+		if latest == nil || status.LastUpdated.After(last) {
+			latest, last = rss, status.LastUpdated
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no completed upload session of %s", arg)
+	}
+	return latest, nil
+}
+
+// renewLocks serializes the renewals of each file, so that an automatic
+// renewal does not run along with a manual one.
+var renewLocks = struct {
+	sync.Mutex
+	files map[string]*sync.Mutex
+}{files: make(map[string]*sync.Mutex)}
+
+// lockRenewal waits until no other renewal of the file runs, and returns the
+// function that lets the next one run.
+func lockRenewal(fileHash string) func() {
+	renewLocks.Lock()
+	l, ok := renewLocks.files[fileHash]
+	if !ok {
+		l = new(sync.Mutex)
+		renewLocks.files[fileHash] = l
+	}
+	renewLocks.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// RenewSession extends the contracts of a completed session by the given days
+// with the hosts that store its shards, pays for the added days and updates the
+// file meta on the guard. A renewal that costs more than limit is not started,
+// a limit of 0 sets no limit. A renewal of the session that was interrupted
+// after its contracts were signed is resumed instead, without paying again the
+// shards it already paid.
+func RenewSession(ctx context.Context, rss *sessions.RenterSession, days int, auto bool, limit int64) (*sessions.Renewal, error) {
+	unlock := lockRenewal(rss.Hash)
+	defer unlock()
+	return renewSession(ctx, rss, days, auto, limit)
+}
+
+// renewSession is RenewSession with the renewal lock of the file held.
+func renewSession(ctx context.Context, rss *sessions.RenterSession, days int, auto bool, limit int64) (*sessions.Renewal, error) {
+	token := tokencfg.GetWbttToken()
+	fileSize := int64(0)
+	if params, err := rss.UploadParams(); err == nil {
+		token = params.Token
+		fileSize = params.FileSize
+	}
+	rss.Token = token
+	priceOnline, err := chain.SettleObject.OracleService.CurrentPrice(token)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := chain.SettleObject.OracleService.CurrentRate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := pendingRenewal(rss)
+	if err != nil {
+		return nil, err
+	}
+	if r != nil {
+		log.Infof("resume renewal %s of session %s", r.Id, rss.SsId)
+	} else if r, err = newRenewal(rss, days, auto, priceOnline.Int64(), rate); err != nil {
+		return nil, err
+	}
+	if fileSize <= 0 {
+		if fileSize, err = shardsSize(rss); err != nil {
+			return nil, err
+		}
+	}
+	var unpaid int64
+	for _, s := range r.Shards {
+		if s.Status != sessions.RenewPaidStatus {
+			unpaid += s.Amount
+		}
+	}
+	if limit > 0 && unpaid > limit {
+		return nil, fmt.Errorf("renewal of session %s costs %d, more than the limit of %d", rss.SsId, unpaid, limit)
+	}
+	if err := checkAvailableBalance(ctx, unpaid, token); err != nil {
+		return nil, err
+	}
+	if err := rss.SaveRenewal(r); err != nil {
+		return nil, err
+	}
+	renewEvent(rss, r, nil)
+
+	fail := func(err error) (*sessions.Renewal, error) {
+		r.Status = sessions.RenewErrorStatus
+		r.Message = err.Error()
+		if serr := rss.SaveRenewal(r); serr != nil {
+			log.Errorf("save renewal %s error: %v", r.Id, serr)
+		}
+		renewEvent(rss, r, err)
+		return r, err
+	}
+
+	// all the hosts sign the renewal before anything is paid
+	var wg sync.WaitGroup
+	for _, s := range r.Shards {
+		if s.Status == sessions.RenewContractStatus || s.Status == sessions.RenewPaidStatus {
+			continue
+		}
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, s.ShardHash, s.ShardIndex)
+		if err != nil {
+			return fail(err)
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return fail(err)
+		}
+		prev := contracts.SignedGuardContract
+		if prev == nil || prev.ContractId != s.PrevContractId {
+			return fail(fmt.Errorf("shard %d of session %s does not have the contract to renew", s.ShardIndex, rss.SsId))
+		}
+		// a host may have signed the contract of an interrupted renewal, it signs
+		// another one
+		s.ContractId = helper.NewContractID(rss.SsId)
+		wg.Add(1)
+		go func(s *sessions.RenewedShard) {
+			defer wg.Done()
+			c, err := renewShardContract(ctx, rss, prev, s, r.Days, token)
+			if err == nil {
+				s.Contract, err = proto.Marshal(c)
+			}
+			if err != nil {
+				s.Status, s.Error = sessions.RenewErrorStatus, err.Error()
+				return
+			}
+			s.Status, s.Error = sessions.RenewContractStatus, ""
+		}(s)
+	}
+	wg.Wait()
+	refused := make([]string, 0)
+	for _, s := range r.Shards {
+		if s.Status == sessions.RenewErrorStatus {
+			refused = append(refused, fmt.Sprintf("shard %d on host %s: %s", s.ShardIndex, s.Host, s.Error))
+		}
+	}
+	if len(refused) > 0 {
+		return fail(fmt.Errorf("hosts did not sign the renewal: %s", strings.Join(refused, "; ")))
+	}
+	r.Status = sessions.RenewContractStatus
+	if err := rss.SaveRenewal(r); err != nil {
+		return nil, err
+	}
+
+	contracts := make([]*guardpb.Contract, len(r.Shards))
+	for i, s := range r.Shards {
+		contracts[i] = new(guardpb.Contract)
+		if err := proto.Unmarshal(s.Contract, contracts[i]); err != nil {
+			return fail(err)
+		}
+		if s.Status == sessions.RenewPaidStatus {
+			continue
+		}
+		realAmount, err := getRealAmount(s.Amount, token)
+		if err != nil {
+			return fail(err)
+		}
+		err = chain.SettleObject.SwapService.Settle(s.Host, realAmount, s.ContractId, token)
+		event := sessions.ShardEvent(sessions.EventCheque, s.ShardIndex, s.ShardHash, s.Host, err)
+		event.Message = fmt.Sprintf("amount %s of token %s for renewed contract %s", realAmount, token, s.ContractId)
+		rss.AddEvent(event)
+		if err != nil {
+			s.Error = err.Error()
+			return fail(fmt.Errorf("pay shard %d of the renewal: %v", s.ShardIndex, err))
+		}
+		s.Status = sessions.RenewPaidStatus
+		if r.Auto {
+			err := sessions.AddAutoRenewSpent(rss.CtxParams.N.Repo.Datastore(), rss.CtxParams.N.Identity.String(), s.Amount)
+			if err != nil {
+				log.Errorf("add amount of renewal %s to the auto renew spent error: %v", r.Id, err)
+			}
+		}
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, s.ShardHash, s.ShardIndex)
+		if err != nil {
+			return fail(err)
+		}
+		if err := shard.Renewed(contracts[i]); err != nil {
+			return fail(err)
+		}
+		if err := rss.SaveRenewal(r); err != nil {
+			return nil, err
+		}
+	}
+	r.Status = sessions.RenewPaidStatus
+	if err := rss.SaveRenewal(r); err != nil {
+		return nil, err
+	}
+
+	// the shards are paid, the guard is asked again until it takes the file meta
+	fsStatus, sig, err := renewedFileMeta(rss, contracts, fileSize, token)
+	if err == nil {
+		err = backoff.Retry(func() error {
+			_, err := submitFileMetaHelper(ctx, rss.CtxParams.Cfg, fsStatus, sig)
+			if err != nil {
+				log.Debugf("renewal %s: submit file meta error: %v", r.Id, err)
+			}
+			return err
+		}, backoff.WithContext(helper.SubmitFileMetaBo(5*time.Minute), ctx))
+	}
+	if err != nil {
+		guardEvent(rss, "renewed file meta submitted", err)
+		return fail(err)
+	}
+	guardEvent(rss, "renewed file meta submitted", nil)
+	r.Status = sessions.RenewCompleteStatus
+	r.Message = ""
+	if err := rss.SaveRenewal(r); err != nil {
+		return nil, err
+	}
+	renewEvent(rss, r, nil)
+	return r, nil
+}
+
+// pendingRenewal returns the renewal of the session that was interrupted after
+// its contracts were signed, or nil. A renewal interrupted before is given up,
+// nothing was paid for it.
+func pendingRenewal(rss *sessions.RenterSession) (*sessions.Renewal, error) {
+	renewals, err := rss.Renewals()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(renewals) - 1; i >= 0; i-- {
+		r := renewals[i]
+		if r.Status == sessions.RenewCompleteStatus {
+			continue
+		}
+		paid := false
+		for _, s := range r.Shards {
+			paid = paid || s.Status == sessions.RenewPaidStatus
+		}
+		switch {
+		case paid || r.Status == sessions.RenewContractStatus || r.Status == sessions.RenewPaidStatus:
+			return r, nil
+		case r.Status == sessions.RenewPendingStatus:
+			r.Status = sessions.RenewErrorStatus
+			r.Message = "interrupted before the contracts were signed"
+			if err := rss.SaveRenewal(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// newRenewal prices the extension of the contracts of the session by the given
+// days.
+func newRenewal(rss *sessions.RenterSession, days int, auto bool, priceOnline int64, rate *big.Int) (*sessions.Renewal, error) {
+	now := time.Now().UTC()
+	r := &sessions.Renewal{
+		Id:        uuid.New().String(),
+		SessionId: rss.SsId,
+		FileHash:  rss.Hash,
+		Days:      days,
+		Auto:      auto,
+		Status:    sessions.RenewPendingStatus,
+		Created:   now,
+	}
+	for pos, h := range rss.ShardHashes {
+		i := rss.ShardIndex(pos)
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, i)
+		if err != nil {
+			return nil, err
+		}
+		if canceled, err := shard.IsCanceled(); err != nil {
+			return nil, err
+		} else if canceled {
+			return nil, fmt.Errorf("the contract of shard %d of session %s was canceled", i, rss.SsId)
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return nil, err
+		}
+		prev := contracts.SignedGuardContract
+		if prev == nil {
+			return nil, fmt.Errorf("shard %d of session %s has no contract", i, rss.SsId)
+		}
+		// hosts do not take less than the current price
+		price := prev.Price
+		if price < priceOnline {
+			price = priceOnline
+		}
+		amount, err := helper.TotalPay(prev.ShardFileSize, price, days, rate)
+		if err != nil {
+			return nil, err
+		}
+		start := prev.RentEnd
+		if start.Before(now) {
+			start = now
+		}
+		r.Shards = append(r.Shards, &sessions.RenewedShard{
+			ShardIndex:     i,
+			ShardHash:      h,
+			Host:           prev.HostPid,
+			PrevContractId: prev.ContractId,
+			RentEnd:        start.Add(time.Duration(days) * 24 * time.Hour),
+			Price:          price,
+			Amount:         amount,
+			Status:         sessions.RenewPendingStatus,
+		})
+		r.Amount += amount
+	}
+	return r, nil
+}
+
+// shardsSize returns the size of the shards of the session.
+func shardsSize(rss *sessions.RenterSession) (int64, error) {
+	var size int64
+	for i := range rss.ShardHashes {
+		shard, err := rss.Shard(i)
+		if err != nil {
+			return 0, err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return 0, err
+		}
+		if c := contracts.SignedGuardContract; c != nil {
+			size += c.ShardFileSize
+		}
+	}
+	return size, nil
+}
+
+// renewShardContract has the host of a shard sign the contract that extends its
+// current one by the given days.
+func renewShardContract(ctx context.Context, rss *sessions.RenterSession, prev *guardpb.Contract,
+	s *sessions.RenewedShard, days int, token common.Address) (*guardpb.Contract, error) {
+	meta := prev.ContractMeta
+	meta.ContractId = s.ContractId
+	meta.RentStart = s.RentEnd.Add(-time.Duration(days) * 24 * time.Hour)
+	meta.RentEnd = s.RentEnd
+	meta.Price = s.Price
+	meta.Amount = s.Amount
+	sig, err := crypto.Sign(rss.CtxParams.N.PrivateKey, &meta)
+	if err != nil {
+		return nil, err
+	}
+	renewal := &guardpb.Contract{
+		ContractMeta:    meta,
+		State:           guardpb.Contract_RENEWED,
+		RenterSignature: sig,
+		LastModifyTime:  time.Now(),
+		PreparerPid:     meta.RenterPid,
+		Token:           token.String(),
+	}
+	renewalBytes, err := proto.Marshal(renewal)
+	if err != nil {
+		return nil, err
+	}
+	hostPid, err := peer.Decode(s.Host)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	output, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/renewinit",
+		s.PrevContractId, renewalBytes)
+	if err != nil {
+		return nil, err
+	}
+	res := new(RenewContractRes)
+	if err := json.Unmarshal(output, res); err != nil {
+		return nil, err
+	}
+	signed := new(guardpb.Contract)
+	if err := proto.Unmarshal(res.Contract, signed); err != nil {
+		return nil, err
+	}
+	sm := signed.ContractMeta
+	if sm.ContractId != meta.ContractId || sm.ShardHash != meta.ShardHash || !sm.RentStart.Equal(meta.RentStart) ||
+		!sm.RentEnd.Equal(meta.RentEnd) || sm.Price != meta.Price || sm.Amount != meta.Amount {
+		return nil, errors.New("host signed another contract than the renewal")
+	}
+	hostPubKey, err := hostPid.ExtractPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := crypto.Verify(hostPubKey, &signed.ContractMeta, signed.HostSignature); !ok || err != nil {
+		return nil, fmt.Errorf("can't verify host signature: %v", err)
+	}
+	return signed, nil
+}
+
+// renewedFileMeta returns the file meta with the renewed contracts of the file,
+// and its signature for the guard.
+func renewedFileMeta(rss *sessions.RenterSession, contracts []*guardpb.Contract,
+	fileSize int64, token common.Address) (*guardpb.FileStoreStatus, []byte, error) {
+	fsStatus, err := NewFileStatus(contracts, rss.CtxParams.Cfg, contracts[0].ContractMeta.RenterPid, rss.Hash, fileSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	fsStatus.RentalState = guardpb.FileStoreStatus_RENEW
+	fsStatus.FileStoreMeta.Token = token.String()
+	sig, err := crypto.Sign(rss.CtxParams.N.PrivateKey, &fsStatus.FileStoreMeta)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fsStatus, sig, nil
+}
+
+// renewEvent records the status of a renewal in the session history.
+func renewEvent(rss *sessions.RenterSession, r *sessions.Renewal, err error) {
+	event := &sessions.Event{
+		Type:    sessions.EventRenew,
+		Status:  r.Status,
+		Message: fmt.Sprintf("renewal %s by %d days for amount %d", r.Id, r.Days, r.Amount),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	rss.AddEvent(event)
+}
+
+// AutoRenew renews the files whose contracts end within Upload.AutoRenew.Before
+// days, as long as the amount spent by the automatic renewals stays within the
+// budget. Interrupted renewals are resumed first.
+func AutoRenew(ctx context.Context, ctxParams *helper.ContextParams) error {
+	cfg := helper.GetUploadConfig(ctxParams).AutoRenew
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Budget <= 0 {
+		log.Warn("auto renew is enabled without a budget")
+		return nil
+	}
+	cursor, err := sessions.GetRenterSessionsCursor(ctxParams)
+	if err != nil {
+		return err
+	}
+	for {
+		rss, err := cursor.NextSession(sessions.RssCompleteStatus)
+		if err != nil {
+			break
+		}
+		if err := autoRenewSession(ctx, rss, cfg); err == errAutoRenewBudget {
+			log.Warnf("auto renew budget of %d is used up", cfg.Budget)
+			return nil
+		} else if err != nil {
+			log.Errorf("auto renew: session %s: %v", rss.SsId, err)
+		}
+	}
+	return nil
+}
+
+var errAutoRenewBudget = errors.New("auto renew budget is used up")
+
+// autoRenewSession renews the session when its contracts are about to end, or
+// resumes its interrupted renewal.
+func autoRenewSession(ctx context.Context, rss *sessions.RenterSession, cfg helper.AutoRenewConfig) error {
+	unlock := lockRenewal(rss.Hash)
+	defer unlock()
+	pending, err := pendingRenewal(rss)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		end, err := contractsEnd(rss)
+		if err != nil {
+			log.Debugf("auto renew: session %s: %v", rss.SsId, err)
+			return nil
+		}
+		// expired files are not renewed, the hosts may not store them anymore
+		now := time.Now()
+		if end.Before(now) || end.After(now.Add(time.Duration(cfg.Before)*24*time.Hour)) {
+			return nil
+		}
+	}
+	spent, err := sessions.AutoRenewSpent(rss.CtxParams.N.Repo.Datastore(), rss.CtxParams.N.Identity.String())
+	if err != nil {
+		return err
+	}
+	if spent >= cfg.Budget {
+		return errAutoRenewBudget
+	}
+	days := cfg.Days
+	if days <= 0 {
+		days = defaultStorageLength
+		if params, err := rss.UploadParams(); err == nil && params.StorageLength > 0 {
+			days = params.StorageLength
+		}
+	}
+	r, err := renewSession(ctx, rss, days, true, cfg.Budget-spent)
+	if err != nil {
+		return err
+	}
+	log.Infof("auto renew: renewed session %s by %d days for %d", rss.SsId, r.Days, r.Amount)
+	return nil
+}
+
+// contractsEnd returns when the first contract of a session ends.
+func contractsEnd(rss *sessions.RenterSession) (time.Time, error) {
+	var end time.Time
+	for i := range rss.ShardHashes {
+		shard, err := rss.Shard(i)
+		if err != nil {
+			return end, err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return end, err
+		}
+		c := contracts.SignedGuardContract
+		if c == nil {
+			return end, fmt.Errorf("shard %d has no contract", rss.ShardIndex(i))
+		}
+		if end.IsZero() || c.RentEnd.Before(end) {
+			end = c.RentEnd
+		}
+	}
+	if end.IsZero() {
+		return end, errors.New("no contracts")
+	}
+	return end, nil
+}
@@ -0,0 +1,130 @@
+package upload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/test/fakeservices"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRenewal(t *testing.T) {
+	end := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	prev := &guardpb.ContractMeta{
+		RenterPid:     "renter",
+		HostPid:       "host",
+		FileHash:      "QmFile",
+		ShardHash:     "QmShard",
+		ShardIndex:    1,
+		ShardFileSize: 1024,
+		RentStart:     end.Add(-30 * 24 * time.Hour),
+		RentEnd:       end,
+	}
+	renewal := *prev
+	renewal.RentStart = end
+	renewal.RentEnd = end.Add(10 * 24 * time.Hour)
+	assert.NoError(t, checkRenewal(prev, &renewal))
+	assert.Equal(t, 10, renewalDays(&renewal))
+
+	other := renewal
+	other.ShardHash = "QmOther"
+	assert.Error(t, checkRenewal(prev, &other))
+
+	early := renewal
+	early.RentStart = end.Add(-time.Hour)
+	assert.Error(t, checkRenewal(prev, &early))
+
+	short := renewal
+	short.RentEnd = end.Add(time.Hour)
+	assert.Error(t, checkRenewal(prev, &short))
+}
+
+func TestResumeRenewal(t *testing.T) {
+	_, payments := setupSettlement(t, 100)
+	services, err := fakeservices.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer services.Close()
+	ssId := "5a7e2c91-3d8b-4f06-b1e4-9c2d7f0a6e35"
+	ctxParams := newContractedSession(t, ssId, 2, append(toPayEvents, sessions.RssToCompleteEvent)...)
+	ctxParams.Cfg.Services = config.DefaultServicesConfig()
+	services.Configure(ctxParams.Cfg)
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the renewal was interrupted after the first shard was paid
+	r := &sessions.Renewal{
+		Id:        "renewal",
+		SessionId: ssId,
+		FileHash:  rss.Hash,
+		Days:      30,
+		Status:    sessions.RenewContractStatus,
+		Amount:    20,
+		Created:   time.Now(),
+	}
+	for i, h := range rss.ShardHashes {
+		c, err := proto.Marshal(&guardpb.Contract{ContractMeta: guardpb.ContractMeta{
+			ContractId: "renewed-" + h,
+			RenterPid:  ctxParams.N.Identity.String(),
+			HostPid:    "host-" + h,
+			FileHash:   rss.Hash,
+			ShardHash:  h,
+			ShardIndex: int32(i),
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Shards = append(r.Shards, &sessions.RenewedShard{
+			ShardIndex:     i,
+			ShardHash:      h,
+			Host:           "host-" + h,
+			PrevContractId: "contract-" + h,
+			ContractId:     "renewed-" + h,
+			Amount:         10,
+			Status:         sessions.RenewContractStatus,
+			Contract:       c,
+		})
+	}
+	r.Shards[0].Status = sessions.RenewPaidStatus
+	if err := rss.SaveRenewal(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// the renewal is resumed whatever the days asked, and the paid shard is not paid again
+	resumed, err := RenewSession(context.Background(), rss, 10, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, r.Id, resumed.Id)
+	assert.Equal(t, 30, resumed.Days)
+	assert.Equal(t, sessions.RenewCompleteStatus, resumed.Status)
+	assert.Equal(t, []string{"renewed-QmShardb"}, payments.paid())
+	shard, err := sessions.GetRenterShard(ctxParams, ssId, "QmShardb", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contracts, err := shard.Contracts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "renewed-QmShardb", contracts.SignedGuardContract.ContractId)
+	fs := services.Guard.FileStatus(ctxParams.N.Identity.String(), rss.Hash)
+	if assert.NotNil(t, fs) {
+		assert.Equal(t, guardpb.FileStoreStatus_RENEW, fs.RentalState)
+		assert.Equal(t, 2, len(fs.Contracts))
+	}
+
+	// nothing is left to resume
+	pending, err := pendingRenewal(rss)
+	assert.NoError(t, err)
+	assert.Nil(t, pending)
+}
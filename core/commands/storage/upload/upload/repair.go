@@ -3,8 +3,10 @@ package upload
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/bittorrent/go-btfs/chain/tokencfg"
 	"github.com/bittorrent/go-btfs/utils"
+	"math"
 	"strings"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
 	"github.com/bittorrent/go-btfs-common/utils/grpc"
 
+	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -43,66 +46,129 @@ This command repairs the given shards of a file.`,
 		if err != nil {
 			return err
 		}
-		fileHash := req.Arguments[0]
-		metaReq := &guardpb.CheckFileStoreMetaRequest{
-			FileHash:     fileHash,
-			RenterPid:    ctxParams.N.Identity.String(),
-			RequesterPid: ctxParams.N.Identity.String(),
-			RequestTime:  time.Now().UTC(),
-		}
-		sig, err := crypto.Sign(ctxParams.N.PrivateKey, metaReq)
+		renterPid, err := peer.Decode(req.Arguments[2])
 		if err != nil {
 			return err
 		}
-		metaReq.Signature = sig
 		ctx, _ := helper.NewGoContext(req.Context)
-		var meta *guardpb.FileStoreStatus
-		err = grpc.GuardClient(ctxParams.Cfg.Services.GuardDomain).WithContext(ctx, func(ctx context.Context,
-			client guardpb.GuardServiceClient) error {
-			meta, err = client.CheckFileStoreMeta(ctx, metaReq)
-			if err != nil {
-				return err
-			}
-			return nil
-		})
+		ssId, err := RepairShards(ctx, ctxParams, req.Arguments[0], strings.Split(req.Arguments[1], ","),
+			renterPid, strings.Split(req.Arguments[3], ","), nil)
 		if err != nil {
 			return err
 		}
-		contracts := meta.Contracts
-		if len(contracts) <= 0 {
-			return errors.New("length of contracts is 0")
-		}
-		ssId, _ := uh.SplitContractId(contracts[0].ContractId)
-		shardIndexes := make([]int, 0)
-		i := 0
-		shardHashes := strings.Split(req.Arguments[1], ",")
-		for _, contract := range contracts {
-			if contract.ShardHash == shardHashes[i] {
-				shardIndexes = append(shardIndexes, int(contract.ShardIndex))
-				i++
-			}
+		seRes := &Res{
+			ID: ssId,
 		}
-		rss, err := sessions.GetRenterSession(ctxParams, ssId, fileHash, shardHashes)
+		return res.Emit(seRes)
+	},
+	Type: Res{},
+}
+
+// RepairShards uploads the given shards of a file again to hosts that are not
+// in the blacklist, for the rest of the rent period of its contracts on the
+// guard. The shards are placed by a new session that holds only them, at their
+// index in the file, and its ID is returned. The repair is recorded on the
+// session of the file, if given.
+func RepairShards(ctx context.Context, ctxParams *uh.ContextParams, fileHash string, shardHashes []string,
+	renterPid peer.ID, blacklist []string, of *sessions.RenterSession) (string, error) {
+	if len(shardHashes) == 0 {
+		return "", errors.New("no shards to repair")
+	}
+	metaReq := &guardpb.CheckFileStoreMetaRequest{
+		FileHash:     fileHash,
+		RenterPid:    ctxParams.N.Identity.String(),
+		RequesterPid: ctxParams.N.Identity.String(),
+		RequestTime:  time.Now().UTC(),
+	}
+	sig, err := crypto.Sign(ctxParams.N.PrivateKey, metaReq)
+	if err != nil {
+		return "", err
+	}
+	metaReq.Signature = sig
+	var meta *guardpb.FileStoreStatus
+	err = grpc.GuardClient(ctxParams.Cfg.Services.GuardDomain).WithContext(ctx, func(ctx context.Context,
+		client guardpb.GuardServiceClient) error {
+		meta, err = client.CheckFileStoreMeta(ctx, metaReq)
 		if err != nil {
 			return err
 		}
-		hp := uh.GetHostsProvider(ctxParams, strings.Split(req.Arguments[3], ","))
-		m := contracts[0].ContractMeta
-		renterPid, err := peer.Decode(req.Arguments[2])
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	contracts := make(map[string]*guardpb.Contract)
+	for _, c := range meta.Contracts {
+		contracts[c.ShardHash] = c
+	}
+	if len(contracts) == 0 {
+		return "", errors.New("length of contracts is 0")
+	}
+	shardIndexes := make([]int, len(shardHashes))
+	for i, h := range shardHashes {
+		c, ok := contracts[h]
+		if !ok {
+			return "", fmt.Errorf("shard %s is not a shard of file %s", h, fileHash)
+		}
+		shardIndexes[i] = int(c.ShardIndex)
+	}
+
+	m := contracts[shardHashes[0]].ContractMeta
+	// the new hosts are paid for the days left of the rent period
+	storageLength := int(math.Ceil(time.Until(m.RentEnd).Hours() / 24))
+	if storageLength <= 0 {
+		return "", fmt.Errorf("the rent period of file %s ended at %s", fileHash, m.RentEnd)
+	}
+
+	ssId := uuid.New().String()
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, fileHash, shardHashes)
+	if err != nil {
+		return "", err
+	}
+	repair := &sessions.Repair{ShardIndexes: shardIndexes}
+	if of != nil {
+		repair.SessionId = of.SsId
+	}
+	if err := rss.SaveRepair(repair); err != nil {
+		return "", err
+	}
+	token := tokencfg.GetWbttToken()
+	rss.Token = token
+	if err := rss.SaveUploadParams(&sessions.UploadParams{
+		Price:         m.Price,
+		Token:         token,
+		ShardSize:     m.ShardFileSize,
+		FileSize:      -1,
+		StorageLength: storageLength,
+		RenterId:      renterPid.String(),
+	}); err != nil {
+		return "", err
+	}
+	for i := range shardHashes {
+		shard, err := rss.Shard(i)
 		if err != nil {
-			return err
+			return "", err
+		}
+		if err := shard.Reset(); err != nil {
+			return "", err
 		}
+	}
+	hp := uh.GetHostsProvider(ctxParams, blacklist)
 
-		// token: notice repair is dropped. This is just a compatible function of 'UploadShard'.
-		UploadShard(rss, hp, m.Price, tokencfg.GetWbttToken(), m.ShardFileSize, -1, false, renterPid, -1,
-			shardIndexes, &RepairParams{
-				RenterStart: m.RentStart,
-				RenterEnd:   m.RentEnd,
-			})
-		seRes := &Res{
-			ID: ssId,
+	// token: notice repair is dropped. This is just a compatible function of 'UploadShard'.
+	err = UploadShard(rss, hp, m.Price, token, m.ShardFileSize, storageLength, false, renterPid, -1,
+		shardIndexes, &RepairParams{
+			RenterStart: m.RentStart,
+			RenterEnd:   m.RentEnd,
+		})
+	if err != nil {
+		_ = rss.To(sessions.RssToErrorEvent, err)
+		return "", err
+	}
+	if of != nil {
+		if err := of.AddRepairSession(ssId); err != nil {
+			return "", err
 		}
-		return res.Emit(seRes)
-	},
-	Type: Res{},
+	}
+	return ssId, nil
 }
@@ -0,0 +1,243 @@
+package upload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	"github.com/bittorrent/go-btfs/repo"
+	"github.com/bittorrent/go-btfs/test/fakeservices"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	config "github.com/bittorrent/go-btfs-config"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/stretchr/testify/assert"
+)
+
+// uploadConfigRepo holds the Upload config, that the mock repo does not.
+type uploadConfigRepo struct {
+	repo.Repo
+	upload *helper.UploadConfig
+}
+
+func (r uploadConfigRepo) GetConfigKey(key string) (interface{}, error) {
+	if key == "Upload" {
+		return r.upload, nil
+	}
+	return r.Repo.GetConfigKey(key)
+}
+
+// waitShardEvent waits for an event of the session about the shard, and returns
+// the index it was placed at.
+func waitShardEvent(t *testing.T, rss *sessions.RenterSession, shardHash string) int {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		events, err := rss.Events()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			if e.ShardHash == shardHash && e.ShardIndex != nil {
+				return *e.ShardIndex
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s did not place shard %s", rss.SsId, shardHash)
+	return -1
+}
+
+func TestAuditRepair(t *testing.T) {
+	setupSettlement(t, 100)
+	services, err := fakeservices.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer services.Close()
+	ssId := "c4d81e27-9a3f-4b65-8e0d-2f7b6a1c9e53"
+	ctxParams := newContractedSession(t, ssId, 3, append(toPayEvents, sessions.RssToCompleteEvent)...)
+	ctxParams.Cfg.Services = config.DefaultServicesConfig()
+	services.Configure(ctxParams.Cfg)
+	ctxParams.Api, err = coreapi.NewCoreAPI(ctxParams.N, options.Api.Offline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxParams.N.Repo = uploadConfigRepo{ctxParams.N.Repo, &helper.UploadConfig{
+		Audit: helper.AuditConfig{Repair: true},
+	}}
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the guard lists the contracts in another order than the shards
+	fs := &guardpb.FileStoreStatus{
+		FileStoreMeta: guardpb.FileStoreMeta{
+			RenterPid: ctxParams.N.Identity.String(),
+			FileHash:  rss.Hash,
+		},
+	}
+	for i := len(rss.ShardHashes) - 1; i >= 0; i-- {
+		h := rss.ShardHashes[i]
+		fs.Contracts = append(fs.Contracts, &guardpb.Contract{ContractMeta: guardpb.ContractMeta{
+			ContractId:    "contract-" + h,
+			RenterPid:     ctxParams.N.Identity.String(),
+			HostPid:       "host-" + h,
+			FileHash:      rss.Hash,
+			ShardHash:     h,
+			ShardIndex:    int32(i),
+			ShardFileSize: 1 << 30,
+			Price:         1,
+			RentStart:     time.Now(),
+			RentEnd:       time.Now().Add(30 * 24 * time.Hour),
+		}})
+	}
+	if _, err := services.Guard.SubmitFileStoreMeta(context.Background(), fs); err != nil {
+		t.Fatal(err)
+	}
+	// the hosts do not store the shards anymore
+	for i, h := range rss.ShardHashes {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := shard.Cancel(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := AuditSession(context.Background(), rss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, a.AtRisk)
+	assert.Empty(t, a.Error)
+	assert.Equal(t, rss.ShardHashes, a.Repaired)
+	if assert.NotEmpty(t, a.RepairSession) {
+		assert.NotEqual(t, ssId, a.RepairSession)
+		repair, err := sessions.GetRenterSession(ctxParams, a.RepairSession, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer repair.Cancel()
+		r, err := repair.Repair()
+		if assert.NoError(t, err) && assert.NotNil(t, r) {
+			assert.Equal(t, ssId, r.SessionId)
+		}
+		ids, err := rss.RepairSessions()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{a.RepairSession}, ids)
+		assert.Equal(t, rss.Hash, repair.Hash)
+		assert.Equal(t, rss.ShardHashes, repair.ShardHashes)
+		for i, h := range repair.ShardHashes {
+			assert.Equal(t, i, waitShardEvent(t, repair, h))
+		}
+	}
+
+	// a repair of some of the shards places them at their index in the file
+	repairSsId, err := RepairShards(context.Background(), ctxParams, rss.Hash, []string{"QmShardc", "QmShardb"},
+		ctxParams.N.Identity, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repair, err := sessions.GetRenterSession(ctxParams, repairSsId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repair.Cancel()
+	assert.Equal(t, []string{"QmShardc", "QmShardb"}, repair.ShardHashes)
+	assert.Equal(t, 2, waitShardEvent(t, repair, "QmShardc"))
+	assert.Equal(t, 1, waitShardEvent(t, repair, "QmShardb"))
+
+	_, err = RepairShards(context.Background(), ctxParams, rss.Hash, []string{"QmOther"}, ctxParams.N.Identity, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestRepairSessionPay(t *testing.T) {
+	_, p := setupSettlement(t, 100)
+	ssId := "e2b7c5a9-4d1f-4e83-9a6c-7f0b3d8e1c24"
+	ctxParams := newContractedSession(t, ssId, 3, append(toPayEvents, sessions.RssToCompleteEvent)...)
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// shards c and b were placed again, by a repair that stopped before paying
+	repairId := "9d4f1a63-2c8e-4b57-b0e1-5a7c3f9d2e86"
+	repair, err := sessions.GetRenterSession(ctxParams, repairId, rss.Hash, []string{"QmShardc", "QmShardb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repair.SaveRepair(&sessions.Repair{SessionId: ssId, ShardIndexes: []int{2, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repair.SaveUploadParams(&sessions.UploadParams{
+		Price:         1,
+		ShardSize:     1 << 30,
+		FileSize:      -1,
+		StorageLength: 1,
+		RenterId:      ctxParams.N.Identity.String(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range repair.ShardHashes {
+		shard, err := repair.Shard(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = shard.Contract(nil, &guardpb.Contract{
+			ContractMeta: guardpb.ContractMeta{
+				ContractId: "repair-" + h,
+				HostPid:    "new-host-" + h,
+				ShardHash:  h,
+				ShardIndex: int32(repair.ShardIndex(i)),
+				Amount:     10,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rss.AddRepairSession(repairId); err != nil {
+		t.Fatal(err)
+	}
+	complete, _, err := repair.GetCompleteShardsNum()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, complete)
+	for _, e := range toPayEvents {
+		if err := repair.To(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repair.Cancel()
+
+	// the session is not audited while it is repaired, nor the repair on its own
+	audits, err := AuditFiles(context.Background(), ctxParams, true)
+	assert.NoError(t, err)
+	assert.Empty(t, audits)
+
+	repair, err = resume(t, ctxParams, repairId)
+	assert.NoError(t, err)
+	waitStatus(t, repair, sessions.RssCompleteStatus)
+	assert.ElementsMatch(t, []string{"repair-QmShardc", "repair-QmShardb"}, p.paid())
+	for i := range repair.ShardHashes {
+		shard, err := repair.Shard(i)
+		assert.NoError(t, err)
+		paid, err := shard.IsPaid()
+		assert.NoError(t, err)
+		assert.True(t, paid)
+	}
+	repairing, err := repairInProgress(rss)
+	assert.NoError(t, err)
+	assert.False(t, repairing)
+
+	// once done, the repaired shards are audited with the repair
+	holders, err := shardSessions(rss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{ssId, repairId, repairId},
+		[]string{holders[0].SsId, holders[1].SsId, holders[2].SsId})
+}
@@ -0,0 +1,174 @@
+package upload
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/utils"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var StorageUploadResumeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Resume an interrupted storage upload session.",
+		ShortDescription: `
+This command picks up an upload session from the step where it stopped, e.g.
+after a daemon restart. Shards that already have signed contracts are not
+signed again, and shards that are already paid are not paid again. A shard
+whose payment was interrupted is not paid again while its cheque may have been
+sent.
+
+The daemon resumes interrupted sessions at startup by itself, if they changed
+within the last Upload.ResumeMaxAge hours (72 by default).`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("session-id", true, false, "ID for the entire storage upload session.").EnableStdin(),
+	},
+	RunTimeout: 15 * time.Minute,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		err := utils.CheckSimpleMode(env)
+		if err != nil {
+			return err
+		}
+
+		ctxParams, err := helper.ExtractContextParams(req, env)
+		if err != nil {
+			return err
+		}
+		ssId := req.Arguments[0]
+		rss, err := sessions.GetResumableRenterSession(ctxParams, ssId)
+		if err != nil {
+			return err
+		}
+		params, err := rss.UploadParams()
+		if err == datastore.ErrNotFound {
+			return fmt.Errorf("session %s was started without resume support", ssId)
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			if err := ResumeSession(rss, params); err != nil {
+				_ = rss.To(sessions.RssToErrorEvent, err)
+			}
+		}()
+		return res.Emit(&Res{
+			ID: ssId,
+		})
+	},
+	Type: Res{},
+}
+
+// ResumeSession continues a persisted session from the status it was left in.
+func ResumeSession(rss *sessions.RenterSession, params *sessions.UploadParams) error {
+	status, err := rss.Status()
+	if err != nil {
+		return err
+	}
+	rss.Token = params.Token
+	log.Infof("resume session %s from status %s", rss.SsId, status.Status)
+	switch status.Status {
+	case sessions.RssInitStatus:
+		renterId, err := peer.Decode(params.RenterId)
+		if err != nil {
+			return err
+		}
+		hp, err := resumeHostsProvider(rss, params)
+		if err != nil {
+			return err
+		}
+		shardIndexes := make([]int, 0)
+		for i := range rss.ShardHashes {
+			shardIndexes = append(shardIndexes, rss.ShardIndex(i))
+		}
+		return UploadShard(rss, hp, params.Price, params.Token, params.ShardSize, params.StorageLength,
+			params.OfflineSigning, renterId, params.FileSize, shardIndexes, nil)
+	case sessions.RssSubmitStatus:
+		return Submit(rss, params.FileSize, params.OfflineSigning)
+	case sessions.RssGuardStatus, sessions.RssGuardFileMetaSignedStatus, sessions.RssGuardQuestionsSignedStatus:
+		return doGuardAndPay(rss, nil, params.FileSize, params.OfflineSigning)
+	case sessions.RssWaitUploadStatus, sessions.RssWaitUploadReqSignedStatus:
+		return ResumeWaitUpload(rss, params)
+	case sessions.RssPayStatus:
+		return pay(rss)
+	default:
+		return fmt.Errorf("session %s in status %q can not be resumed", rss.SsId, status.Status)
+	}
+}
+
+// AutoResumable reports whether the daemon resumes the session at startup, i.e.
+// whether it changed within the last Upload.ResumeMaxAge hours.
+func AutoResumable(rss *sessions.RenterSession) (bool, error) {
+	maxAge := helper.GetUploadConfig(rss.CtxParams).ResumeMaxAge
+	if maxAge < 0 {
+		return false, nil
+	}
+	status, err := rss.Status()
+	if err != nil {
+		return false, err
+	}
+	return time.Since(status.LastUpdated) <= time.Duration(maxAge)*time.Hour, nil
+}
+
+// ResumeWaitUpload continues a session that was waiting for hosts to store its shards.
+func ResumeWaitUpload(rss *sessions.RenterSession, params *sessions.UploadParams) error {
+	fileSize := params.FileSize
+	if fileSize <= 0 {
+		fileSize = math.MaxInt64
+	}
+	return waitUpload(rss, params.OfflineSigning, newResumeFileStatus(rss, fileSize))
+}
+
+// resumeHostsProvider rebuilds the hosts provider of a session, leaving out the
+// hosts that already hold a contracted shard of it and the hosts it skipped or
+// that failed its shards.
+func resumeHostsProvider(rss *sessions.RenterSession, params *sessions.UploadParams) (helper.IHostsProvider, error) {
+	usedHosts := make(map[string]bool)
+	for i := range rss.ShardHashes {
+		shard, err := rss.Shard(i)
+		if err != nil {
+			return nil, err
+		}
+		contracts, err := shard.Contracts()
+		if err != nil {
+			return nil, err
+		}
+		if contracts.SignedGuardContract != nil {
+			usedHosts[contracts.SignedGuardContract.HostPid] = true
+		}
+	}
+	skipped, err := rss.SkippedHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range skipped {
+		usedHosts[h.HostId] = true
+	}
+	failed, err := rss.FailedHosts()
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range failed {
+		usedHosts[h.HostId] = true
+	}
+	if params.HostSelectMode == "custom" {
+		hosts := make([]string, 0)
+		for _, h := range params.HostIDs {
+			if !usedHosts[h] {
+				hosts = append(hosts, h)
+			}
+		}
+		return helper.GetCustomizedHostsProvider(rss.CtxParams, hosts), nil
+	}
+	blacklist := make([]string, 0, len(usedHosts))
+	for h := range usedHosts {
+		blacklist = append(blacklist, h)
+	}
+	return getHostsProvider(rss, params, blacklist)
+}
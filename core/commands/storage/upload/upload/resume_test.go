@@ -0,0 +1,329 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/accounting"
+	"github.com/bittorrent/go-btfs/chain"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+	"github.com/bittorrent/go-btfs/settlement/swap"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	vaultmock "github.com/bittorrent/go-btfs/settlement/swap/vault/mock"
+	statestore "github.com/bittorrent/go-btfs/statestore/mock"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFileHash is the file of the test sessions.
+const testFileHash = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+
+type fakeOracle struct{}
+
+func (fakeOracle) CurrentPrice(common.Address) (*big.Int, error)      { return big.NewInt(1), nil }
+func (fakeOracle) CurrentRate(common.Address) (*big.Int, error)       { return big.NewInt(1), nil }
+func (fakeOracle) CurrentTotalPrice(common.Address) (*big.Int, error) { return big.NewInt(1), nil }
+func (fakeOracle) CheckNewPrice(common.Address) (*big.Int, error)     { return big.NewInt(1), nil }
+
+type payments struct {
+	sync.Mutex
+	contracts []string
+}
+
+func (p *payments) paid() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string(nil), p.contracts...)
+}
+
+// setupSettlement stands in the settlement with a vault holding balance, and
+// records the payments sent.
+func setupSettlement(t *testing.T, balance int64) (*accounting.Accounting, *payments) {
+	store := statestore.NewStateStore()
+	t.Cleanup(func() { store.Close() })
+	acc, err := accounting.NewAccounting(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := new(payments)
+	acc.SetPayFunc(func(ctx context.Context, peer string, amount *big.Int, contractId string, token common.Address) {
+		p.Lock()
+		p.contracts = append(p.contracts, contractId)
+		p.Unlock()
+		acc.NotifyPaymentSent(peer, amount, contractId, nil, token)
+	})
+	chain.SettleObject.Accounting = acc
+	chain.SettleObject.SwapService = swap.New(nil, nil, nil, nil, nil, 0, nil, acc)
+	chain.SettleObject.OracleService = fakeOracle{}
+	chain.SettleObject.VaultService = vaultmock.NewVault(vaultmock.WithVaultAvailableBalanceFunc(
+		func(ctx context.Context, token common.Address) (*big.Int, error) {
+			return big.NewInt(balance), nil
+		}))
+	return acc, p
+}
+
+// newContractedSession returns a session whose shards all have a contract with
+// their host, walked to the given status.
+func newContractedSession(t *testing.T, ssId string, shards int, events ...string) *uh.ContextParams {
+	node, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	cfg, err := node.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxParams := &uh.ContextParams{Ctx: context.Background(), N: node, Cfg: cfg}
+	hashes := make([]string, shards)
+	for i := range hashes {
+		hashes[i] = "QmShard" + string(rune('a'+i))
+	}
+	rss, err := sessions.GetRenterSession(ctxParams, ssId, testFileHash, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rss.SaveUploadParams(&sessions.UploadParams{
+		Price:          1,
+		ShardSize:      1 << 30,
+		StorageLength:  1,
+		RenterId:       node.Identity.String(),
+		HostSelectMode: "custom",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range hashes {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = shard.Contract(nil, &guardpb.Contract{
+			ContractMeta: guardpb.ContractMeta{
+				ContractId: "contract-" + h,
+				HostPid:    "host-" + h,
+				ShardHash:  h,
+				ShardIndex: int32(i),
+				Amount:     10,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, e := range events {
+		if err := rss.To(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the daemon stops
+	rss.Cancel()
+	return ctxParams
+}
+
+func resume(t *testing.T, ctxParams *uh.ContextParams, ssId string) (*sessions.RenterSession, error) {
+	rss, err := sessions.GetResumableRenterSession(ctxParams, ssId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := rss.UploadParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rss, ResumeSession(rss, params)
+}
+
+func waitStatus(t *testing.T, rss *sessions.RenterSession, status string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		st, err := rss.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s did not reach %s", rss.SsId, status)
+}
+
+var toPayEvents = []string{
+	sessions.RssToSubmitEvent,
+	sessions.RssToGuardEvent,
+	sessions.RssToGuardFileMetaSignedEvent,
+	sessions.RssToGuardQuestionsSignedEvent,
+	sessions.RssToWaitUploadEvent,
+	sessions.RssToWaitUploadReqSignedEvent,
+	sessions.RssToPayEvent,
+}
+
+func TestResumeContracted(t *testing.T) {
+	// the balance covers the shards placed, not the contracts to submit
+	setupSettlement(t, 2)
+	ssId := "3f9c1a52-7d4e-4b8a-9e21-6c0d5b7a8f14"
+	ctxParams := newContractedSession(t, ssId, 2)
+
+	rss, err := resume(t, ctxParams, ssId)
+	assert.NoError(t, err)
+	// the shards are not placed again, the session goes on with submitting
+	// their contracts
+	waitStatus(t, rss, sessions.RssErrorStatus)
+	events, err := rss.Events()
+	assert.NoError(t, err)
+	submitted := false
+	for _, e := range events {
+		assert.NotEqual(t, sessions.EventShardAttempt, e.Type)
+		assert.NotEqual(t, sessions.EventShardFailed, e.Type)
+		if e.Type == sessions.EventStatus && e.Status == sessions.RssSubmitStatus {
+			submitted = true
+		}
+	}
+	assert.True(t, submitted)
+	for i, h := range rss.ShardHashes {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		assert.NoError(t, err)
+		c, err := shard.Contracts()
+		assert.NoError(t, err)
+		assert.Equal(t, "contract-"+h, c.SignedGuardContract.ContractId)
+	}
+}
+
+func TestResumePay(t *testing.T) {
+	_, p := setupSettlement(t, 100)
+	ssId := "8b2e4f61-0c3d-4a7b-b5e9-1d6f2a8c4e07"
+	ctxParams := newContractedSession(t, ssId, 3, toPayEvents...)
+	paidShard, err := sessions.GetRenterShard(ctxParams, ssId, "QmSharda", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, paidShard.Paid())
+
+	rss, err := resume(t, ctxParams, ssId)
+	assert.NoError(t, err)
+	waitStatus(t, rss, sessions.RssCompleteStatus)
+	// the shard paid before the daemon stopped is not paid again
+	assert.ElementsMatch(t, []string{"contract-QmShardb", "contract-QmShardc"}, p.paid())
+	for i, h := range rss.ShardHashes {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		assert.NoError(t, err)
+		paid, err := shard.IsPaid()
+		assert.NoError(t, err)
+		assert.True(t, paid)
+	}
+}
+
+func TestResumePaying(t *testing.T) {
+	acc, p := setupSettlement(t, 100)
+	ssId := "c4a7e2d9-5b1f-4c3e-8a60-2f9d7b3e1a58"
+	ctxParams := newContractedSession(t, ssId, 2, toPayEvents...)
+	// the daemon stopped in the middle of the payments, the first one was sent
+	// and the second was not
+	for i, h := range []string{"QmSharda", "QmShardb"} {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, shard.Paying())
+	}
+	assert.NoError(t, acc.Settle("host-QmSharda", big.NewInt(10), "contract-QmSharda", common.Address{}))
+	acc.Close()
+
+	rss, err := resume(t, ctxParams, ssId)
+	assert.NoError(t, err)
+	waitStatus(t, rss, sessions.RssCompleteStatus)
+	// the payment sent before the daemon stopped is not sent again
+	assert.Equal(t, []string{"contract-QmSharda", "contract-QmShardb"}, p.paid())
+	for i, h := range rss.ShardHashes {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		assert.NoError(t, err)
+		paid, err := shard.IsPaid()
+		assert.NoError(t, err)
+		assert.True(t, paid)
+	}
+}
+
+func TestResumePayingIssued(t *testing.T) {
+	acc, p := setupSettlement(t, 100)
+	store := statestore.NewStateStore()
+	t.Cleanup(func() { store.Close() })
+	addressbook := swap.NewAddressbook(store)
+	chain.SettleObject.SwapService = swap.New(nil, store, nil, nil, addressbook, 0, nil, acc)
+	issued := map[common.Address]*big.Int{
+		common.HexToAddress("aaaa"): big.NewInt(10),
+		common.HexToAddress("bbbb"): big.NewInt(15),
+	}
+	chain.SettleObject.VaultService = vaultmock.NewVault(
+		vaultmock.WithVaultAvailableBalanceFunc(func(ctx context.Context, token common.Address) (*big.Int, error) {
+			return big.NewInt(100), nil
+		}),
+		vaultmock.WithLastChequeFunc(func(beneficiary common.Address, token common.Address) (*vault.SignedCheque, error) {
+			payout, ok := issued[beneficiary]
+			if !ok {
+				return nil, vault.ErrNoCheque
+			}
+			return &vault.SignedCheque{Cheque: vault.Cheque{CumulativePayout: payout}}, nil
+		}),
+	)
+
+	ssId := "7b1e4d2a-3c9f-4a85-b6d0-e8f25a1c9d37"
+	ctxParams := newContractedSession(t, ssId, 2, toPayEvents...)
+	// the daemon stopped with the cheques of both payments pending in the
+	// ledger, the vault issued the first one and not the second
+	for i, h := range []string{"QmSharda", "QmShardb"} {
+		shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, shard.Paying())
+		assert.NoError(t, addressbook.PutBeneficiary("host-"+h, common.HexToAddress(strings.Repeat(h[7:], 4))))
+		assert.NoError(t, acc.NotifyPaymentSending("host-"+h, big.NewInt(10), "contract-"+h,
+			big.NewInt(int64(10*(i+1))), common.Address{}))
+	}
+
+	rss, err := resume(t, ctxParams, ssId)
+	assert.NoError(t, err)
+	waitStatus(t, rss, sessions.RssCompleteStatus)
+	// the cheque the vault issued is not sent again
+	assert.Equal(t, []string{"contract-QmShardb"}, p.paid())
+	c, err := acc.PayableContract("host-QmSharda", "contract-QmSharda")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(10), c.Paid.Int64())
+		assert.Nil(t, c.Pending)
+	}
+}
+
+func TestAutoResumable(t *testing.T) {
+	ssId := "5e8d3c1b-9a2f-4e7d-a6b4-0f1c8e2d7a39"
+	ctxParams := newContractedSession(t, ssId, 1)
+	rss, err := sessions.GetResumableRenterSession(ctxParams, ssId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := AutoResumable(rss)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// a session left alone for longer than the max age
+	status, err := rss.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status.LastUpdated = time.Now().Add(-(uh.DefaultResumeMaxAge + 1) * time.Hour)
+	err = sessions.Save(ctxParams.N.Repo.Datastore(),
+		fmt.Sprintf(sessions.RenterSessionStatusKey, ctxParams.N.Identity.Pretty(), ssId), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err = AutoResumable(rss)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,202 @@
+package upload
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	// MaxConcurrentShardUploads caps the shards being contracted at once, across all
+	// upload sessions.
+	MaxConcurrentShardUploads = 32
+	// MaxShardUploadsPerHost caps the shards being contracted with a single host at once.
+	MaxShardUploadsPerHost = 2
+
+	scheduler = newShardScheduler()
+)
+
+// shardScheduler hands out the slots to contract shards with hosts. Sessions waiting
+// for a slot are served in turns, so a file with many shards does not hold back the
+// uploads started after it.
+type shardScheduler struct {
+	mu      sync.Mutex
+	running int
+	// waiting tickets per session, and the sessions with waiting tickets in turn order
+	queues map[string][]chan struct{}
+	turns  []string
+	// slots in use per session
+	sessions map[string]int
+	// slots in use per host, and the channels closed when one is freed
+	hosts     map[string]int
+	hostFreed map[string]chan struct{}
+}
+
+func newShardScheduler() *shardScheduler {
+	return &shardScheduler{
+		queues:    make(map[string][]chan struct{}),
+		sessions:  make(map[string]int),
+		hosts:     make(map[string]int),
+		hostFreed: make(map[string]chan struct{}),
+	}
+}
+
+// QueueStatus is the state of the upload queue seen from a session.
+type QueueStatus struct {
+	// shards of the session waiting for a slot, and being contracted
+	Queued  int
+	Running int
+	// shards of all sessions waiting for a slot, and being contracted
+	TotalQueued  int
+	TotalRunning int
+}
+
+func (s *shardScheduler) status(ssId string) *QueueStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &QueueStatus{
+		Queued:       len(s.queues[ssId]),
+		Running:      s.sessions[ssId],
+		TotalRunning: s.running,
+	}
+	for _, q := range s.queues {
+		st.TotalQueued += len(q)
+	}
+	return st
+}
+
+// acquire waits for a slot to contract a shard of the session. The slot must be
+// given back with the returned release func.
+func (s *shardScheduler) acquire(ctx context.Context, ssId string) (func(), error) {
+	s.mu.Lock()
+	if s.running < MaxConcurrentShardUploads && len(s.turns) == 0 {
+		s.take(ssId)
+		s.mu.Unlock()
+		return func() { s.release(ssId) }, nil
+	}
+	ticket := make(chan struct{})
+	if len(s.queues[ssId]) == 0 {
+		s.turns = append(s.turns, ssId)
+	}
+	s.queues[ssId] = append(s.queues[ssId], ticket)
+	s.mu.Unlock()
+
+	select {
+	case <-ticket:
+		return func() { s.release(ssId) }, nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ticket:
+		// granted while giving up, pass the slot on
+		s.put(ssId)
+		s.grant()
+		return nil, ctx.Err()
+	default:
+	}
+	q := s.queues[ssId]
+	for i, t := range q {
+		if t == ticket {
+			q = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	s.setQueue(ssId, q)
+	return nil, ctx.Err()
+}
+
+// take counts a slot as used by the session. Must be called with the lock held.
+func (s *shardScheduler) take(ssId string) {
+	s.running++
+	s.sessions[ssId]++
+}
+
+// put counts a slot of the session as free. Must be called with the lock held.
+func (s *shardScheduler) put(ssId string) {
+	s.running--
+	if s.sessions[ssId]--; s.sessions[ssId] <= 0 {
+		delete(s.sessions, ssId)
+	}
+}
+
+func (s *shardScheduler) release(ssId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(ssId)
+	s.grant()
+}
+
+// grant hands free slots to the waiting sessions in turns. Must be called with
+// the lock held.
+func (s *shardScheduler) grant() {
+	for s.running < MaxConcurrentShardUploads && len(s.turns) > 0 {
+		ssId := s.turns[0]
+		s.turns = s.turns[1:]
+		q := s.queues[ssId]
+		s.take(ssId)
+		close(q[0])
+		s.setQueue(ssId, q[1:])
+	}
+}
+
+// setQueue replaces the waiting tickets of a session, keeping the session in the
+// turns while it has tickets. Must be called with the lock held.
+func (s *shardScheduler) setQueue(ssId string, q []chan struct{}) {
+	queued := false
+	for i, t := range s.turns {
+		if t == ssId {
+			if len(q) == 0 {
+				s.turns = append(s.turns[:i], s.turns[i+1:]...)
+			}
+			queued = true
+			break
+		}
+	}
+	if len(q) == 0 {
+		delete(s.queues, ssId)
+		return
+	}
+	s.queues[ssId] = q
+	if !queued {
+		s.turns = append(s.turns, ssId)
+	}
+}
+
+// acquireHost waits until fewer than MaxShardUploadsPerHost shards are being
+// contracted with the host. The slot must be given back with the returned release func.
+func (s *shardScheduler) acquireHost(ctx context.Context, host string) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.hosts[host] >= MaxShardUploadsPerHost {
+		freed, ok := s.hostFreed[host]
+		if !ok {
+			freed = make(chan struct{})
+			s.hostFreed[host] = freed
+		}
+		s.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+		}
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	s.hosts[host]++
+	return func() { s.releaseHost(host) }, nil
+}
+
+func (s *shardScheduler) releaseHost(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hosts[host]--; s.hosts[host] <= 0 {
+		delete(s.hosts, host)
+	}
+	// wake up the waiters, they race for the freed slot
+	if freed, ok := s.hostFreed[host]; ok {
+		close(freed)
+		delete(s.hostFreed, host)
+	}
+}
@@ -0,0 +1,132 @@
+package upload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withLimits(t *testing.T, total, perHost int) {
+	oldTotal, oldPerHost := MaxConcurrentShardUploads, MaxShardUploadsPerHost
+	MaxConcurrentShardUploads, MaxShardUploadsPerHost = total, perHost
+	t.Cleanup(func() {
+		MaxConcurrentShardUploads, MaxShardUploadsPerHost = oldTotal, oldPerHost
+	})
+}
+
+func TestShardSchedulerFairness(t *testing.T) {
+	withLimits(t, 2, 2)
+	s := newShardScheduler()
+	ctx := context.Background()
+
+	// session a takes all the slots and queues more shards before b arrives
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		r, err := s.acquire(ctx, "a")
+		assert.NoError(t, err)
+		releases = append(releases, r)
+	}
+	order := make(chan string, 6)
+	wait := func(ssId string) {
+		r, err := s.acquire(ctx, ssId)
+		assert.NoError(t, err)
+		order <- ssId
+		r()
+	}
+	for i := 0; i < 3; i++ {
+		go wait("a")
+		waitQueued(t, s, "a", i+1)
+	}
+	go wait("b")
+	waitQueued(t, s, "b", 1)
+
+	st := s.status("a")
+	assert.Equal(t, &QueueStatus{Queued: 3, Running: 2, TotalQueued: 4, TotalRunning: 2}, st)
+
+	// a single free slot goes to a, then b, though a queued more shards first
+	s.mu.Lock()
+	MaxConcurrentShardUploads = 1
+	s.mu.Unlock()
+	releases[0]()
+	releases[1]()
+	got := []string{<-order, <-order, <-order, <-order}
+	assert.Equal(t, []string{"a", "b", "a", "a"}, got)
+	deadline := time.Now().Add(time.Second)
+	for *s.status("a") != (QueueStatus{}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("slots not given back: %+v", s.status("a"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShardSchedulerCancel(t *testing.T) {
+	withLimits(t, 1, 1)
+	s := newShardScheduler()
+	release, err := s.acquire(context.Background(), "a")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := s.acquire(ctx, "b")
+		errc <- err
+	}()
+	waitQueued(t, s, "b", 1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+	assert.Equal(t, 0, s.status("b").Queued)
+
+	release()
+	release, err = s.acquire(context.Background(), "c")
+	assert.NoError(t, err)
+	release()
+}
+
+func TestShardSchedulerHostLimit(t *testing.T) {
+	withLimits(t, 10, 1)
+	s := newShardScheduler()
+	ctx := context.Background()
+	release, err := s.acquireHost(ctx, "h1")
+	assert.NoError(t, err)
+	// other hosts are not held back
+	other, err := s.acquireHost(ctx, "h2")
+	assert.NoError(t, err)
+	other()
+
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = s.acquireHost(tctx, "h1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	acquired := make(chan struct{})
+	go func() {
+		r, err := s.acquireHost(ctx, "h1")
+		assert.NoError(t, err)
+		close(acquired)
+		r()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a host over its limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("host slot not handed over")
+	}
+}
+
+func waitQueued(t *testing.T, s *shardScheduler, ssId string, n int) {
+	deadline := time.Now().Add(time.Second)
+	for s.status(ssId).Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("session %s never queued %d shards", ssId, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
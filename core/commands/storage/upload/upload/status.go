@@ -17,15 +17,28 @@ import (
 	"github.com/ipfs/go-datastore"
 )
 
+const followOptionName = "follow"
+
 var StorageUploadStatusCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Check storage upload and payment status (From client's perspective).",
 		ShortDescription: `
-This command print upload and payment status by the time queried.`,
+This command print upload and payment status by the time queried.
+While the shards are being contracted, Queue shows how many shards wait for
+an upload slot and how many hold one, for the session and for all sessions.
+Events is the history of the session: its status changes, the hosts asked to
+take each shard and why they failed, the cheques sent and the guard responses.
+FailedHosts lists the hosts that did not take a shard, they are not asked again.
+Renewals lists the extensions of the contracts of a completed session.
+With --follow, the status is printed again with the new events as they happen,
+until the session completes or fails.`,
 	},
 	Arguments: []cmds.Argument{
 		cmds.StringArg("session-id", true, false, "ID for the entire storage upload session.").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.BoolOption(followOptionName, "f", "Stream the events of the session as they happen.").WithDefault(false),
+	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		err := utils.CheckSimpleMode(env)
 		if err != nil {
@@ -68,7 +81,7 @@ This command print upload and payment status by the time queried.`,
 		status.FileHash = session.Hash
 		fullyCompleted := true
 		for i, h := range session.ShardHashes {
-			shard, err := sessions.GetRenterShard(ctxParams, ssId, h, i)
+			shard, err := sessions.GetRenterShard(ctxParams, ssId, h, session.ShardIndex(i))
 			if err != nil {
 				return err
 			}
@@ -132,10 +145,37 @@ This command print upload and payment status by the time queried.`,
 			}
 		}
 		status.Shards = shards
+		// shards are contracted while the session is in init
+		if status.Status == sessions.RssInitStatus && len(shards) > 0 {
+			status.Queue = scheduler.status(ssId)
+		}
+		status.SkippedHosts, err = session.SkippedHosts()
+		if err != nil {
+			return err
+		}
+		status.FailedHosts, err = session.FailedHosts()
+		if err != nil {
+			return err
+		}
+		status.Events, err = session.Events()
+		if err != nil {
+			return err
+		}
+		status.Renewals, err = session.Renewals()
+		if err != nil {
+			return err
+		}
 		if len(status.Shards) == 0 && status.Status == sessions.RssInitStatus {
 			status.Message = "session not found"
+			return res.Emit(status)
 		}
-		return res.Emit(status)
+		if err := res.Emit(status); err != nil {
+			return err
+		}
+		if follow, _ := req.Options[followOptionName].(bool); follow {
+			return followEvents(req.Context, res, session, status.Status, status.Events)
+		}
+		return nil
 	},
 	Type: StatusRes{},
 }
@@ -146,6 +186,50 @@ type StatusRes struct {
 	AdditionalInfo string
 	FileHash       string
 	Shards         map[string]*ShardStatus
+	SkippedHosts   []*sessions.SkippedHost `json:",omitempty"`
+	FailedHosts    []*sessions.FailedHost  `json:",omitempty"`
+	Queue          *QueueStatus            `json:",omitempty"`
+	Events         []*sessions.Event       `json:",omitempty"`
+	Renewals       []*sessions.Renewal     `json:",omitempty"`
+}
+
+// followEvents emits the events of the session added after the seen ones, along
+// with the status they led to, until the session completes or fails.
+func followEvents(ctx context.Context, res cmds.ResponseEmitter, session *sessions.RenterSession,
+	status string, seen []*sessions.Event) error {
+	var seq uint64
+	if len(seen) > 0 {
+		seq = seen[len(seen)-1].Seq
+	}
+	for status != sessions.RssCompleteStatus && status != sessions.RssErrorStatus {
+		events, changed, err := session.EventsSince(seq)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		seq = events[len(events)-1].Seq
+		st, err := session.Status()
+		if err != nil {
+			return err
+		}
+		status = st.Status
+		if err := res.Emit(&StatusRes{
+			Status:   st.Status,
+			Message:  st.Message,
+			FileHash: session.Hash,
+			Events:   events,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type ShardStatus struct {
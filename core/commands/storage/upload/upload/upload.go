@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/bittorrent/go-btfs/chain/tokencfg"
 	"github.com/bittorrent/go-btfs/utils"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +23,7 @@ import (
 	cmds "github.com/bittorrent/go-btfs-cmds"
 
 	"github.com/cenkalti/backoff/v4"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/google/uuid"
 	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -36,12 +38,18 @@ const (
 	customizedPayoutOptionName       = "customize-payout"
 	customizedPayoutPeriodOptionName = "customize-payout-period"
 	copyName                         = "copy"
+	directoryModeOptionName          = "directory-mode"
 
 	defaultRepFactor     = 3
 	defaultStorageLength = 30
 
 	uploadPriceOptionName   = "price"
 	storageLengthOptionName = "storage-length"
+
+	uploadTimeout = 15 * time.Minute
+	// encodeTimeout is how long the session of a file that is not reed-solomon
+	// encoded may take to encode it, on top of uploadTimeout.
+	encodeTimeout = time.Hour
 )
 
 var (
@@ -54,7 +62,7 @@ var StorageUploadCmd = &cmds.Command{
 		Tagline: "Store files on BTFS network nodes through BTT payment.",
 		ShortDescription: `
 By default, BTFS selects hosts based on overall score according to the current client's environment.
-To upload a file, <file-hash> must refer to a reed-solomon encoded file.
+To upload a file, <file-hash> should refer to a reed-solomon encoded file.
 
 To create a reed-solomon encoded file from a normal file:
 
@@ -65,6 +73,23 @@ Run command to upload:
 
     $ btfs storage upload <file-hash>
 
+Any other file or directory, given by hash or path, is encoded on the fly with the data
+and parity shards set in config, 10 and 20 by default:
+    $ btfs config --json Upload.ErasureCoding '{"DataShards": 10, "ParityShards": 20}'
+    $ btfs storage upload /btfs/<dir-hash>/docs
+
+A directory is packed into one encoded archive, or each of its files is uploaded by its
+own session with --directory-mode=per-file. The tree is recorded in a manifest:
+    $ btfs storage upload manifest <dir-hash>
+
+Such a file is encoded by the session: the command returns the session id right away,
+and the status of the session shows the encoding, then the upload.
+
+To upload plain copies of a file instead, use --copy with the number of extra copies.
+Without --copy the file is encoded; --copy=0 uploads one plain copy, as uploads did
+before encoding on the fly:
+    $ btfs storage upload <file-hash> --copy=0
+
 To custom upload and storage a file on specific hosts:
     Use -m with 'custom' mode, and put host identifiers in -s, with multiple hosts separated by ','.
 
@@ -76,8 +101,36 @@ To custom upload and storage a file on specific hosts:
     # Total # of hosts (N) must match # of shards given
     $ btfs storage upload <shard-hash1> <shard-hash2> ... <shard-hashN> -l -m=custom -s=<host1-peer-id>,<host2-peer-id>,...,<hostN-peer-id>
 
+To order the synced hosts by a selection strategy, use -m with one of:
+    cheapest:    prefer hosts asking a lower storage price
+    latency:     prefer hosts with a lower measured latency
+    reliability: prefer hosts that set up past contracts successfully
+    ip-spread:   spread shards over as many ip prefixes (/16 for ipv4, /32 for
+                 ipv6) and hub-reported regions as possible
+
+    $ btfs storage upload <file-hash> -m=ip-spread
+
+The default strategy and the weights of each strategy can be set in config:
+    $ btfs config HostSelector.Strategy reliability
+    $ btfs config --json HostSelector.Weights.cheapest '{"price": 1, "latency": 0.5, "reliability": 0.5, "score": 0.1}'
+
+To only use hosts that ask no more than a max price per GiB per day, use -p.
+Hosts that ask more are skipped and listed in the status of the session:
+    $ btfs storage upload <file-hash> -p=<max-price>
+
+A shard that a host fails to take is placed again with another host, and the host is
+not asked again in the session. The session fails once more shards failed than the
+retry budget allows, 30 by default:
+    $ btfs config --json Upload.ShardRetryBudget 10
+
 Use status command to check for completion:
-    $ btfs storage upload status <session-id> | jq`,
+    $ btfs storage upload status <session-id> | jq
+
+Use resume command to continue a session interrupted by a daemon restart:
+    $ btfs storage upload resume <session-id>
+
+Use renew command to extend the storage of a completed upload with the same hosts:
+    $ btfs storage upload renew <session-id> --len 30`,
 	},
 	Subcommands: map[string]*cmds.Command{
 		"init":              StorageUploadInitCmd,
@@ -86,6 +139,12 @@ Use status command to check for completion:
 		"recvcontract":      StorageUploadRecvContractCmd,
 		"status":            StorageUploadStatusCmd,
 		"repair":            StorageUploadRepairCmd,
+		"resume":            StorageUploadResumeCmd,
+		"renew":             StorageUploadRenewCmd,
+		"renewinit":         StorageUploadRenewInitCmd,
+		"recvcancel":        StorageUploadRecvCancelCmd,
+		"manifest":          StorageUploadManifestCmd,
+		"audit":             StorageUploadAuditCmd,
 		"getcontractbatch":  offline.StorageUploadGetContractBatchCmd,
 		"signcontractbatch": offline.StorageUploadSignContractBatchCmd,
 		"getunsigned":       offline.StorageUploadGetUnsignedCmd,
@@ -98,18 +157,19 @@ Use status command to check for completion:
 		cmds.StringArg("upload-signature", false, false, "Session signature when upload upload."),
 	},
 	Options: []cmds.Option{
-		cmds.Int64Option(uploadPriceOptionName, "p", "Max price per GiB per day of storage in µBTT (=0.000001BTT)."),
+		cmds.Int64Option(uploadPriceOptionName, "p", "Max price per GiB per day of storage in µBTT (=0.000001BTT). Hosts asking more are skipped."),
 		cmds.IntOption(replicationFactorOptionName, "r", "Replication factor for the file with erasure coding built-in.").WithDefault(defaultRepFactor),
-		cmds.StringOption(hostSelectModeOptionName, "m", "Based on this mode to select hosts and upload automatically. Default: mode set in config option Experimental.HostsSyncMode."),
+		cmds.StringOption(hostSelectModeOptionName, "m", "Based on this mode to select hosts and upload automatically. Can be 'custom', 'cheapest', 'latency', 'reliability' or 'ip-spread', or a hub mode such as 'score' or 'price' to keep the order of the hosts synced in that mode. Default: strategy set in config option HostSelector.Strategy, or the order of hosts synced in Experimental.HostsSyncMode."),
 		cmds.StringOption(hostSelectionOptionName, "s", "Use only these selected hosts in order on 'custom' mode. Use ',' as delimiter."),
 		cmds.BoolOption(testOnlyOptionName, "t", "Enable host search under all domains 0.0.0.0 (useful for local test)."),
 		cmds.IntOption(storageLengthOptionName, "len", "File storage period on hosts in days.").WithDefault(defaultStorageLength),
 		cmds.BoolOption(customizedPayoutOptionName, "Enable file storage customized payout schedule.").WithDefault(false),
 		cmds.IntOption(customizedPayoutPeriodOptionName, "Period of customized payout schedule.").WithDefault(1),
-		cmds.IntOption(copyName, "copy num of file hash.").WithDefault(0),
+		cmds.IntOption(copyName, "Upload plain copies of a file that is not reed-solomon encoded instead of encoding it, the number of extra copies. Default: encode the file as set in Upload.ErasureCoding; --copy=0 uploads a single plain copy as before."),
+		cmds.StringOption(directoryModeOptionName, "How to upload a directory that is not reed-solomon encoded, 'archive' or 'per-file'. Default: Upload.DirectoryMode in config, or 'archive'."),
 		cmds.StringOption(tokencfg.TokenTypeName, "tk", "file storage with token type,default WBTT, other TRX/USDD/USDT.").WithDefault("WBTT"),
 	},
-	RunTimeout: 15 * time.Minute,
+	RunTimeout: uploadTimeout,
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		err := utils.CheckSimpleMode(env)
 		if err != nil {
@@ -146,28 +206,21 @@ Use status command to check for completion:
 			return nil
 		}, helper.WaitingForPeersBo)
 
-		var shardHashes []string
-		var fileSize int64
-		var shardSize int64
-
 		// token: parse token argument
 		tokenStr := req.Options[tokencfg.TokenTypeName].(string)
 		token, bl := tokencfg.MpTokenAddr[tokenStr]
 		if !bl {
 			return errors.New("your input token is none. ")
 		}
-		fmt.Println("token =", token, tokenStr)
-
-		fileHash := req.Arguments[0]
-		shardHashes, fileSize, shardSize, err = helper.GetShardHashes(ctxParams, fileHash)
-		if len(shardHashes) == 0 && fileSize == -1 && shardSize == -1 &&
-			strings.HasPrefix(err.Error(), "invalid hash: file must be reed-solomon encoded") {
-			if copyNum, ok := req.Options[copyName].(int); ok {
-				shardHashes, fileSize, shardSize, err = helper.GetShardHashesCopy(ctxParams, fileHash, copyNum)
-				fmt.Printf("copy get, shardHashes:%v fileSize:%v, shardSize:%v, copy:%v err:%v \n",
-					shardHashes, fileSize, shardSize, copyNum, err)
-			}
+		log.Debugf("token = %s %s", token, tokenStr)
+
+		fileHash, err := resolveUploadHash(ctxParams, req.Arguments[0])
+		if err != nil {
+			return err
 		}
+		copyNum, copyOk := req.Options[copyName].(int)
+		dirMode, _ := req.Options[directoryModeOptionName].(string)
+		targets, err := uploadTargets(ctxParams, fileHash, copyNum, copyOk)
 		if err != nil {
 			return err
 		}
@@ -182,65 +235,202 @@ Use status command to check for completion:
 			return err
 		}
 		price := priceObj.Int64()
+		maxPrice, _ := req.Options[uploadPriceOptionName].(int64)
+		if maxPrice > 0 && price > maxPrice {
+			return fmt.Errorf("current storage price %d is higher than max price %d, please raise price to at least %d",
+				price, maxPrice, price)
+		}
 		// token: get new rate
 		rate, err := chain.SettleObject.OracleService.CurrentRate(token)
 		if err != nil {
 			return err
 		}
-		_, err = helper.TotalPay(shardSize, price, storageLength, rate)
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
-		}
 
 		// sync hosts from hub hosts.
 		if !ctxParams.Cfg.Experimental.HostsSyncEnabled {
 			_ = SyncHosts(ctxParams)
 		}
-		hp := helper.GetHostsProvider(ctxParams, make([]string, 0))
-		if mode, ok := req.Options[hostSelectModeOptionName].(string); ok {
-			var hostIDs []string
-			if mode == "custom" {
-				if hosts, ok := req.Options[hostSelectionOptionName].(string); ok {
-					hostIDs = strings.Split(hosts, ",")
-				}
-				if len(hostIDs) != len(shardHashes) {
-					return fmt.Errorf("custom mode hosts length must match shard hashes length")
-				}
-				hp = helper.GetCustomizedHostsProvider(ctxParams, hostIDs)
+		mode, _ := req.Options[hostSelectModeOptionName].(string)
+		var hostIDs []string
+		if mode == "custom" {
+			if hosts, ok := req.Options[hostSelectionOptionName].(string); ok {
+				hostIDs = strings.Split(hosts, ",")
 			}
 		}
-		rss, err := sessions.GetRenterSessionWithToken(ctxParams, ssId, fileHash, shardHashes, token)
-		if err != nil {
-			return err
-		}
+		var offlineMeta *renterpb.OfflineMeta
 		if offlineSigning {
 			offNonceTimestamp, err := strconv.ParseUint(req.Arguments[2], 10, 64)
 			if err != nil {
 				return err
 			}
-			err = rss.SaveOfflineMeta(&renterpb.OfflineMeta{
+			offlineMeta = &renterpb.OfflineMeta{
 				OfflinePeerId:    req.Arguments[1],
 				OfflineNonceTs:   offNonceTimestamp,
 				OfflineSignature: req.Arguments[3],
-			})
+			}
+		}
+
+		job := &uploadJob{
+			ctxParams:      ctxParams,
+			token:          token,
+			price:          price,
+			maxPrice:       maxPrice,
+			rate:           rate,
+			storageLength:  storageLength,
+			offlineSigning: offlineSigning,
+			offlineMeta:    offlineMeta,
+			renterId:       renterId,
+			mode:           mode,
+			hostIDs:        hostIDs,
+		}
+		if targets == nil {
+			// the file is encoded by the session, so that encoding does not count
+			// against the timeout of the command
+			rss, err := job.encodeSession(ssId, fileHash)
 			if err != nil {
 				return err
 			}
+			go job.encodeAndStart(rss, fileHash, dirMode)
+			return res.Emit(&Res{ID: ssId})
 		}
-		shardIndexes := make([]int, 0)
-		for i, _ := range rss.ShardHashes {
-			shardIndexes = append(shardIndexes, i)
+		if err := job.check(targets); err != nil {
+			return err
 		}
-		UploadShard(rss, hp, price, token, shardSize, storageLength, offlineSigning, renterId, fileSize, shardIndexes, nil)
-		seRes := &Res{
-			ID: ssId,
+		seRes, err := job.start(ssId, targets, nil)
+		if err != nil {
+			return err
 		}
 		return res.Emit(seRes)
 	},
 	Type: Res{},
 }
 
+// uploadJob holds the parameters that the sessions of an upload share.
+type uploadJob struct {
+	ctxParams      *helper.ContextParams
+	token          common.Address
+	price          int64
+	maxPrice       int64
+	rate           *big.Int
+	storageLength  int
+	offlineSigning bool
+	offlineMeta    *renterpb.OfflineMeta
+	renterId       peer.ID
+	mode           string
+	hostIDs        []string
+}
+
+// check returns an error if a target cannot be paid for, or does not have as many
+// shards as the custom hosts.
+func (j *uploadJob) check(targets []*uploadTarget) error {
+	for _, t := range targets {
+		if _, err := helper.TotalPay(t.shardSize, j.price, j.storageLength, j.rate); err != nil {
+			return err
+		}
+		if j.mode == "custom" && len(j.hostIDs) != len(t.shardHashes) {
+			return fmt.Errorf("custom mode hosts length must match shard hashes length")
+		}
+	}
+	return nil
+}
+
+// start starts a session for each target, the first one with the given ssId. The
+// returned result lists the sessions started so far, also on error.
+func (j *uploadJob) start(ssId string, targets []*uploadTarget, manifest *sessions.Manifest) (*Res, error) {
+	ctxParams := j.ctxParams
+	seRes := &Res{}
+	for i, t := range targets {
+		if i > 0 {
+			ssId = uuid.New().String()
+		}
+		rss, err := sessions.GetRenterSessionWithToken(ctxParams, ssId, t.hash, t.shardHashes, j.token)
+		if err != nil {
+			return seRes, err
+		}
+		params := &sessions.UploadParams{
+			Price:          j.price,
+			MaxPrice:       j.maxPrice,
+			Token:          j.token,
+			ShardSize:      t.shardSize,
+			FileSize:       t.fileSize,
+			StorageLength:  j.storageLength,
+			OfflineSigning: j.offlineSigning,
+			RenterId:       j.renterId.String(),
+			HostSelectMode: j.mode,
+			HostIDs:        j.hostIDs,
+		}
+		err = rss.SaveUploadParams(params)
+		if err != nil {
+			return seRes, err
+		}
+		hp, err := getHostsProvider(rss, params, make([]string, 0))
+		if err != nil {
+			return seRes, err
+		}
+		if j.offlineMeta != nil {
+			err = rss.SaveOfflineMeta(j.offlineMeta)
+			if err != nil {
+				return seRes, err
+			}
+		}
+		if manifest != nil {
+			// the manifest is saved as each session starts, so that it covers
+			// the sessions already started if a later one fails to start
+			if t.entry != nil {
+				t.entry.SessionId = ssId
+			} else {
+				manifest.SessionId = ssId
+			}
+			err = sessions.SaveManifest(ctxParams.N.Repo.Datastore(), ctxParams.N.Identity.String(), manifest)
+			if err != nil {
+				return seRes, err
+			}
+		}
+		shardIndexes := make([]int, 0)
+		for i, _ := range rss.ShardHashes {
+			shardIndexes = append(shardIndexes, i)
+		}
+		UploadShard(rss, hp, j.price, j.token, t.shardSize, j.storageLength, j.offlineSigning, j.renterId, t.fileSize, shardIndexes, nil)
+		if i == 0 {
+			seRes.ID = ssId
+		}
+		if len(targets) > 1 {
+			seRes.Sessions = append(seRes.Sessions, ssId)
+		}
+	}
+	if manifest != nil {
+		seRes.EncodedHash = manifest.EncodedHash
+	}
+	return seRes, nil
+}
+
+// getHostsProvider returns the provider of hosts for the shards of a session, leaving
+// out the blacklisted hosts. Hosts that ask more than the max price of the session are
+// skipped and recorded in the session.
+func getHostsProvider(rss *sessions.RenterSession, params *sessions.UploadParams, blacklist []string) (helper.IHostsProvider, error) {
+	if params.HostSelectMode == "custom" {
+		return helper.GetCustomizedHostsProvider(rss.CtxParams, params.HostIDs), nil
+	}
+	selector, err := helper.GetHostSelector(rss.CtxParams, params.HostSelectMode)
+	if err != nil {
+		return nil, err
+	}
+	ceiling := &helper.PriceCeiling{
+		MaxPrice: params.MaxPrice,
+		OnSkip: func(hostId string, priceAsk int64) {
+			err := rss.AddSkippedHost(&sessions.SkippedHost{
+				HostId:   hostId,
+				PriceAsk: priceAsk,
+				Reason:   fmt.Sprintf("price ask %d is higher than max price %d", priceAsk, params.MaxPrice),
+			})
+			if err != nil {
+				log.Debugf("record skipped host %s error: %s", hostId, err.Error())
+			}
+		},
+	}
+	return helper.GetHostsProviderWithSelector(rss.CtxParams, blacklist, selector, ceiling), nil
+}
+
 func SyncHosts(ctxParams *helper.ContextParams) error {
 	cfg, err := ctxParams.N.Repo.Config()
 	if err != nil {
@@ -256,4 +446,8 @@ func SyncHosts(ctxParams *helper.ContextParams) error {
 
 type Res struct {
 	ID string
+	// EncodedHash is the hash the file or directory was encoded to, if it was.
+	EncodedHash string `json:",omitempty"`
+	// Sessions are the sessions of a directory uploaded per file.
+	Sessions []string `json:",omitempty"`
 }
@@ -37,142 +37,236 @@ func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price in
 		return err
 	}
 
-	for index, shardHash := range rss.ShardHashes {
-		go func(i int, h string) {
-			err := backoff.Retry(func() error {
-				select {
-				case <-rss.Ctx.Done():
-					return nil
-				default:
-					break
+	// place asks hosts to take a shard until one does. A host that does not take
+	// it is blacklisted and the next one is asked, the shard is only failed when
+	// no host takes it in time.
+	place := func(i int, h string) {
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, i)
+		if err != nil {
+			_ = rss.To(sessions.RssToErrorEvent, err)
+			return
+		}
+		var hostErr error
+		err = backoff.Retry(func() error {
+			select {
+			case <-rss.Ctx.Done():
+				return nil
+			default:
+				break
+			}
+			release, err := scheduler.acquire(rss.Ctx, rss.SsId)
+			if err != nil {
+				return nil
+			}
+			defer release()
+			host, err := hp.NextValidHost()
+			if err != nil {
+				rss.AddEvent(sessions.ShardEvent(sessions.EventShardFailed, i, h, "", err))
+				terr := rss.To(sessions.RssToErrorEvent, err)
+				if terr != nil {
+					// Ignore err, just print error log
+					log.Debugf("original err: %s, transition err: %s", err.Error(), terr.Error())
 				}
-				host, err := hp.NextValidHost()
+				return nil
+			}
+			releaseHost, err := scheduler.acquireHost(rss.Ctx, host)
+			if err != nil {
+				return nil
+			}
+			defer releaseHost()
+			rss.AddEvent(sessions.ShardEvent(sessions.EventShardAttempt, i, h, host, nil))
+			failed := func(err error) error {
+				rss.AddEvent(sessions.ShardEvent(sessions.EventShardFailed, i, h, host, err))
+				return err
+			}
+			// hostFailed keeps the host from being asked again, the shard is retried
+			// with the next host
+			hostFailed := func(err error) error {
+				hostErr = failed(err)
+				hp.Blacklist(host)
+				if err := rss.AddFailedHost(&sessions.FailedHost{HostId: host, Reason: err.Error()}); err != nil {
+					log.Debugf("record failed host %s error: %s", host, err.Error())
+				}
+				return hostErr
+			}
+
+			hostPid, err := peer.Decode(host)
+			if err != nil {
+				log.Errorf("shard %s decodes host_pid error: %s", h, err.Error())
+				return hostFailed(err)
+			}
+
+			//token: check host tokens
+			{
+				ctx, _ := context.WithTimeout(rss.Ctx, 60*time.Second)
+				output, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/supporttokens")
 				if err != nil {
-					terr := rss.To(sessions.RssToErrorEvent, err)
-					if terr != nil {
-						// Ignore err, just print error log
-						log.Debugf("original err: %s, transition err: %s", err.Error(), terr.Error())
-					}
-					return nil
+					log.Debugf("uploadShard, remote.P2PCall(supporttokens) timeout, hostPid = %v, will try again.", hostPid)
+					return hostFailed(fmt.Errorf("supporttokens: %v", err))
 				}
 
-				hostPid, err := peer.Decode(host)
+				var mpToken map[string]common.Address
+				err = json.Unmarshal(output, &mpToken)
 				if err != nil {
-					log.Errorf("shard %s decodes host_pid error: %s", h, err.Error())
-					return err
+					return hostFailed(err)
 				}
 
-				//token: check host tokens
-				{
-					ctx, _ := context.WithTimeout(rss.Ctx, 60*time.Second)
-					output, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/supporttokens")
-					if err != nil {
-						fmt.Printf("uploadShard, remote.P2PCall(supporttokens) timeout, hostPid = %v, will try again. \n", hostPid)
-						return err
+				ok := false
+				for _, v := range mpToken {
+					if token == v {
+						ok = true
 					}
+				}
+				if !ok {
+					return hostFailed(errors.New("token not supported by host"))
+				}
+			}
+
+			// TotalPay
+			contractId := helper.NewContractID(rss.SsId)
+			cb := make(chan error)
+			ShardErrChanMap.Set(contractId, cb)
 
-					var mpToken map[string]common.Address
-					err = json.Unmarshal(output, &mpToken)
+			errChan := make(chan error, 2)
+			var guardContractBytes []byte
+			go func() {
+				tmp := func() error {
+					guardContractBytes, err = RenterSignGuardContract(rss, &ContractParams{
+						ContractId:    contractId,
+						RenterPid:     renterId.Pretty(),
+						HostPid:       host,
+						ShardIndex:    int32(i),
+						ShardHash:     h,
+						ShardSize:     shardSize,
+						FileHash:      rss.Hash,
+						StartTime:     time.Now(),
+						StorageLength: int64(storageLength),
+						Price:         price,
+						TotalPay:      expectOnePay,
+					}, offlineSigning, rp, token.String())
 					if err != nil {
+						log.Errorf("shard %s signs guard_contract error: %s", h, err.Error())
 						return err
 					}
-
-					ok := false
-					for _, v := range mpToken {
-						if token == v {
-							ok = true
-						}
-					}
-					if !ok {
-						return nil
-					}
+					return nil
+				}()
+				errChan <- tmp
+			}()
+			c := 0
+			for err := range errChan {
+				c++
+				if err != nil {
+					return failed(err)
 				}
+				if c >= 1 {
+					break
+				}
+			}
 
-				// TotalPay
-				contractId := helper.NewContractID(rss.SsId)
-				cb := make(chan error)
-				ShardErrChanMap.Set(contractId, cb)
-
-				errChan := make(chan error, 2)
-				var guardContractBytes []byte
-				go func() {
-					tmp := func() error {
-						guardContractBytes, err = RenterSignGuardContract(rss, &ContractParams{
-							ContractId:    contractId,
-							RenterPid:     renterId.Pretty(),
-							HostPid:       host,
-							ShardIndex:    int32(i),
-							ShardHash:     h,
-							ShardSize:     shardSize,
-							FileHash:      rss.Hash,
-							StartTime:     time.Now(),
-							StorageLength: int64(storageLength),
-							Price:         price,
-							TotalPay:      expectOnePay,
-						}, offlineSigning, rp, token.String())
-						if err != nil {
-							log.Errorf("shard %s signs guard_contract error: %s", h, err.Error())
-							return err
-						}
-						return nil
-					}()
-					errChan <- tmp
-				}()
-				c := 0
-				for err := range errChan {
-					c++
-					if err != nil {
-						return err
-					}
-					if c >= 1 {
-						break
-					}
+			go func() {
+				ctx, _ := context.WithTimeout(rss.Ctx, 10*time.Second)
+				_, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/init",
+					rss.SsId,
+					rss.Hash,
+					h,
+					price,
+					nil,
+					guardContractBytes,
+					storageLength,
+					shardSize,
+					i,
+					renterId,
+				)
+				if err != nil {
+					cb <- err
+				}
+			}()
+			// host needs to send recv in 30 seconds, or the contract will be invalid.
+			tick := time.Tick(30 * time.Second)
+			select {
+			case err = <-cb:
+				ShardErrChanMap.Remove(contractId)
+				recordHostOutcome(rss, host, err == nil)
+				if err != nil {
+					return hostFailed(err)
 				}
+				rss.AddEvent(sessions.ShardEvent(sessions.EventShardContracted, i, h, host, nil))
+				return nil
+			case <-tick:
+				ShardErrChanMap.Remove(contractId)
+				recordHostOutcome(rss, host, false)
+				return hostFailed(errors.New("host timeout"))
+			}
+		}, helper.HandleShardBo)
+		if err != nil {
+			if hostErr == nil {
+				hostErr = errors.New("timeout: failed to setup contract in " + helper.HandleShardBo.MaxElapsedTime.String())
+			}
+			if err := shard.Fail(hostErr); err != nil {
+				_ = rss.To(sessions.RssToErrorEvent, err)
+			}
+		}
+	}
+	for index, shardHash := range rss.ShardHashes {
+		i := shardIndexes[index]
+		shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, shardHash, i)
+		if err == nil {
+			err = resetShard(shard)
+		}
+		if err == errShardContracted {
+			// shards that already have a contract are kept when a session is resumed
+			continue
+		} else if err != nil {
+			_ = rss.To(sessions.RssToErrorEvent, err)
+			return err
+		}
+		go place(i, shardHash)
+	}
 
-				go func() {
-					ctx, _ := context.WithTimeout(rss.Ctx, 10*time.Second)
-					_, err := remote.P2PCall(ctx, rss.CtxParams.N, rss.CtxParams.Api, hostPid, "/storage/upload/init",
-						rss.SsId,
-						rss.Hash,
-						h,
-						price,
-						nil,
-						guardContractBytes,
-						storageLength,
-						shardSize,
-						i,
-						renterId,
-					)
-					if err != nil {
-						cb <- err
+	// failed shards are placed again with other hosts, as long as the retry budget lasts
+	budget := helper.GetUploadConfig(rss.CtxParams).ShardRetryBudget
+	retries, err := shardRetries(rss)
+	if err != nil {
+		return err
+	}
+	replaceFailedShards := func() error {
+		for index, h := range rss.ShardHashes {
+			i := shardIndexes[index]
+			shard, err := sessions.GetRenterShard(rss.CtxParams, rss.SsId, h, i)
+			if err != nil {
+				return err
+			}
+			if failed, err := shard.IsFailed(); err != nil {
+				return err
+			} else if !failed {
+				continue
+			}
+			if retries >= budget {
+				msg := fmt.Sprintf("there are some error shards and the retry budget of %d is used up", budget)
+				if events, err := rss.Events(); err == nil {
+					if reasons := sessions.FailureReasons(events); reasons != "" {
+						msg += ": " + reasons
 					}
-				}()
-				// host needs to send recv in 30 seconds, or the contract will be invalid.
-				tick := time.Tick(30 * time.Second)
-				select {
-				case err = <-cb:
-					ShardErrChanMap.Remove(contractId)
-					return err
-				case <-tick:
-					return errors.New("host timeout")
 				}
-			}, helper.HandleShardBo)
-			if err != nil {
-				_ = rss.To(sessions.RssToErrorEvent,
-					errors.New("timeout: failed to setup contract in "+helper.HandleShardBo.MaxElapsedTime.String()))
+				return errors.New(msg)
+			}
+			if err := shard.Reset(); err != nil {
+				return err
 			}
-		}(shardIndexes[index], shardHash)
+			retries++
+			event := sessions.ShardEvent(sessions.EventShardRequeued, i, h, "", nil)
+			event.Message = fmt.Sprintf("retry %d of %d", retries, budget)
+			rss.AddEvent(event)
+			go place(i, h)
+		}
+		return nil
 	}
 	// waiting for contracts of 30(n) shards
 	go func(rss *sessions.RenterSession, numShards int) {
-		tick := time.Tick(5 * time.Second)
-		for true {
-			select {
-			case <-tick:
-				completeNum, errorNum, err := rss.GetCompleteShardsNum()
-				if err != nil {
-					continue
-				}
+		updates := rss.ShardUpdates()
+		for {
+			completeNum, errorNum, err := rss.GetCompleteShardsNum()
+			if err == nil {
 				log.Info("session", rss.SsId, "contractNum", completeNum, "errorNum", errorNum)
 				if completeNum == numShards {
 					// while all shards upload completely, submit its.
@@ -182,10 +276,15 @@ func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price in
 					}
 					return
 				} else if errorNum > 0 {
-					_ = rss.To(sessions.RssToErrorEvent, errors.New("there are some error shards"))
-					log.Error("session:", rss.SsId, ",errorNum:", errorNum)
-					return
+					if err := replaceFailedShards(); err != nil {
+						_ = rss.To(sessions.RssToErrorEvent, err)
+						log.Error("session:", rss.SsId, ",errorNum:", errorNum)
+						return
+					}
 				}
+			}
+			select {
+			case <-updates:
 			case <-rss.Ctx.Done():
 				log.Infof("session %s done", rss.SsId)
 				return
@@ -195,3 +294,41 @@ func UploadShard(rss *sessions.RenterSession, hp helper.IHostsProvider, price in
 
 	return nil
 }
+
+var errShardContracted = errors.New("shard already contracted")
+
+// resetShard readies a shard to be placed: a failed shard is put back in init,
+// and errShardContracted is returned for a shard that has a contract.
+func resetShard(shard *sessions.RenterShard) error {
+	if contracted, err := shard.IsContracted(); err != nil {
+		return err
+	} else if contracted {
+		return errShardContracted
+	}
+	if failed, err := shard.IsFailed(); err != nil || !failed {
+		return err
+	}
+	return shard.Reset()
+}
+
+// shardRetries counts the shards of the session that were already placed again.
+func shardRetries(rss *sessions.RenterSession) (int, error) {
+	events, err := rss.Events()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range events {
+		if e.Type == sessions.EventShardRequeued {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// recordHostOutcome keeps track of contract outcomes for the reliability host selection strategy.
+func recordHostOutcome(rss *sessions.RenterSession, host string, success bool) {
+	if err := helper.RecordHostOutcome(rss.CtxParams, host, success); err != nil {
+		log.Debugf("record outcome of host %s error: %s", host, err.Error())
+	}
+}
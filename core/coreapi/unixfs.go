@@ -195,39 +195,33 @@ func (api *UnixfsAPI) Add(ctx context.Context, filesNode files.Node, opts ...opt
 			}
 		}
 		// log.Infof("The file will be encrypted with pubkey: %s", settings.Pubkey)
-		switch f := filesNode.(type) {
-		case files.File:
-			bytes, err := ioutil.ReadAll(f)
-			if err != nil {
-				return nil, err
-			}
-
-			ciphertext, metadata, err := ecies.Encrypt(pubKey, bytes)
-			if err != nil {
-				return nil, err
-			}
-			m := make(map[string]interface{})
-			m["Iv"] = metadata.Iv
-			m["EphemPublicKey"] = metadata.EphemPublicKey
-			m["Mac"] = metadata.Mac
-			m["Mode"] = metadata.Mode
-			if err != nil {
-				return nil, err
-			}
-
-			settings.TokenMetadata, err = api.appendMetaMap(settings.TokenMetadata, m)
-			if err != nil {
-				return nil, err
-			}
-			filesNode = files.NewBytesFile([]byte(ciphertext))
-		default:
-			return nil, notSupport(f)
+		if settings.NoCopy {
+			return nil, errors.New("encryption can not be used with nocopy")
+		}
+		if _, ok := filesNode.(files.Directory); ok && chunker.IsReedSolomon(settings.Chunker) {
+			return nil, errors.New("encryption of directories is not supported with reed-solomon chunker")
+		}
+		meta, masterKey, err := newEncryptionMeta(pubKey, segmentSizeFromChunker(settings.Chunker))
+		if err != nil {
+			return nil, err
+		}
+		settings.TokenMetadata, err = api.appendMetaMap(settings.TokenMetadata,
+			map[string]interface{}{encryptionMetaKey: meta})
+		if err != nil {
+			return nil, err
+		}
+		filesNode, err = encryptNode(filesNode, masterKey, meta.SegmentSize)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	if settings.PinDuration != 0 {
 		fileAdder.PinDuration = settings.PinDuration
 	}
+	if !settings.OnlyHash {
+		fileAdder.PinExpiryStore = api.repo.Datastore()
+	}
 	// This block is intentionally placed here so that
 	// any execution case can append metadata
 	if settings.TokenMetadata != "" {
@@ -320,36 +314,12 @@ func (api *UnixfsAPI) Get(ctx context.Context, p path.Path, opts ...options.Unix
 	if !settings.Metadata && settings.Decrypt {
 		node, err = unixfile.NewUnixfsFile(ctx, ses.dag, nd,
 			unixfile.UnixfsFileOptions{RepairShards: settings.Repairs})
-		switch f := node.(type) {
-		case files.File:
-			privKey, err := api.getPrivateKey(settings.PrivateKey)
-			if err != nil {
-				return nil, err
-			}
-
-			mbytes, err := api.GetMetadata(ctx, p)
-			if err != nil {
-				return nil, err
-			}
-
-			t := &ecies.EciesMetadata{}
-			err = json.Unmarshal(mbytes, t)
-			if err != nil {
-				return nil, err
-			}
-
-			bytes, err := ioutil.ReadAll(f)
-			if err != nil {
-				return nil, err
-			}
-
-			s, err := ecies.Decrypt(privKey, string(bytes), t)
-			if err != nil {
-				return nil, err
-			}
-			node = files.NewBytesFile([]byte(s))
-		default:
-			return nil, notSupport(f)
+		if err != nil {
+			return nil, err
+		}
+		node, err = api.decrypt(ctx, p, node, settings.PrivateKey)
+		if err != nil {
+			return nil, err
 		}
 	} else {
 		var ds ipld.DAGService
@@ -395,6 +365,78 @@ func (api *UnixfsAPI) Get(ctx context.Context, p path.Path, opts ...options.Unix
 	return node, err
 }
 
+// decrypt wraps the node at p for decryption. Files and directories added with
+// streaming encryption are decrypted lazily, either from their own metadata or from
+// the metadata of the root they were added with, while files encrypted in one
+// piece by older versions are decrypted in memory.
+func (api *UnixfsAPI) decrypt(ctx context.Context, p path.Path, node files.Node, privateKey string) (files.Node, error) {
+	privKey, err := api.getPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	mbytes, err := api.GetMetadata(ctx, p)
+	if err == nil && len(mbytes) > 0 {
+		meta, err := parseEncryptionMeta(mbytes)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return decryptLegacy(node, privKey, mbytes)
+		}
+		return decryptWithMeta(node, privKey, meta)
+	}
+	// files inside an encrypted directory only carry metadata on the root
+	root, _, perr := splitPath(p)
+	if perr != nil {
+		if err == nil {
+			err = fmt.Errorf("%s is not encrypted", p.String())
+		}
+		return nil, err
+	}
+	mbytes, err = api.GetMetadata(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseEncryptionMeta(mbytes)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("%s is not encrypted", p.String())
+	}
+	return decryptWithMeta(node, privKey, meta)
+}
+
+func decryptWithMeta(node files.Node, privKey string, meta *encryptionMeta) (files.Node, error) {
+	masterKey, err := meta.masterKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptNode(node, masterKey, meta.SegmentSize)
+}
+
+func decryptLegacy(node files.Node, privKey string, mbytes []byte) (files.Node, error) {
+	f, ok := node.(files.File)
+	if !ok {
+		return nil, notSupport(node)
+	}
+	t := &ecies.EciesMetadata{}
+	err := json.Unmarshal(mbytes, t)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ecies.Decrypt(privKey, string(bytes), t)
+	if err != nil {
+		return nil, err
+	}
+	return files.NewBytesFile([]byte(s)), nil
+}
+
 func (api *UnixfsAPI) getReedSolomonFile(ctx context.Context, settings *options.UnixfsGetSettings,
 	p path.Path) (n files.Node, e error) {
 	defer func() {
@@ -0,0 +1,506 @@
+package coreapi
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	files "github.com/bittorrent/go-btfs-files"
+	ecies "github.com/bittorrent/go-eccrypto"
+)
+
+// Files added with `--encrypt` are stored as a sequence of AES-GCM sealed
+// segments. Every file gets its own random key, which heads the file wrapped
+// with a random master key. The master key is wrapped with ECIES for the
+// receiver and recorded in the token metadata, so a whole directory tree can be
+// decrypted by the owner of the private key.
+//
+// The wrapped key takes the place of the start of the first segment, so that
+// every sealed segment still fills exactly one chunk of a fixed size chunker.
+const (
+	encryptionMetaKey  = "Encryption"
+	encryptionVersion  = 1
+	encryptionCipher   = "aes-256-gcm"
+	encryptionKeySize  = 32
+	gcmNonceSize       = 12
+	gcmTagSize         = 16
+	fileKeySize        = gcmNonceSize + encryptionKeySize + gcmTagSize
+	defaultChunkerSize = 256 * 1024
+)
+
+var fileKeyAad = []byte("btfs-file-key")
+
+var errEncryptedSeek = errors.New("seek is not supported while encrypting")
+
+type encryptionMeta struct {
+	Version     int
+	Cipher      string
+	SegmentSize int64
+	Key         string
+	KeyMeta     *ecies.EciesMetadata
+}
+
+// segmentSizeFromChunker returns the plaintext size of a segment, chosen so that
+// every sealed segment fills exactly one chunk of a fixed size chunker.
+func segmentSizeFromChunker(chunkerStr string) int64 {
+	size := int64(defaultChunkerSize)
+	if strings.HasPrefix(chunkerStr, "size-") {
+		if s, err := strconv.ParseInt(strings.TrimPrefix(chunkerStr, "size-"), 10, 64); err == nil && s > gcmTagSize+fileKeySize {
+			size = s
+		}
+	}
+	return size - gcmTagSize
+}
+
+// newEncryptionMeta creates a random master key, which wraps the keys of the
+// files, and wraps it for pubKey.
+func newEncryptionMeta(pubKey string, segmentSize int64) (*encryptionMeta, []byte, error) {
+	masterKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, keyMeta, err := ecies.Encrypt(pubKey, masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &encryptionMeta{
+		Version:     encryptionVersion,
+		Cipher:      encryptionCipher,
+		SegmentSize: segmentSize,
+		Key:         wrapped,
+		KeyMeta:     keyMeta,
+	}, masterKey, nil
+}
+
+// masterKey unwraps the master key with the given private key.
+func (m *encryptionMeta) masterKey(privKey string) ([]byte, error) {
+	if m.Version != encryptionVersion || m.Cipher != encryptionCipher {
+		return nil, fmt.Errorf("unsupported encryption: version %d, cipher %s", m.Version, m.Cipher)
+	}
+	if m.SegmentSize <= fileKeySize || m.KeyMeta == nil {
+		return nil, errors.New("invalid encryption metadata")
+	}
+	key, err := ecies.Decrypt(privKey, m.Key, m.KeyMeta)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != encryptionKeySize {
+		return nil, errors.New("failed to unwrap the master key, wrong private key?")
+	}
+	return []byte(key), nil
+}
+
+// parseEncryptionMeta extracts the encryption entry from token metadata, it returns
+// nil without error if the metadata does not describe streaming encryption.
+func parseEncryptionMeta(metadata []byte) (*encryptionMeta, error) {
+	m := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(metadata, &m); err != nil {
+		return nil, err
+	}
+	raw, ok := m[encryptionMetaKey]
+	if !ok {
+		return nil, nil
+	}
+	em := new(encryptionMeta)
+	if err := json.Unmarshal(raw, em); err != nil {
+		return nil, err
+	}
+	return em, nil
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newFileKey creates a random file key, and returns it wrapped with the master
+// key along with its cipher.
+func newFileKey(masterKey []byte) ([]byte, cipher.AEAD, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	wrap, err := newAead(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAead(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wrap.Seal(nonce, nonce, key, fileKeyAad), aead, nil
+}
+
+// openFileKey unwraps a file key with the master key and returns its cipher.
+func openFileKey(masterKey []byte, wrapped []byte) (cipher.AEAD, error) {
+	wrap, err := newAead(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := wrap.Open(nil, wrapped[:gcmNonceSize], wrapped[gcmNonceSize:], fileKeyAad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the file key: %v", err)
+	}
+	return newAead(key)
+}
+
+// segmentNonce and segmentAad bind each segment to its position, and mark the
+// last one so that a truncated file can not be decrypted.
+func segmentNonce(index int64) []byte {
+	nonce := make([]byte, gcmNonceSize)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+func segmentAad(index int64, last bool) []byte {
+	aad := make([]byte, 9)
+	binary.BigEndian.PutUint64(aad, uint64(index))
+	if last {
+		aad[8] = 1
+	}
+	return aad
+}
+
+// segmentCount returns how many segments a file of plainSize bytes is sealed
+// in, the first one is shorter by the wrapped file key.
+func segmentCount(plainSize int64, segmentSize int64) int64 {
+	return (plainSize + fileKeySize + segmentSize - 1) / segmentSize
+}
+
+// encryptNode wraps a file or a directory tree so that it is encrypted while
+// it is read by the adder.
+func encryptNode(node files.Node, masterKey []byte, segmentSize int64) (files.Node, error) {
+	switch n := node.(type) {
+	case *files.Symlink:
+		return n, nil
+	case files.File:
+		wrapped, aead, err := newFileKey(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		return &encryptingFile{
+			src:         n,
+			buf:         bufio.NewReaderSize(n, int(segmentSize)+1),
+			aead:        aead,
+			segmentSize: segmentSize,
+			plain:       make([]byte, segmentSize),
+			sealed:      wrapped,
+		}, nil
+	case files.Directory:
+		return &encryptingDirectory{
+			Directory:   n,
+			masterKey:   masterKey,
+			segmentSize: segmentSize,
+		}, nil
+	default:
+		return nil, notSupport(n)
+	}
+}
+
+type encryptingFile struct {
+	src         files.File
+	buf         *bufio.Reader
+	aead        cipher.AEAD
+	segmentSize int64
+	index       int64
+	plain       []byte
+	sealed      []byte
+	done        bool
+}
+
+func (f *encryptingFile) Read(p []byte) (int, error) {
+	for len(f.sealed) == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		if err := f.sealNext(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.sealed)
+	f.sealed = f.sealed[n:]
+	return n, nil
+}
+
+func (f *encryptingFile) sealNext() error {
+	plain := f.plain
+	if f.index == 0 {
+		plain = plain[:f.segmentSize-fileKeySize]
+	}
+	n, err := io.ReadFull(f.buf, plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err != nil
+	if !last {
+		if _, err := f.buf.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	f.sealed = f.aead.Seal(f.sealed[:0], segmentNonce(f.index), f.plain[:n], segmentAad(f.index, last))
+	f.index++
+	f.done = last
+	return nil
+}
+
+func (f *encryptingFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errEncryptedSeek
+}
+
+func (f *encryptingFile) Size() (int64, error) {
+	size, err := f.src.Size()
+	if err != nil {
+		return 0, err
+	}
+	return fileKeySize + size + segmentCount(size, f.segmentSize)*gcmTagSize, nil
+}
+
+func (f *encryptingFile) Close() error {
+	return f.src.Close()
+}
+
+type encryptingDirectory struct {
+	files.Directory
+	masterKey   []byte
+	segmentSize int64
+}
+
+func (d *encryptingDirectory) Entries() files.DirIterator {
+	return &encryptingIterator{
+		DirIterator: d.Directory.Entries(),
+		dir:         d,
+	}
+}
+
+type encryptingIterator struct {
+	files.DirIterator
+	dir  *encryptingDirectory
+	node files.Node
+	err  error
+}
+
+func (it *encryptingIterator) Next() bool {
+	if !it.DirIterator.Next() {
+		return false
+	}
+	it.node, it.err = encryptNode(it.DirIterator.Node(), it.dir.masterKey, it.dir.segmentSize)
+	return it.err == nil
+}
+
+func (it *encryptingIterator) Node() files.Node {
+	return it.node
+}
+
+func (it *encryptingIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
+
+// decryptNode wraps a file or a directory tree so that it is decrypted lazily
+// while it is read.
+func decryptNode(node files.Node, masterKey []byte, segmentSize int64) (files.Node, error) {
+	switch n := node.(type) {
+	case nil:
+		// the metadata entry of a directory has no node
+		return nil, nil
+	case *files.Symlink:
+		return n, nil
+	case files.File:
+		cipherSize, err := n.Size()
+		if err != nil {
+			return nil, err
+		}
+		sealedSize := segmentSize + gcmTagSize
+		segments := (cipherSize + sealedSize - 1) / sealedSize
+		if cipherSize < fileKeySize+gcmTagSize || cipherSize-(segments-1)*sealedSize < gcmTagSize {
+			return nil, errors.New("invalid encrypted file size")
+		}
+		return &decryptingFile{
+			src:         n,
+			masterKey:   masterKey,
+			segmentSize: segmentSize,
+			segments:    segments,
+			size:        cipherSize - fileKeySize - segments*gcmTagSize,
+			current:     -1,
+			sealed:      make([]byte, sealedSize),
+		}, nil
+	case files.Directory:
+		return &decryptingDirectory{
+			Directory:   n,
+			masterKey:   masterKey,
+			segmentSize: segmentSize,
+		}, nil
+	default:
+		return nil, notSupport(n)
+	}
+}
+
+type decryptingFile struct {
+	src         files.File
+	masterKey   []byte
+	aead        cipher.AEAD
+	segmentSize int64
+	segments    int64
+	size        int64
+	offset      int64
+	srcOffset   int64
+	current     int64
+	sealed      []byte
+	plain       []byte
+}
+
+func (f *decryptingFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	// offsets are counted from the wrapped file key, so that the segments
+	// after the shorter first one start at a multiple of the segment size
+	index := (f.offset + fileKeySize) / f.segmentSize
+	if index != f.current {
+		if err := f.openSegment(index); err != nil {
+			return 0, err
+		}
+	}
+	start := index * f.segmentSize
+	if index == 0 {
+		start = fileKeySize
+	}
+	n := copy(p, f.plain[f.offset+fileKeySize-start:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// openFileKey reads the wrapped file key at the start of the file.
+func (f *decryptingFile) openFileKey() error {
+	if f.srcOffset != 0 {
+		if _, err := f.src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		f.srcOffset = 0
+	}
+	wrapped := make([]byte, fileKeySize)
+	n, err := io.ReadFull(f.src, wrapped)
+	f.srcOffset += int64(n)
+	if err != nil {
+		return err
+	}
+	f.aead, err = openFileKey(f.masterKey, wrapped)
+	return err
+}
+
+func (f *decryptingFile) openSegment(index int64) error {
+	if f.aead == nil {
+		if err := f.openFileKey(); err != nil {
+			return err
+		}
+	}
+	sealedSize := f.segmentSize + gcmTagSize
+	sealed := f.sealed
+	pos := index * sealedSize
+	if index == 0 {
+		sealed = sealed[:sealedSize-fileKeySize]
+		pos = fileKeySize
+	}
+	// only seek the source for ranged reads, so sequential reads work on any file
+	if pos != f.srcOffset {
+		if _, err := f.src.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		f.srcOffset = pos
+	}
+	n, err := io.ReadFull(f.src, sealed)
+	f.srcOffset += int64(n)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := index == f.segments-1
+	f.plain, err = f.aead.Open(f.plain[:0], segmentNonce(index), sealed[:n], segmentAad(index, last))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %v", index, err)
+	}
+	f.current = index
+	return nil
+}
+
+func (f *decryptingFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *decryptingFile) Size() (int64, error) {
+	return f.size, nil
+}
+
+func (f *decryptingFile) Close() error {
+	return f.src.Close()
+}
+
+type decryptingDirectory struct {
+	files.Directory
+	masterKey   []byte
+	segmentSize int64
+}
+
+func (d *decryptingDirectory) Entries() files.DirIterator {
+	return &decryptingIterator{
+		DirIterator: d.Directory.Entries(),
+		dir:         d,
+	}
+}
+
+type decryptingIterator struct {
+	files.DirIterator
+	dir  *decryptingDirectory
+	node files.Node
+	err  error
+}
+
+func (it *decryptingIterator) Next() bool {
+	if !it.DirIterator.Next() {
+		return false
+	}
+	it.node, it.err = decryptNode(it.DirIterator.Node(), it.dir.masterKey, it.dir.segmentSize)
+	return it.err == nil
+}
+
+func (it *decryptingIterator) Node() files.Node {
+	return it.node
+}
+
+func (it *decryptingIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
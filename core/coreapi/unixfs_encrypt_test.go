@@ -0,0 +1,340 @@
+package coreapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/keystore"
+	"github.com/bittorrent/go-btfs/repo"
+
+	config "github.com/bittorrent/go-btfs-config"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/bittorrent/interface-go-btfs-core/path"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	ci "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type bytesSeekFile struct {
+	*bytes.Reader
+}
+
+func (f *bytesSeekFile) Close() error {
+	return nil
+}
+
+func (f *bytesSeekFile) Size() (int64, error) {
+	return f.Reader.Size(), nil
+}
+
+func encryptBytes(t *testing.T, masterKey []byte, data []byte, segmentSize int64) []byte {
+	n, err := encryptNode(files.NewBytesFile(data), masterKey, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := n.(files.File)
+	ciphertext, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := f.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(len(ciphertext)), size)
+	return ciphertext
+}
+
+func TestEncryptDecryptSegments(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{7}, encryptionKeySize)
+	segmentSize := int64(64)
+	for _, l := range []int{0, 1, 3, 4, 5, 63, 64, 65, 68, 69, 128, 1000} {
+		data := make([]byte, l)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		ciphertext := encryptBytes(t, masterKey, data, segmentSize)
+		n, err := decryptNode(&bytesSeekFile{bytes.NewReader(ciphertext)}, masterKey, segmentSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := n.(files.File)
+		size, err := f.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, int64(l), size)
+		plaintext, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, data, plaintext)
+
+		// ranged reads only open the segments they need
+		if l > 70 {
+			_, err = f.Seek(70, io.SeekStart)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, data[70:], rest)
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{7}, encryptionKeySize)
+	segmentSize := int64(64)
+	data := bytes.Repeat([]byte("btfs"), 100)
+	ciphertext := encryptBytes(t, masterKey, data, segmentSize)
+
+	// every file has its own random key
+	assert.NotEqual(t, ciphertext, encryptBytes(t, masterKey, data, segmentSize))
+
+	// which only the master key unwraps
+	n, err := decryptNode(files.NewBytesFile(ciphertext), bytes.Repeat([]byte{8}, encryptionKeySize), segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(n.(files.File))
+	assert.Error(t, err)
+
+	// dropping whole trailing segments is detected
+	truncated := ciphertext[:2*(segmentSize+gcmTagSize)]
+	n, err = decryptNode(files.NewBytesFile(truncated), masterKey, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(n.(files.File))
+	assert.Error(t, err)
+}
+
+func TestEncryptDecryptDirectory(t *testing.T) {
+	masterKey := bytes.Repeat([]byte{7}, encryptionKeySize)
+	segmentSize := int64(64)
+	data := map[string][]byte{
+		"a":       []byte("top"),
+		"d/b":     bytes.Repeat([]byte("b"), 100),
+		"d/e/c":   bytes.Repeat([]byte("c"), 200),
+		"d/e/f/g": {},
+	}
+	tree := files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile(data["a"]),
+		"d": files.NewMapDirectory(map[string]files.Node{
+			"b": files.NewBytesFile(data["d/b"]),
+			"e": files.NewMapDirectory(map[string]files.Node{
+				"c": files.NewBytesFile(data["d/e/c"]),
+				"f": files.NewMapDirectory(map[string]files.Node{
+					"g": files.NewBytesFile(data["d/e/f/g"]),
+				}),
+			}),
+		}),
+	})
+	n, err := encryptNode(tree, masterKey, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the encrypted tree back the way it was added
+	var sealed func(d files.Directory) files.Directory
+	sealed = func(d files.Directory) files.Directory {
+		m := make(map[string]files.Node)
+		it := d.Entries()
+		for it.Next() {
+			switch n := it.Node().(type) {
+			case files.File:
+				b, err := ioutil.ReadAll(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m[it.Name()] = &bytesSeekFile{bytes.NewReader(b)}
+			case files.Directory:
+				m[it.Name()] = sealed(n)
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return files.NewMapDirectory(m)
+	}
+	n, err = decryptNode(sealed(n.(files.Directory)), masterKey, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := make(map[string][]byte)
+	err = files.Walk(n, func(fpath string, nd files.Node) error {
+		if f, ok := nd.(files.File); ok {
+			b, err := ioutil.ReadAll(f)
+			plain[fpath] = b
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, plain)
+}
+
+func TestSegmentSizeFromChunker(t *testing.T) {
+	assert.Equal(t, int64(defaultChunkerSize-gcmTagSize), segmentSizeFromChunker(""))
+	assert.Equal(t, int64(1024-gcmTagSize), segmentSizeFromChunker("size-1024"))
+	assert.Equal(t, int64(defaultChunkerSize-gcmTagSize), segmentSizeFromChunker("rabin"))
+}
+
+// newEncryptionAPI returns the api of an offline node with a secp256k1 key, the
+// files it adds are encrypted for itself.
+func newEncryptionAPI(t *testing.T) coreiface.CoreAPI {
+	sk, pk, err := ci.GenerateSecp256k1Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kbytes, err := ci.MarshalPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := config.Config{}
+	c.Identity = config.Identity{
+		PeerID:  id.Pretty(),
+		PrivKey: base64.StdEncoding.EncodeToString(kbytes),
+	}
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{
+		Repo: &repo.Mock{
+			C: c,
+			D: syncds.MutexWrap(datastore.NewMapDatastore()),
+			K: keystore.NewMemKeystore(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	api, err := NewCoreAPI(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func getDecrypted(t *testing.T, api coreiface.CoreAPI, p path.Path) files.Node {
+	n, err := api.Unixfs().Get(context.Background(), p, options.Unixfs.Decrypt(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEncryptAddGet(t *testing.T) {
+	api := newEncryptionAPI(t)
+	ctx := context.Background()
+	data := make([]byte, 5000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := api.Unixfs().Add(ctx, files.NewBytesFile(data), options.Unixfs.Encrypt(true),
+		options.Unixfs.Chunker("size-1024"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what is stored is not the file
+	n, err := api.Unixfs().Get(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadAll(n.(files.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(stored), string(data[:64]))
+
+	f := getDecrypted(t, api, p).(files.File)
+	size, err := f.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(len(data)), size)
+	plain, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, plain)
+
+	// a ranged read across segments
+	f = getDecrypted(t, api, p).(files.File)
+	if _, err := f.Seek(3000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 1500)
+	if _, err := io.ReadFull(f, part); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data[3000:4500], part)
+}
+
+func TestEncryptAddGetDirectory(t *testing.T) {
+	api := newEncryptionAPI(t)
+	ctx := context.Background()
+	data := map[string][]byte{
+		"a":     []byte("top"),
+		"d/b":   bytes.Repeat([]byte("b"), 3000),
+		"d/e/c": bytes.Repeat([]byte("c"), 5000),
+	}
+	tree := files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile(data["a"]),
+		"d": files.NewMapDirectory(map[string]files.Node{
+			"b": files.NewBytesFile(data["d/b"]),
+			"e": files.NewMapDirectory(map[string]files.Node{
+				"c": files.NewBytesFile(data["d/e/c"]),
+			}),
+		}),
+	})
+	p, err := api.Unixfs().Add(ctx, tree, options.Unixfs.Encrypt(true), options.Unixfs.Chunker("size-1024"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := make(map[string][]byte)
+	err = files.Walk(getDecrypted(t, api, p), func(fpath string, nd files.Node) error {
+		if f, ok := nd.(files.File); ok {
+			b, err := ioutil.ReadAll(f)
+			plain[fpath] = b
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, plain)
+
+	// a file of the tree is decrypted on its own, with the metadata of the root
+	f := getDecrypted(t, api, path.Join(p, "d", "e", "c")).(files.File)
+	if _, err := f.Seek(2000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data["d/e/c"][2000:], rest)
+}
@@ -0,0 +1,160 @@
+package corerepo
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+const pinExpiryKeyPrefix = "/btfs/pins/expiry/"
+
+// PinReapInterval is how often the daemon looks for expired pins.
+var PinReapInterval = time.Minute
+
+// PinExpiry records the time after which a pin is removed by the daemon.
+type PinExpiry struct {
+	Cid       string
+	Recursive bool
+	ExpiresAt time.Time
+}
+
+// Remaining returns the lifetime left for the pin at the given time.
+func (e *PinExpiry) Remaining(now time.Time) time.Duration {
+	if d := e.ExpiresAt.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// PinDurationDays converts a pin duration count, given in days, to a time.Duration.
+func PinDurationDays(count int64) time.Duration {
+	return time.Duration(count) * 24 * time.Hour
+}
+
+func pinExpiryKey(c cid.Cid) ds.Key {
+	return ds.NewKey(pinExpiryKeyPrefix + c.String())
+}
+
+// SetPinExpiry stores the expiry time of the pin on c.
+func SetPinExpiry(ctx context.Context, d ds.Datastore, c cid.Cid, recursive bool, expiresAt time.Time) error {
+	b, err := json.Marshal(&PinExpiry{
+		Cid:       c.String(),
+		Recursive: recursive,
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, pinExpiryKey(c), b)
+}
+
+// GetPinExpiry returns the expiry of the pin on c, or ds.ErrNotFound if the pin never expires.
+func GetPinExpiry(ctx context.Context, d ds.Datastore, c cid.Cid) (*PinExpiry, error) {
+	b, err := d.Get(ctx, pinExpiryKey(c))
+	if err != nil {
+		return nil, err
+	}
+	e := new(PinExpiry)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// RemovePinExpiry makes the pin on c permanent again. It is a no-op if no expiry is set.
+func RemovePinExpiry(ctx context.Context, d ds.Datastore, c cid.Cid) error {
+	err := d.Delete(ctx, pinExpiryKey(c))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// ListPinExpiries returns the expiries of all pins, keyed by cid string.
+func ListPinExpiries(ctx context.Context, d ds.Datastore) (map[string]*PinExpiry, error) {
+	results, err := d.Query(ctx, dsq.Query{Prefix: pinExpiryKeyPrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	expiries := make(map[string]*PinExpiry)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		e := new(PinExpiry)
+		if err := json.Unmarshal(r.Value, e); err != nil {
+			log.Warnf("skip malformed pin expiry %s: %v", r.Key, err)
+			continue
+		}
+		expiries[e.Cid] = e
+	}
+	return expiries, nil
+}
+
+// ReapExpiredPins unpins every pin that has expired at the given time, and
+// returns the cids that were unpinned.
+func ReapExpiredPins(ctx context.Context, n *core.IpfsNode, now time.Time) ([]cid.Cid, error) {
+	d := n.Repo.Datastore()
+	expiries, err := ListPinExpiries(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	reaped := make([]cid.Cid, 0)
+	for _, e := range expiries {
+		if now.Before(e.ExpiresAt) {
+			continue
+		}
+		c, err := cid.Decode(e.Cid)
+		if err != nil {
+			return reaped, err
+		}
+		unpinErr := n.Pinning.Unpin(ctx, c, e.Recursive)
+		if unpinErr != nil && unpinErr != pin.ErrNotPinned {
+			return reaped, unpinErr
+		}
+		if err := RemovePinExpiry(ctx, d, c); err != nil {
+			return reaped, err
+		}
+		// the pin may have been removed by hand in the meantime
+		if unpinErr == nil {
+			reaped = append(reaped, c)
+		}
+	}
+	if len(reaped) == 0 {
+		return reaped, nil
+	}
+	return reaped, n.Pinning.Flush(ctx)
+}
+
+// PeriodicPinReaper unpins expired pins every PinReapInterval, and runs a
+// garbage collection whenever something was unpinned.
+func PeriodicPinReaper(ctx context.Context, node *core.IpfsNode) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(PinReapInterval):
+			reaped, err := ReapExpiredPins(ctx, node, time.Now())
+			if err != nil {
+				log.Error(err)
+			}
+			if len(reaped) == 0 {
+				continue
+			}
+			log.Infof("unpinned %d expired pins", len(reaped))
+			if err := GarbageCollect(node, ctx); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package corerepo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/core/corerepo"
+	coremock "github.com/bittorrent/go-btfs/core/mock"
+
+	ds "github.com/ipfs/go-datastore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestPinExpiryStore(t *testing.T) {
+	ctx := context.Background()
+	d := ds.NewMapDatastore()
+	c := dag.NodeWithData([]byte("expiring")).Cid()
+
+	if _, err := corerepo.GetPinExpiry(ctx, d, c); err != ds.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	expiresAt := time.Now().Add(corerepo.PinDurationDays(2))
+	if err := corerepo.SetPinExpiry(ctx, d, c, true, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	e, err := corerepo.GetPinExpiry(ctx, d, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Recursive || !e.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected expiry %+v", e)
+	}
+	if r := e.Remaining(expiresAt.Add(-time.Hour)); r != time.Hour {
+		t.Fatalf("expected an hour left, got %s", r)
+	}
+	if r := e.Remaining(expiresAt.Add(time.Hour)); r != 0 {
+		t.Fatalf("expected no time left, got %s", r)
+	}
+
+	expiries, err := corerepo.ListPinExpiries(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expiries) != 1 || expiries[c.String()] == nil {
+		t.Fatalf("unexpected expiries %v", expiries)
+	}
+
+	if err := corerepo.RemovePinExpiry(ctx, d, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := corerepo.RemovePinExpiry(ctx, d, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := corerepo.GetPinExpiry(ctx, d, c); err != ds.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReapExpiredPins(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	expired := dag.NodeWithData([]byte("expired"))
+	alive := dag.NodeWithData([]byte("alive"))
+	for _, nd := range []*dag.ProtoNode{expired, alive} {
+		if err := n.DAG.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Pinning.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Pinning.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d := n.Repo.Datastore()
+	if err := corerepo.SetPinExpiry(ctx, d, expired.Cid(), true, now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := corerepo.SetPinExpiry(ctx, d, alive.Cid(), true, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	reaped, err := corerepo.ReapExpiredPins(ctx, n, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reaped) != 1 || !reaped[0].Equals(expired.Cid()) {
+		t.Fatalf("unexpected reaped pins %v", reaped)
+	}
+	if _, pinned, err := n.Pinning.IsPinnedWithType(ctx, expired.Cid(), pin.Recursive); err != nil || pinned {
+		t.Fatalf("expired pin still pinned, err: %v", err)
+	}
+	if _, pinned, err := n.Pinning.IsPinnedWithType(ctx, alive.Cid(), pin.Recursive); err != nil || !pinned {
+		t.Fatalf("alive pin was unpinned, err: %v", err)
+	}
+	if _, err := corerepo.GetPinExpiry(ctx, d, expired.Cid()); err != ds.ErrNotFound {
+		t.Fatalf("expected expiry to be removed, got %v", err)
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"io"
 	gopath "path"
 	"strconv"
+	"time"
 
 	chunker "github.com/bittorrent/go-btfs-chunker"
 	files "github.com/bittorrent/go-btfs-files"
+	"github.com/bittorrent/go-btfs/core/corerepo"
 	"github.com/bittorrent/go-mfs"
 	"github.com/bittorrent/go-unixfs"
 	"github.com/bittorrent/go-unixfs/importer/balanced"
@@ -21,6 +23,7 @@ import (
 	coreiface "github.com/bittorrent/interface-go-btfs-core"
 	"github.com/bittorrent/interface-go-btfs-core/path"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	pin "github.com/ipfs/go-ipfs-pinner"
 	posinfo "github.com/ipfs/go-ipfs-posinfo"
@@ -94,6 +97,9 @@ type Adder struct {
 	liveNodes        uint64
 	TokenMetadata    string
 	PinDuration      int64
+	// PinExpiryStore is where the expiry of the root pin is recorded
+	// when PinDuration is set.
+	PinExpiryStore datastore.Datastore
 }
 
 func (adder *Adder) GcLocker() bstore.GCLocker {
@@ -273,10 +279,36 @@ func (adder *Adder) PinRoot(ctx context.Context, root ipld.Node) error {
 		if err != nil {
 			return err
 		}
+		if err := adder.removePinExpiry(ctx, adder.tempRoot); err != nil {
+			return err
+		}
 		adder.tempRoot = rnk
 	}
 	adder.pinning.PinWithMode(rnk, pin.Recursive)
-	return adder.pinning.Flush(ctx)
+	if err := adder.pinning.Flush(ctx); err != nil {
+		return err
+	}
+	return adder.setPinExpiry(ctx, rnk)
+}
+
+// setPinExpiry records when the pin on root expires. Pinning without
+// a duration makes the pin permanent.
+func (adder *Adder) setPinExpiry(ctx context.Context, root cid.Cid) error {
+	if adder.PinExpiryStore == nil {
+		return nil
+	}
+	if adder.PinDuration <= 0 {
+		return corerepo.RemovePinExpiry(ctx, adder.PinExpiryStore, root)
+	}
+	expiresAt := time.Now().Add(corerepo.PinDurationDays(adder.PinDuration))
+	return corerepo.SetPinExpiry(ctx, adder.PinExpiryStore, root, true, expiresAt)
+}
+
+func (adder *Adder) removePinExpiry(ctx context.Context, root cid.Cid) error {
+	if adder.PinExpiryStore == nil {
+		return nil
+	}
+	return corerepo.RemovePinExpiry(ctx, adder.PinExpiryStore, root)
 }
 
 // outputDirs outputs directory dagnodes in a postorder DFS pattern.
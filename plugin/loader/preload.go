@@ -5,6 +5,7 @@ import (
 	pluginflatfs "github.com/bittorrent/go-btfs/plugin/plugins/flatfs"
 	pluginipldgit "github.com/bittorrent/go-btfs/plugin/plugins/git"
 	pluginlevelds "github.com/bittorrent/go-btfs/plugin/plugins/levelds"
+	pluginpool "github.com/bittorrent/go-btfs/plugin/plugins/pool"
 )
 
 // DO NOT EDIT THIS FILE
@@ -16,4 +17,5 @@ func init() {
 	Preload(pluginbadgerds.Plugins...)
 	Preload(pluginflatfs.Plugins...)
 	Preload(pluginlevelds.Plugins...)
+	Preload(pluginpool.Plugins...)
 }
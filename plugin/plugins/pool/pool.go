@@ -0,0 +1,173 @@
+package pool
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bittorrent/go-btfs/plugin"
+	"github.com/bittorrent/go-btfs/repo"
+	"github.com/bittorrent/go-btfs/repo/fsrepo"
+	"github.com/bittorrent/go-btfs/repo/pool"
+
+	"github.com/dustin/go-humanize"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+)
+
+// Plugins is exported list of plugins that will be loaded
+var Plugins = []plugin.Plugin{
+	&poolPlugin{},
+}
+
+type poolPlugin struct{}
+
+var _ plugin.PluginDatastore = (*poolPlugin)(nil)
+
+func (*poolPlugin) Name() string {
+	return "ds-pool"
+}
+
+func (*poolPlugin) Version() string {
+	return "0.1.0"
+}
+
+func (*poolPlugin) Init(_ *plugin.Environment) error {
+	return nil
+}
+
+func (*poolPlugin) DatastoreTypeName() string {
+	return "pool"
+}
+
+type datastoreConfig struct {
+	path      string
+	shardFun  *flatfs.ShardIdV1
+	syncField bool
+	policy    string
+	capacity  uint64
+	roots     []pool.RootConfig
+}
+
+// DatastoreConfigParser returns a configuration stub for a storage pool from the
+// given parameters. The pool takes the flatfs parameters for its primary root,
+// and its other roots from "roots", e.g.
+//
+//	{
+//	  "type": "pool",
+//	  "path": "blocks",
+//	  "shardFunc": "/repo/flatfs/shard/v1/next-to-last/2",
+//	  "sync": true,
+//	  "policy": "most-free",
+//	  "roots": [{"path": "/mnt/disk2/blocks", "capacity": "2TB"}]
+//	}
+func (*poolPlugin) DatastoreConfigParser() fsrepo.ConfigFromMap {
+	return func(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
+		var c datastoreConfig
+		var ok bool
+		var err error
+
+		c.path, ok = params["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("'path' field is missing or not string")
+		}
+
+		sshardFun, ok := params["shardFunc"].(string)
+		if !ok {
+			return nil, fmt.Errorf("'shardFunc' field is missing or not a string")
+		}
+		c.shardFun, err = flatfs.ParseShardFunc(sshardFun)
+		if err != nil {
+			return nil, err
+		}
+
+		c.syncField, ok = params["sync"].(bool)
+		if !ok {
+			return nil, fmt.Errorf("'sync' field is missing or not boolean")
+		}
+
+		c.policy = pool.PolicyMostFree
+		if p, ok := params["policy"]; ok {
+			c.policy, ok = p.(string)
+			if !ok {
+				return nil, fmt.Errorf("'policy' field is not a string")
+			}
+		}
+
+		c.capacity, err = parseCapacity(params)
+		if err != nil {
+			return nil, err
+		}
+
+		if r, ok := params["roots"]; ok {
+			roots, ok := r.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("'roots' field is not a list")
+			}
+			for _, r := range roots {
+				rp, ok := r.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("'roots' entry is not a map")
+				}
+				var rc pool.RootConfig
+				rc.Path, ok = rp["path"].(string)
+				if !ok {
+					return nil, fmt.Errorf("'path' field of a root is missing or not string")
+				}
+				rc.Capacity, err = parseCapacity(rp)
+				if err != nil {
+					return nil, err
+				}
+				if d, ok := rp["drain"]; ok {
+					rc.Drain, ok = d.(bool)
+					if !ok {
+						return nil, fmt.Errorf("'drain' field of a root is not boolean")
+					}
+				}
+				c.roots = append(c.roots, rc)
+			}
+		}
+		return &c, nil
+	}
+}
+
+func parseCapacity(params map[string]interface{}) (uint64, error) {
+	v, ok := params["capacity"]
+	if !ok {
+		return 0, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("'capacity' field is not a string")
+	}
+	return humanize.ParseBytes(s)
+}
+
+// DiskSpec is the spec of the primary root alone, so that an existing flatfs
+// datastore can be turned into a pool, and roots added or removed, without the
+// spec of the repo changing.
+func (c *datastoreConfig) DiskSpec() fsrepo.DiskSpec {
+	return map[string]interface{}{
+		"type":      "flatfs",
+		"path":      c.path,
+		"shardFunc": c.shardFun.String(),
+	}
+}
+
+func (c *datastoreConfig) Create(path string) (repo.Datastore, error) {
+	abs := func(p string) string {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(path, p)
+		}
+		return p
+	}
+
+	roots := []pool.RootConfig{{Path: abs(c.path), Capacity: c.capacity}}
+	for _, rc := range c.roots {
+		rc.Path = abs(rc.Path)
+		roots = append(roots, rc)
+	}
+	return pool.Open(roots, pool.Options{
+		Policy:    c.policy,
+		ShardFunc: c.shardFun,
+		Sync:      c.syncField,
+	})
+}
@@ -0,0 +1,521 @@
+// Package pool implements a datastore that spreads its keys over flatfs
+// datastores on several disks, the roots of the pool, each with its own
+// capacity. New keys are placed on a root by a placement policy, and keys are
+// moved between the roots online by a rebalance.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	logging "github.com/ipfs/go-log"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+const (
+	// PolicyFillFirst fills the roots one after the other, in their order.
+	PolicyFillFirst = "fill-first"
+	// PolicyBalanced places keys on the root that is the least full relative to
+	// its capacity, so that the roots fill evenly.
+	PolicyBalanced = "balanced"
+	// PolicyMostFree places keys on the root with the most room left.
+	PolicyMostFree = "most-free"
+
+	// freeSpaceTTL is how long the free space of a disk is cached.
+	freeSpaceTTL = 5 * time.Second
+
+	keyLocks = 64
+)
+
+var log = logging.Logger("repo/pool")
+
+// ErrNoSpace is returned when no root of the pool has room for a value.
+var ErrNoSpace = errors.New("no disk of the storage pool has space left")
+
+// diskFree returns the free space of the disk of a path.
+var diskFree = func(path string) (uint64, error) {
+	du, err := disk.Usage(path)
+	if err != nil {
+		return 0, err
+	}
+	return du.Free, nil
+}
+
+var (
+	pools     = make(map[*Datastore]struct{})
+	poolsLock sync.Mutex
+)
+
+// RootConfig is a directory of the pool.
+type RootConfig struct {
+	Path string
+	// Capacity is how many bytes the root may hold, 0 is the free space of
+	// its disk.
+	Capacity uint64
+	// Drain moves the keys of the root to the other roots, so that it can be
+	// removed from the pool once empty.
+	Drain bool
+}
+
+// Options of a pool, shared by its roots.
+type Options struct {
+	Policy    string
+	ShardFunc *flatfs.ShardIdV1
+	Sync      bool
+}
+
+type Datastore struct {
+	opts Options
+
+	// lock guards the roots
+	lock  sync.RWMutex
+	roots []*root
+	// keyLocks serialize the writes and moves of a key
+	keyLocks [keyLocks]sync.Mutex
+
+	rebalance rebalancer
+}
+
+type root struct {
+	RootConfig
+	ds *flatfs.Datastore
+
+	freeLock sync.Mutex
+	free     uint64
+	freeAt   time.Time
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+
+// Open opens the roots of a pool, the first root being its primary root. A
+// rebalance is started when roots are draining or over their capacity.
+func Open(roots []RootConfig, opts Options) (*Datastore, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("storage pool has no roots")
+	}
+	if err := checkPolicy(opts.Policy); err != nil {
+		return nil, err
+	}
+	p := &Datastore{opts: opts}
+	for _, rc := range roots {
+		if err := p.addRoot(rc); err != nil {
+			p.closeRoots()
+			return nil, err
+		}
+	}
+	poolsLock.Lock()
+	pools[p] = struct{}{}
+	poolsLock.Unlock()
+
+	if p.needsRebalance(context.Background()) {
+		if err := p.Rebalance(); err != nil {
+			log.Errorf("rebalance storage pool: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// Pools returns the open pools.
+func Pools() []*Datastore {
+	poolsLock.Lock()
+	defer poolsLock.Unlock()
+	ps := make([]*Datastore, 0, len(pools))
+	for p := range pools {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func checkPolicy(policy string) error {
+	switch policy {
+	case PolicyFillFirst, PolicyBalanced, PolicyMostFree:
+		return nil
+	}
+	return fmt.Errorf("unknown placement policy %q, expect %q, %q or %q", policy,
+		PolicyFillFirst, PolicyBalanced, PolicyMostFree)
+}
+
+// AddRoot adds a directory to the pool, new keys can be placed on it right away.
+func (p *Datastore) AddRoot(rc RootConfig) error {
+	p.lock.RLock()
+	for _, r := range p.roots {
+		if r.Path == rc.Path {
+			p.lock.RUnlock()
+			return fmt.Errorf("%s is already in the storage pool", rc.Path)
+		}
+	}
+	p.lock.RUnlock()
+	return p.addRoot(rc)
+}
+
+func (p *Datastore) addRoot(rc RootConfig) error {
+	if err := os.MkdirAll(rc.Path, 0755); err != nil {
+		return err
+	}
+	fds, err := flatfs.CreateOrOpen(rc.Path, p.opts.ShardFunc, p.opts.Sync)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", rc.Path, err)
+	}
+	p.lock.Lock()
+	p.roots = append(p.roots, &root{RootConfig: rc, ds: fds})
+	p.lock.Unlock()
+	return nil
+}
+
+// Drain starts moving the keys of a root to the other roots. The root takes no
+// new keys, and can be removed from the pool once it is empty. The primary root
+// cannot be drained.
+func (p *Datastore) Drain(path string) error {
+	p.lock.Lock()
+	var found *root
+	others := 0
+	for _, r := range p.roots {
+		if r.Path == path {
+			found = r
+		} else if !r.Drain {
+			others++
+		}
+	}
+	if found == nil {
+		p.lock.Unlock()
+		return fmt.Errorf("%s is not in the storage pool", path)
+	}
+	if found == p.roots[0] {
+		p.lock.Unlock()
+		return fmt.Errorf("%s is the primary root of the storage pool and cannot be drained", path)
+	}
+	if others == 0 {
+		p.lock.Unlock()
+		return errors.New("the storage pool has no other disk to drain to")
+	}
+	found.Drain = true
+	p.lock.Unlock()
+	return p.Rebalance()
+}
+
+func (p *Datastore) snapshot() []*root {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return append([]*root(nil), p.roots...)
+}
+
+func (p *Datastore) keyLock(key ds.Key) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write(key.Bytes())
+	return &p.keyLocks[h.Sum32()%keyLocks]
+}
+
+// space returns how many bytes the root holds, and how many more it can take.
+func (r *root) space(ctx context.Context) (used uint64, room uint64, err error) {
+	used, err = r.ds.DiskUsage(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	r.freeLock.Lock()
+	if time.Since(r.freeAt) > freeSpaceTTL {
+		free, err := diskFree(r.Path)
+		if err != nil {
+			r.freeLock.Unlock()
+			return 0, 0, err
+		}
+		r.free, r.freeAt = free, time.Now()
+	}
+	room = r.free
+	r.freeLock.Unlock()
+	if r.Capacity > 0 {
+		left := uint64(0)
+		if used < r.Capacity {
+			left = r.Capacity - used
+		}
+		if left < room {
+			room = left
+		}
+	}
+	return used, room, nil
+}
+
+// fill is how full the root is, relative to its capacity.
+func fill(used, room uint64) float64 {
+	if used+room == 0 {
+		return 1
+	}
+	return float64(used) / float64(used+room)
+}
+
+// place returns the root to put a value of the given size on, by the policy of
+// the pool, other than exclude.
+func (p *Datastore) place(ctx context.Context, roots []*root, size uint64, exclude *root) (*root, error) {
+	var (
+		best      *root
+		bestScore float64
+	)
+	for _, r := range roots {
+		if r == exclude || p.draining(r) {
+			continue
+		}
+		used, room, err := r.space(ctx)
+		if err != nil {
+			log.Errorf("space of %s: %v", r.Path, err)
+			continue
+		}
+		if room < size {
+			continue
+		}
+		var score float64
+		switch p.opts.Policy {
+		case PolicyFillFirst:
+			return r, nil
+		case PolicyBalanced:
+			score = -fill(used+size, room-size)
+		default:
+			score = float64(room)
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	if best == nil {
+		return nil, ErrNoSpace
+	}
+	return best, nil
+}
+
+// find calls fn on the roots until one of them has the key.
+func (p *Datastore) find(ctx context.Context, key ds.Key, fn func(r *root) error) error {
+	lookup := func() error {
+		for _, r := range p.snapshot() {
+			if err := fn(r); err != ds.ErrNotFound {
+				return err
+			}
+		}
+		return ds.ErrNotFound
+	}
+	err := lookup()
+	if err == ds.ErrNotFound && p.rebalance.running() {
+		// the key may have moved between the roots while they were looked up
+		l := p.keyLock(key)
+		l.Lock()
+		defer l.Unlock()
+		err = lookup()
+	}
+	return err
+}
+
+func (p *Datastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	l := p.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	roots := p.snapshot()
+	// a key is overwritten where it is
+	for _, r := range roots {
+		if has, err := r.ds.Has(ctx, key); err != nil {
+			return err
+		} else if has {
+			return r.ds.Put(ctx, key, value)
+		}
+	}
+	r, err := p.place(ctx, roots, uint64(len(value)), nil)
+	if err != nil {
+		return err
+	}
+	return r.ds.Put(ctx, key, value)
+}
+
+func (p *Datastore) Delete(ctx context.Context, key ds.Key) error {
+	l := p.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	for _, r := range p.snapshot() {
+		if err := r.ds.Delete(ctx, key); err != nil && err != ds.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Datastore) Get(ctx context.Context, key ds.Key) (value []byte, err error) {
+	err = p.find(ctx, key, func(r *root) error {
+		value, err = r.ds.Get(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+func (p *Datastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	err := p.find(ctx, key, func(r *root) error {
+		has, err := r.ds.Has(ctx, key)
+		if err == nil && !has {
+			return ds.ErrNotFound
+		}
+		return err
+	})
+	if err == ds.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (p *Datastore) GetSize(ctx context.Context, key ds.Key) (size int, err error) {
+	err = p.find(ctx, key, func(r *root) error {
+		size, err = r.ds.GetSize(ctx, key)
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// Query queries the roots one after the other. The results are only ordered,
+// offset and limited once merged.
+func (p *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	rq := q
+	rq.Orders, rq.Offset, rq.Limit = nil, 0, 0
+	roots := p.snapshot()
+	results := make([]query.Results, 0, len(roots))
+	closeAll := func() error {
+		var err error
+		for _, res := range results {
+			if e := res.Close(); e != nil {
+				err = e
+			}
+		}
+		return err
+	}
+	for _, r := range roots {
+		res, err := r.ds.Query(ctx, rq)
+		if err != nil {
+			_ = closeAll()
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	// a key being moved can be on two roots at once
+	var seen map[string]bool
+	if p.rebalance.running() {
+		seen = make(map[string]bool)
+	}
+	i := 0
+	merged := query.ResultsFromIterator(rq, query.Iterator{
+		Next: func() (query.Result, bool) {
+			for i < len(results) {
+				res, ok := results[i].NextSync()
+				if !ok {
+					i++
+					continue
+				}
+				if seen != nil && res.Error == nil {
+					if seen[res.Key] {
+						continue
+					}
+					seen[res.Key] = true
+				}
+				return res, true
+			}
+			return query.Result{}, false
+		},
+		Close: closeAll,
+	})
+	return query.NaiveQueryApply(query.Query{Orders: q.Orders, Offset: q.Offset, Limit: q.Limit}, merged), nil
+}
+
+func (p *Datastore) Sync(ctx context.Context, prefix ds.Key) error {
+	for _, r := range p.snapshot() {
+		if err := r.ds.Sync(ctx, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Datastore) Batch(_ context.Context) (ds.Batch, error) {
+	return ds.NewBasicBatch(p), nil
+}
+
+// DiskUsage is the disk usage of all the roots.
+func (p *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	var total uint64
+	for _, r := range p.snapshot() {
+		du, err := r.ds.DiskUsage(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += du
+	}
+	return total, nil
+}
+
+// Free is how many more bytes the roots that are not draining can take, each
+// within its capacity and the free space of its disk.
+func (p *Datastore) Free(ctx context.Context) (uint64, error) {
+	var total uint64
+	for _, r := range p.snapshot() {
+		if p.draining(r) {
+			continue
+		}
+		_, room, err := r.space(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += room
+	}
+	return total, nil
+}
+
+func (p *Datastore) Close() error {
+	poolsLock.Lock()
+	delete(pools, p)
+	poolsLock.Unlock()
+	p.rebalance.stop()
+	return p.closeRoots()
+}
+
+func (p *Datastore) closeRoots() error {
+	var err error
+	for _, r := range p.snapshot() {
+		if e := r.ds.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Status is the state of a pool and of its rebalance.
+type Status struct {
+	Policy    string
+	Roots     []*RootStatus
+	Rebalance *RebalanceStatus `json:",omitempty"`
+}
+
+type RootStatus struct {
+	Path     string
+	Capacity uint64
+	Used     uint64
+	// Room is how many more bytes the root can take.
+	Room     uint64
+	Draining bool   `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+func (p *Datastore) Status(ctx context.Context) *Status {
+	s := &Status{Policy: p.opts.Policy, Rebalance: p.rebalance.status()}
+	for _, r := range p.snapshot() {
+		rs := &RootStatus{Path: r.Path, Capacity: r.Capacity, Draining: p.draining(r)}
+		used, room, err := r.space(ctx)
+		if err != nil {
+			rs.Error = err.Error()
+		}
+		rs.Used, rs.Room = used, room
+		s.Roots = append(s.Roots, rs)
+	}
+	return s
+}
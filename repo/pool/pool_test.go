@@ -0,0 +1,163 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	"github.com/stretchr/testify/assert"
+)
+
+const mib = 1 << 20
+
+func openTestPool(t *testing.T, policy string, capacities ...uint64) *Datastore {
+	diskFree = func(string) (uint64, error) { return 1 << 40, nil }
+	dir := t.TempDir()
+	roots := make([]RootConfig, len(capacities))
+	for i, c := range capacities {
+		roots[i] = RootConfig{Path: filepath.Join(dir, fmt.Sprint(i)), Capacity: c}
+	}
+	p, err := Open(roots, Options{Policy: policy, ShardFunc: flatfs.NextToLast(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func putKeys(t *testing.T, p *Datastore, n int, size int) []ds.Key {
+	keys := make([]ds.Key, n)
+	for i := range keys {
+		keys[i] = ds.NewKey(fmt.Sprintf("KEY%04d", i))
+		if err := p.Put(context.Background(), keys[i], make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+// rootsKeys counts the keys on each root, the disk usage of a root also counts
+// its directories.
+func rootsKeys(t *testing.T, p *Datastore) []int {
+	counts := make([]int, 0)
+	for _, r := range p.snapshot() {
+		res, err := r.ds.Query(context.Background(), query.Query{KeysOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := res.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts = append(counts, len(entries))
+	}
+	return counts
+}
+
+func waitRebalance(t *testing.T, p *Datastore) *RebalanceStatus {
+	for i := 0; i < 500 && p.rebalance.running(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := p.rebalance.status()
+	if st == nil || st.Running {
+		t.Fatal("rebalance did not finish")
+	}
+	return st
+}
+
+func TestPlacement(t *testing.T) {
+	ctx := context.Background()
+	p := openTestPool(t, PolicyFillFirst, 9*mib/2, 0)
+	putKeys(t, p, 6, mib)
+	assert.Equal(t, []int{4, 2}, rootsKeys(t, p))
+
+	p = openTestPool(t, PolicyBalanced, 9*mib/2, 10*mib)
+	putKeys(t, p, 6, mib)
+	assert.Equal(t, []int{2, 4}, rootsKeys(t, p))
+
+	p = openTestPool(t, PolicyMostFree, 7*mib/2, 11*mib/5)
+	putKeys(t, p, 4, mib)
+	assert.Equal(t, []int{3, 1}, rootsKeys(t, p))
+	err := p.Put(ctx, ds.NewKey("FULL"), make([]byte, 3*mib/2))
+	assert.Equal(t, ErrNoSpace, err)
+}
+
+func TestFree(t *testing.T) {
+	ctx := context.Background()
+	p := openTestPool(t, PolicyFillFirst, 4*mib, 2*mib)
+	free, err := p.Free(ctx)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, free, uint64(6*mib))
+	assert.Greater(t, free, uint64(5*mib))
+
+	putKeys(t, p, 1, mib)
+	left, err := p.Free(ctx)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, left, free-mib)
+
+	// a draining root takes no new keys, so it has no room left
+	assert.NoError(t, p.Drain(p.roots[1].Path))
+	waitRebalance(t, p)
+	left, err = p.Free(ctx)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, left, uint64(3*mib))
+}
+
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	// the primary root is too small for the keys
+	p := openTestPool(t, PolicyFillFirst, mib/2, 0, 0)
+	keys := putKeys(t, p, 20, mib)
+	assert.Equal(t, []int{0, 20, 0}, rootsKeys(t, p))
+
+	assert.Error(t, p.Drain(p.roots[0].Path))
+	assert.NoError(t, p.Drain(p.roots[1].Path))
+	st := waitRebalance(t, p)
+	assert.Empty(t, st.Error)
+	assert.Equal(t, int64(20), st.Moved)
+	assert.Equal(t, []int{0, 0, 20}, rootsKeys(t, p))
+	for _, k := range keys {
+		has, err := p.Has(ctx, k)
+		assert.NoError(t, err)
+		assert.True(t, has)
+	}
+	// drained roots take no new keys
+	assert.NoError(t, p.Put(ctx, ds.NewKey("NEW"), make([]byte, mib)))
+	assert.Equal(t, []int{0, 0, 21}, rootsKeys(t, p))
+}
+
+func TestRebalanceBalanced(t *testing.T) {
+	ctx := context.Background()
+	p := openTestPool(t, PolicyFillFirst, 0)
+	putKeys(t, p, 10, mib)
+	p.opts.Policy = PolicyBalanced
+	p.roots[0].Capacity = 40 * mib
+	assert.NoError(t, p.AddRoot(RootConfig{Path: filepath.Join(t.TempDir(), "new"), Capacity: 40 * mib}))
+
+	assert.NoError(t, p.Rebalance())
+	st := waitRebalance(t, p)
+	assert.Empty(t, st.Error)
+	counts := rootsKeys(t, p)
+	assert.Equal(t, 10, counts[0]+counts[1])
+	assert.InDelta(t, 5, counts[0], 1)
+
+	res, err := p.Query(ctx, query.Query{KeysOnly: true, Limit: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7)
+	res, err = p.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = res.Rest()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 10)
+}
@@ -0,0 +1,222 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// balanceSlack is how much fuller than the pool, relative to its capacity, a
+// root may be before the balanced policy moves keys off it.
+const balanceSlack = 0.05
+
+// RebalanceStatus is the progress of the last rebalance of a pool.
+type RebalanceStatus struct {
+	Running    bool
+	Started    time.Time
+	Finished   time.Time
+	Moved      int64
+	MovedBytes uint64
+	Error      string `json:",omitempty"`
+}
+
+type rebalancer struct {
+	active int32
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	st     *RebalanceStatus
+}
+
+func (rb *rebalancer) running() bool {
+	return atomic.LoadInt32(&rb.active) == 1
+}
+
+func (rb *rebalancer) status() *RebalanceStatus {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.st == nil {
+		return nil
+	}
+	st := *rb.st
+	return &st
+}
+
+func (rb *rebalancer) moved(size uint64) {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	rb.st.Moved++
+	rb.st.MovedBytes += size
+}
+
+// stop cancels a running rebalance and waits for it to return.
+func (rb *rebalancer) stop() {
+	rb.lock.Lock()
+	cancel, done := rb.cancel, rb.done
+	rb.lock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Rebalance starts moving keys between the roots in the background. Draining
+// roots are emptied, roots over their capacity are brought back within it, and
+// with the balanced policy the roots are evened out. The pool stays usable while
+// keys move.
+func (p *Datastore) Rebalance() error {
+	rb := &p.rebalance
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.running() {
+		return errors.New("storage pool is already rebalancing")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	rb.cancel, rb.done = cancel, done
+	st := &RebalanceStatus{Running: true, Started: time.Now()}
+	rb.st = st
+	atomic.StoreInt32(&rb.active, 1)
+	go func() {
+		defer close(done)
+		err := p.doRebalance(ctx)
+		rb.lock.Lock()
+		defer rb.lock.Unlock()
+		st.Running, st.Finished = false, time.Now()
+		if err != nil {
+			log.Errorf("rebalance storage pool: %v", err)
+			st.Error = err.Error()
+		}
+		atomic.StoreInt32(&rb.active, 0)
+	}()
+	return nil
+}
+
+func (p *Datastore) doRebalance(ctx context.Context) error {
+	roots := p.snapshot()
+	for _, src := range roots {
+		excess, err := p.excess(ctx, roots, src)
+		if err != nil {
+			return err
+		}
+		if excess == 0 {
+			continue
+		}
+		if err := p.shed(ctx, roots, src, excess); err != nil {
+			return fmt.Errorf("move keys off %s: %v", src.Path, err)
+		}
+	}
+	return nil
+}
+
+// needsRebalance reports whether a root holds more than it should.
+func (p *Datastore) needsRebalance(ctx context.Context) bool {
+	roots := p.snapshot()
+	for _, r := range roots {
+		if excess, err := p.excess(ctx, roots, r); err == nil && excess > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// excess returns how many bytes should move off a root.
+func (p *Datastore) excess(ctx context.Context, roots []*root, r *root) (uint64, error) {
+	used, room, err := r.space(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if p.draining(r) {
+		return used, nil
+	}
+	if r.Capacity > 0 && used > r.Capacity {
+		return used - r.Capacity, nil
+	}
+	if p.opts.Policy != PolicyBalanced {
+		return 0, nil
+	}
+	var totalUsed, totalSize uint64
+	for _, o := range roots {
+		if p.draining(o) {
+			continue
+		}
+		u, rm, err := o.space(ctx)
+		if err != nil {
+			return 0, err
+		}
+		totalUsed, totalSize = totalUsed+u, totalSize+u+rm
+	}
+	if totalSize == 0 {
+		return 0, nil
+	}
+	size := float64(used + room)
+	target := float64(totalUsed) / float64(totalSize) * size
+	if float64(used) <= target+balanceSlack*size {
+		return 0, nil
+	}
+	return used - uint64(target), nil
+}
+
+func (p *Datastore) draining(r *root) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return r.Drain
+}
+
+// shed moves keys off a root to the others, until excess bytes are moved.
+func (p *Datastore) shed(ctx context.Context, roots []*root, src *root, excess uint64) error {
+	res, err := src.ds.Query(ctx, query.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+	var moved uint64
+	for moved < excess {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		e, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if e.Error != nil {
+			return e.Error
+		}
+		size := uint64(e.Size)
+		dest, err := p.place(ctx, roots, size, src)
+		if err != nil {
+			return err
+		}
+		if err := p.move(ctx, ds.NewKey(e.Key), src, dest); err != nil {
+			return err
+		}
+		moved += size
+		p.rebalance.moved(size)
+	}
+	return nil
+}
+
+// move copies a key to another root, then deletes it from its root.
+func (p *Datastore) move(ctx context.Context, key ds.Key, src *root, dest *root) error {
+	l := p.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	v, err := src.ds.Get(ctx, key)
+	if err == ds.ErrNotFound {
+		// deleted meanwhile
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := dest.ds.Put(ctx, key, v); err != nil {
+		return err
+	}
+	return src.ds.Delete(ctx, key)
+}
@@ -24,6 +24,7 @@ type Interface interface {
 
 type Accounting interface {
 	Settle(peer string, amount *big.Int, contractId string, token common.Address) error
-	NotifyPaymentReceived(peer string, amount *big.Int, token common.Address) error
-	NotifyPaymentSent(peer string, amount *big.Int, receivedError error, token common.Address)
+	NotifyPaymentReceived(peer string, amount *big.Int, contractId string, token common.Address) error
+	NotifyPaymentSending(peer string, amount *big.Int, contractId string, cumulativePayout *big.Int, token common.Address) error
+	NotifyPaymentSent(peer string, amount *big.Int, contractId string, receivedError error, token common.Address)
 }
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/bittorrent/go-btfs/chain/tokencfg"
 	"math/big"
+	"sort"
 	"sync"
 
 	"github.com/bittorrent/go-btfs/settlement"
@@ -93,8 +94,9 @@ func (s *Service) GetProtocols() swapprotocol.Interface {
 	return s.proto
 }
 
-// ReceiveCheque is called by the swap protocol if a cheque is received.
-func (s *Service) ReceiveCheque(ctx context.Context, peer string, cheque *vault.SignedCheque, realAmount *big.Int, token common.Address) (err error) {
+// ReceiveCheque is called by the swap protocol if a cheque is received, in
+// payment of the contract if contractId is set.
+func (s *Service) ReceiveCheque(ctx context.Context, peer string, cheque *vault.SignedCheque, realAmount *big.Int, contractId string, token common.Address) (err error) {
 	// check this is the same vault for this peer as previously
 	expectedVault, known, err := s.addressbook.Vault(peer)
 	if err != nil {
@@ -146,7 +148,7 @@ func (s *Service) ReceiveCheque(ctx context.Context, peer string, cheque *vault.
 		return err
 	}
 
-	return s.accounting.NotifyPaymentReceived(peer, receivedAmount, token)
+	return s.accounting.NotifyPaymentReceived(peer, receivedAmount, contractId, token)
 }
 
 // Pay initiates a payment to the given peer
@@ -154,7 +156,7 @@ func (s *Service) Pay(ctx context.Context, peer string, amount *big.Int, contrac
 	var err error
 	defer func() {
 		if err != nil {
-			s.accounting.NotifyPaymentSent(peer, amount, err, token)
+			s.accounting.NotifyPaymentSent(peer, amount, contractId, err, token)
 		}
 	}()
 
@@ -168,7 +170,17 @@ func (s *Service) Pay(ctx context.Context, peer string, amount *big.Int, contrac
 			return
 		}
 	*/
-	balance, err := s.proto.EmitCheque(ctx, peer, amount, contractId, token, s.vault.Issue)
+	// the cheque is recorded in the ledger before it goes out, for a payment
+	// interrupted after that to be reconciled with the vault
+	issue := func(ctx context.Context, beneficiary common.Address, amount *big.Int, token common.Address, sendChequeFunc vault.SendChequeFunc) (*big.Int, error) {
+		return s.vault.Issue(ctx, beneficiary, amount, token, func(cheque *vault.SignedCheque) error {
+			if err := s.accounting.NotifyPaymentSending(peer, amount, contractId, cheque.CumulativePayout, token); err != nil {
+				return err
+			}
+			return sendChequeFunc(cheque)
+		})
+	}
+	balance, err := s.proto.EmitCheque(ctx, peer, amount, contractId, token, issue)
 
 	if err != nil {
 		return
@@ -176,7 +188,7 @@ func (s *Service) Pay(ctx context.Context, peer string, amount *big.Int, contrac
 
 	bal, _ := big.NewFloat(0).SetInt(balance).Float64()
 	s.metrics.AvailableBalance.Set(bal)
-	s.accounting.NotifyPaymentSent(peer, amount, nil, token)
+	s.accounting.NotifyPaymentSent(peer, amount, contractId, nil, token)
 	amountFloat, _ := big.NewFloat(0).SetInt(amount).Float64()
 	s.metrics.TotalSent.Add(amountFloat)
 	s.metrics.ChequesSent.Inc()
@@ -474,6 +486,57 @@ func (s *Service) CashCheque(ctx context.Context, peer string, token common.Addr
 	return s.cashout.CashCheque(ctx, vaultAddress, s.vault.Address(), token)
 }
 
+// VaultCashout is the outcome of cashing the last cheque of a vault: the
+// cashout transaction, or why it was not sent.
+type VaultCashout struct {
+	Peer   string
+	Vault  common.Address
+	TxHash common.Hash
+	Error  string `json:",omitempty"`
+}
+
+// CashAllCheques cashes the last cheques of every peer with uncashed cheques
+// in the token, one transaction per vault. Vaults with a pending cashout are
+// left out, a vault that fails does not stop the others.
+func (s *Service) CashAllCheques(ctx context.Context, token common.Address) ([]*VaultCashout, error) {
+	cheques, err := s.chequeStore.LastReceivedCheques(token)
+	if err != nil {
+		return nil, err
+	}
+	vaults := make([]common.Address, 0, len(cheques))
+	for vaultAddress := range cheques {
+		vaults = append(vaults, vaultAddress)
+	}
+	sort.Slice(vaults, func(i, j int) bool { return bytes.Compare(vaults[i][:], vaults[j][:]) < 0 })
+
+	cashouts := make([]*VaultCashout, 0, len(vaults))
+	for _, vaultAddress := range vaults {
+		c := &VaultCashout{Vault: vaultAddress}
+		peer, known, err := s.addressbook.VaultPeer(vaultAddress)
+		if err != nil || !known {
+			peer = vaultAddress.String()
+		}
+		c.Peer = peer
+
+		status, err := s.cashout.CashoutStatus(ctx, vaultAddress, token)
+		if err != nil {
+			c.Error = err.Error()
+			cashouts = append(cashouts, c)
+			continue
+		}
+		pending := status.Last != nil && status.Last.Result == nil && !status.Last.Reverted
+		if pending || status.UncashedAmount == nil || status.UncashedAmount.Sign() <= 0 {
+			continue
+		}
+		c.TxHash, err = s.cashout.CashCheque(ctx, vaultAddress, s.vault.Address(), token)
+		if err != nil {
+			c.Error = err.Error()
+		}
+		cashouts = append(cashouts, c)
+	}
+	return cashouts, nil
+}
+
 // CashoutStatus gets the status of the latest cashout transaction for the peers vault
 func (s *Service) CashoutStatus(ctx context.Context, peer string, token common.Address) (*vault.CashoutStatus, error) {
 	vaultAddress, known, err := s.addressbook.Vault(peer)
@@ -59,7 +59,7 @@ func (t *testObserver) PeerDebt(peer string) (*big.Int, error) {
 	return nil, nil
 }
 
-func (t *testObserver) NotifyPaymentReceived(peer string, amount *big.Int, token common.Address) error {
+func (t *testObserver) NotifyPaymentReceived(peer string, amount *big.Int, contractId string, token common.Address) error {
 	t.receivedCalled <- notifyPaymentReceivedCall{
 		peer:   peer,
 		amount: amount,
@@ -75,7 +75,11 @@ func (t *testObserver) Settle(peer string, amount *big.Int, contractId string, t
 	return nil
 }
 
-func (t *testObserver) NotifyPaymentSent(peer string, amount *big.Int, err error, token common.Address) {
+func (t *testObserver) NotifyPaymentSending(peer string, amount *big.Int, contractId string, cumulativePayout *big.Int, token common.Address) error {
+	return nil
+}
+
+func (t *testObserver) NotifyPaymentSent(peer string, amount *big.Int, contractId string, err error, token common.Address) {
 	t.sentCalled <- notifyPaymentSentCall{
 		peer:   peer,
 		amount: amount,
@@ -212,7 +216,7 @@ func TestReceiveCheque(t *testing.T) {
 		observer,
 	)
 
-	err := swap.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, TOKEN)
+	err := swap.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, "", TOKEN)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -279,7 +283,7 @@ func TestReceiveChequeReject(t *testing.T) {
 		observer,
 	)
 
-	err := swap.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, TOKEN)
+	err := swap.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, "", TOKEN)
 	if err == nil {
 		t.Fatal("accepted invalid cheque")
 	}
@@ -331,7 +335,7 @@ func TestReceiveChequeWrongVault(t *testing.T) {
 		observer,
 	)
 
-	err := swapService.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, TOKEN)
+	err := swapService.ReceiveCheque(context.Background(), peer, cheque, exchangeRate, "", TOKEN)
 	if err == nil {
 		t.Fatal("accepted invalid cheque")
 	}
@@ -618,6 +622,77 @@ func TestCashoutStatus(t *testing.T) {
 	}
 }
 
+func TestCashAllCheques(t *testing.T) {
+	store := mockstore.NewStateStore()
+
+	ourVaultAddress := common.HexToAddress("fffa")
+	cashable, pending, cashed, failing := common.HexToAddress("aaaa"), common.HexToAddress("bbbb"),
+		common.HexToAddress("cccc"), common.HexToAddress("dddd")
+	txHash := common.HexToHash("eeee")
+	cashErr := errors.New("nonce too low")
+
+	swapService := swap.New(
+		&swapProtocolMock{},
+		store,
+		mockvault.NewVault(
+			mockvault.WithVaultAddressFunc(func() common.Address {
+				return ourVaultAddress
+			}),
+		),
+		mockchequestore.NewChequeStore(
+			mockchequestore.WithLastReceivedChequesFunc(func() (map[common.Address]*vault.SignedCheque, error) {
+				return map[common.Address]*vault.SignedCheque{cashable: {}, pending: {}, cashed: {}, failing: {}}, nil
+			}),
+		),
+		&addressbookMock{
+			vaultPeer: func(v common.Address) (string, bool, error) {
+				return v.String() + "-peer", true, nil
+			},
+		},
+		int64(1),
+		&cashoutMock{
+			cashoutStatus: func(ctx context.Context, c common.Address, token common.Address) (*vault.CashoutStatus, error) {
+				switch c {
+				case pending:
+					return &vault.CashoutStatus{Last: &vault.LastCashout{}, UncashedAmount: big.NewInt(10)}, nil
+				case cashed:
+					return &vault.CashoutStatus{UncashedAmount: big.NewInt(0)}, nil
+				}
+				return &vault.CashoutStatus{UncashedAmount: big.NewInt(10)}, nil
+			},
+			cashCheque: func(ctx context.Context, c common.Address, r common.Address, token common.Address) (common.Hash, error) {
+				if r != ourVaultAddress {
+					t.Fatalf("not cashing with the right recipient. wanted %v, got %v", ourVaultAddress, r)
+				}
+				switch c {
+				case cashable:
+					return txHash, nil
+				case failing:
+					return common.Hash{}, cashErr
+				}
+				t.Fatalf("cashing the wrong vault %v", c)
+				return common.Hash{}, nil
+			},
+		},
+		nil,
+	)
+
+	cashouts, err := swapService.CashAllCheques(context.Background(), TOKEN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cashouts) != 2 {
+		t.Fatalf("unexpected cashouts %v", cashouts)
+	}
+	// the vault that fails is reported after the one cashed, in address order
+	if c := cashouts[0]; c.Vault != cashable || c.Peer != cashable.String()+"-peer" || c.TxHash != txHash || c.Error != "" {
+		t.Fatalf("unexpected cashout %+v", c)
+	}
+	if c := cashouts[1]; c.Vault != failing || c.Peer != failing.String()+"-peer" || c.Error != cashErr.Error() {
+		t.Fatalf("unexpected cashout %+v", c)
+	}
+}
+
 func TestStateStoreKeys(t *testing.T) {
 	address := common.HexToAddress("0xabcd")
 	swarmAddress := peerInfo.ID("deff").String()
@@ -59,7 +59,7 @@ type Interface interface {
 // Swap is the interface the settlement layer should implement to receive cheques.
 type Swap interface {
 	// ReceiveCheque is called by the swap protocol if a cheque is received.
-	ReceiveCheque(ctx context.Context, peer string, cheque *vault.SignedCheque, realAmount *big.Int, token common.Address) error
+	ReceiveCheque(ctx context.Context, peer string, cheque *vault.SignedCheque, realAmount *big.Int, contractId string, token common.Address) error
 	GetChainid() int64
 	PutBeneficiary(peer string, beneficiary common.Address) (common.Address, error)
 	Beneficiary(peer string) (beneficiary common.Address, known bool, err error)
@@ -104,7 +104,7 @@ func (s *Service) SetSwap(swap Swap) {
 	s.swap = swap
 }
 
-func (s *Service) Handler(ctx context.Context, requestPid string, encodedCheque string, amountCheck *big.Int, token common.Address) (err error) {
+func (s *Service) Handler(ctx context.Context, requestPid string, encodedCheque string, amountCheck *big.Int, contractId string, token common.Address) (err error) {
 	var signedCheque *vault.SignedCheque
 	err = json.Unmarshal([]byte(encodedCheque), &signedCheque)
 	if err != nil {
@@ -112,7 +112,7 @@ func (s *Service) Handler(ctx context.Context, requestPid string, encodedCheque
 	}
 
 	// signature validation
-	return s.swap.ReceiveCheque(ctx, requestPid, signedCheque, amountCheck, token)
+	return s.swap.ReceiveCheque(ctx, requestPid, signedCheque, amountCheck, contractId, token)
 }
 
 // InitiateCheque attempts to send a cheque to a peer.
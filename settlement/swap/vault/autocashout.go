@@ -0,0 +1,301 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/settlement/swap/priceoracle"
+	"github.com/bittorrent/go-btfs/transaction"
+	"github.com/bittorrent/go-btfs/transaction/storage"
+	"github.com/bittorrent/go-btfs/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// DefaultCashoutGasLimit is the gas a cashout is assumed to use when the
+	// policy does not set one.
+	DefaultCashoutGasLimit = 200000
+
+	autoCashoutLogPrefix = "swap_autocashout_log_"
+	autoCashoutGasPrefix = "swap_autocashout_gas_"
+)
+
+// AutoCashoutPolicy decides when the cheques received from a vault are cashed
+// automatically.
+type AutoCashoutPolicy struct {
+	// Thresholds is the uncashed amount of each token from which a vault is
+	// cashed. Tokens without a threshold are cashed on MinGasRatio alone.
+	Thresholds map[common.Address]*big.Int
+	// MinGasRatio is how many times the gas cost of the cashout the uncashed
+	// amount, valued in BTT, must be worth.
+	MinGasRatio int64
+	// MaxAge is how long cheques stay uncashed before they are cashed below
+	// the threshold, as long as they are worth more than the gas. 0 sets no age.
+	MaxAge time.Duration
+	// DailyGasBudget is the most gas, in wei, that automatic cashouts spend per
+	// day. nil sets no budget.
+	DailyGasBudget *big.Int
+	// GasLimit is the gas a cashout is assumed to use.
+	GasLimit uint64
+}
+
+// AutoCashoutDecision is what the policy decided for the uncashed cheques of a
+// vault in a token, with the figures it decided on.
+type AutoCashoutDecision struct {
+	Time     int64
+	Vault    common.Address
+	Token    common.Address
+	Uncashed *big.Int
+	// Value is the uncashed amount valued in wei of BTT.
+	Value    *big.Int
+	GasPrice *big.Int
+	GasCost  *big.Int
+	// Age is how many seconds the oldest uncashed cheque was received ago.
+	Age    int64
+	Cash   bool
+	Reason string
+	TxHash common.Hash
+	Error  string `json:",omitempty"`
+}
+
+// decide fills in whether the cheques of d are cashed and why, spent is the
+// gas spent on automatic cashouts today.
+func (p *AutoCashoutPolicy) decide(d *AutoCashoutDecision, spent *big.Int) {
+	aged := p.MaxAge > 0 && time.Duration(d.Age)*time.Second >= p.MaxAge
+	threshold := p.Thresholds[d.Token]
+	minValue := new(big.Int).Mul(d.GasCost, big.NewInt(p.MinGasRatio))
+	switch {
+	case p.DailyGasBudget != nil && new(big.Int).Add(spent, d.GasCost).Cmp(p.DailyGasBudget) > 0:
+		d.Reason = fmt.Sprintf("daily gas budget of %s is used up", p.DailyGasBudget)
+	case aged && d.Value.Cmp(d.GasCost) > 0:
+		d.Cash, d.Reason = true, fmt.Sprintf("cheques are older than %s", p.MaxAge)
+	case threshold != nil && d.Uncashed.Cmp(threshold) < 0:
+		d.Reason = fmt.Sprintf("uncashed amount is below the threshold of %s", threshold)
+	case d.Value.Cmp(minValue) < 0:
+		d.Reason = fmt.Sprintf("uncashed value is below %d times the gas cost", p.MinGasRatio)
+	case threshold != nil:
+		d.Cash, d.Reason = true, fmt.Sprintf("uncashed amount is over the threshold of %s", threshold)
+	default:
+		d.Cash, d.Reason = true, fmt.Sprintf("uncashed value is over %d times the gas cost", p.MinGasRatio)
+	}
+}
+
+// AutoCashoutService cashes the cheques received from vaults according to a
+// policy, and logs every decision it makes.
+type AutoCashoutService struct {
+	lock        sync.Mutex
+	store       storage.StateStorer
+	backend     transaction.Backend
+	cashout     CashoutService
+	chequeStore ChequeStore
+	oracle      priceoracle.Service
+	recipient   common.Address
+}
+
+// NewAutoCashoutService creates a new AutoCashoutService cashing into the
+// recipient vault.
+func NewAutoCashoutService(
+	store storage.StateStorer,
+	backend transaction.Backend,
+	cashout CashoutService,
+	chequeStore ChequeStore,
+	oracle priceoracle.Service,
+	recipient common.Address,
+) *AutoCashoutService {
+	return &AutoCashoutService{
+		store:       store,
+		backend:     backend,
+		cashout:     cashout,
+		chequeStore: chequeStore,
+		oracle:      oracle,
+		recipient:   recipient,
+	}
+}
+
+// Run decides for every vault with uncashed cheques in the given tokens, and
+// cashes them when the policy says so. Vaults without uncashed cheques are
+// not logged.
+func (s *AutoCashoutService) Run(ctx context.Context, p *AutoCashoutPolicy, tokens []common.Address) ([]*AutoCashoutDecision, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	gasLimit := p.GasLimit
+	if gasLimit == 0 {
+		gasLimit = DefaultCashoutGasLimit
+	}
+	gasPrice, err := s.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gasCost := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	bttRate, err := s.oracle.CurrentRate(tokencfg.GetWbttToken())
+	if err != nil {
+		return nil, err
+	}
+	lastCashed, err := s.lastCashed()
+	if err != nil {
+		return nil, err
+	}
+	spent, err := s.GasSpentToday()
+	if err != nil {
+		return nil, err
+	}
+
+	var decisions []*AutoCashoutDecision
+	for _, token := range tokens {
+		cheques, err := s.chequeStore.LastReceivedCheques(token)
+		if err != nil {
+			return decisions, err
+		}
+		rate, err := s.oracle.CurrentRate(token)
+		if err != nil {
+			return decisions, err
+		}
+		vaults := make([]common.Address, 0, len(cheques))
+		for v := range cheques {
+			vaults = append(vaults, v)
+		}
+		sort.Slice(vaults, func(i, j int) bool { return bytes.Compare(vaults[i][:], vaults[j][:]) < 0 })
+		for _, v := range vaults {
+			if err := ctx.Err(); err != nil {
+				return decisions, err
+			}
+			now := time.Now()
+			d := &AutoCashoutDecision{Time: now.Unix(), Vault: v, Token: token, GasPrice: gasPrice, GasCost: gasCost}
+			err := s.evaluate(ctx, d, rate, bttRate, lastCashed[cashedKey(v, token)], now)
+			if err == nil && d.Uncashed.Sign() <= 0 {
+				continue
+			}
+			if err != nil {
+				d.Error = err.Error()
+			} else if d.Reason == "" {
+				p.decide(d, spent)
+			}
+			if d.Cash {
+				d.TxHash, err = s.cashout.CashCheque(ctx, v, s.recipient, token)
+				if err != nil {
+					d.Error = err.Error()
+				} else {
+					spent.Add(spent, gasCost)
+					if err := s.store.Put(autoCashoutGasKey(), spent); err != nil {
+						log.Errorf("auto cashout: save gas spent: %v", err)
+					}
+				}
+			}
+			s.logDecision(d)
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions, nil
+}
+
+// evaluate fills in the uncashed amount of d, with its value and age. The
+// reason is set when the vault is not to be cashed whatever the policy.
+func (s *AutoCashoutService) evaluate(ctx context.Context, d *AutoCashoutDecision, rate, bttRate *big.Int,
+	lastCashed int64, now time.Time) error {
+	status, err := s.cashout.CashoutStatus(ctx, d.Vault, d.Token)
+	if err != nil {
+		return err
+	}
+	d.Uncashed = status.UncashedAmount
+	if d.Uncashed == nil || d.Uncashed.Sign() <= 0 {
+		d.Uncashed = big.NewInt(0)
+		return nil
+	}
+	if rate.Sign() <= 0 {
+		return errors.New("no exchange rate of the token")
+	}
+	d.Value = new(big.Int).Div(new(big.Int).Mul(d.Uncashed, bttRate), rate)
+	records, err := s.chequeStore.ReceivedChequeRecordsByPeer(d.Vault)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if r.Token != d.Token || r.ReceiveTime <= lastCashed {
+			continue
+		}
+		if age := now.Unix() - r.ReceiveTime; age > d.Age {
+			d.Age = age
+		}
+	}
+	if status.Last != nil && status.Last.Result == nil && !status.Last.Reverted {
+		d.Reason = "a cashout is pending"
+	}
+	return nil
+}
+
+func cashedKey(vault, token common.Address) string {
+	return vault.String() + token.String()
+}
+
+// lastCashed returns when each vault was last cashed successfully in each
+// token, by cashedKey.
+func (s *AutoCashoutService) lastCashed() (map[string]int64, error) {
+	results, err := s.cashout.CashoutResults()
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]int64)
+	for _, r := range results {
+		k := cashedKey(r.Vault, r.Token)
+		if r.Status == "success" && r.CashTime > last[k] {
+			last[k] = r.CashTime
+		}
+	}
+	return last, nil
+}
+
+func autoCashoutGasKey() string {
+	return fmt.Sprintf("%s%d", autoCashoutGasPrefix, utils.TodayUnix())
+}
+
+// GasSpentToday returns the gas spent on automatic cashouts today, in wei.
+func (s *AutoCashoutService) GasSpentToday() (*big.Int, error) {
+	spent := big.NewInt(0)
+	if err := s.store.Get(autoCashoutGasKey(), &spent); err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func (s *AutoCashoutService) logDecision(d *AutoCashoutDecision) {
+	if d.Cash && d.Error == "" {
+		log.Infof("auto cashout: cash vault %s in token %s: %s, tx %s", d.Vault, d.Token, d.Reason, d.TxHash)
+	}
+	key := fmt.Sprintf("%s%020d_%x_%x", autoCashoutLogPrefix, time.Now().UnixNano(), d.Vault, d.Token)
+	if err := s.store.Put(key, d); err != nil {
+		log.Errorf("auto cashout: save decision: %v", err)
+	}
+}
+
+// Log returns the decisions made since the given time, oldest first.
+func (s *AutoCashoutService) Log(since time.Time) ([]*AutoCashoutDecision, error) {
+	var decisions []*AutoCashoutDecision
+	err := s.store.Iterate(autoCashoutLogPrefix, func(key, value []byte) (bool, error) {
+		if !strings.HasPrefix(string(key), autoCashoutLogPrefix) {
+			return true, nil
+		}
+		d := new(AutoCashoutDecision)
+		if err := json.Unmarshal(value, d); err != nil {
+			return true, err
+		}
+		if d.Time >= since.Unix() {
+			decisions = append(decisions, d)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(decisions, func(i, j int) bool { return decisions[i].Time < decisions[j].Time })
+	return decisions, nil
+}
@@ -0,0 +1,126 @@
+package vault_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	chequestoremock "github.com/bittorrent/go-btfs/settlement/swap/chequestore/mock"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	storemock "github.com/bittorrent/go-btfs/statestore/mock"
+	"github.com/bittorrent/go-btfs/transaction/backendmock"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type cashoutMock struct {
+	uncashed map[common.Address]*big.Int
+	cashed   []common.Address
+}
+
+func (m *cashoutMock) CashCheque(ctx context.Context, vault, recipient common.Address, token common.Address) (common.Hash, error) {
+	m.cashed = append(m.cashed, vault)
+	return common.HexToHash("dddd"), nil
+}
+
+func (m *cashoutMock) CashoutStatus(ctx context.Context, vaultAddress common.Address, token common.Address) (*vault.CashoutStatus, error) {
+	return &vault.CashoutStatus{UncashedAmount: m.uncashed[vaultAddress]}, nil
+}
+
+func (m *cashoutMock) HasCashoutAction(ctx context.Context, peer common.Address, token common.Address) (bool, error) {
+	return false, nil
+}
+
+func (m *cashoutMock) CashoutResults() ([]vault.CashOutResult, error) {
+	return nil, nil
+}
+
+type oracleMock struct{}
+
+func (oracleMock) CurrentPrice(token common.Address) (*big.Int, error) { return big.NewInt(1), nil }
+func (oracleMock) CurrentRate(token common.Address) (*big.Int, error)  { return big.NewInt(1), nil }
+func (oracleMock) CurrentTotalPrice(token common.Address) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+func (oracleMock) CheckNewPrice(token common.Address) (*big.Int, error) { return big.NewInt(1), nil }
+
+func TestAutoCashout(t *testing.T) {
+	token := tokencfg.GetWbttToken()
+	rich, poor, old := common.HexToAddress("aaaa"), common.HexToAddress("bbbb"), common.HexToAddress("cccc")
+	cashout := &cashoutMock{uncashed: map[common.Address]*big.Int{
+		rich: big.NewInt(5000),
+		poor: big.NewInt(500),
+		old:  big.NewInt(200),
+	}}
+	store := storemock.NewStateStore()
+	s := vault.NewAutoCashoutService(
+		store,
+		backendmock.New(backendmock.WithSuggestGasPriceFunc(func(ctx context.Context) (*big.Int, error) {
+			return big.NewInt(1), nil
+		})),
+		cashout,
+		chequestoremock.NewChequeStore(
+			chequestoremock.WithLastReceivedChequesFunc(func() (map[common.Address]*vault.SignedCheque, error) {
+				return map[common.Address]*vault.SignedCheque{rich: {}, poor: {}, old: {}, common.HexToAddress("dddd"): {}}, nil
+			}),
+			chequestoremock.WithReceivedChequeRecordsByPeerFunc(func(v common.Address) ([]vault.ChequeRecord, error) {
+				received := time.Now().Add(-time.Hour)
+				if v == old {
+					received = time.Now().Add(-48 * time.Hour)
+				}
+				return []vault.ChequeRecord{{Token: token, Vault: v, ReceiveTime: received.Unix()}}, nil
+			}),
+		),
+		oracleMock{},
+		common.HexToAddress("ffff"),
+	)
+	p := &vault.AutoCashoutPolicy{
+		Thresholds:  map[common.Address]*big.Int{token: big.NewInt(1000)},
+		MinGasRatio: 2,
+		MaxAge:      24 * time.Hour,
+		GasLimit:    100,
+	}
+
+	decisions, err := s.Run(context.Background(), p, []common.Address{token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the vault without uncashed cheques is not logged
+	if len(decisions) != 3 {
+		t.Fatalf("expect 3 decisions, got %d", len(decisions))
+	}
+	if len(cashout.cashed) != 2 || cashout.cashed[0] != rich || cashout.cashed[1] != old {
+		t.Fatalf("unexpected vaults cashed %v", cashout.cashed)
+	}
+	spent, err := s.GasSpentToday()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent.Cmp(big.NewInt(200)) != 0 {
+		t.Fatalf("expect 200 gas spent, got %s", spent)
+	}
+
+	// the budget stops further cashouts for the day
+	cashout.cashed = nil
+	p.DailyGasBudget = big.NewInt(250)
+	if _, err := s.Run(context.Background(), p, []common.Address{token}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cashout.cashed) != 0 {
+		t.Fatalf("cashed %v over the gas budget", cashout.cashed)
+	}
+
+	logged, err := s.Log(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logged) != 6 {
+		t.Fatalf("expect 6 logged decisions, got %d", len(logged))
+	}
+	for _, d := range logged {
+		if d.Reason == "" {
+			t.Fatalf("decision without a reason %+v", d)
+		}
+	}
+}
@@ -136,9 +136,11 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque, a
 		return nil, ErrWrongBeneficiary
 	}
 
-	//if token != cheque.Token {
-	//	return nil, ErrTokenCheque
-	//}
+	// the cheque must be signed for the token it pays in, the signature is
+	// recovered over the token of the cheque below
+	if token != cheque.Token {
+		return nil, ErrTokenCheque
+	}
 
 	// don't allow concurrent processing of cheques
 	// this would be sufficient on a per vault basis
@@ -148,7 +150,7 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque, a
 	// load the lastCumulativePayout for the cheques vault
 	var lastCumulativePayout *big.Int
 	var lastReceivedCheque *SignedCheque
-	err := s.store.Get(lastReceivedChequeKey(cheque.Vault, token), &lastReceivedCheque)
+	err := s.store.Get(lastReceivedChequeKey(cheque.Vault, cheque.Token), &lastReceivedCheque)
 	if err != nil {
 		if err != storage.ErrNotFound {
 			return nil, err
@@ -220,7 +222,7 @@ func (s *chequeStore) ReceiveCheque(ctx context.Context, cheque *SignedCheque, a
 	}
 
 	// store the accepted cheque
-	err = s.store.Put(lastReceivedChequeKey(cheque.Vault, token), cheque)
+	err = s.store.Put(lastReceivedChequeKey(cheque.Vault, cheque.Token), cheque)
 	if err != nil {
 		return nil, err
 	}
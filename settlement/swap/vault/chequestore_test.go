@@ -151,6 +151,42 @@ func TestReceiveChequeInvalidBeneficiary(t *testing.T) {
 	}
 }
 
+func TestReceiveChequeWrongToken(t *testing.T) {
+	store := storemock.NewStateStore()
+	beneficiary := common.HexToAddress("0xffff")
+	cumulativePayout := big.NewInt(10)
+	vaultAddress := common.HexToAddress("0xeeee")
+	sig := make([]byte, 65)
+	chainID := int64(1)
+
+	cheque := &vault.SignedCheque{
+		Cheque: vault.Cheque{
+			Token:            common.HexToAddress("0xaaaa"),
+			Beneficiary:      beneficiary,
+			CumulativePayout: cumulativePayout,
+			Vault:            vaultAddress,
+		},
+		Signature: sig,
+	}
+
+	chequestore := vault.NewChequeStore(
+		store,
+		&factoryMock{},
+		chainID,
+		beneficiary,
+		transactionmock.New(),
+		nil,
+	)
+
+	_, err := chequestore.ReceiveCheque(context.Background(), cheque, cumulativePayout, TOKEN)
+	if !errors.Is(err, vault.ErrTokenCheque) {
+		t.Fatalf("wrong error. wanted %v, got %v", vault.ErrTokenCheque, err)
+	}
+	if _, err := chequestore.LastReceivedCheque(vaultAddress, TOKEN); !errors.Is(err, vault.ErrNoCheque) {
+		t.Fatalf("stored cheque with wrong token, got %v", err)
+	}
+}
+
 func TestReceiveChequeInvalidAmount(t *testing.T) {
 	store := storemock.NewStateStore()
 	beneficiary := common.HexToAddress("0xffff")
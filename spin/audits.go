@@ -0,0 +1,44 @@
+package spin
+
+import (
+	"context"
+	"time"
+
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/upload"
+	"github.com/bittorrent/go-btfs/settlement/swap/swapprotocol"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+)
+
+const (
+	auditsPeriod  = time.Hour
+	auditsTimeout = 50 * time.Minute
+)
+
+// Audits challenges the hosts of the uploaded files that are due for an
+// audit, when Upload.Audit is enabled.
+func Audits(req *cmds.Request, env cmds.Environment) {
+	params, err := uh.ExtractContextParams(req, env)
+	if err != nil {
+		log.Errorf("Failed to get context params %s", err)
+		return
+	}
+	if !params.Cfg.Experimental.StorageClientEnabled {
+		return
+	}
+	go periodicSync(auditsPeriod, auditsTimeout, "audits",
+		func(ctx context.Context) error {
+			if !uh.GetUploadConfig(params).Audit.Enabled {
+				return nil
+			}
+			// repairs send cheques over the requests of the daemon
+			swapprotocol.Req = req
+			swapprotocol.Env = env
+			_, err := upload.AuditFiles(ctx, params, false)
+			if err != nil {
+				log.Errorf("Failed to audit files %s", err)
+			}
+			return err
+		})
+}
@@ -0,0 +1,49 @@
+package spin
+
+import (
+	"context"
+	"time"
+
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/commands/cheque"
+)
+
+const autoCashoutTimeout = 30 * time.Minute
+
+// AutoCashout cashes the received cheques according to the AutoCashout
+// policy, when it is enabled. The policy is read again on every check.
+func AutoCashout(node *core.IpfsNode) {
+	if chain.SettleObject.AutoCashout == nil {
+		return
+	}
+	go func() {
+		for {
+			cfg := cheque.GetAutoCashoutConfig(node.Repo)
+			if cfg.Enabled {
+				autoCashout(cfg)
+			}
+			time.Sleep(time.Duration(cfg.Interval) * time.Minute)
+		}
+	}()
+}
+
+func autoCashout(cfg *cheque.AutoCashoutConfig) {
+	p, err := cfg.Policy()
+	if err != nil {
+		log.Errorf("Invalid auto cashout policy %s", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), autoCashoutTimeout)
+	defer cancel()
+	decisions, err := chain.SettleObject.AutoCashout.Run(ctx, p, cheque.AutoCashoutTokens())
+	if err != nil {
+		log.Errorf("Failed to auto cashout cheques %s", err)
+		return
+	}
+	for _, d := range decisions {
+		if d.Error != "" {
+			log.Errorf("Failed to auto cashout vault %s: %s", d.Vault, d.Error)
+		}
+	}
+}
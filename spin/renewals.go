@@ -0,0 +1,41 @@
+package spin
+
+import (
+	"context"
+	"time"
+
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/upload"
+	"github.com/bittorrent/go-btfs/settlement/swap/swapprotocol"
+
+	cmds "github.com/bittorrent/go-btfs-cmds"
+)
+
+const (
+	renewalsPeriod  = 12 * time.Hour
+	renewalsTimeout = 2 * time.Hour
+)
+
+// Renewals renews the uploaded files that are about to expire, when
+// Upload.AutoRenew is enabled.
+func Renewals(req *cmds.Request, env cmds.Environment) {
+	params, err := uh.ExtractContextParams(req, env)
+	if err != nil {
+		log.Errorf("Failed to get context params %s", err)
+		return
+	}
+	if !params.Cfg.Experimental.StorageClientEnabled {
+		return
+	}
+	go periodicSync(renewalsPeriod, renewalsTimeout, "renewals",
+		func(ctx context.Context) error {
+			// cheques are sent over the requests of the daemon
+			swapprotocol.Req = req
+			swapprotocol.Env = env
+			err := upload.AutoRenew(ctx, params)
+			if err != nil {
+				log.Errorf("Failed to renew files %s", err)
+			}
+			return err
+		})
+}
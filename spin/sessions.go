@@ -24,19 +24,33 @@ func RenterSessions(req *cmds.Request, env cmds.Environment) {
 		// Limit to 10 at a time to lower resource consumption
 		sem := syncutil.NewSem(10)
 		for {
-			session, err := cursor.NextSession(sessions.RssWaitUploadReqSignedStatus)
+			session, err := cursor.NextSession(sessions.ResumableStatuses()...)
 			if err != nil {
 				break
 			}
 			if session == nil {
 				break
 			}
+			// sessions left alone for too long are only resumed on request
+			if ok, err := upload.AutoResumable(session); err != nil || !ok {
+				log.Infof("not resuming session %s at startup, resumable: %v, err: %v", session.SsId, ok, err)
+				continue
+			}
 			sem.Acquire(1)
 			go func(session *sessions.RenterSession) {
 				defer sem.Release(1)
-				if e := upload.ResumeWaitUploadOnSigning(session); e != nil {
+				params, err := session.UploadParams()
+				if err != nil {
+					// sessions started without persisted upload params can only
+					// be resumed while they wait for the hosts to store shards
+					if st, e := session.Status(); e == nil && st.Status == sessions.RssWaitUploadReqSignedStatus {
+						_ = upload.ResumeWaitUploadOnSigning(session)
+					}
 					return
 				}
+				if e := upload.ResumeSession(session, params); e != nil {
+					_ = session.To(sessions.RssToErrorEvent, e)
+				}
 			}(session)
 		}
 	}()
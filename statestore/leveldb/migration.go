@@ -1,10 +1,15 @@
 package leveldb
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"strings"
 
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	"github.com/bittorrent/go-btfs/transaction/storage"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -22,10 +27,15 @@ const (
 	dbSchemaNoStamp       = "no-stamp"
 	dbSchemaFlushBlock    = "flushblock"
 	dbSchemaSwapAddr      = "swapaddr"
+	dbSchemaChequeToken   = "cheque-token"
+
+	// lastReceivedChequePrefix is where the chequestore keeps the last cheque
+	// received from each vault, behind the token unless it is WBTT.
+	lastReceivedChequePrefix = "swap_vault_last_received_cheque_"
 )
 
 var (
-	dbSchemaCurrent = dbSchemaFlushBlock
+	dbSchemaCurrent = dbSchemaChequeToken
 )
 
 type migration struct {
@@ -42,6 +52,7 @@ var schemaMigrations = []migration{
 	{name: dbSchemaNoStamp, fn: migrateStamp},
 	{name: dbSchemaFlushBlock, fn: migrateFB},
 	{name: dbSchemaSwapAddr, fn: migrateSwap},
+	{name: dbSchemaChequeToken, fn: migrateChequeToken},
 }
 
 func migrateFB(s *store) error {
@@ -108,6 +119,10 @@ func migrateSwap(s *store) error {
 			if len(split) != 2 {
 				return errors.New("no peer in key")
 			}
+			if isHexAddress(split[1]) {
+				// already keyed by the hex address
+				continue
+			}
 
 			addr := common.BytesToAddress([]byte(split[1]))
 			fixed := fmt.Sprintf("%s%x", prefix, addr)
@@ -135,6 +150,77 @@ func migrateSwap(s *store) error {
 	return migratePrefix("swap_beneficiary_peer_")
 }
 
+func isHexAddress(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 2*common.AddressLength
+}
+
+// receivedChequeKey is the last received cheque key of the vault in the token,
+// as the chequestore computes it. Cheques from before multiple tokens have no
+// token, and are WBTT.
+func receivedChequeKey(vault, token common.Address) string {
+	if token == (common.Address{}) || tokencfg.IsWBTTOfAnyChain(token) {
+		return fmt.Sprintf("%s%x", lastReceivedChequePrefix, vault)
+	}
+	return fmt.Sprintf("%s_%s%x", token, lastReceivedChequePrefix, vault)
+}
+
+// migrateChequeToken moves the last received cheques that were stored under
+// another token than the one they were signed for to the key of their token.
+// When a cheque of the vault is already stored there, the one with the higher
+// cumulative payout is kept.
+func migrateChequeToken(s *store) error {
+	type storedCheque struct {
+		Token            common.Address
+		Vault            common.Address
+		CumulativePayout *big.Int
+	}
+	var keys []string
+	if err := s.Iterate("", func(k, v []byte) (bool, error) {
+		if strings.Contains(string(k), lastReceivedChequePrefix) {
+			keys = append(keys, string(k))
+		}
+		return false, nil
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var raw json.RawMessage
+		if err := s.Get(key, &raw); err != nil {
+			return err
+		}
+		var cheque storedCheque
+		if err := json.Unmarshal(raw, &cheque); err != nil {
+			return fmt.Errorf("cheque of key %s: %w", key, err)
+		}
+		fixed := receivedChequeKey(cheque.Vault, cheque.Token)
+		if fixed == key {
+			continue
+		}
+
+		var existing storedCheque
+		err := s.Get(fixed, &existing)
+		switch {
+		case err == nil && existing.CumulativePayout != nil && cheque.CumulativePayout != nil &&
+			existing.CumulativePayout.Cmp(cheque.CumulativePayout) >= 0:
+			log.Infof("statestore migration: drop cheque of vault %x stored under the wrong token, "+
+				"a later cheque of token %s is stored", cheque.Vault, cheque.Token)
+		case err == nil || errors.Is(err, storage.ErrNotFound):
+			log.Infof("statestore migration: move cheque of vault %x from %s to token %s", cheque.Vault, key, cheque.Token)
+			if err := s.Put(fixed, raw); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+		if err := s.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *store) migrate(schemaName string) error {
 	migrations, err := getMigrations(schemaName, dbSchemaCurrent, schemaMigrations, s)
 	if err != nil {
@@ -3,6 +3,7 @@ package leveldb
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"testing"
 
 	"github.com/bittorrent/go-btfs/transaction/storage"
@@ -288,10 +289,21 @@ func TestMigrationSwap(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// keys of the hex address are kept as they are
+	hexKey := fmt.Sprintf("swap_beneficiary_peer_%x", storedAddress)
+	if err = db.Put(hexKey, "peer"); err != nil {
+		t.Fatal(err)
+	}
+
 	if err = migrateSwap(db.(*store)); err != nil {
 		t.Fatal(err)
 	}
 
+	var peer string
+	if err = db.Get(hexKey, &peer); err != nil || peer != "peer" {
+		t.Fatalf("hex key changed. got %q, error %v", peer, err)
+	}
+
 	var retrievedAddress common.Address
 	if err = db.Get("swap_peer_chequebook_000000000000000000000000000000000000abcd", &retrievedAddress); err != nil {
 		t.Fatal(err)
@@ -317,3 +329,76 @@ func TestMigrationSwap(t *testing.T) {
 		t.Fatalf("legacyKey2 not deleted. got error %v", err)
 	}
 }
+
+func TestMigrationChequeToken(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := NewStateStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type cheque struct {
+		Token            common.Address
+		Vault            common.Address
+		CumulativePayout *big.Int
+		Signature        []byte
+	}
+	wbtt := common.HexToAddress("0x23181F21DEa5936e24163FFABa4Ea3B316B57f3C")
+	usdt := common.HexToAddress("0xdB28719F7f938507dBfe4f0eAe55668903D34a15")
+	vault1 := common.HexToAddress("0xaaaa")
+	vault2 := common.HexToAddress("0xbbbb")
+	vault3 := common.HexToAddress("0xcccc")
+
+	usdtKey := func(vault common.Address) string {
+		return fmt.Sprintf("%s_swap_vault_last_received_cheque_%x", usdt, vault)
+	}
+	wbttKey := func(vault common.Address) string {
+		return fmt.Sprintf("swap_vault_last_received_cheque_%x", vault)
+	}
+	stored := map[string]*cheque{
+		// a usdt cheque stored as wbtt, moved
+		wbttKey(vault1): {Token: usdt, Vault: vault1, CumulativePayout: big.NewInt(10), Signature: []byte{1}},
+		// a wbtt cheque stored as usdt, older than the wbtt one, dropped
+		usdtKey(vault2): {Token: wbtt, Vault: vault2, CumulativePayout: big.NewInt(5)},
+		wbttKey(vault2): {Token: wbtt, Vault: vault2, CumulativePayout: big.NewInt(20)},
+		// cheques under their own token, or from before tokens, are kept
+		usdtKey(vault3): {Token: usdt, Vault: vault3, CumulativePayout: big.NewInt(30)},
+		wbttKey(vault3): {Vault: vault3, CumulativePayout: big.NewInt(40)},
+	}
+	for k, c := range stored {
+		if err = db.Put(k, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = migrateChequeToken(db.(*store)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int64{
+		usdtKey(vault1): 10,
+		wbttKey(vault2): 20,
+		usdtKey(vault3): 30,
+		wbttKey(vault3): 40,
+	}
+	for k, payout := range expected {
+		var c cheque
+		if err = db.Get(k, &c); err != nil {
+			t.Fatalf("get %s: %v", k, err)
+		}
+		if c.CumulativePayout.Int64() != payout {
+			t.Fatalf("got wrong cheque under %s. wanted payout %d, got %d", k, payout, c.CumulativePayout)
+		}
+	}
+	var c cheque
+	if err = db.Get(usdtKey(vault1), &c); err != nil || len(c.Signature) != 1 {
+		t.Fatalf("moved cheque lost its signature: %v", err)
+	}
+	for _, k := range []string{wbttKey(vault1), usdtKey(vault2)} {
+		if err = db.Get(k, &c); err != storage.ErrNotFound {
+			t.Fatalf("cheque under %s not deleted. got error %v", k, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package fakeservices
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/core/hub"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
+	onlinepb "github.com/bittorrent/go-btfs-common/protos/online"
+	"github.com/bittorrent/go-btfs-common/utils/grpc"
+	"github.com/gogo/protobuf/proto"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func startServices(t *testing.T) *Services {
+	s, err := Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+	s := startServices(t)
+	s.Hub.AddHost(&hubpb.Host{NodeId: "a", Score: 1, StoragePriceAsk: 300}, nil)
+	s.Hub.AddHost(&hubpb.Host{NodeId: "b", Score: 3, StoragePriceAsk: 100}, nil)
+	s.Hub.AddHost(&hubpb.Host{NodeId: "c", Score: 2, StoragePriceAsk: 200}, nil)
+
+	query := func(mode hubpb.HostsReq_Mode) []string {
+		var resp *hubpb.HostsResp
+		err := grpc.HubQueryClient(s.Addr).WithContext(ctx, func(ctx context.Context,
+			client hubpb.HubQueryServiceClient) (err error) {
+			resp, err = client.GetHosts(ctx, &hubpb.HostsReq{Id: "c", Mode: mode})
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0)
+		for _, h := range resp.Hosts.Hosts {
+			ids = append(ids, h.NodeId)
+		}
+		return ids
+	}
+	if ids := query(hubpb.HostsReq_SCORE); len(ids) != 2 || ids[0] != "b" || ids[1] != "a" {
+		t.Fatalf("hosts by score %v", ids)
+	}
+	if ids := query(hubpb.HostsReq_PRICE); len(ids) != 2 || ids[0] != "b" || ids[1] != "a" {
+		t.Fatalf("hosts by price %v", ids)
+	}
+
+	ns, err := hub.GetHostSettings(ctx, s.Addr, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.StoragePriceAsk != 300 {
+		t.Fatalf("storage price ask %d, want 300", ns.StoragePriceAsk)
+	}
+	s.Hub.RemoveHost("a")
+	if _, err := hub.GetHostSettings(ctx, s.Addr, "a"); err == nil {
+		t.Fatal("got the settings of a removed host")
+	}
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	s := startServices(t)
+	call := func(f func(ctx context.Context, client guardpb.GuardServiceClient) error) {
+		if err := grpc.GuardClient(s.Addr).WithContext(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contract := func(shard, host string) *guardpb.Contract {
+		return &guardpb.Contract{ContractMeta: guardpb.ContractMeta{
+			ContractId: shard + host, FileHash: "file", ShardHash: shard, HostPid: host}}
+	}
+	fs := &guardpb.FileStoreStatus{
+		FileStoreMeta: guardpb.FileStoreMeta{RenterPid: "renter", FileHash: "file"},
+		Contracts:     []*guardpb.Contract{contract("s0", "h0"), contract("s1", "h1")},
+	}
+	call(func(ctx context.Context, client guardpb.GuardServiceClient) error {
+		res, err := client.SubmitFileStoreMeta(ctx, &guardpb.FileStoreStatus{})
+		if err == nil && res.Code == guardpb.ResponseCode_SUCCESS {
+			t.Fatal("accepted a file without renter")
+		}
+		res, err = client.SubmitFileStoreMeta(ctx, fs)
+		if err == nil && res.Code != guardpb.ResponseCode_SUCCESS {
+			t.Fatal(res.Message)
+		}
+		res, err = client.SendQuestions(ctx, &guardpb.FileChallengeQuestions{
+			FileHash: "file",
+			ShardQuestions: []*guardpb.ShardChallengeQuestions{{
+				FileHash:  "file",
+				ShardHash: "s1",
+				Questions: []*guardpb.ChallengeQuestion{{ShardHash: "s1", Nonce: "n", ExpectAnswer: "42"}},
+			}},
+		})
+		if err == nil && res.Code != guardpb.ResponseCode_SUCCESS {
+			t.Fatal(res.Message)
+		}
+		return err
+	})
+
+	// h0 has no prepared questions, h1 has to answer the one of the renter
+	answer := func(shard, host, ans string) *guardpb.Result {
+		var res *guardpb.Result
+		call(func(ctx context.Context, client guardpb.GuardServiceClient) error {
+			q, err := client.RequestChallenge(ctx, &guardpb.ReadyForChallengeRequest{
+				RenterPid: "renter", FileHash: "file", ShardHash: shard, HostPid: host})
+			if err != nil {
+				return err
+			}
+			if q.Question.ExpectAnswer != "" {
+				t.Fatal("question gives the answer away")
+			}
+			q.Question.ExpectAnswer = ans
+			res, err = client.ResponseChallenge(ctx, &guardpb.ResponseChallengeQuestion{
+				Answer: q.Question, FileHash: "file", HostPid: host})
+			return err
+		})
+		return res
+	}
+	if res := answer("s0", "h0", "anything"); res.Code != guardpb.ResponseCode_SUCCESS {
+		t.Fatal(res.Message)
+	}
+	if st := s.Guard.FileStatus("renter", "file"); st.State != guardpb.FileStoreStatus_UPLOADING {
+		t.Fatalf("file %s before all hosts are ready", st.State)
+	}
+	if res := answer("s1", "h1", "wrong"); res.Code == guardpb.ResponseCode_SUCCESS {
+		t.Fatal("accepted a wrong answer")
+	}
+	// the question was used up by the wrong answer
+	if res := answer("s1", "h1", "42"); res.Code != guardpb.ResponseCode_SUCCESS {
+		t.Fatal(res.Message)
+	}
+
+	call(func(ctx context.Context, client guardpb.GuardServiceClient) error {
+		st, err := client.CheckFileStoreMeta(ctx, &guardpb.CheckFileStoreMetaRequest{RenterPid: "renter", FileHash: "file"})
+		if err != nil {
+			return err
+		}
+		if st.State != guardpb.FileStoreStatus_RUNNING {
+			t.Fatalf("file %s after all hosts are ready", st.State)
+		}
+		for _, c := range st.Contracts {
+			if c.State != guardpb.Contract_READY_CHALLENGE {
+				t.Fatalf("contract %s %s", c.ContractId, c.State)
+			}
+		}
+		list, err := client.ListHostContracts(ctx, &guardpb.ListHostContractsRequest{HostPid: "h1", RequestPageSize: 10})
+		if err != nil {
+			return err
+		}
+		if len(list.Contracts) != 1 || list.Contracts[0].ShardHash != "s1" {
+			t.Fatalf("contracts of h1 %v", list.Contracts)
+		}
+		return nil
+	})
+}
+
+func TestOnline(t *testing.T) {
+	ctx := context.Background()
+	s := startServices(t)
+	priv, pub, err := ic.GenerateKeyPair(ic.Secp256k1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, err := ic.MarshalPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := proto.Marshal(&onlinepb.PayLoadInfo{NodeId: id.String(), Node: &nodepb.Node{BtfsVersion: "v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := priv.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(req *onlinepb.ReqSignMetrics) *onlinepb.RespSignMetrics {
+		var resp *onlinepb.RespSignMetrics
+		err := grpc.OnlineClient(s.Addr).WithContext(ctx, func(ctx context.Context,
+			client onlinepb.OnlineServiceClient) (err error) {
+			resp, err = client.UpdateSignMetrics(ctx, req)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for i := 1; i <= 2; i++ {
+		resp := report(&onlinepb.ReqSignMetrics{PublicKey: pubBytes, Signature: sig, Payload: payload})
+		if resp.Code != onlinepb.ResponseCode_SUCCESS {
+			t.Fatal(resp.Message)
+		}
+		if resp.SignedInfo.Peer != id.String() || resp.SignedInfo.Nonce != uint32(i) || resp.SignedInfo.Version != "v1" {
+			t.Fatalf("signed info %+v", resp.SignedInfo)
+		}
+	}
+	resp := report(&onlinepb.ReqSignMetrics{PublicKey: pubBytes, Signature: []byte("bad"), Payload: payload})
+	if resp.Code == onlinepb.ResponseCode_SUCCESS {
+		t.Fatal("accepted a report with a bad signature")
+	}
+	if si := s.Online.LastSignedInfo(id.String()); si == nil || si.Nonce != 2 {
+		t.Fatalf("last signed info %+v", si)
+	}
+}
@@ -0,0 +1,250 @@
+package fakeservices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	guardpb "github.com/bittorrent/go-btfs-common/protos/guard"
+	"github.com/google/uuid"
+)
+
+// Guard is a fake guard that keeps the file store meta submitted by renters and
+// moves contracts to READY_CHALLENGE once their hosts answer a challenge. Hosts
+// are asked the questions the renter sent for their shard, and their answers are
+// checked against the expected ones. Without questions, any answer is accepted,
+// as the fake does not hold the shard data to check it.
+type Guard struct {
+	guardpb.UnimplementedGuardServiceServer
+
+	mu        sync.Mutex
+	files     map[string]*guardpb.FileStoreStatus
+	prepared  map[string][]*guardpb.ChallengeQuestion
+	questions map[string]*guardpb.ChallengeQuestion
+}
+
+// NewGuard returns a guard without any files.
+func NewGuard() *Guard {
+	return &Guard{
+		files:     make(map[string]*guardpb.FileStoreStatus),
+		prepared:  make(map[string][]*guardpb.ChallengeQuestion),
+		questions: make(map[string]*guardpb.ChallengeQuestion),
+	}
+}
+
+func fileKey(renterPid, fileHash string) string {
+	return renterPid + "/" + fileHash
+}
+
+func shardKey(fileHash, shardHash string) string {
+	return fileHash + "/" + shardHash
+}
+
+func questionKey(fileHash, shardHash, hostPid string) string {
+	return fileHash + "/" + shardHash + "/" + hostPid
+}
+
+func guardResult(err error) *guardpb.Result {
+	if err != nil {
+		return &guardpb.Result{Code: guardpb.ResponseCode_OTHER_ERROR, Message: err.Error(), ResponseTime: time.Now()}
+	}
+	return &guardpb.Result{Code: guardpb.ResponseCode_SUCCESS, ResponseTime: time.Now()}
+}
+
+// FileStatus returns a copy of the status of a file, or nil if it was never submitted.
+func (g *Guard) FileStatus(renterPid, fileHash string) *guardpb.FileStoreStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fs, ok := g.files[fileKey(renterPid, fileHash)]
+	if !ok {
+		return nil
+	}
+	return clone(fs).(*guardpb.FileStoreStatus)
+}
+
+// SubmitFileStoreMeta keeps the file status of an upload.
+func (g *Guard) SubmitFileStoreMeta(ctx context.Context, fs *guardpb.FileStoreStatus) (*guardpb.Result, error) {
+	switch {
+	case fs.RenterPid == "" || fs.FileHash == "":
+		return guardResult(errors.New("renter pid and file hash are required")), nil
+	case len(fs.Contracts) == 0:
+		return guardResult(errors.New("no contracts")), nil
+	}
+	for _, c := range fs.Contracts {
+		if c.HostPid == "" || c.ShardHash == "" || c.FileHash != fs.FileHash {
+			return guardResult(fmt.Errorf("invalid contract %s", c.ContractId)), nil
+		}
+	}
+	fs = clone(fs).(*guardpb.FileStoreStatus)
+	fs.State = guardpb.FileStoreStatus_UPLOADING
+	fs.GuardReceiveTime = time.Now()
+
+	g.mu.Lock()
+	g.files[fileKey(fs.RenterPid, fs.FileHash)] = fs
+	g.mu.Unlock()
+	return guardResult(nil), nil
+}
+
+// SendQuestions keeps the challenge questions prepared by a renter for the shards
+// of a file.
+func (g *Guard) SendQuestions(ctx context.Context, fq *guardpb.FileChallengeQuestions) (*guardpb.Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, sq := range fq.ShardQuestions {
+		if sq.FileHash != fq.FileHash {
+			return guardResult(fmt.Errorf("questions of shard %s are for another file", sq.ShardHash)), nil
+		}
+		key := shardKey(fq.FileHash, sq.ShardHash)
+		for _, q := range sq.Questions {
+			g.prepared[key] = append(g.prepared[key], clone(q).(*guardpb.ChallengeQuestion))
+		}
+	}
+	return guardResult(nil), nil
+}
+
+// CheckFileStoreMeta returns the status of a submitted file.
+func (g *Guard) CheckFileStoreMeta(ctx context.Context,
+	req *guardpb.CheckFileStoreMetaRequest) (*guardpb.FileStoreStatus, error) {
+	fs := g.FileStatus(req.RenterPid, req.FileHash)
+	if fs == nil {
+		return nil, fmt.Errorf("file %s of renter %s not found", req.FileHash, req.RenterPid)
+	}
+	fs.CurrentTime = time.Now()
+	return fs, nil
+}
+
+// contract returns the contract of a host for a shard of a file. Must be called
+// with the lock held.
+func (g *Guard) contract(renterPid, fileHash, shardHash, hostPid string) (*guardpb.FileStoreStatus,
+	*guardpb.Contract, error) {
+	for _, fs := range g.files {
+		if fs.FileHash != fileHash || (renterPid != "" && fs.RenterPid != renterPid) {
+			continue
+		}
+		for _, c := range fs.Contracts {
+			if c.ShardHash == shardHash && c.HostPid == hostPid {
+				return fs, c, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no contract of host %s for shard %s", hostPid, shardHash)
+}
+
+// ready marks a contract ready for challenge, and the file running once all of
+// its contracts are. Must be called with the lock held.
+func ready(fs *guardpb.FileStoreStatus, c *guardpb.Contract) {
+	now := time.Now()
+	c.State = guardpb.Contract_READY_CHALLENGE
+	c.LastModifyTime = now
+	c.LastSuccessChallengeTime = now
+	for _, o := range fs.Contracts {
+		if o.State != guardpb.Contract_READY_CHALLENGE {
+			return
+		}
+	}
+	fs.State = guardpb.FileStoreStatus_RUNNING
+}
+
+// ReadyForChallenge marks the contract of a host ready for challenge.
+func (g *Guard) ReadyForChallenge(ctx context.Context, req *guardpb.ReadyForChallengeRequest) (*guardpb.Result, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fs, c, err := g.contract(req.RenterPid, req.FileHash, req.ShardHash, req.HostPid)
+	if err != nil {
+		return guardResult(err), nil
+	}
+	ready(fs, c)
+	return guardResult(nil), nil
+}
+
+// RequestChallenge hands a host a question on the shard it stores.
+func (g *Guard) RequestChallenge(ctx context.Context,
+	req *guardpb.ReadyForChallengeRequest) (*guardpb.RequestChallengeQuestion, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, c, err := g.contract(req.RenterPid, req.FileHash, req.ShardHash, req.HostPid)
+	if err != nil {
+		return nil, err
+	}
+	var q *guardpb.ChallengeQuestion
+	if qs := g.prepared[shardKey(req.FileHash, c.ShardHash)]; len(qs) > 0 {
+		q = qs[0]
+		g.prepared[shardKey(req.FileHash, c.ShardHash)] = qs[1:]
+		q.HostPid = c.HostPid
+	} else {
+		q = &guardpb.ChallengeQuestion{
+			ShardHash: c.ShardHash,
+			HostPid:   c.HostPid,
+			Nonce:     uuid.New().String(),
+		}
+	}
+	g.questions[questionKey(req.FileHash, c.ShardHash, c.HostPid)] = q
+	asked := clone(q).(*guardpb.ChallengeQuestion)
+	asked.ExpectAnswer = ""
+	return &guardpb.RequestChallengeQuestion{
+		Question:    asked,
+		PrepareTime: req.PrepareTime,
+		IsRepair:    req.IsRepair,
+		FileHash:    req.FileHash,
+	}, nil
+}
+
+// ResponseChallenge takes the answer of a host to its question, and marks its
+// contract ready for challenge.
+func (g *Guard) ResponseChallenge(ctx context.Context,
+	resp *guardpb.ResponseChallengeQuestion) (*guardpb.Result, error) {
+	if resp.Answer == nil {
+		return guardResult(errors.New("no answer")), nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := questionKey(resp.FileHash, resp.Answer.ShardHash, resp.HostPid)
+	q, ok := g.questions[key]
+	if !ok || q.Nonce != resp.Answer.Nonce || q.ChunkIndex != resp.Answer.ChunkIndex {
+		return guardResult(errors.New("answer does not match a question")), nil
+	}
+	if resp.Answer.ExpectAnswer == "" || (q.ExpectAnswer != "" && q.ExpectAnswer != resp.Answer.ExpectAnswer) {
+		return guardResult(errors.New("wrong answer")), nil
+	}
+	delete(g.questions, key)
+	fs, c, err := g.contract("", resp.FileHash, resp.Answer.ShardHash, resp.HostPid)
+	if err != nil {
+		return guardResult(err), nil
+	}
+	ready(fs, c)
+	return guardResult(nil), nil
+}
+
+// ListHostContracts returns a page of the contracts of a host.
+func (g *Guard) ListHostContracts(ctx context.Context,
+	req *guardpb.ListHostContractsRequest) (*guardpb.ContractsList, error) {
+	g.mu.Lock()
+	contracts := make([]*guardpb.Contract, 0)
+	for _, fs := range g.files {
+		for _, c := range fs.Contracts {
+			if c.HostPid == req.HostPid {
+				contracts = append(contracts, clone(c).(*guardpb.Contract))
+			}
+		}
+	}
+	g.mu.Unlock()
+	if size := int(req.RequestPageSize); size > 0 {
+		start := int(req.RequestPageIndex) * size
+		if start > len(contracts) {
+			start = len(contracts)
+		}
+		end := start + size
+		if end > len(contracts) {
+			end = len(contracts)
+		}
+		contracts = contracts[start:end]
+	}
+	return &guardpb.ContractsList{
+		Request:      req,
+		GenerateTime: time.Now(),
+		Contracts:    contracts,
+		Count:        int32(len(contracts)),
+	}, nil
+}
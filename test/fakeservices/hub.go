@@ -0,0 +1,134 @@
+package fakeservices
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+)
+
+// Hub is a fake hub that serves the hosts registered with AddHost.
+type Hub struct {
+	hubpb.UnimplementedHubQueryServiceServer
+
+	mu       sync.Mutex
+	hosts    []*hubpb.Host
+	settings map[string]*hubpb.SettingsData
+}
+
+// NewHub returns a hub without any hosts.
+func NewHub() *Hub {
+	return &Hub{settings: make(map[string]*hubpb.SettingsData)}
+}
+
+// AddHost registers a host, replacing an earlier registration of the same node.
+// Without settings, the settings are taken from the price asks of the host.
+func (h *Hub) AddHost(host *hubpb.Host, settings *hubpb.SettingsData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if settings == nil {
+		settings = &hubpb.SettingsData{
+			StoragePriceAsk:   float64(host.StoragePriceAsk),
+			BandwidthPriceAsk: float64(host.BandwidthPriceAsk),
+			StorageTimeMin:    float64(host.StorageTimeMin),
+			BandwidthLimit:    host.BandwidthLimit,
+			CollateralStake:   float64(host.CollateralStake),
+		}
+	}
+	host = clone(host).(*hubpb.Host)
+	for i, o := range h.hosts {
+		if o.NodeId == host.NodeId {
+			h.hosts = append(h.hosts[:i], h.hosts[i+1:]...)
+			break
+		}
+	}
+	h.hosts = append(h.hosts, host)
+	h.settings[host.NodeId] = settings
+}
+
+// RemoveHost unregisters a host.
+func (h *Hub) RemoveHost(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, o := range h.hosts {
+		if o.NodeId == id {
+			h.hosts = append(h.hosts[:i], h.hosts[i+1:]...)
+			break
+		}
+	}
+	delete(h.settings, id)
+}
+
+// GetHosts returns the registered hosts other than the requester, best ones first
+// for the score and price modes.
+func (h *Hub) GetHosts(ctx context.Context, req *hubpb.HostsReq) (*hubpb.HostsResp, error) {
+	h.mu.Lock()
+	hosts := make([]*hubpb.Host, 0, len(h.hosts))
+	for _, host := range h.hosts {
+		if host.NodeId != req.Id {
+			hosts = append(hosts, clone(host).(*hubpb.Host))
+		}
+	}
+	h.mu.Unlock()
+
+	switch req.Mode {
+	case hubpb.HostsReq_SCORE:
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].Score > hosts[j].Score })
+	case hubpb.HostsReq_PRICE:
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].StoragePriceAsk < hosts[j].StoragePriceAsk })
+	}
+	if req.RespSize > 0 && int(req.RespSize) < len(hosts) {
+		hosts = hosts[:req.RespSize]
+	}
+	return &hubpb.HostsResp{
+		Code:         hubpb.ResponseCode_SUCCESS,
+		Hosts:        &hubpb.HostsData{Hosts: hosts},
+		RespSize:     int32(len(hosts)),
+		Mode:         req.Mode.String(),
+		ResponseTime: time.Now(),
+	}, nil
+}
+
+// QueryNodes returns the registered hosts among the requested nodes.
+func (h *Hub) QueryNodes(ctx context.Context, req *hubpb.NodesReq) (*hubpb.HostsResp, error) {
+	want := make(map[string]bool, len(req.NodeId))
+	for _, id := range req.NodeId {
+		want[id] = true
+	}
+	h.mu.Lock()
+	hosts := make([]*hubpb.Host, 0, len(req.NodeId))
+	for _, host := range h.hosts {
+		if want[host.NodeId] {
+			hosts = append(hosts, clone(host).(*hubpb.Host))
+		}
+	}
+	h.mu.Unlock()
+	return &hubpb.HostsResp{
+		Code:         hubpb.ResponseCode_SUCCESS,
+		Hosts:        &hubpb.HostsData{Hosts: hosts},
+		RespSize:     int32(len(hosts)),
+		ResponseTime: time.Now(),
+	}, nil
+}
+
+// GetSettings returns the settings of a registered host.
+func (h *Hub) GetSettings(ctx context.Context, req *hubpb.SettingsReq) (*hubpb.SettingsResp, error) {
+	h.mu.Lock()
+	s, ok := h.settings[req.Id]
+	h.mu.Unlock()
+	if !ok {
+		return &hubpb.SettingsResp{
+			Code:         hubpb.ResponseCode_OTHER_ERROR,
+			Message:      fmt.Sprintf("host %s not found", req.Id),
+			ResponseTime: time.Now(),
+		}, nil
+	}
+	return &hubpb.SettingsResp{
+		Code:         hubpb.ResponseCode_SUCCESS,
+		SettingsData: clone(s).(*hubpb.SettingsData),
+		ResponseTime: time.Now(),
+	}, nil
+}
@@ -0,0 +1,125 @@
+package fakeservices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	onlinepb "github.com/bittorrent/go-btfs-common/protos/online"
+	"github.com/gogo/protobuf/proto"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Online is a fake online service that signs the online reports of nodes.
+type Online struct {
+	onlinepb.UnimplementedOnlineServiceServer
+
+	mu     sync.Mutex
+	signed map[string]*onlinepb.SignedInfo
+	daily  map[string]*onlinepb.SignedInfo
+}
+
+// NewOnline returns an online service that has not seen any node.
+func NewOnline() *Online {
+	return &Online{
+		signed: make(map[string]*onlinepb.SignedInfo),
+		daily:  make(map[string]*onlinepb.SignedInfo),
+	}
+}
+
+// verify checks the report was signed by the node it is about.
+func verify(req *onlinepb.ReqSignMetrics) (*onlinepb.PayLoadInfo, error) {
+	pub, err := ic.UnmarshalPublicKey(req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := pub.Verify(req.Payload, req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid signature")
+	}
+	payload := new(onlinepb.PayLoadInfo)
+	if err := proto.Unmarshal(req.Payload, payload); err != nil {
+		return nil, err
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if id.String() != payload.NodeId {
+		return nil, fmt.Errorf("report of %s signed by %s", payload.NodeId, id)
+	}
+	return payload, nil
+}
+
+func (o *Online) sign(m map[string]*onlinepb.SignedInfo, payload *onlinepb.PayLoadInfo) *onlinepb.SignedInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := uint32(time.Now().Unix())
+	si, ok := m[payload.NodeId]
+	if !ok {
+		si = &onlinepb.SignedInfo{Peer: payload.NodeId, CreatedTime: now}
+		m[payload.NodeId] = si
+	}
+	si.Nonce++
+	si.SignedTime = now
+	if payload.Node != nil {
+		si.Version = payload.Node.BtfsVersion
+	}
+	return clone(si).(*onlinepb.SignedInfo)
+}
+
+// UpdateSignMetrics signs the online report of a node.
+func (o *Online) UpdateSignMetrics(ctx context.Context, req *onlinepb.ReqSignMetrics) (*onlinepb.RespSignMetrics, error) {
+	payload, err := verify(req)
+	if err != nil {
+		return &onlinepb.RespSignMetrics{Code: onlinepb.ResponseCode_OTHER_ERROR, Message: err.Error()}, nil
+	}
+	return &onlinepb.RespSignMetrics{
+		Code:       onlinepb.ResponseCode_SUCCESS,
+		SignedInfo: o.sign(o.signed, payload),
+		Signature:  "fake",
+	}, nil
+}
+
+// DoDailyStatusReport records the daily report of a node.
+func (o *Online) DoDailyStatusReport(ctx context.Context, req *onlinepb.ReqSignMetrics) (*onlinepb.Result, error) {
+	payload, err := verify(req)
+	if err != nil {
+		return &onlinepb.Result{Code: onlinepb.ResponseCode_OTHER_ERROR, Message: err.Error(), ResponseTime: time.Now()}, nil
+	}
+	o.sign(o.daily, payload)
+	return &onlinepb.Result{Code: onlinepb.ResponseCode_SUCCESS, ResponseTime: time.Now()}, nil
+}
+
+// GetLastDailySignedInfo returns the last daily report of a node.
+func (o *Online) GetLastDailySignedInfo(ctx context.Context,
+	req *onlinepb.ReqLastDailySignedInfo) (*onlinepb.RespSignMetrics, error) {
+	o.mu.Lock()
+	si, ok := o.daily[req.PeerId]
+	o.mu.Unlock()
+	if !ok {
+		return &onlinepb.RespSignMetrics{Code: onlinepb.ResponseCode_OTHER_ERROR, Message: "no daily report"}, nil
+	}
+	return &onlinepb.RespSignMetrics{
+		Code:       onlinepb.ResponseCode_SUCCESS,
+		SignedInfo: clone(si).(*onlinepb.SignedInfo),
+		Signature:  "fake",
+	}, nil
+}
+
+// LastSignedInfo returns the last online report signed for a node, or nil.
+func (o *Online) LastSignedInfo(peerID string) *onlinepb.SignedInfo {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	si, ok := o.signed[peerID]
+	if !ok {
+		return nil
+	}
+	return clone(si).(*onlinepb.SignedInfo)
+}
@@ -0,0 +1,487 @@
+package integrationtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bittorrent/go-btfs/accounting"
+	"github.com/bittorrent/go-btfs/chain"
+	"github.com/bittorrent/go-btfs/chain/tokencfg"
+	oldcmds "github.com/bittorrent/go-btfs/commands"
+	"github.com/bittorrent/go-btfs/core"
+	"github.com/bittorrent/go-btfs/core/commands/storage/helper"
+	uh "github.com/bittorrent/go-btfs/core/commands/storage/upload/helper"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/sessions"
+	"github.com/bittorrent/go-btfs/core/commands/storage/upload/upload"
+	"github.com/bittorrent/go-btfs/core/coreapi"
+	"github.com/bittorrent/go-btfs/core/corehttp/remote"
+	mock "github.com/bittorrent/go-btfs/core/mock"
+	"github.com/bittorrent/go-btfs/core/node/libp2p"
+	"github.com/bittorrent/go-btfs/repo"
+	"github.com/bittorrent/go-btfs/settlement/swap"
+	chequestoremock "github.com/bittorrent/go-btfs/settlement/swap/chequestore/mock"
+	"github.com/bittorrent/go-btfs/settlement/swap/swapprotocol"
+	"github.com/bittorrent/go-btfs/settlement/swap/swapprotocol/pb"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	vaultmock "github.com/bittorrent/go-btfs/settlement/swap/vault/mock"
+	storemock "github.com/bittorrent/go-btfs/statestore/mock"
+	"github.com/bittorrent/go-btfs/test/fakeservices"
+	"github.com/bittorrent/go-btfs/transaction"
+	"github.com/bittorrent/go-btfs/transaction/backendsimulation"
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+
+	shell "github.com/bittorrent/go-btfs-api"
+	cmds "github.com/bittorrent/go-btfs-cmds"
+	cmdshttp "github.com/bittorrent/go-btfs-cmds/http"
+	hubpb "github.com/bittorrent/go-btfs-common/protos/hub"
+	nodepb "github.com/bittorrent/go-btfs-common/protos/node"
+	config "github.com/bittorrent/go-btfs-config"
+	files "github.com/bittorrent/go-btfs-files"
+	coreiface "github.com/bittorrent/interface-go-btfs-core"
+	"github.com/bittorrent/interface-go-btfs-core/options"
+	"github.com/ethereum/go-ethereum/common"
+	ethcore "github.com/ethereum/go-ethereum/core"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ipfs/go-datastore"
+	syncds "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// storageNet is a renter and hosts on a mock network, with their hub and guard
+// services served by fakes. Every node serves the remote storage api to the
+// others, as a daemon does.
+type storageNet struct {
+	services *fakeservices.Services
+	renter   *core.IpfsNode
+	hosts    []*core.IpfsNode
+	apis     map[*core.IpfsNode]coreiface.CoreAPI
+	envs     map[*core.IpfsNode]*oldcmds.Context
+}
+
+// newStorageNode is a mock public node with a secp256k1 identity, as storage
+// contracts are verified against the public key embedded in the peer id.
+func newStorageNode(ctx context.Context, mn mocknet.Mocknet) (*core.IpfsNode, error) {
+	cfg, err := config.Init(io.Discard, 2048, "Secp256k1", "", "", false)
+	if err != nil {
+		return nil, err
+	}
+	count := len(mn.Peers())
+	cfg.Addresses.Swarm = []string{fmt.Sprintf("/ip4/18.0.%d.%d/tcp/4001", count>>16, count&0xFF)}
+	cfg.Datastore = config.Datastore{}
+	return core.NewNode(ctx, &core.BuildCfg{
+		Online:  true,
+		Routing: libp2p.DHTServerOption,
+		Repo:    &repo.Mock{C: *cfg, D: syncds.MutexWrap(datastore.NewMapDatastore())},
+		Host:    mock.MockHostOption(mn),
+	})
+}
+
+func newStorageNet(ctx context.Context, t *testing.T, numHosts int) *storageNet {
+	services, err := fakeservices.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { services.Close() })
+	sn := &storageNet{
+		services: services,
+		apis:     make(map[*core.IpfsNode]coreiface.CoreAPI),
+		envs:     make(map[*core.IpfsNode]*oldcmds.Context),
+	}
+
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	for i := 0; i <= numHosts; i++ {
+		n, err := newStorageNode(ctx, mn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		cfg, err := n.Repo.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		services.Configure(cfg)
+		cfg.Experimental.StorageHostEnabled = i > 0
+		cfg.UI.Host.ContractManager = &config.ContractManager{LowWater: 100, HighWater: 300, Threshold: 10 * 1000 * 1000}
+		if err := n.Repo.SetConfig(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if sn.apis[n], err = coreapi.NewCoreAPI(n); err != nil {
+			t.Fatal(err)
+		}
+		sn.envs[n] = &oldcmds.Context{
+			ConstructNode: func() (*core.IpfsNode, error) { return n, nil },
+			LoadConfig:    func(string) (*config.Config, error) { return n.Repo.Config() },
+			ConfigRoot:    t.TempDir(),
+			ReqLog:        &oldcmds.ReqLog{},
+		}
+		serveRemoteAPI(ctx, t, n, sn.envs[n])
+		if i == 0 {
+			sn.renter = n
+			continue
+		}
+		sn.hosts = append(sn.hosts, n)
+		services.Hub.AddHost(&hubpb.Host{
+			NodeId:          n.Identity.String(),
+			Score:           float32(i),
+			StoragePriceAsk: 1,
+			StorageTimeMin:  30,
+		}, nil)
+	}
+	// the renter settings are kept locally
+	err = helper.PutHostStorageConfig(ctx, sn.renter, &nodepb.Node_Settings{StoragePriceAsk: 1, StorageTimeMin: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	return sn
+}
+
+// remoteRoot holds the commands the nodes call on each other during an upload.
+var remoteRoot = &cmds.Command{
+	Subcommands: map[string]*cmds.Command{
+		"storage": {
+			Subcommands: map[string]*cmds.Command{
+				"upload": upload.StorageUploadCmd,
+			},
+		},
+		"p2p": {
+			Subcommands: map[string]*cmds.Command{
+				"handshake": handshakeCmd,
+			},
+		},
+	},
+}
+
+// handshakeCmd answers the swap handshake with the beneficiary of the chain,
+// as the p2p handshake of the daemon does.
+var handshakeCmd = &cmds.Command{
+	Arguments: []cmds.Argument{
+		cmds.StringArg("chain-id", true, false, "the specified chain."),
+		cmds.StringArg("peer-id", true, false, "the id of peer who send handshake data."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		return cmds.EmitOnce(res, &pb.Handshake{Beneficiary: chain.ChainObject.OverlayAddress.Bytes()})
+	},
+	Type: pb.Handshake{},
+}
+
+// serveRemoteAPI serves the remote commands to the peers of the node, the way the
+// daemon forwards them from libp2p streams to its remote api listener.
+func serveRemoteAPI(ctx context.Context, t *testing.T, n *core.IpfsNode, env *oldcmds.Context) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := cmdshttp.NewServerConfig()
+	cfg.SetAllowedMethods(http.MethodPost)
+	cfg.APIPath = "/api/" + shell.API_VERSION
+	mux := http.NewServeMux()
+	mux.Handle(cfg.APIPath+"/", cmdshttp.NewHandler(env, remoteRoot, cfg))
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(lis)
+	t.Cleanup(func() { srv.Close() })
+
+	addr, err := manet.FromNetAddr(lis.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.P2P.ForwardRemote(ctx, remote.P2PRemoteCallProto, addr, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compatibleFactory deems all vaults compatible.
+type compatibleFactory struct {
+	vault.Factory
+}
+
+func (compatibleFactory) IsVaultCompatibleBetween(context.Context, peer.ID, peer.ID) (bool, error) {
+	return true, nil
+}
+
+type fixedOracle struct{}
+
+func (fixedOracle) CurrentPrice(common.Address) (*big.Int, error)      { return big.NewInt(1), nil }
+func (fixedOracle) CurrentRate(common.Address) (*big.Int, error)       { return big.NewInt(1000000), nil }
+func (fixedOracle) CurrentTotalPrice(common.Address) (*big.Int, error) { return big.NewInt(1), nil }
+func (fixedOracle) CheckNewPrice(common.Address) (*big.Int, error)     { return big.NewInt(1), nil }
+
+// settlement stands in the settlement layer. It is process global, so it is
+// shared by the renter and the hosts: cheques are issued from a mock vault of the
+// renter, received into a mock cheque store, and cashed by transactions on a
+// simulated chain.
+type settlement struct {
+	swap    *swap.Service
+	txs     transaction.Service
+	vault   common.Address
+	token   common.Address
+	mu      sync.Mutex
+	payouts map[common.Address]*big.Int
+	issued  int
+	cheques []*vault.SignedCheque
+}
+
+func newSettlement(t *testing.T) *settlement {
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := ethcrypto.PubkeyToAddress(key.PublicKey)
+	backend := backendsimulation.NewChain(ethcore.GenesisAlloc{
+		sender: {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)},
+	})
+	t.Cleanup(func() { backend.Close() })
+	monitor := transaction.NewMonitor(backend, sender, 10*time.Millisecond, 0)
+	t.Cleanup(func() { monitor.Close() })
+	store := storemock.NewStateStore()
+	txs, err := transaction.NewService(backend, crypto.NewDefaultSigner(key), store,
+		backendsimulation.ChainID, monitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { txs.Close() })
+
+	tokencfg.InitToken(backendsimulation.ChainID.Int64())
+	s := &settlement{
+		txs:     txs,
+		vault:   common.HexToAddress("0x7a017"),
+		token:   tokencfg.GetWbttToken(),
+		payouts: make(map[common.Address]*big.Int),
+	}
+	chequeStore := chequestoremock.NewChequeStore(
+		chequestoremock.WithReceiveChequeFunc(func(ctx context.Context, cheque *vault.SignedCheque,
+			amount *big.Int) (*big.Int, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.cheques = append(s.cheques, cheque)
+			return amount, nil
+		}),
+		chequestoremock.WithLastReceivedChequeFunc(func(v common.Address) (*vault.SignedCheque, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if len(s.cheques) == 0 {
+				return nil, vault.ErrNoCheque
+			}
+			return s.cheques[len(s.cheques)-1], nil
+		}),
+	)
+	vaultService := vaultmock.NewVault(
+		vaultmock.WithVaultAddressFunc(func() common.Address { return s.vault }),
+		vaultmock.WithVaultAvailableBalanceFunc(func(ctx context.Context, token common.Address) (*big.Int, error) {
+			return big.NewInt(1 << 40), nil
+		}),
+		vaultmock.WithVaultIssueFunc(s.issue),
+		vaultmock.WithTotalReceivedFunc(func(common.Address) (*big.Int, error) { return big.NewInt(0), nil }),
+		vaultmock.WithTotalReceivedCountFunc(func(common.Address) (int, error) { return 0, nil }),
+	)
+	cashout := vault.NewCashoutService(store, backend, txs, chequeStore)
+
+	acc, err := accounting.NewAccounting(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := swapprotocol.New(sender, fixedOracle{})
+	s.swap = swap.New(proto, store, vaultService, chequeStore, swap.NewAddressbook(store),
+		backendsimulation.ChainID.Int64(), cashout, acc)
+	proto.SetSwap(s.swap)
+	acc.SetPayFunc(s.swap.Pay)
+	swapprotocol.SwapProtocol = proto
+
+	chain.ChainObject = chain.ChainInfo{
+		OverlayAddress:     sender,
+		ChainID:            backendsimulation.ChainID.Int64(),
+		Backend:            backend,
+		TransactionService: txs,
+	}
+	chain.SettleObject = chain.SettleInfo{
+		Factory:        compatibleFactory{},
+		VaultService:   vaultService,
+		ChequeStore:    chequeStore,
+		CashoutService: cashout,
+		SwapService:    s.swap,
+		OracleService:  fixedOracle{},
+		Accounting:     acc,
+	}
+	return s
+}
+
+// issue issues a cheque of the renter vault for the amount, as vault.Issue does.
+func (s *settlement) issue(ctx context.Context, beneficiary common.Address, amount *big.Int, token common.Address,
+	send vault.SendChequeFunc) (*big.Int, error) {
+	s.mu.Lock()
+	payout, ok := s.payouts[beneficiary]
+	if !ok {
+		payout = big.NewInt(0)
+	}
+	payout = new(big.Int).Add(payout, amount)
+	s.payouts[beneficiary] = payout
+	s.issued++
+	s.mu.Unlock()
+	cheque := &vault.SignedCheque{
+		Cheque: vault.Cheque{
+			Token:            token,
+			Vault:            s.vault,
+			Beneficiary:      beneficiary,
+			CumulativePayout: payout,
+		},
+		Signature: []byte("signature"),
+	}
+	if err := send(cheque); err != nil {
+		return nil, err
+	}
+	return big.NewInt(1 << 40), nil
+}
+
+func (s *settlement) received() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cheques)
+}
+
+// run executes the command at path on the node, as the daemon api does, and
+// returns what it emitted.
+func (sn *storageNet) run(ctx context.Context, n *core.IpfsNode, path []string, opts cmds.OptMap,
+	args ...string) (interface{}, error) {
+	root := &cmds.Command{Subcommands: remoteRoot.Subcommands}
+	req, err := cmds.NewRequest(ctx, path, opts, args, nil, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.FillDefaults(); err != nil {
+		return nil, err
+	}
+	re, res := cmds.NewChanResponsePair(req)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- cmds.NewExecutor(root).Execute(req, re, sn.envs[n])
+	}()
+	v, err := res.Next()
+	if exErr := <-errc; exErr != nil {
+		return nil, exErr
+	}
+	return v, err
+}
+
+// TestStorageUpload uploads a file from the renter to the hosts: the renter
+// picks its hosts, which fetch their shards, answer the challenge of the guard
+// and are paid with cheques, which they then cash.
+func TestStorageUpload(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	settle := newSettlement(t)
+	sn := newStorageNet(ctx, t, 3)
+
+	// a shard per host
+	root, err := sn.apis[sn.renter].Unixfs().Add(ctx, files.NewBytesFile(RandomBytes(300<<10)),
+		options.Unixfs.Chunker("reed-solomon-1-2-262144"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostIDs := ""
+	for i, h := range sn.hosts {
+		if i > 0 {
+			hostIDs += ","
+		}
+		hostIDs += h.Identity.String()
+	}
+	v, err := sn.run(ctx, sn.renter, []string{"storage", "upload"}, cmds.OptMap{
+		"host-select-mode": "custom",
+		"host-selection":   hostIDs,
+	}, root.Cid().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssId := v.(*upload.Res).ID
+
+	// the session goes through the contracts, the guard and the payments
+	var status *upload.StatusRes
+	for {
+		v, err := sn.run(ctx, sn.renter, []string{"storage", "upload", "status"}, nil, ssId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status = v.(*upload.StatusRes)
+		if status.Status == sessions.RssCompleteStatus || status.Status == sessions.RssErrorStatus {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("session is still %s", status.Status)
+		case <-time.After(time.Second):
+		}
+	}
+	if status.Status != sessions.RssCompleteStatus {
+		for _, e := range status.Events {
+			t.Logf("%+v", e)
+		}
+		t.Fatalf("session failed: %s", status.Message)
+	}
+	fileStatus := sn.services.Guard.FileStatus(sn.renter.Identity.String(), root.Cid().String())
+	if fileStatus == nil || len(fileStatus.Contracts) != len(sn.hosts) {
+		t.Fatalf("guard has file status %v", fileStatus)
+	}
+
+	// every host receives the cheque paying its contract
+	for settle.received() < len(sn.hosts) {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%d cheques received, want %d", settle.received(), len(sn.hosts))
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	renterID := sn.renter.Identity.String()
+	for _, c := range fileStatus.Contracts {
+		hostCtx := &uh.ContextParams{Ctx: ctx, N: hostNode(sn, c.HostPid)}
+		shard, err := sessions.GetHostShard(hostCtx, c.ContractId, 0, 0, big.NewInt(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !shard.IsPayStatus() {
+			t.Fatalf("host %s did not receive the cheque of contract %s", c.HostPid, c.ContractId)
+		}
+	}
+	l, err := chain.SettleObject.Accounting.PeerLedger(renterID, settle.token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Receivable.Contracts) != len(sn.hosts) || l.Receivable.Outstanding.Sign() != 0 {
+		t.Fatalf("%d contracts of the renter paid, %s outstanding", len(l.Receivable.Contracts), l.Receivable.Outstanding)
+	}
+
+	// and cashes it out
+	txHash, err := settle.swap.CashCheque(ctx, renterID, settle.token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := settle.txs.WaitForReceipt(ctx, txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != 1 {
+		t.Fatal("cashout transaction failed")
+	}
+}
+
+func hostNode(sn *storageNet, id string) *core.IpfsNode {
+	for _, n := range sn.hosts {
+		if n.Identity.String() == id {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package backendsimulation
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/bittorrent/go-btfs/transaction"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ChainID is the chain id of the simulated chain.
+var ChainID = big.NewInt(1337)
+
+// DefaultGasLimit is the block gas limit of the simulated chain.
+const DefaultGasLimit = 30_000_000
+
+// Chain is a transaction.Backend on top of an in-memory blockchain that runs
+// transactions and contracts. Every transaction sent is mined in its own block,
+// so that its receipt is available right away.
+type Chain struct {
+	*backends.SimulatedBackend
+
+	mu sync.Mutex
+}
+
+var _ transaction.Backend = (*Chain)(nil)
+
+// NewChain returns a simulated chain with the accounts of alloc funded, or deployed
+// with code.
+func NewChain(alloc core.GenesisAlloc) *Chain {
+	for addr, account := range alloc {
+		if account.Balance == nil {
+			account.Balance = new(big.Int)
+			alloc[addr] = account
+		}
+	}
+	return &Chain{SimulatedBackend: backends.NewSimulatedBackend(alloc, DefaultGasLimit)}
+}
+
+// SendTransaction sends the transaction and mines a block with it.
+func (c *Chain) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
+		return err
+	}
+	c.SimulatedBackend.Commit()
+	return nil
+}
+
+// Commit mines a block with the pending transactions.
+func (c *Chain) Commit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SimulatedBackend.Commit()
+}
+
+// BlockNumber returns the number of the latest block.
+func (c *Chain) BlockNumber(ctx context.Context) (uint64, error) {
+	return c.Blockchain().CurrentBlock().NumberU64(), nil
+}
@@ -0,0 +1,91 @@
+package backendsimulation_test
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	conabi "github.com/bittorrent/go-btfs/chain/abi"
+	"github.com/bittorrent/go-btfs/settlement/swap/vault"
+	storemock "github.com/bittorrent/go-btfs/statestore/mock"
+	"github.com/bittorrent/go-btfs/transaction"
+	"github.com/bittorrent/go-btfs/transaction/backendsimulation"
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := ethcrypto.PubkeyToAddress(key.PublicKey)
+	recipient := common.HexToAddress("0xabcd")
+	factoryAddress := common.HexToAddress("0xfac7")
+	chain := backendsimulation.NewChain(core.GenesisAlloc{
+		sender:         {Balance: new(big.Int).Exp(big.NewInt(10), big.NewInt(24), nil)},
+		factoryAddress: {Code: common.FromHex(conabi.FactoryDeployedBin)},
+	})
+	defer chain.Close()
+
+	monitor := transaction.NewMonitor(chain, sender, 10*time.Millisecond, 0)
+	defer monitor.Close()
+	service, err := transaction.NewService(chain, crypto.NewDefaultSigner(key), storemock.NewStateStore(),
+		backendsimulation.ChainID, monitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+
+	value := big.NewInt(1000)
+	txHash, err := service.Send(ctx, &transaction.TxRequest{To: &recipient, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	receipt, err := service.WaitForReceipt(ctx, txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != 1 {
+		t.Fatal("transfer failed")
+	}
+	number, err := chain.BlockNumber(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != receipt.BlockNumber.Uint64() {
+		t.Fatalf("block number %d, want %d", number, receipt.BlockNumber.Uint64())
+	}
+	balance, err := chain.BalanceAt(ctx, recipient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(value) != 0 {
+		t.Fatalf("balance %d, want %d", balance, value)
+	}
+
+	// contracts run on the chain
+	factory := vault.NewFactory(chain, service, factoryAddress)
+	if err := factory.VerifyBytecode(ctx); err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.Decode("16Uiu2HAmPTdSkeZdeRgEB5KYEuJRYuXRGbAAvQxSb2PXxmSqbaRc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := factory.GetPeerVault(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != (common.Address{}) {
+		t.Fatalf("vault %s for a peer without one", v)
+	}
+}
@@ -0,0 +1,231 @@
+// Package remote implements a signer that keeps its key on another machine,
+// and asks a remote signer over HTTP for every signature.
+//
+// The protocol is JSON over HTTP, all byte strings are 0x prefixed hex:
+//
+//	GET  /account          -> {"Address": "0x..", "PublicKey": "0x.."}
+//	POST /sign             {"Data": "0x.."} -> {"Signature": "0x.."}
+//	POST /sign-typed-data  {"TypedData": {..}} -> {"Signature": "0x.."}
+//	POST /sign-tx          {"Tx": "0x..", "ChainID": "0x.."} -> {"Tx": "0x.."}
+//
+// The public key is in the 33 bytes compressed format, transactions in their
+// binary encoding. Signatures are in the ethereum (r,s,v) format with v 27 or
+// 28. Failed requests are answered with a non 2xx status and
+// {"Message": ".."}. When a token is set it is sent as a bearer token in the
+// Authorization header.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+	"github.com/bittorrent/go-btfs/transaction/crypto/eip712"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	accountPath       = "/account"
+	signPath          = "/sign"
+	signTypedDataPath = "/sign-typed-data"
+	signTxPath        = "/sign-tx"
+
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	// ErrAddressMismatch is the error if the remote signer reports an address
+	// that is not the one of its public key, or not the one asked for, or signs
+	// with another key.
+	ErrAddressMismatch = errors.New("remote signer address mismatch")
+)
+
+type accountResponse struct {
+	Address   common.Address
+	PublicKey hexutil.Bytes
+}
+
+type signRequest struct {
+	Data hexutil.Bytes
+}
+
+type signTypedDataRequest struct {
+	TypedData *eip712.TypedData
+}
+
+type signatureResponse struct {
+	Signature hexutil.Bytes
+}
+
+type signTxRequest struct {
+	Tx      hexutil.Bytes
+	ChainID *hexutil.Big
+}
+
+type signTxResponse struct {
+	Tx hexutil.Bytes
+}
+
+type errorResponse struct {
+	Message string
+}
+
+type remoteSigner struct {
+	url     string
+	token   string
+	client  *http.Client
+	address common.Address
+	pubKey  *ecdsa.PublicKey
+}
+
+// NewSigner connects to the remote signer at url. If ethAddress is not nil, the
+// remote signer must sign for that address.
+func NewSigner(url string, token string, ethAddress *common.Address) (crypto.Signer, error) {
+	s := &remoteSigner{
+		url:    strings.TrimSuffix(url, "/"),
+		token:  token,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+	var account accountResponse
+	if err := s.call(http.MethodGet, accountPath, nil, &account); err != nil {
+		return nil, err
+	}
+	pubKey, err := btcec.ParsePubKey(account.PublicKey, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("remote signer public key: %w", err)
+	}
+	s.pubKey = (*ecdsa.PublicKey)(pubKey)
+	eth, err := crypto.NewEthereumAddress(*s.pubKey)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.address[:], eth)
+	if s.address != account.Address || (ethAddress != nil && *ethAddress != s.address) {
+		return nil, ErrAddressMismatch
+	}
+	return s, nil
+}
+
+func (s *remoteSigner) call(method, path string, request, response interface{}) error {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, s.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("remote signer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return fmt.Errorf("remote signer: %s", e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// PublicKey returns the public key of the remote signer.
+func (s *remoteSigner) PublicKey() (*ecdsa.PublicKey, error) {
+	return s.pubKey, nil
+}
+
+// EthereumAddress returns the ethereum address of the remote signer.
+func (s *remoteSigner) EthereumAddress() (common.Address, error) {
+	return s.address, nil
+}
+
+// Sign signs data with ethereum prefix (eip191 type 0x45), and checks the
+// signature was made with the key of the remote signer.
+func (s *remoteSigner) Sign(data []byte) ([]byte, error) {
+	var resp signatureResponse
+	if err := s.call(http.MethodPost, signPath, &signRequest{Data: data}, &resp); err != nil {
+		return nil, err
+	}
+	if err := s.checkSigner(crypto.Recover(resp.Signature, data)); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// SignTypedData signs data according to eip712, and checks the signature was
+// made with the key of the remote signer.
+func (s *remoteSigner) SignTypedData(typedData *eip712.TypedData) ([]byte, error) {
+	var resp signatureResponse
+	if err := s.call(http.MethodPost, signTypedDataPath, &signTypedDataRequest{TypedData: typedData}, &resp); err != nil {
+		return nil, err
+	}
+	if err := s.checkSigner(crypto.RecoverEIP712(resp.Signature, typedData)); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// checkSigner checks the public key recovered from a signature is the one of
+// the remote signer.
+func (s *remoteSigner) checkSigner(pubKey *ecdsa.PublicKey, err error) error {
+	if err != nil {
+		return fmt.Errorf("remote signer signature: %w", err)
+	}
+	eth, err := crypto.NewEthereumAddress(*pubKey)
+	if err != nil {
+		return err
+	}
+	if common.BytesToAddress(eth) != s.address {
+		return ErrAddressMismatch
+	}
+	return nil
+}
+
+// SignTx signs an ethereum transaction, and checks the remote signer signed
+// the same transaction it was sent.
+func (s *remoteSigner) SignTx(transaction *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	tx, err := transaction.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var resp signTxResponse
+	if err := s.call(http.MethodPost, signTxPath, &signTxRequest{Tx: tx, ChainID: (*hexutil.Big)(chainID)}, &resp); err != nil {
+		return nil, err
+	}
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(resp.Tx); err != nil {
+		return nil, err
+	}
+	txSigner := types.NewEIP155Signer(chainID)
+	if txSigner.Hash(signed) != txSigner.Hash(transaction) {
+		return nil, errors.New("remote signer signed another transaction")
+	}
+	sender, err := types.Sender(txSigner, signed)
+	if err != nil {
+		return nil, err
+	}
+	if sender != s.address {
+		return nil, ErrAddressMismatch
+	}
+	return signed, nil
+}
@@ -0,0 +1,184 @@
+package remote_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+	"github.com/bittorrent/go-btfs/transaction/crypto/eip712"
+	"github.com/bittorrent/go-btfs/transaction/crypto/remote"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var testTypedData = &eip712.TypedData{
+	Domain: eip712.TypedDataDomain{
+		Name:    "test",
+		Version: "1.0",
+	},
+	Types: eip712.Types{
+		"EIP712Domain": {
+			{
+				Name: "name",
+				Type: "string",
+			},
+			{
+				Name: "version",
+				Type: "string",
+			},
+		},
+		"MyType": {
+			{
+				Name: "test",
+				Type: "string",
+			},
+		},
+	},
+	Message: eip712.TypedDataMessage{
+		"test": "abc",
+	},
+	PrimaryType: "MyType",
+}
+
+func newTestSigner(t *testing.T) crypto.Signer {
+	t.Helper()
+	data, err := hex.DecodeString("634fb5a872396d9693e5c9f9d7233cfa93f395c093371017ff44aa9ae6564cdd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := crypto.DecodeSecp256k1PrivateKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.NewDefaultSigner(privKey)
+}
+
+func TestRemoteSigner(t *testing.T) {
+	local := newTestSigner(t)
+	server := httptest.NewServer(remote.NewHandler(local, "secret"))
+	defer server.Close()
+
+	address, err := local.EthereumAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := remote.NewSigner(server.URL, "secret", &address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("ethereum address", func(t *testing.T) {
+		got, err := signer.EthereumAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != address {
+			t.Fatalf("wrong address. expected %x, got %x", address, got)
+		}
+	})
+
+	t.Run("sign", func(t *testing.T) {
+		testBytes := []byte("test string")
+		sig, err := signer.Sign(testBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := local.Sign(testBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, sig) {
+			t.Fatalf("wrong signature. expected %x, got %x", expected, sig)
+		}
+	})
+
+	t.Run("sign typed data", func(t *testing.T) {
+		sig, err := signer.SignTypedData(testTypedData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := hex.DecodeString("60f054c45d37a0359d4935da0454bc19f02a8c01ceee8a112cfe48c8e2357b842e897f76389fb96947c6d2c80cbfe081052204e7b0c3cc1194a973a09b1614f71c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, sig) {
+			t.Fatalf("wrong signature. expected %x, got %x", expected, sig)
+		}
+	})
+
+	t.Run("sign tx", func(t *testing.T) {
+		chainID := big.NewInt(10)
+		beneficiary := common.HexToAddress("8d3766440f0d7b949a5e32995d09619a7f86e632")
+		tx, err := signer.SignTx(types.NewTransaction(0, beneficiary, big.NewInt(0), 21000, big.NewInt(1), []byte{1}), chainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender, err := types.Sender(types.NewEIP155Signer(chainID), tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sender != address {
+			t.Fatalf("wrong sender. expected %x, got %x", address, sender)
+		}
+	})
+}
+
+func TestRemoteSignerUnauthorized(t *testing.T) {
+	server := httptest.NewServer(remote.NewHandler(newTestSigner(t), "secret"))
+	defer server.Close()
+
+	if _, err := remote.NewSigner(server.URL, "wrong", nil); err == nil {
+		t.Fatal("expected error for wrong token")
+	}
+}
+
+func TestRemoteSignerWrongAddress(t *testing.T) {
+	server := httptest.NewServer(remote.NewHandler(newTestSigner(t), ""))
+	defer server.Close()
+
+	address := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	_, err := remote.NewSigner(server.URL, "", &address)
+	if !errors.Is(err, remote.ErrAddressMismatch) {
+		t.Fatalf("expected address mismatch, got %v", err)
+	}
+}
+
+// otherKeySigner reports the account of one key, but signs with another.
+type otherKeySigner struct {
+	crypto.Signer
+	other crypto.Signer
+}
+
+func (s *otherKeySigner) Sign(data []byte) ([]byte, error) {
+	return s.other.Sign(data)
+}
+
+func (s *otherKeySigner) SignTypedData(typedData *eip712.TypedData) ([]byte, error) {
+	return s.other.SignTypedData(typedData)
+}
+
+func TestRemoteSignerWrongSignature(t *testing.T) {
+	otherKey, err := crypto.GenerateSecp256k1Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &otherKeySigner{Signer: newTestSigner(t), other: crypto.NewDefaultSigner(otherKey)}
+	server := httptest.NewServer(remote.NewHandler(local, ""))
+	defer server.Close()
+
+	signer, err := remote.NewSigner(server.URL, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Sign([]byte("test string")); !errors.Is(err, remote.ErrAddressMismatch) {
+		t.Fatalf("expected address mismatch, got %v", err)
+	}
+	if _, err := signer.SignTypedData(testTypedData); !errors.Is(err, remote.ErrAddressMismatch) {
+		t.Fatalf("expected address mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/bittorrent/go-btfs/transaction/crypto"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// NewHandler serves the remote signer protocol with the signer, e.g. as a
+// stand-in for a key management service. Requests must carry the token when it
+// is set.
+func NewHandler(signer crypto.Signer, token string) http.Handler {
+	h := &handler{signer: signer, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc(accountPath, h.account)
+	mux.HandleFunc(signPath, h.sign)
+	mux.HandleFunc(signTypedDataPath, h.signTypedData)
+	mux.HandleFunc(signTxPath, h.signTx)
+	return h.authorize(mux)
+}
+
+type handler struct {
+	signer crypto.Signer
+	token  string
+}
+
+func (h *handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.token != "" &&
+			subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+h.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&errorResponse{Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// decode reads the request of a POST, it answers the error itself.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func (h *handler) account(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	pubKey, err := h.signer.PublicKey()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	address, err := h.signer.EthereumAddress()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, &accountResponse{Address: address, PublicKey: crypto.EncodeSecp256k1PublicKey(pubKey)})
+}
+
+func (h *handler) sign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	sig, err := h.signer.Sign(req.Data)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, &signatureResponse{Signature: sig})
+}
+
+func (h *handler) signTypedData(w http.ResponseWriter, r *http.Request) {
+	var req signTypedDataRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.TypedData == nil {
+		writeError(w, http.StatusBadRequest, "no typed data")
+		return
+	}
+	sig, err := h.signer.SignTypedData(req.TypedData)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, &signatureResponse{Signature: sig})
+}
+
+func (h *handler) signTx(w http.ResponseWriter, r *http.Request) {
+	var req signTxRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.ChainID == nil {
+		writeError(w, http.StatusBadRequest, "no chain id")
+		return
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(req.Tx); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	signed, err := h.signer.SignTx(tx, req.ChainID.ToInt())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	b, err := signed.MarshalBinary()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, &signTxResponse{Tx: b})
+}